@@ -2,66 +2,439 @@ package opcua
 
 import (
 	"context"
+	"crypto/rsa"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/gopcua/opcua/logger"
 	"github.com/gopcua/opcua/ua"
 	"github.com/gopcua/opcua/uacp"
 	"github.com/gopcua/opcua/uasc"
 )
 
+// ConnState is the state of the connection of a client to the server.
+type ConnState uint8
+
+const (
+	// Closed is the state before Open and after Close.
+	Closed ConnState = iota
+
+	// Connected is the state while the secure channel and the session
+	// are established.
+	Connected
+
+	// Disconnected is the state after the connection has failed.
+	Disconnected
+
+	// Reconnecting is the state while the client tries to establish a
+	// new secure channel and to restore the session and the
+	// subscriptions.
+	Reconnecting
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Closed:
+		return "Closed"
+	case Connected:
+		return "Connected"
+	case Disconnected:
+		return "Disconnected"
+	case Reconnecting:
+		return "Reconnecting"
+	default:
+		return fmt.Sprintf("ConnState(%d)", uint8(s))
+	}
+}
+
+var (
+	// reconnectMinDelay and reconnectMaxDelay are the bounds of the
+	// delay between two reconnect attempts. The delay doubles after
+	// every failed attempt.
+	reconnectMinDelay = 100 * time.Millisecond
+	reconnectMaxDelay = 30 * time.Second
+
+	// reconnectTimeout is the time after which a reconnect attempt is
+	// abandoned.
+	reconnectTimeout = 10 * time.Second
+)
+
 // Client is a high-level client for an OPC/UA server.
 // It establishes a secure channel and a session.
+//
+// When the connection fails the client dials the server again until it
+// is closed. It activates the existing session on the new secure channel
+// or creates a new session if the server has removed it. The
+// subscriptions are transferred to the new session or created again.
+// Requests fail while the client is not connected.
 type Client struct {
 	Addr string
 
-	config  *uasc.Config
+	// Logger is the logger for the connection and the secure channel
+	// unless the configuration has its own. If nil nothing is logged.
+	Logger logger.Logger
+
+	// ConnState is called when the state of the connection changes. It
+	// must not block and must not call Close.
+	ConnState func(ConnState)
+
+	config     *uasc.Config
+	sessionCfg *uasc.SessionConfig
+
+	// identity is the user identity token for the session. If nil the
+	// user is anonymous.
+	identity interface{}
+
+	// userKey is the private key of the certificate of an
+	// X509IdentityToken.
+	userKey *rsa.PrivateKey
+
+	// endpoints are the endpoints returned by GetEndpoints.
+	endpoints []*ua.EndpointDescription
+
+	// mu guards sechan, session, state, ready and closing.
+	mu      sync.RWMutex
 	sechan  *uasc.SecureChannel
 	session *uasc.Session
+	state   ConnState
+
+	// ready is closed while the client is connected.
+	ready chan struct{}
+
+	// closing is closed by Close and stops the reconnect loop. Open
+	// creates a new one.
+	closing chan struct{}
+
+	// subMu guards subs, stopPublish and the parameters of the
+	// subscriptions.
+	subMu sync.Mutex
+	subs  map[uint32]*Subscription
+
+	// stopPublish stops the publish loop. It is nil if the loop is
+	// not running.
+	stopPublish context.CancelFunc
+
+	// pathMu guards paths which caches the node ids resolved by
+	// NodesFromPaths for the current session.
+	pathMu sync.Mutex
+	paths  map[string]*ua.NodeID
+}
+
+// Option configures a client.
+type Option func(*Client)
+
+// AuthAnonymous authenticates the session as an anonymous user. This is
+// the default.
+func AuthAnonymous() Option {
+	return func(c *Client) {
+		c.identity = &ua.AnonymousIdentityToken{}
+	}
 }
 
-func NewClient(addr string, cfg *uasc.Config) *Client {
-	return &Client{Addr: addr, config: cfg}
+// AuthUsername authenticates the session with a user name and a password.
+// The policy id of the token is the one of the first user token policy of
+// the endpoint for user names. The password is encrypted with the security
+// policy of the user token policy and the nonce of the server. The session
+// is not activated if the policy requires encryption but the secure
+// channel is not secured.
+func AuthUsername(user, pass string) Option {
+	return func(c *Client) {
+		c.identity = &ua.UserNameIdentityToken{UserName: user, Password: []byte(pass)}
+	}
+}
+
+// AuthCertificate authenticates the session with the DER encoded X.509
+// certificate of the user. The policy id of the token is the one of the
+// first user token policy of the endpoint for certificates. The
+// certificate and the nonce of the server are signed with the private key
+// and the security policy of the user token policy.
+func AuthCertificate(cert []byte, key *rsa.PrivateKey) Option {
+	return func(c *Client) {
+		c.identity = &ua.X509IdentityToken{CertificateData: cert}
+		c.userKey = key
+	}
+}
+
+// DiscoveryEndpoints sets the endpoints which GetEndpoints has returned.
+// The client closes the connection if the endpoints which the server
+// returns when the session is created do not match them.
+func DiscoveryEndpoints(eps []*ua.EndpointDescription) Option {
+	return func(c *Client) {
+		c.endpoints = eps
+	}
+}
+
+func NewClient(addr string, cfg *uasc.Config, opts ...Option) *Client {
+	c := &Client{Addr: addr, config: cfg}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // Open connects to the server and establishes a secure channel
-// and a session.
-func (c *Client) Open() error {
-	ctx := context.Background()
-	conn, err := uacp.Dial(ctx, c.Addr)
+// and a session. It fails if this does not complete before ctx is done.
+func (c *Client) Open(ctx context.Context) error {
+	sechan, err := c.dial(ctx)
 	if err != nil {
 		return err
 	}
-	sechan := uasc.NewSecureChannel(conn, nil)
-	if err := sechan.Open(); err != nil {
-		conn.Close()
-		return err
-	}
-	sechan.EndpointURL = c.Addr
-	c.sechan = sechan
 
 	// todo(fs): this should probably be configurable.
-	sessionCfg := uasc.NewClientSessionConfig(
-		[]string{"en-US"},
-		&ua.AnonymousIdentityToken{PolicyID: "open62541-anonymous-policy"},
-	)
+	c.sessionCfg = uasc.NewClientSessionConfig([]string{"en-US"}, c.identity)
+	c.sessionCfg.UserKey = c.userKey
+	c.sessionCfg.DiscoveryEndpoints = c.endpoints
 
-	session := uasc.NewSession(sechan, sessionCfg)
-	if err := session.Open(); err != nil {
+	session := uasc.NewSession(sechan, c.sessionCfg)
+	if err := session.Open(ctx); err != nil {
 		sechan.Close()
 		return err
 	}
+
+	closing := make(chan struct{})
+	c.mu.Lock()
+	c.sechan = sechan
 	c.session = session
+	c.ready = make(chan struct{})
+	c.closing = closing
+	c.mu.Unlock()
+	c.setState(Connected)
+
+	go c.monitor(sechan, closing)
 	return nil
 }
 
-// Close closes the session, the secure channel and the network
-// connection to the server.
+// dial connects to the server and opens a secure channel.
+func (c *Client) dial(ctx context.Context) (*uasc.SecureChannel, error) {
+	d := &uacp.Dialer{Logger: c.Logger}
+	conn, err := d.Dial(ctx, c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	sechan := uasc.NewSecureChannel(conn, c.config)
+	if err := sechan.Open(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	sechan.EndpointURL = c.Addr
+	return sechan, nil
+}
+
+// Close closes the subscriptions, the session, the secure channel and
+// the network connection to the server.
 func (c *Client) Close() error {
-	if c.session != nil {
-		c.session.Close()
+	c.closeSubscriptions()
+
+	c.mu.Lock()
+	if c.closing != nil && !isClosed(c.closing) {
+		close(c.closing)
+	}
+	sechan, session := c.sechan, c.session
+	c.mu.Unlock()
+	c.setState(Closed)
+
+	if session != nil {
+		session.Close()
+	}
+	if sechan == nil {
+		return nil
+	}
+	return sechan.Close()
+}
+
+// State returns the state of the connection.
+func (c *Client) State() ConnState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// setState changes the state of the connection and reports the change.
+// Only Closed can be set after Close. It returns false if the state could
+// not be changed since the client is closed.
+func (c *Client) setState(state ConnState) bool {
+	c.mu.Lock()
+	if state != Closed && isClosed(c.closing) {
+		c.mu.Unlock()
+		return false
+	}
+	if state == c.state {
+		c.mu.Unlock()
+		return true
+	}
+	if state == Connected {
+		close(c.ready)
+	} else if c.state == Connected {
+		c.ready = make(chan struct{})
+	}
+	c.state = state
+	c.mu.Unlock()
+
+	if c.ConnState != nil {
+		c.ConnState(state)
+	}
+	return true
+}
+
+// channel returns the current secure channel.
+func (c *Client) channel() *uasc.SecureChannel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sechan
+}
+
+// send sends the request on the current secure channel and calls h with
+// the response. It fails with ua.StatusBadServerNotConnected if the client
+// has not been opened and with ua.StatusBadSecureChannelClosed while the
+// client is reconnecting since the secure channel has failed.
+func (c *Client) send(ctx context.Context, req interface{}, h func(interface{}) error) error {
+	sechan := c.channel()
+	if sechan == nil {
+		return ua.StatusBadServerNotConnected
+	}
+	if sechan.Err() != nil {
+		return ua.StatusBadSecureChannelClosed
+	}
+	return sechan.SendWithContext(ctx, req, h)
+}
+
+// isConnected returns true if the client is connected and its secure
+// channel has not failed.
+func (c *Client) isConnected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state == Connected && c.sechan.Err() == nil
+}
+
+// waitConnected waits until the client is connected. It returns false if
+// ctx is done or the client is closed.
+func (c *Client) waitConnected(ctx context.Context) bool {
+	for {
+		c.mu.RLock()
+		ready, sechan, closing := c.ready, c.sechan, c.closing
+		c.mu.RUnlock()
+
+		select {
+		case <-ready:
+			if sechan.Err() == nil {
+				return true
+			}
+			// the failed secure channel has not been noticed yet
+			select {
+			case <-time.After(reconnectMinDelay):
+			case <-ctx.Done():
+				return false
+			}
+		case <-closing:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// monitor waits until the secure channel fails and reconnects until
+// closing is closed.
+func (c *Client) monitor(sechan *uasc.SecureChannel, closing chan struct{}) {
+	log := logger.Or(c.Logger)
+	for {
+		select {
+		case <-closing:
+			return
+		case <-sechan.Done():
+		}
+		if !c.setState(Disconnected) {
+			return
+		}
+		log.Warn("opcua: connection lost", "addr", c.Addr, "err", sechan.Err())
+
+		delay := reconnectMinDelay
+		for {
+			if !c.setState(Reconnecting) {
+				return
+			}
+			var err error
+			if sechan, err = c.reconnect(closing); err == nil {
+				break
+			}
+			log.Warn("opcua: reconnect failed", "addr", c.Addr, "err", err, "retry", delay)
+			select {
+			case <-closing:
+				return
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > reconnectMaxDelay {
+				delay = reconnectMaxDelay
+			}
+		}
+		log.Info("opcua: reconnected", "addr", c.Addr)
+	}
+}
+
+// reconnect opens a new secure channel and activates the session on it.
+// If this fails it creates a new session and restores the subscriptions.
+// It returns the new secure channel. It is abandoned when closing is
+// closed.
+//
+// Specification: Part 4, 6.7
+func (c *Client) reconnect(closing chan struct{}) (*uasc.SecureChannel, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), reconnectTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	sechan, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	session := c.session
+	c.mu.RUnlock()
+
+	restore := false
+	if err := session.Activate(ctx, sechan); err != nil {
+		logger.Or(c.Logger).Info("opcua: session not activated", "err", err)
+		session = uasc.NewSession(sechan, c.sessionCfg)
+		if err := session.Open(ctx); err != nil {
+			sechan.Close()
+			return nil, err
+		}
+		restore = true
+	}
+
+	c.mu.Lock()
+	if isClosed(closing) {
+		c.mu.Unlock()
+		sechan.Close()
+		return nil, ua.StatusBadSecureChannelClosed
+	}
+	c.sechan = sechan
+	c.session = session
+	c.mu.Unlock()
+
+	if restore {
+		c.clearPaths()
+		c.restoreSubscriptions(ctx)
+	}
+	c.setState(Connected)
+	return sechan, nil
+}
+
+// isClosed returns true if ch is closed.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
 	}
-	return c.sechan.Close()
 }
 
 // Node returns a node object which accesses its attributes
@@ -70,10 +443,14 @@ func (c *Client) Node(id *ua.NodeID) *Node {
 	return &Node{ID: id, c: c}
 }
 
-// Read executes a synchronous read request.
-func (c *Client) Read(req *ua.ReadRequest) (*ua.ReadResponse, error) {
+// Read executes a synchronous read request and waits for the
+// response until ctx is done.
+//
+// A bad ServiceResult is returned as *ua.ServiceError. The status of
+// the individual values can be checked with ua.DataValuesError.
+func (c *Client) Read(ctx context.Context, req *ua.ReadRequest) (*ua.ReadResponse, error) {
 	var res *ua.ReadResponse
-	err := c.sechan.Send(req, func(v interface{}) error {
+	err := c.send(ctx, req, func(v interface{}) error {
 		r, ok := v.(*ua.ReadResponse)
 		if !ok {
 			return fmt.Errorf("invalid response: %T", v)
@@ -84,10 +461,14 @@ func (c *Client) Read(req *ua.ReadRequest) (*ua.ReadResponse, error) {
 	return res, err
 }
 
-// Browse executes a synchronous browse request.
-func (c *Client) Browse(req *ua.BrowseRequest) (*ua.BrowseResponse, error) {
+// Browse executes a synchronous browse request and waits for the
+// response until ctx is done.
+//
+// A bad ServiceResult is returned as *ua.ServiceError. The status of
+// the individual results can be checked with ua.BrowseResultsError.
+func (c *Client) Browse(ctx context.Context, req *ua.BrowseRequest) (*ua.BrowseResponse, error) {
 	var res *ua.BrowseResponse
-	err := c.sechan.Send(req, func(v interface{}) error {
+	err := c.send(ctx, req, func(v interface{}) error {
 		r, ok := v.(*ua.BrowseResponse)
 		if !ok {
 			return fmt.Errorf("invalid response: %T", v)
@@ -98,29 +479,96 @@ func (c *Client) Browse(req *ua.BrowseRequest) (*ua.BrowseResponse, error) {
 	return res, err
 }
 
-// todo(fs): this is not done yet since we need to be able to register
-// todo(fs): monitored items.
-type Subscription struct {
-	res *ua.CreateSubscriptionResponse
+// BrowseNext executes a synchronous request for the next references of
+// the continuation points of a previous Browse or BrowseNext request and
+// waits for the response until ctx is done. If ReleaseContinuationPoints
+// is set the continuation points are released instead.
+//
+// A bad ServiceResult is returned as *ua.ServiceError. The status of
+// the individual results can be checked with ua.BrowseResultsError.
+func (c *Client) BrowseNext(ctx context.Context, req *ua.BrowseNextRequest) (*ua.BrowseNextResponse, error) {
+	var res *ua.BrowseNextResponse
+	err := c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.BrowseNextResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		res = r
+		return nil
+	})
+	return res, err
 }
 
-// todo(fs): return subscription object with channel
-func (c *Client) Subscribe(intv time.Duration) (*Subscription, error) {
-	req := &ua.CreateSubscriptionRequest{
-		RequestedPublishingInterval: float64(intv / time.Millisecond),
-		RequestedLifetimeCount:      60,
-		RequestedMaxKeepAliveCount:  20,
-		PublishingEnabled:           true,
-	}
+// TranslateBrowsePathsToNodeIDs executes a synchronous request which
+// resolves browse paths to the ids of their target nodes and waits for
+// the response until ctx is done.
+//
+// A bad ServiceResult is returned as *ua.ServiceError. The status of
+// the individual results can be checked with ua.BrowsePathResultsError.
+func (c *Client) TranslateBrowsePathsToNodeIDs(ctx context.Context, req *ua.TranslateBrowsePathsToNodeIDsRequest) (*ua.TranslateBrowsePathsToNodeIDsResponse, error) {
+	var res *ua.TranslateBrowsePathsToNodeIDsResponse
+	err := c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.TranslateBrowsePathsToNodeIDsResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		res = r
+		return nil
+	})
+	return res, err
+}
 
-	var res *ua.CreateSubscriptionResponse
-	err := c.sechan.Send(req, func(v interface{}) error {
-		r, ok := v.(*ua.CreateSubscriptionResponse)
+// HistoryRead executes a synchronous history read request and waits for
+// the response until ctx is done. If ReleaseContinuationPoints is set the
+// continuation points of the nodes are released instead.
+//
+// A bad ServiceResult is returned as *ua.ServiceError. The status of
+// the individual results is returned in their StatusCode.
+func (c *Client) HistoryRead(ctx context.Context, req *ua.HistoryReadRequest) (*ua.HistoryReadResponse, error) {
+	var res *ua.HistoryReadResponse
+	err := c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.HistoryReadResponse)
 		if !ok {
 			return fmt.Errorf("invalid response: %T", v)
 		}
 		res = r
 		return nil
 	})
-	return &Subscription{res}, err
+	return res, err
+}
+
+// Write executes a synchronous write request and waits for the
+// response until ctx is done.
+//
+// A bad ServiceResult is returned as *ua.ServiceError. The status of
+// the individual writes can be checked with ua.StatusCodesError.
+func (c *Client) Write(ctx context.Context, req *ua.WriteRequest) (*ua.WriteResponse, error) {
+	var res *ua.WriteResponse
+	err := c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.WriteResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		res = r
+		return nil
+	})
+	return res, err
+}
+
+// Call executes a synchronous call request for one or more methods and
+// waits for the response until ctx is done.
+//
+// A bad ServiceResult is returned as *ua.ServiceError. The results of
+// the individual methods have their own status codes.
+func (c *Client) Call(ctx context.Context, req *ua.CallRequest) (*ua.CallResponse, error) {
+	var res *ua.CallResponse
+	err := c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.CallResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		res = r
+		return nil
+	})
+	return res, err
 }
@@ -0,0 +1,303 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+const testProxyEndpoint = "opc.tcp://127.0.0.1:48406"
+
+// testProxy forwards the connections to the test server so that the tests
+// can break them. It replaces the endpoint url in the HEL message with the
+// one of the server which has the same length.
+type testProxy struct {
+	l net.Listener
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newTestProxy(t *testing.T) *testProxy {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:48406")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProxy{l: l}
+	go p.serve()
+	return p
+}
+
+func (p *testProxy) serve() {
+	for {
+		c, err := p.l.Accept()
+		if err != nil {
+			return
+		}
+		go p.forward(c)
+	}
+}
+
+func (p *testProxy) forward(c net.Conn) {
+	s, err := net.Dial("tcp", "127.0.0.1:48403")
+	if err != nil {
+		c.Close()
+		return
+	}
+	p.mu.Lock()
+	p.conns = append(p.conns, c, s)
+	p.mu.Unlock()
+
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(c, hdr); err != nil {
+		c.Close()
+		s.Close()
+		return
+	}
+	hel := make([]byte, binary.LittleEndian.Uint32(hdr[4:]))
+	copy(hel, hdr)
+	if _, err := io.ReadFull(c, hel[len(hdr):]); err != nil {
+		c.Close()
+		s.Close()
+		return
+	}
+	s.Write(bytes.Replace(hel, []byte(testProxyEndpoint), []byte(testServerEndpoint), 1))
+
+	go func() {
+		io.Copy(s, c)
+		s.Close()
+		c.Close()
+	}()
+	io.Copy(c, s)
+	s.Close()
+	c.Close()
+}
+
+// drop breaks all connections.
+func (p *testProxy) drop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+}
+
+func (p *testProxy) close() {
+	p.l.Close()
+	p.drop()
+}
+
+func TestConnStateString(t *testing.T) {
+	cases := map[ConnState]string{
+		Closed:       "Closed",
+		Connected:    "Connected",
+		Disconnected: "Disconnected",
+		Reconnecting: "Reconnecting",
+		ConnState(9): "ConnState(9)",
+	}
+	for s, want := range cases {
+		if got := s.String(); got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	cases := []struct {
+		name string
+
+		// restart restarts the server which removes the session.
+		restart bool
+
+		// rejectTransfer rejects the transfer of the subscription to the
+		// new session so that it is created again.
+		rejectTransfer bool
+
+		// transfers is the expected number of transfer requests.
+		transfers int
+	}{
+		{name: "activate session"},
+		{name: "transfer subscription", restart: true, transfers: 1},
+		{name: "recreate subscription", restart: true, rejectTransfer: true, transfers: 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			subs := &testSubscriptions{rejectTransfer: c.rejectTransfer}
+			start := func() (stop func()) {
+				srv := &Server{EndpointURL: testServerEndpoint}
+				subs.register(srv)
+				return startTestServer(t, srv)
+			}
+			stop := start()
+			defer func() { stop() }()
+
+			p := newTestProxy(t)
+			defer p.close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			states := make(chan ConnState, 16)
+			cli := NewClient(testProxyEndpoint, nil)
+			cli.ConnState = func(s ConnState) { states <- s }
+			if err := cli.Open(ctx); err != nil {
+				t.Fatal(err)
+			}
+			defer cli.Close()
+
+			sub, err := cli.Subscribe(ctx, &SubscriptionParameters{Interval: 10 * time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := sub.Monitor(ctx, ua.NewNumericNodeID(0, id.Server_ServerStatus_CurrentTime)); err != nil {
+				t.Fatal(err)
+			}
+			last := nextDataChange(t, sub).Value.Value.Value.(int32)
+
+			if c.restart {
+				stop()
+				stop = start()
+			} else {
+				p.drop()
+			}
+
+			var got []ConnState
+			for len(got) < 4 {
+				select {
+				case s := <-states:
+					got = append(got, s)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("timeout after states %v", got)
+				}
+			}
+			verify.Values(t, "states", got, []ConnState{Connected, Disconnected, Reconnecting, Connected})
+			subs.mu.Lock()
+			transfers := subs.transfers
+			subs.mu.Unlock()
+			if transfers != c.transfers {
+				t.Fatalf("got %d transfers want %d", transfers, c.transfers)
+			}
+
+			if c.rejectTransfer {
+				// the messages before the reconnect can still be queued
+				for {
+					m := <-sub.C
+					if sc, ok := m.(*StatusChangeMessage); ok {
+						if got, want := sc.Status, ua.StatusBadSubscriptionIDInvalid; got != want {
+							t.Fatalf("got status %v want %v", got, want)
+						}
+						break
+					}
+					dc, ok := m.(*DataChangeMessage)
+					if !ok {
+						t.Fatalf("got %#v want *DataChangeMessage", m)
+					}
+					last = dc.Value.Value.Value.(int32)
+				}
+				if got, want := sub.ID, uint32(testSubscriptionID+1); got != want {
+					t.Fatalf("got subscription id %d want %d", got, want)
+				}
+				// the new subscription starts with sequence number 1
+				last = 0
+			}
+
+			// no values are lost
+			for i := 0; i < 5; i++ {
+				v := nextDataChange(t, sub).Value.Value.Value.(int32)
+				if v != last+1 {
+					t.Fatalf("got value %d want %d", v, last+1)
+				}
+				last = v
+			}
+
+			// requests are sent on the new secure channel
+			if _, err := cli.Subscribe(ctx, nil); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestClientNotConnected(t *testing.T) {
+	cli := NewClient(testServerEndpoint, nil)
+	if _, err := cli.Node(ua.NewNumericNodeID(0, 1)).Value(context.Background()); err != ua.StatusBadServerNotConnected {
+		t.Fatalf("got error %v want %v", err, ua.StatusBadServerNotConnected)
+	}
+}
+
+func TestClientClosedWhileReconnecting(t *testing.T) {
+	stop := newTestServer(t)
+	p := newTestProxy(t)
+	defer p.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	states := make(chan ConnState, 16)
+	cli := NewClient(testProxyEndpoint, nil)
+	cli.ConnState = func(s ConnState) { states <- s }
+	if err := cli.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server is gone and the client keeps reconnecting
+	stop()
+	for s := range states {
+		if s == Reconnecting {
+			break
+		}
+	}
+	if _, err := cli.Node(ua.NewNumericNodeID(0, 1)).Value(ctx); err != ua.StatusBadSecureChannelClosed {
+		t.Fatalf("got error %v want %v", err, ua.StatusBadSecureChannelClosed)
+	}
+
+	cli.Close()
+	if got, want := cli.State(), Closed; got != want {
+		t.Fatalf("got state %v want %v", got, want)
+	}
+}
+
+func TestClientReopen(t *testing.T) {
+	defer newTestServer(t)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	states := make(chan ConnState, 16)
+	cli := NewClient(testServerEndpoint, nil)
+	cli.ConnState = func(s ConnState) { states <- s }
+	for i := 0; i < 2; i++ {
+		if err := cli.Open(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cli.Node(ua.NewNumericNodeID(0, 1)).Value(ctx); err != nil {
+			t.Fatal(err)
+		}
+		cli.Close()
+	}
+
+	// the client does not reconnect after the second Close
+	time.Sleep(3 * reconnectMinDelay)
+	close(states)
+	var got []ConnState
+	for s := range states {
+		got = append(got, s)
+	}
+	verify.Values(t, "", got, []ConnState{Connected, Closed, Connected, Closed})
+}
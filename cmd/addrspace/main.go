@@ -0,0 +1,249 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Command addrspace generates the nodes and references of the standard
+// namespace 0 for the server/addrspace package.
+//
+// The attributes and references are taken from the official NodeSet
+// Opc.Ua.NodeSet2.xml and the names of the constants from NodeIds.csv.
+// Run schema/update-schema.sh to download both.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// node is a node of namespace 0.
+type node struct {
+	ID              uint32
+	Class           string
+	Name            string
+	DisplayName     string
+	Description     string
+	IsAbstract      bool
+	Symmetric       bool
+	InverseName     string
+	DataType        uint32
+	ValueRank       int32
+	AccessLevel     uint8
+	EventNotifier   uint8
+	ContainsNoLoops bool
+	Historizing     bool
+}
+
+// ref is a forward reference between two nodes of namespace 0.
+type ref struct {
+	Source, Type, Target uint32
+}
+
+// model contains the nodes and references of namespace 0 and the
+// symbolic names of the node ids from NodeIds.csv.
+type model struct {
+	names map[uint32]string
+	nodes []*node
+	refs  []ref
+}
+
+func main() {
+	log.SetFlags(0)
+
+	in := flag.String("in", "schema/NodeIds.csv", "path to NodeIds.csv")
+	nodeset := flag.String("nodeset", "schema/Opc.Ua.NodeSet2.xml", "path to Opc.Ua.NodeSet2.xml")
+	out := flag.String("out", "server/addrspace/ns0_gen.go", "path to generated file")
+	flag.Parse()
+
+	if *in == "" {
+		log.Fatal("-in is required")
+	}
+	if *nodeset == "" {
+		log.Fatal("-nodeset is required")
+	}
+	if *out == "" {
+		log.Fatal("-out is required")
+	}
+
+	rows, err := readCSV(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	m, err := fromNodeSet(*nodeset, rows)
+	if os.IsNotExist(err) {
+		log.Fatalf("%s not found. Run schema/update-schema.sh to download it.", *nodeset)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, m.lines()); err != nil {
+		log.Fatalf("Error generating code: %v", err)
+	}
+
+	bfmt, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatalf("Error formatting code: %v", err)
+	}
+
+	if err := ioutil.WriteFile(*out, bfmt, 0644); err != nil {
+		log.Fatalf("Error writing %s: %v", *out, err)
+	}
+	log.Printf("Wrote %s", *out)
+}
+
+// csvRow is a row of NodeIds.csv.
+type csvRow struct {
+	Name  string
+	ID    uint32
+	Class string
+}
+
+func readCSV(path string) ([]csvRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %v", path, err)
+	}
+	defer f.Close()
+
+	recs, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", path, err)
+	}
+
+	var rows []csvRow
+	for _, r := range recs {
+		id, err := strconv.ParseUint(r[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing %s: invalid id %q", path, r[1])
+		}
+		rows = append(rows, csvRow{Name: r[0], ID: uint32(id), Class: r[2]})
+	}
+	return rows, nil
+}
+
+// lines returns the Go literals of the nodes and the references.
+func (m *model) lines() map[string][]string {
+	sort.Slice(m.nodes, func(i, j int) bool { return m.nodes[i].ID < m.nodes[j].ID })
+	sort.Slice(m.refs, func(i, j int) bool {
+		a, b := m.refs[i], m.refs[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Target < b.Target
+	})
+
+	var nodes, refs []string
+	for _, n := range m.nodes {
+		f := []string{
+			"ID: " + m.id(n.ID),
+			"Class: ua.NodeClass" + n.Class,
+			"Name: " + strconv.Quote(n.Name),
+		}
+		if n.DisplayName != "" && n.DisplayName != n.Name {
+			f = append(f, "DisplayName: "+strconv.Quote(n.DisplayName))
+		}
+		if n.Description != "" {
+			f = append(f, "Description: "+strconv.Quote(n.Description))
+		}
+		if n.IsAbstract {
+			f = append(f, "IsAbstract: true")
+		}
+		if n.Symmetric {
+			f = append(f, "Symmetric: true")
+		}
+		if n.InverseName != "" {
+			f = append(f, "InverseName: "+strconv.Quote(n.InverseName))
+		}
+		if n.DataType != 0 {
+			f = append(f, "DataType: "+m.id(n.DataType))
+		}
+		if n.Class == "Variable" || n.Class == "VariableType" {
+			f = append(f, fmt.Sprintf("ValueRank: %d", n.ValueRank))
+		}
+		if n.AccessLevel != 0 {
+			f = append(f, fmt.Sprintf("AccessLevel: %d", n.AccessLevel))
+		}
+		if n.EventNotifier != 0 {
+			f = append(f, fmt.Sprintf("EventNotifier: %d", n.EventNotifier))
+		}
+		if n.ContainsNoLoops {
+			f = append(f, "ContainsNoLoops: true")
+		}
+		if n.Historizing {
+			f = append(f, "Historizing: true")
+		}
+		nodes = append(nodes, "{"+strings.Join(f, ", ")+"}")
+	}
+	for _, r := range m.refs {
+		refs = append(refs, fmt.Sprintf("{%s, %s, %s}", m.id(r.Source), m.id(r.Type), m.id(r.Target)))
+	}
+	return map[string][]string{"Nodes": nodes, "Refs": refs}
+}
+
+// id returns the constant from the id package for the node id
+// or the number if there is none.
+func (m *model) id(n uint32) string {
+	if name, ok := m.names[n]; ok {
+		return "id." + goName(name)
+	}
+	return strconv.FormatUint(uint64(n), 10)
+}
+
+var tmpl = template.Must(template.New("").Parse(`
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Code generated by cmd/addrspace. DO NOT EDIT!
+
+package addrspace
+
+import (
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+)
+
+var ns0Nodes = []ns0Node{
+	{{range .Nodes}}{{.}},
+	{{end}}
+}
+
+var ns0References = []ns0Reference{
+	{{range .Refs}}{{.}},
+	{{end}}
+}
+`))
+
+// goName returns the name of the constant in the id package. It must
+// match the conversion in cmd/id.
+func goName(s string) string {
+	r1 := strings.NewReplacer(
+		"Guid", "GUID",
+		"Id", "ID",
+		"Json", "JSON",
+		"QualityOfService", "QoS",
+		"Uadp", "UADP",
+		"Uri", "URI",
+		"Url", "URL",
+		"Xml", "XML",
+	)
+	r2 := strings.NewReplacer(
+		"IDentity", "Identity",
+	)
+	return r2.Replace(r1.Replace(s))
+}
@@ -0,0 +1,166 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// uaNodeSet is the part of the UANodeSet schema which is required for
+// the nodes of namespace 0.
+type uaNodeSet struct {
+	Aliases []struct {
+		Alias string `xml:"Alias,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"Aliases>Alias"`
+	Objects        []uaNode `xml:"UAObject"`
+	Variables      []uaNode `xml:"UAVariable"`
+	Methods        []uaNode `xml:"UAMethod"`
+	ObjectTypes    []uaNode `xml:"UAObjectType"`
+	VariableTypes  []uaNode `xml:"UAVariableType"`
+	ReferenceTypes []uaNode `xml:"UAReferenceType"`
+	DataTypes      []uaNode `xml:"UADataType"`
+	Views          []uaNode `xml:"UAView"`
+}
+
+type uaNode struct {
+	NodeID          string `xml:"NodeId,attr"`
+	BrowseName      string `xml:"BrowseName,attr"`
+	DisplayName     string `xml:"DisplayName"`
+	Description     string `xml:"Description"`
+	IsAbstract      bool   `xml:"IsAbstract,attr"`
+	Symmetric       bool   `xml:"Symmetric,attr"`
+	InverseName     string `xml:"InverseName"`
+	DataType        string `xml:"DataType,attr"`
+	ValueRank       *int32 `xml:"ValueRank,attr"`
+	AccessLevel     *uint8 `xml:"AccessLevel,attr"`
+	EventNotifier   uint8  `xml:"EventNotifier,attr"`
+	ContainsNoLoops bool   `xml:"ContainsNoLoops,attr"`
+	Historizing     bool   `xml:"Historizing,attr"`
+	References      []struct {
+		ReferenceType string `xml:"ReferenceType,attr"`
+		IsForward     string `xml:"IsForward,attr"`
+		Target        string `xml:",chardata"`
+	} `xml:"References>Reference"`
+}
+
+// fromNodeSet reads the nodes and references of namespace 0 from the
+// NodeSet. The references are stored only in forward direction.
+func fromNodeSet(path string, rows []csvRow) (*model, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ns uaNodeSet
+	if err := xml.NewDecoder(f).Decode(&ns); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", path, err)
+	}
+
+	m := &model{names: map[uint32]string{}}
+	for _, r := range rows {
+		m.names[r.ID] = r.Name
+	}
+
+	aliases := map[string]string{}
+	for _, a := range ns.Aliases {
+		aliases[a.Alias] = strings.TrimSpace(a.Value)
+	}
+	resolve := func(s string) (uint32, error) {
+		s = strings.TrimSpace(s)
+		if v, ok := aliases[s]; ok {
+			s = v
+		}
+		if !strings.HasPrefix(s, "i=") {
+			return 0, fmt.Errorf("unsupported node id %q", s)
+		}
+		id, err := strconv.ParseUint(s[2:], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid node id %q", s)
+		}
+		return uint32(id), nil
+	}
+
+	seen := map[ref]bool{}
+	classes := []struct {
+		class string
+		nodes []uaNode
+	}{
+		{"Object", ns.Objects},
+		{"Variable", ns.Variables},
+		{"Method", ns.Methods},
+		{"ObjectType", ns.ObjectTypes},
+		{"VariableType", ns.VariableTypes},
+		{"ReferenceType", ns.ReferenceTypes},
+		{"DataType", ns.DataTypes},
+		{"View", ns.Views},
+	}
+	for _, c := range classes {
+		for _, un := range c.nodes {
+			id, err := resolve(un.NodeID)
+			if err != nil {
+				return nil, err
+			}
+			n := &node{
+				ID:              id,
+				Class:           c.class,
+				Name:            un.BrowseName,
+				DisplayName:     strings.TrimSpace(un.DisplayName),
+				Description:     strings.TrimSpace(un.Description),
+				IsAbstract:      un.IsAbstract,
+				Symmetric:       un.Symmetric,
+				InverseName:     strings.TrimSpace(un.InverseName),
+				ValueRank:       -1,
+				EventNotifier:   un.EventNotifier,
+				ContainsNoLoops: un.ContainsNoLoops,
+				Historizing:     un.Historizing,
+			}
+			if un.ValueRank != nil {
+				n.ValueRank = *un.ValueRank
+			}
+			switch c.class {
+			case "Variable", "VariableType":
+				n.DataType = 24 // BaseDataType
+				if un.DataType != "" {
+					if n.DataType, err = resolve(un.DataType); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if c.class == "Variable" {
+				n.AccessLevel = 1 // CurrentRead
+				if un.AccessLevel != nil {
+					n.AccessLevel = *un.AccessLevel
+				}
+			}
+			m.nodes = append(m.nodes, n)
+
+			for _, r := range un.References {
+				typ, err := resolve(r.ReferenceType)
+				if err != nil {
+					return nil, err
+				}
+				target, err := resolve(r.Target)
+				if err != nil {
+					return nil, err
+				}
+				fwd := ref{Source: id, Type: typ, Target: target}
+				if r.IsForward == "false" {
+					fwd = ref{Source: target, Type: typ, Target: id}
+				}
+				if !seen[fwd] {
+					seen[fwd] = true
+					m.refs = append(m.refs, fwd)
+				}
+			}
+		}
+	}
+	return m, nil
+}
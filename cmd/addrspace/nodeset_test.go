@@ -0,0 +1,107 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+const testNodeSet = `<?xml version="1.0" encoding="utf-8"?>
+<UANodeSet xmlns="http://opcfoundation.org/UA/2011/03/UANodeSet.xsd">
+  <Aliases>
+    <Alias Alias="HasComponent">i=47</Alias>
+    <Alias Alias="HasProperty">i=46</Alias>
+    <Alias Alias="HasTypeDefinition">i=40</Alias>
+    <Alias Alias="UtcTime">i=294</Alias>
+  </Aliases>
+  <UAObject NodeId="i=2253" BrowseName="Server" EventNotifier="1">
+    <DisplayName>Server</DisplayName>
+    <References>
+      <Reference ReferenceType="HasTypeDefinition">i=2004</Reference>
+      <Reference ReferenceType="HasComponent">i=2256</Reference>
+    </References>
+  </UAObject>
+  <UAVariable NodeId="i=2256" BrowseName="ServerStatus" DataType="i=862">
+    <DisplayName>ServerStatus</DisplayName>
+    <References>
+      <Reference ReferenceType="HasComponent" IsForward="false">i=2253</Reference>
+      <Reference ReferenceType="HasComponent">i=2258</Reference>
+    </References>
+  </UAVariable>
+  <UAVariable NodeId="i=2258" BrowseName="CurrentTime" DataType="UtcTime" ValueRank="-1" AccessLevel="3">
+    <DisplayName>CurrentTime</DisplayName>
+    <Description>The current time of the server.</Description>
+  </UAVariable>
+  <UAVariableType NodeId="i=68" BrowseName="PropertyType" IsAbstract="false" ValueRank="-2">
+    <DisplayName>PropertyType</DisplayName>
+  </UAVariableType>
+  <UAReferenceType NodeId="i=46" BrowseName="HasProperty">
+    <DisplayName>HasProperty</DisplayName>
+    <InverseName>PropertyOf</InverseName>
+  </UAReferenceType>
+</UANodeSet>
+`
+
+func TestFromNodeSet(t *testing.T) {
+	f, err := ioutil.TempFile("", "gopcua-nodeset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(testNodeSet); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	rows := []csvRow{
+		{Name: "Server", ID: 2253, Class: "Object"},
+		{Name: "Server_ServerStatus_CurrentTime", ID: 2258, Class: "Variable"},
+	}
+	m, err := fromNodeSet(f.Name(), rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &model{
+		names: map[uint32]string{2253: "Server", 2258: "Server_ServerStatus_CurrentTime"},
+		nodes: []*node{
+			{ID: 2253, Class: "Object", Name: "Server", DisplayName: "Server", ValueRank: -1, EventNotifier: 1},
+			{ID: 2256, Class: "Variable", Name: "ServerStatus", DisplayName: "ServerStatus", DataType: 862, ValueRank: -1, AccessLevel: 1},
+			{ID: 2258, Class: "Variable", Name: "CurrentTime", DisplayName: "CurrentTime", Description: "The current time of the server.", DataType: 294, ValueRank: -1, AccessLevel: 3},
+			{ID: 68, Class: "VariableType", Name: "PropertyType", DisplayName: "PropertyType", DataType: 24, ValueRank: -2},
+			{ID: 46, Class: "ReferenceType", Name: "HasProperty", DisplayName: "HasProperty", InverseName: "PropertyOf", ValueRank: -1},
+		},
+		refs: []ref{
+			{Source: 2253, Type: 40, Target: 2004},
+			{Source: 2253, Type: 47, Target: 2256},
+			{Source: 2256, Type: 47, Target: 2258},
+		},
+	}
+	verify.Values(t, "", m, want)
+
+	t.Run("unsupported node id", func(t *testing.T) {
+		g, err := ioutil.TempFile("", "gopcua-nodeset")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(g.Name())
+		g.WriteString(`<UANodeSet><UAObject NodeId="ns=1;s=foo" BrowseName="Foo"/></UANodeSet>`)
+		g.Close()
+
+		if _, err := fromNodeSet(g.Name(), nil); err == nil {
+			t.Fatal("got nil want error")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := fromNodeSet(f.Name()+".missing", nil); err == nil {
+			t.Fatal("got nil want error")
+		}
+	})
+}
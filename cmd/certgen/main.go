@@ -0,0 +1,83 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file
+
+// Command certgen creates a self-signed application instance certificate
+// and its private key. They are stored in the own directories of a pki
+// certificate store with -dir or written to the -cert and -key files.
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gopcua/opcua/pki"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	host, _ := os.Hostname()
+
+	dir := flag.String("dir", "", "path to the pki certificate store")
+	certFile := flag.String("cert", "cert.der", "path to the DER encoded certificate if -dir is not set")
+	keyFile := flag.String("key", "key.pem", "path to the PEM encoded private key if -dir is not set")
+	uri := flag.String("uri", "", "application uri, e.g. urn:"+host+":gopcua:client")
+	name := flag.String("name", "", "application name (default: the application uri)")
+	org := flag.String("org", "", "organization")
+	hosts := flag.String("host", host, "comma separated DNS names and IP addresses")
+	policy := flag.String("policy", "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256", "security policy uri")
+	bits := flag.Int("bits", 0, "RSA key length 2048 or 4096 (default: the shortest which fits -policy)")
+	days := flag.Int("days", 365, "days the certificate is valid")
+	flag.Parse()
+
+	if *uri == "" {
+		log.Fatal("-uri is required")
+	}
+
+	opts := pki.CertificateOptions{
+		ApplicationURI:    *uri,
+		ApplicationName:   *name,
+		Organization:      *org,
+		SecurityPolicyURI: *policy,
+		KeyLength:         *bits,
+		Lifetime:          time.Duration(*days) * 24 * time.Hour,
+	}
+	for _, h := range strings.Split(*hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			opts.Hosts = append(opts.Hosts, h)
+		}
+	}
+
+	cert, key, err := pki.NewCertificate(opts)
+	if err != nil {
+		log.Fatalf("Error creating certificate: %v", err)
+	}
+
+	if *dir != "" {
+		s, err := pki.Open(*dir)
+		if err != nil {
+			log.Fatalf("Error opening %s: %v", *dir, err)
+		}
+		if err := s.SaveOwn(cert, key); err != nil {
+			log.Fatalf("Error saving certificate: %v", err)
+		}
+		log.Printf("Saved certificate in %s", s.Path(pki.OwnCertsDir))
+		return
+	}
+
+	b := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(*keyFile, b, 0600); err != nil {
+		log.Fatalf("Error writing %s: %v", *keyFile, err)
+	}
+	if err := ioutil.WriteFile(*certFile, cert, 0644); err != nil {
+		log.Fatalf("Error writing %s: %v", *certFile, err)
+	}
+	log.Printf("Saved certificate in %s and key in %s", *certFile, *keyFile)
+}
@@ -29,7 +29,7 @@ func TestSecureChannel(t *testing.T) {
 		t.Fatal(err)
 	}
 	s := uasc.NewSecureChannel(conn, nil)
-	if err := s.Open(); err != nil {
+	if err := s.Open(ctx); err != nil {
 		t.Fatal(err)
 	}
 	defer s.Close()
@@ -37,13 +37,14 @@ func TestSecureChannel(t *testing.T) {
 
 func TestClientRead(t *testing.T) {
 	t.Skip()
+	ctx := context.Background()
 	c := NewClient("opc.tcp://localhost:4840", nil)
-	if err := c.Open(); err != nil {
+	if err := c.Open(ctx); err != nil {
 		t.Fatal(err)
 	}
 	defer c.Close()
 
-	v, err := c.Node(ua.NewNumericNodeID(0, 2258)).Value()
+	v, err := c.Node(ua.NewNumericNodeID(0, 2258)).Value(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,128 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	"github.com/gopcua/opcua/securitypolicy"
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uacp"
+	"github.com/gopcua/opcua/uasc"
+)
+
+const (
+	// uatcpTransport is the transport profile of the endpoints which the
+	// client supports.
+	uatcpTransport = "http://opcfoundation.org/UA-Profile/Transport/uatcp-uasc-uabinary"
+
+	// defaultLifetime is the requested lifetime of the security token
+	// in milliseconds.
+	defaultLifetime = 3600000
+)
+
+// GetEndpoints returns the endpoints of the server at the url. It opens
+// a secure channel without security and without a session for the
+// request and closes it afterwards.
+//
+// Specification: Part 4, 5.4.4
+func GetEndpoints(ctx context.Context, url string) ([]*ua.EndpointDescription, error) {
+	var res *ua.GetEndpointsResponse
+	err := discover(ctx, url, &ua.GetEndpointsRequest{EndpointURL: url}, func(v interface{}) error {
+		r, ok := v.(*ua.GetEndpointsResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		res = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Endpoints, nil
+}
+
+// FindServers returns the servers which are known to the server at the
+// url, e.g. a discovery server. Like GetEndpoints it does not need a
+// session.
+//
+// Specification: Part 4, 5.4.2
+func FindServers(ctx context.Context, url string) ([]*ua.ApplicationDescription, error) {
+	var res *ua.FindServersResponse
+	err := discover(ctx, url, &ua.FindServersRequest{EndpointURL: url}, func(v interface{}) error {
+		r, ok := v.(*ua.FindServersResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		res = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Servers, nil
+}
+
+// discover sends the request of a discovery service on a new secure
+// channel without security.
+func discover(ctx context.Context, url string, req interface{}, h func(interface{}) error) error {
+	conn, err := (&uacp.Dialer{}).Dial(ctx, url)
+	if err != nil {
+		return err
+	}
+	sechan := uasc.NewSecureChannel(conn, nil)
+	if err := sechan.Open(ctx); err != nil {
+		conn.Close()
+		return err
+	}
+	sechan.EndpointURL = url
+	defer sechan.Close()
+	return sechan.SendWithContext(ctx, req, h)
+}
+
+// SelectEndpoint returns the endpoint with the highest security level
+// which has the security policy and the security mode and a
+// configuration for a secure channel to it. If policy is empty or mode
+// is ua.MessageSecurityModeInvalid any policy or mode matches. Endpoints
+// with security policies or transports which are not supported are
+// ignored.
+//
+// The configuration has the certificate of the server. For the security
+// modes Sign and SignAndEncrypt the certificate and the private key of
+// the client must be added to it.
+func SelectEndpoint(endpoints []*ua.EndpointDescription, policy string, mode ua.MessageSecurityMode) (*ua.EndpointDescription, *uasc.Config, error) {
+	supported := make(map[string]bool)
+	for _, uri := range securitypolicy.SupportedPolicies() {
+		supported[uri] = true
+	}
+
+	var ep *ua.EndpointDescription
+	for _, e := range endpoints {
+		switch {
+		case e == nil || !supported[e.SecurityPolicyURI]:
+			continue
+		case e.TransportProfileURI != "" && e.TransportProfileURI != uatcpTransport:
+			continue
+		case policy != "" && e.SecurityPolicyURI != policy:
+			continue
+		case mode != ua.MessageSecurityModeInvalid && e.SecurityMode != mode:
+			continue
+		}
+		if ep == nil || e.SecurityLevel > ep.SecurityLevel {
+			ep = e
+		}
+	}
+	if ep == nil {
+		return nil, nil, fmt.Errorf("opcua: no endpoint with security policy %q and mode %v", policy, mode)
+	}
+
+	cfg := uasc.NewClientConfig(ep.SecurityPolicyURI, nil, nil, uint32(rand.Int31()), ep.SecurityMode, defaultLifetime)
+	if ep.SecurityMode != ua.MessageSecurityModeNone {
+		cfg.RemoteCertificate = ep.ServerCertificate
+	}
+	return ep, cfg, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestDiscovery(t *testing.T) {
+	defer startTestServer(t, &Server{EndpointURL: testServerEndpoint})()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("GetEndpoints", func(t *testing.T) {
+		eps, err := GetEndpoints(ctx, testServerEndpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(eps), 1; got != want {
+			t.Fatalf("got %d endpoints want %d", got, want)
+		}
+		ep, cfg, err := SelectEndpoint(eps, "", ua.MessageSecurityModeInvalid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := ep.EndpointURL, testServerEndpoint; got != want {
+			t.Fatalf("got endpoint %s want %s", got, want)
+		}
+
+		// the selected endpoint can be used by the client
+		c := NewClient(ep.EndpointURL, cfg)
+		if err := c.Open(ctx); err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	})
+
+	t.Run("FindServers", func(t *testing.T) {
+		servers, err := FindServers(ctx, testServerEndpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var uris []string
+		for _, s := range servers {
+			uris = append(uris, s.ApplicationURI)
+		}
+		verify.Values(t, "", uris, []string{"urn:gopcua:server"})
+	})
+
+	t.Run("no server", func(t *testing.T) {
+		if _, err := GetEndpoints(ctx, "opc.tcp://127.0.0.1:48407"); err == nil {
+			t.Fatal("want error")
+		}
+	})
+}
+
+func TestSelectEndpoint(t *testing.T) {
+	const (
+		none   = "http://opcfoundation.org/UA/SecurityPolicy#None"
+		basic  = "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+		aes    = "http://opcfoundation.org/UA/SecurityPolicy#Aes128_Sha256_RsaOaep"
+		custom = "http://example.com/SecurityPolicy#Custom"
+	)
+	ep := func(url, policy string, mode ua.MessageSecurityMode, level uint8) *ua.EndpointDescription {
+		return &ua.EndpointDescription{
+			EndpointURL:         url,
+			SecurityPolicyURI:   policy,
+			SecurityMode:        mode,
+			SecurityLevel:       level,
+			ServerCertificate:   []byte("cert"),
+			TransportProfileURI: uatcpTransport,
+		}
+	}
+	https := ep("https", basic, ua.MessageSecurityModeSignAndEncrypt, 200)
+	https.TransportProfileURI = "http://opcfoundation.org/UA-Profile/Transport/https-uabinary"
+	endpoints := []*ua.EndpointDescription{
+		ep("none", none, ua.MessageSecurityModeNone, 0),
+		ep("basic-sign", basic, ua.MessageSecurityModeSign, 10),
+		ep("basic-encrypt", basic, ua.MessageSecurityModeSignAndEncrypt, 20),
+		ep("aes-encrypt", aes, ua.MessageSecurityModeSignAndEncrypt, 30),
+		ep("custom", custom, ua.MessageSecurityModeSignAndEncrypt, 100),
+		https,
+	}
+
+	cases := []struct {
+		policy string
+		mode   ua.MessageSecurityMode
+		url    string
+	}{
+		{"", ua.MessageSecurityModeInvalid, "aes-encrypt"},
+		{basic, ua.MessageSecurityModeInvalid, "basic-encrypt"},
+		{basic, ua.MessageSecurityModeSign, "basic-sign"},
+		{"", ua.MessageSecurityModeNone, "none"},
+		{aes, ua.MessageSecurityModeSign, ""},
+		{custom, ua.MessageSecurityModeInvalid, ""},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s/%d", c.policy, c.mode), func(t *testing.T) {
+			ep, cfg, err := SelectEndpoint(endpoints, c.policy, c.mode)
+			if c.url == "" {
+				if err == nil {
+					t.Fatalf("got endpoint %s want error", ep.EndpointURL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := ep.EndpointURL, c.url; got != want {
+				t.Fatalf("got endpoint %s want %s", got, want)
+			}
+			if got, want := cfg.SecurityPolicyURI, ep.SecurityPolicyURI; got != want {
+				t.Fatalf("got policy %s want %s", got, want)
+			}
+			if got, want := cfg.SecurityMode, ep.SecurityMode; got != want {
+				t.Fatalf("got mode %v want %v", got, want)
+			}
+			var cert []byte
+			if ep.SecurityMode != ua.MessageSecurityModeNone {
+				cert = ep.ServerCertificate
+			}
+			verify.Values(t, "", cfg.RemoteCertificate, cert)
+		})
+	}
+}
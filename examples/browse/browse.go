@@ -5,63 +5,39 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 
 	"github.com/gopcua/opcua"
-	uid "github.com/gopcua/opcua/id"
 	"github.com/gopcua/opcua/ua"
 )
 
-func join(a, b string) string {
-	if a == "" {
-		return b
-	}
-	return a + "." + b
-}
-
-func browse(n *opcua.Node, path string, level int) ([]string, error) {
-	if level > 10 {
-		return nil, nil
-	}
-	// nodeClass, err := n.NodeClass()
-	// if err != nil {
-	// 	return nil, err
-	// }
-	browseName, err := n.BrowseName()
-	if err != nil {
-		return nil, err
-	}
-	path = join(path, browseName.Name)
+func main() {
+	endpoint := flag.String("endpoint", "opc.tcp://localhost:4840", "OPC UA Endpoint URL")
+	nodeID := flag.String("node", "i=84", "node id of the root node")
+	depth := flag.Int("depth", 3, "maximum depth")
+	flag.Parse()
 
-	typeDefs := ua.NewTwoByteNodeID(uid.HasTypeDefinition)
-	refs, err := n.References(typeDefs)
+	id, err := ua.NewNodeID(*nodeID)
 	if err != nil {
-		return nil, err
+		log.Fatalf("invalid node id: %v", err)
 	}
-	// todo(fs): example still incomplete
-	log.Printf("refs: %#v err: %v", refs, err)
-	return nil, nil
-}
 
-func main() {
-	endpoint := flag.String("endpoint", "opc.tcp://localhost:4840", "OPC UA Endpoint URL")
-	flag.Parse()
+	ctx := context.Background()
 
 	c := opcua.NewClient(*endpoint, nil)
-	if err := c.Open(); err != nil {
+	if err := c.Open(ctx); err != nil {
 		log.Fatal(err)
 	}
 	defer c.Close()
 
-	root := c.Node(ua.NewStringNodeID(1, "Root"))
-
-	nodeList, err := browse(root, "", 0)
+	err = opcua.Walk(ctx, c.Node(id), func(path string, d int, ref *ua.ReferenceDescription) error {
+		fmt.Printf("%s %s %v\n", path, ref.NodeID.NodeID, ref.NodeClass)
+		return nil
+	}, opcua.WalkMaxDepth(*depth))
 	if err != nil {
 		log.Fatal(err)
 	}
-	for _, s := range nodeList {
-		fmt.Println(s)
-	}
 }
@@ -5,6 +5,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 
@@ -14,15 +15,24 @@ import (
 
 func main() {
 	endpoint := flag.String("endpoint", "opc.tcp://localhost:4840", "OPC UA Endpoint URL")
+	user := flag.String("user", "", "user name. If empty the user is anonymous")
+	pass := flag.String("pass", "", "password of the user")
 	flag.Parse()
 
-	c := opcua.NewClient(*endpoint, nil)
-	if err := c.Open(); err != nil {
+	ctx := context.Background()
+
+	var opts []opcua.Option
+	if *user != "" {
+		opts = append(opts, opcua.AuthUsername(*user, *pass))
+	}
+
+	c := opcua.NewClient(*endpoint, nil, opts...)
+	if err := c.Open(ctx); err != nil {
 		log.Fatal(err)
 	}
 	defer c.Close()
 
-	v, err := c.Node(ua.NewNumericNodeID(0, 2258)).Value()
+	v, err := c.Node(ua.NewNumericNodeID(0, 2258)).Value(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -5,95 +5,80 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"log"
+	"math"
+	"os"
+	"time"
 
-	"github.com/gopcua/opcua/uacp"
+	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/logger"
+	"github.com/gopcua/opcua/server/addrspace"
+	"github.com/gopcua/opcua/ua"
 )
 
 func main() {
 	var (
-		endpoint = flag.String("endpoint", "opc.tcp://example.com/foo/bar", "OPC UA Endpoint URL")
+		endpoint = flag.String("endpoint", "opc.tcp://localhost:4840", "OPC UA Endpoint URL")
+		debug    = flag.Bool("debug", false, "enable debug logging")
+		history  = flag.String("history", "", "file for the history of the simulated values. If empty the last hour is kept in memory")
 	)
 	flag.Parse()
 
-	log.Printf("Listening on %s", *endpoint)
-	l, err := uacp.Listen(*endpoint, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	c, err := l.Accept(context.Background())
-	if err != nil {
-		log.Fatal(err)
+	level := logger.LevelInfo
+	if *debug {
+		level = logger.LevelDebug
 	}
-	defer c.Close()
-	log.Printf("conn %d: connection from %s", c.ID(), c.RemoteAddr())
 
-	// listener, err := uacp.Listen(*endpoint, uint32(*bufsize))
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	// log.Printf("Started listening on %s.", listener.Endpoint())
-
-	// cfg := uasc.NewServerConfig(
-	// 	"http://opcfoundation.org/UA/SecurityPolicy#None",
-	// 	nil, nil, 1111, services.SecModeNone, 2222, 3600000,
-	// )
-	// for {
-	// 	func() {
-	// 		ctx := context.Background()
-	// 		ctx, cancel := context.WithCancel(ctx)
-	// 		defer cancel()
-
-	// 		conn, err := listener.Accept(ctx)
-	// 		if err != nil {
-	// 			log.Print(err)
-	// 			return
-	// 		}
-	// 		defer func() {
-	// 			conn.Close()
-	// 			log.Println("Successfully closed connection")
-	// 		}()
-	// 		log.Printf("Successfully established connection with %v", conn.RemoteAddr())
+	srv := &opcua.Server{
+		EndpointURL: *endpoint,
+		Logger:      logger.NewStd(log.New(os.Stderr, "", log.LstdFlags), level),
+	}
 
-	// 		secChan, err := uasc.ListenAndAcceptSecureChannel(ctx, conn, cfg)
-	// 		if err != nil {
-	// 			log.Fatal(err)
-	// 		}
-	// 		defer func() {
-	// 			secChan.Close()
-	// 			log.Printf("Successfully closed secure channel with %v", conn.RemoteAddr())
-	// 		}()
-	// 		log.Printf("Successfully opened secure channel with %v", conn.RemoteAddr())
+	// serve the standard nodes and update the current time of the server.
+	as := addrspace.New()
+	as.Register(srv)
 
-	// 		sessCfg := uasc.NewServerSessionConfig(secChan)
-	// 		session, err := uasc.ListenAndAcceptSession(ctx, secChan, sessCfg)
-	// 		if err != nil {
-	// 			log.Fatal(err)
-	// 		}
-	// 		defer func() {
-	// 			session.Close()
-	// 			log.Printf("Successfully closed session with %v", conn.RemoteAddr())
-	// 		}()
-	// 		log.Printf("Successfully activated session with %v", conn.RemoteAddr())
+	// record the history of a simulated temperature.
+	if *history == "" {
+		as.SetHistory(addrspace.NewMemoryHistory(3600))
+	} else {
+		h, err := addrspace.OpenFileHistory(*history)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer h.Close()
+		as.SetHistory(h)
+	}
+	temp := &addrspace.VariableNode{
+		BaseNode: addrspace.BaseNode{
+			ID:          ua.NewStringNodeID(1, "Temperature"),
+			BrowseName:  &ua.QualifiedName{NamespaceIndex: 1, Name: "Temperature"},
+			DisplayName: &ua.LocalizedText{Text: "Temperature"},
+		},
+		DataType:        ua.NewNumericNodeID(0, id.Double),
+		ValueRank:       -1,
+		AccessLevel:     0x05, // CurrentRead | HistoryRead
+		UserAccessLevel: 0x05,
+		Historizing:     true,
+	}
+	if err := as.AddNode(temp); err != nil {
+		log.Fatal(err)
+	}
+	if err := as.AddReference(ua.NewNumericNodeID(0, id.ObjectsFolder), ua.NewNumericNodeID(0, id.Organizes), temp.ID); err != nil {
+		log.Fatal(err)
+	}
 
-	// 		buf := make([]byte, 1024)
-	// 		for {
-	// 			n, err := session.ReadService(buf)
-	// 			if err != nil {
-	// 				log.Printf("Couldn't read UASC: %s", err)
-	// 				continue
-	// 			}
-	// 			log.Printf("Successfully received message: %x\n%s", buf[:n], utils.Wireshark(0, buf[:n]))
+	go func() {
+		for now := range time.Tick(time.Second) {
+			as.SetValue(ua.NewNumericNodeID(0, id.Server_ServerStatus_CurrentTime), ua.MustVariant(now))
+			t := 20 + 5*math.Sin(2*math.Pi*float64(now.Unix()%600)/600)
+			if err := as.SetValue(temp.ID, ua.MustVariant(t)); err != nil {
+				log.Print(err)
+			}
+		}
+	}()
 
-	// 			srv, err := services.Decode(buf[:n])
-	// 			if err != nil {
-	// 				log.Printf("Couldn't decode received bytes as Service: %s", err)
-	// 				continue
-	// 			}
-	// 			log.Printf("Successfully decoded as Service: %v", srv)
-	// 		}
-	// 	}()
-	// }
+	log.Fatal(srv.ListenAndServe())
 }
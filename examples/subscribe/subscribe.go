@@ -5,26 +5,60 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"time"
 
 	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/ua"
 )
 
 func main() {
 	endpoint := flag.String("endpoint", "opc.tcp://localhost:4840", "OPC UA Endpoint URL")
+	nodeID := flag.String("node", "i=2258", "node id to monitor")
+	interval := flag.Duration("interval", time.Second, "publishing interval")
 	flag.Parse()
 
+	id, err := ua.NewNodeID(*nodeID)
+	if err != nil {
+		log.Fatalf("invalid node id: %v", err)
+	}
+
+	ctx := context.Background()
+
 	c := opcua.NewClient(*endpoint, nil)
-	if err := c.Open(); err != nil {
+	c.ConnState = func(s opcua.ConnState) { log.Printf("connection %v", s) }
+	if err := c.Open(ctx); err != nil {
 		log.Fatal(err)
 	}
 	defer c.Close()
 
-	sub, err := c.Subscribe(time.Second)
+	sub, err := c.Subscribe(ctx, &opcua.SubscriptionParameters{Interval: *interval})
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("sub: %v", sub)
+	defer sub.Cancel(ctx)
+	log.Printf("created subscription %d with interval %v", sub.ID, sub.RevisedPublishingInterval)
+
+	if _, err := sub.Monitor(ctx, id); err != nil {
+		log.Fatal(err)
+	}
+
+	for msg := range sub.C {
+		switch m := msg.(type) {
+		case *opcua.DataChangeMessage:
+			if m.Value.Value == nil {
+				log.Printf("%s: status %v", m.Item.NodeID, ua.StatusCode(m.Value.Status))
+				continue
+			}
+			log.Printf("%s: %v", m.Item.NodeID, m.Value.Value.Value)
+		case *opcua.GapMessage:
+			log.Printf("lost notifications %d to %d", m.From, m.To)
+		case *opcua.StatusChangeMessage:
+			log.Printf("status changed: %v", m.Status)
+		case *opcua.ErrorMessage:
+			log.Printf("error: %v", m.Err)
+		}
+	}
 }
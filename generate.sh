@@ -4,3 +4,4 @@ rm -f */*_gen.go
 go run cmd/id/main.go
 go run cmd/status/main.go
 go run cmd/service/*.go
+go run ./cmd/addrspace
@@ -0,0 +1,310 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+// HistoryReadRaw returns an iterator over the stored values of a node
+// between start and end. The values are returned in reverse order if end
+// is before start. Either start or end can be the zero time to read the
+// values from or up to the oldest or newest value.
+//
+// The iterator reads the values page by page as the server returns them
+// and must be closed if it is not read to the end.
+//
+// Specification: Part 11, 6.4.3
+func (c *Client) HistoryReadRaw(ctx context.Context, nodeID *ua.NodeID, start, end time.Time) *HistoryValues {
+	return &HistoryValues{r: c.historyReader(ctx, nodeID, &ua.ReadRawModifiedDetails{
+		StartTime: start,
+		EndTime:   end,
+	})}
+}
+
+// HistoryReadProcessed returns an iterator over the aggregated values of a
+// node between start and end. aggregate is the id of the aggregate
+// function, e.g. id.AggregateFunction_Average, and interval is the length
+// of the intervals for which the aggregate is computed. If interval is 0
+// the aggregate is computed for the whole range. The aggregate
+// configuration of the server is used.
+//
+// The iterator must be closed if it is not read to the end.
+//
+// Specification: Part 11, 6.4.4
+func (c *Client) HistoryReadProcessed(ctx context.Context, nodeID *ua.NodeID, start, end time.Time, interval time.Duration, aggregate uint32) *HistoryValues {
+	return &HistoryValues{r: c.historyReader(ctx, nodeID, &ua.ReadProcessedDetails{
+		StartTime:          start,
+		EndTime:            end,
+		ProcessingInterval: durationMillis(interval),
+		AggregateType:      []*ua.NodeID{ua.NewNumericNodeID(0, aggregate)},
+		AggregateConfiguration: &ua.AggregateConfiguration{
+			UseServerCapabilitiesDefaults: true,
+		},
+	})}
+}
+
+// HistoryReadAtTime returns an iterator over the values of a node at the
+// given times. The server interpolates the values between the stored
+// values with simple bounds. The iterator returns one value for every time
+// in the same order.
+//
+// The iterator must be closed if it is not read to the end.
+//
+// Specification: Part 11, 6.4.5
+func (c *Client) HistoryReadAtTime(ctx context.Context, nodeID *ua.NodeID, times ...time.Time) *HistoryValues {
+	return &HistoryValues{r: c.historyReader(ctx, nodeID, &ua.ReadAtTimeDetails{
+		ReqTimes:        times,
+		UseSimpleBounds: true,
+	})}
+}
+
+// HistoryReadEvents returns an iterator over the stored events of a node
+// which is an event notifier between start and end. The fields of the
+// events are selected by the filter like for MonitorEvents.
+//
+// The iterator must be closed if it is not read to the end.
+//
+// Specification: Part 11, 6.4.2
+func (c *Client) HistoryReadEvents(ctx context.Context, nodeID *ua.NodeID, start, end time.Time, filter *EventFilter) *HistoryEvents {
+	if filter == nil || len(filter.Select) == 0 {
+		return &HistoryEvents{r: &historyReader{err: fmt.Errorf("opcua: event filter has no fields")}}
+	}
+	where, err := filter.Where.ContentFilter()
+	if err != nil {
+		return &HistoryEvents{r: &historyReader{err: err}}
+	}
+
+	it := &HistoryEvents{}
+	for _, o := range filter.Select {
+		it.paths = append(it.paths, o.FieldPath())
+	}
+	it.r = c.historyReader(ctx, nodeID, &ua.ReadEventDetails{
+		StartTime: start,
+		EndTime:   end,
+		Filter: &ua.EventFilter{
+			SelectClauses: filter.Select,
+			WhereClause:   where,
+		},
+	})
+	return it
+}
+
+// HistoryValues is an iterator over the values of a history read.
+//
+//	it := c.HistoryReadRaw(ctx, nodeID, start, end)
+//	defer it.Close()
+//	for it.Next() {
+//		v := it.Value()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type HistoryValues struct {
+	r      *historyReader
+	values []*ua.DataValue
+	value  *ua.DataValue
+}
+
+// Next advances the iterator to the next value. It returns false when
+// there are no more values or an error occurred.
+func (it *HistoryValues) Next() bool {
+	for len(it.values) == 0 {
+		data, ok := it.r.next()
+		if !ok {
+			it.value = nil
+			return false
+		}
+		switch d := data.(type) {
+		case nil:
+		case *ua.HistoryData:
+			it.values = d.DataValues
+		case *ua.HistoryModifiedData:
+			it.values = d.DataValues
+		default:
+			it.r.fail(fmt.Errorf("opcua: invalid history data: %T", data))
+			it.value = nil
+			return false
+		}
+	}
+	it.value, it.values = it.values[0], it.values[1:]
+	return true
+}
+
+// Value returns the current value.
+func (it *HistoryValues) Value() *ua.DataValue {
+	return it.value
+}
+
+// Err returns the error which stopped the iterator, if any. A bad status
+// code of the history read is returned as ua.StatusCode.
+func (it *HistoryValues) Err() error {
+	return it.r.err
+}
+
+// Close releases the continuation point of the server if the iterator
+// was not read to the end. It can be called more than once.
+func (it *HistoryValues) Close() error {
+	it.values = nil
+	return it.r.close()
+}
+
+// HistoryEvents is an iterator over the events of a history read. It is
+// used like HistoryValues.
+type HistoryEvents struct {
+	r      *historyReader
+	paths  []string
+	events []*ua.HistoryEventFieldList
+	fields map[string]*ua.Variant
+}
+
+// Next advances the iterator to the next event. It returns false when
+// there are no more events or an error occurred.
+func (it *HistoryEvents) Next() bool {
+	for len(it.events) == 0 {
+		data, ok := it.r.next()
+		if !ok {
+			it.fields = nil
+			return false
+		}
+		switch d := data.(type) {
+		case nil:
+		case *ua.HistoryEvent:
+			it.events = d.Events
+		default:
+			it.r.fail(fmt.Errorf("opcua: invalid history data: %T", data))
+			it.fields = nil
+			return false
+		}
+	}
+
+	ev := it.events[0]
+	it.events = it.events[1:]
+	it.fields = make(map[string]*ua.Variant, len(it.paths))
+	if ev == nil {
+		return true
+	}
+	for i, v := range ev.EventFields {
+		if i < len(it.paths) {
+			it.fields[it.paths[i]] = v
+		}
+	}
+	return true
+}
+
+// Fields returns the fields of the current event keyed by the browse
+// paths of the event filter like in EventMessage.
+func (it *HistoryEvents) Fields() map[string]*ua.Variant {
+	return it.fields
+}
+
+// Err returns the error which stopped the iterator, if any. A bad status
+// code of the history read is returned as ua.StatusCode.
+func (it *HistoryEvents) Err() error {
+	return it.r.err
+}
+
+// Close releases the continuation point of the server if the iterator
+// was not read to the end. It can be called more than once.
+func (it *HistoryEvents) Close() error {
+	it.events = nil
+	return it.r.close()
+}
+
+// historyReader reads the history of a node with the details page by page
+// and follows the continuation points.
+type historyReader struct {
+	c       *Client
+	ctx     context.Context
+	nodeID  *ua.NodeID
+	details *ua.ExtensionObject
+
+	// cp is the continuation point of the next page.
+	cp   []byte
+	done bool
+	err  error
+}
+
+func (c *Client) historyReader(ctx context.Context, nodeID *ua.NodeID, details interface{}) *historyReader {
+	return &historyReader{
+		c:       c,
+		ctx:     ctx,
+		nodeID:  nodeID,
+		details: ua.NewExtensionObject(details),
+	}
+}
+
+// next reads the next page and returns the decoded history data. The data
+// is nil if the page is empty. It returns false when the history has been
+// read completely or the read failed.
+func (r *historyReader) next() (interface{}, bool) {
+	if r.done || r.err != nil {
+		return nil, false
+	}
+	res, err := r.c.HistoryRead(r.ctx, r.request(false))
+	if err != nil {
+		r.fail(err)
+		return nil, false
+	}
+	if len(res.Results) != 1 {
+		r.fail(fmt.Errorf("opcua: got %d history read results for 1 node", len(res.Results)))
+		return nil, false
+	}
+	hr := res.Results[0]
+	if hr.StatusCode.IsBad() {
+		// the server has released the continuation point
+		r.cp = nil
+		r.fail(hr.StatusCode)
+		return nil, false
+	}
+	r.cp = hr.ContinuationPoint
+	r.done = len(r.cp) == 0
+	if hr.HistoryData == nil {
+		return nil, true
+	}
+	return hr.HistoryData.Value, true
+}
+
+func (r *historyReader) request(release bool) *ua.HistoryReadRequest {
+	return &ua.HistoryReadRequest{
+		HistoryReadDetails:        r.details,
+		TimestampsToReturn:        ua.TimestampsToReturnBoth,
+		ReleaseContinuationPoints: release,
+		NodesToRead: []*ua.HistoryReadValueID{
+			{
+				NodeID:            r.nodeID,
+				DataEncoding:      &ua.QualifiedName{},
+				ContinuationPoint: r.cp,
+			},
+		},
+	}
+}
+
+// fail stops the reader with the error.
+func (r *historyReader) fail(err error) {
+	r.err = err
+	r.done = true
+}
+
+// close releases the continuation point unless the history has been read
+// completely.
+func (r *historyReader) close() error {
+	r.done = true
+	if len(r.cp) == 0 {
+		return nil
+	}
+
+	// the context of the read may be done already
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	_, err := r.c.HistoryRead(ctx, r.request(true))
+	r.cp = nil
+	return err
+}
@@ -0,0 +1,302 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+var testHistoryStart = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testHistory stores the values 0..9 of a variable at one second
+// intervals from testHistoryStart and returns the results of history
+// reads in pages.
+type testHistory struct {
+	mu       sync.Mutex
+	pageSize int
+	pending  map[string][]interface{}
+	released []string
+	next     int
+}
+
+func newTestHistory(pageSize int) *testHistory {
+	return &testHistory{pageSize: pageSize, pending: make(map[string][]interface{})}
+}
+
+func (h *testHistory) value(i int) *ua.DataValue {
+	return &ua.DataValue{
+		EncodingMask:    ua.DataValueValue | ua.DataValueSourceTimestamp,
+		Value:           ua.MustVariant(float64(i)),
+		SourceTimestamp: testHistoryStart.Add(time.Duration(i) * time.Second),
+	}
+}
+
+// index returns the index of the value at t.
+func (h *testHistory) index(t time.Time) int {
+	return int(t.Sub(testHistoryStart) / time.Second)
+}
+
+// results returns all results of a history read.
+func (h *testHistory) results(details interface{}) ([]interface{}, ua.StatusCode) {
+	var results []interface{}
+	switch d := details.(type) {
+	case *ua.ReadRawModifiedDetails:
+		from, to := h.index(d.StartTime), h.index(d.EndTime)
+		step := 1
+		if to < from {
+			step = -1
+		}
+		for i := from; ; i += step {
+			if i >= 0 && i < 10 {
+				results = append(results, h.value(i))
+			}
+			if i == to {
+				break
+			}
+		}
+	case *ua.ReadProcessedDetails:
+		if len(d.AggregateType) != 1 || d.AggregateType[0].IntID() != id.AggregateFunction_Average {
+			return nil, ua.StatusBadAggregateNotSupported
+		}
+		n := int(d.ProcessingInterval / 1000)
+		for i := h.index(d.StartTime); i+n <= h.index(d.EndTime); i += n {
+			sum := 0
+			for k := i; k < i+n; k++ {
+				sum += k
+			}
+			v := h.value(i)
+			v.Value = ua.MustVariant(float64(sum) / float64(n))
+			results = append(results, v)
+		}
+	case *ua.ReadAtTimeDetails:
+		for _, t := range d.ReqTimes {
+			v := h.value(h.index(t))
+			v.SourceTimestamp = t
+			results = append(results, v)
+		}
+	case *ua.ReadEventDetails:
+		for i := h.index(d.StartTime); i < h.index(d.EndTime); i++ {
+			ev := &ua.HistoryEventFieldList{}
+			for k := range d.Filter.SelectClauses {
+				ev.EventFields = append(ev.EventFields, ua.MustVariant(fmt.Sprintf("%d.%d", i, k)))
+			}
+			results = append(results, ev)
+		}
+	default:
+		return nil, ua.StatusBadHistoryOperationUnsupported
+	}
+	return results, ua.StatusOK
+}
+
+// page returns the result with the first page of the results.
+// h.mu must be held.
+func (h *testHistory) page(results []interface{}) *ua.HistoryReadResult {
+	r := &ua.HistoryReadResult{StatusCode: ua.StatusOK}
+	if len(results) > h.pageSize {
+		h.next++
+		cp := fmt.Sprintf("cp%d", h.next)
+		h.pending[cp] = results[h.pageSize:]
+		r.ContinuationPoint = []byte(cp)
+		results = results[:h.pageSize]
+	}
+
+	var data interface{}
+	if len(results) > 0 {
+		if _, ok := results[0].(*ua.DataValue); ok {
+			d := &ua.HistoryData{}
+			for _, v := range results {
+				d.DataValues = append(d.DataValues, v.(*ua.DataValue))
+			}
+			data = d
+		} else {
+			d := &ua.HistoryEvent{}
+			for _, v := range results {
+				d.Events = append(d.Events, v.(*ua.HistoryEventFieldList))
+			}
+			data = d
+		}
+	}
+	r.HistoryData = ua.NewExtensionObject(data)
+	return r
+}
+
+func (h *testHistory) historyRead(v interface{}) (interface{}, error) {
+	req := v.(*ua.HistoryReadRequest)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := &ua.HistoryReadResponse{}
+	for _, n := range req.NodesToRead {
+		if len(n.ContinuationPoint) == 0 {
+			results, code := h.results(req.HistoryReadDetails.Value)
+			if code.IsBad() {
+				res.Results = append(res.Results, &ua.HistoryReadResult{StatusCode: code, HistoryData: ua.NewExtensionObject(nil)})
+				continue
+			}
+			res.Results = append(res.Results, h.page(results))
+			continue
+		}
+
+		cp := string(n.ContinuationPoint)
+		results, ok := h.pending[cp]
+		delete(h.pending, cp)
+		switch {
+		case !ok:
+			res.Results = append(res.Results, &ua.HistoryReadResult{StatusCode: ua.StatusBadContinuationPointInvalid, HistoryData: ua.NewExtensionObject(nil)})
+		case req.ReleaseContinuationPoints:
+			h.released = append(h.released, cp)
+			res.Results = append(res.Results, &ua.HistoryReadResult{StatusCode: ua.StatusOK, HistoryData: ua.NewExtensionObject(nil)})
+		default:
+			res.Results = append(res.Results, h.page(results))
+		}
+	}
+	return res, nil
+}
+
+func newHistoryTestClient(t *testing.T, h *testHistory) (c *Client, stop func()) {
+	t.Helper()
+	srv := &Server{EndpointURL: testServerEndpoint}
+	srv.Handle(&ua.HistoryReadRequest{}, h.historyRead)
+	stopServer := startTestServer(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c = NewClient(testServerEndpoint, nil)
+	if err := c.Open(ctx); err != nil {
+		stopServer()
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close()
+		stopServer()
+	}
+}
+
+// historyValues returns the values and the seconds of the source
+// timestamps from the start of the history.
+func historyValues(t *testing.T, it *HistoryValues) (values []float64, secs []int) {
+	t.Helper()
+	defer it.Close()
+	for it.Next() {
+		v := it.Value()
+		values = append(values, v.Value.Value.(float64))
+		secs = append(secs, int(v.SourceTimestamp.Sub(testHistoryStart)/time.Second))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return values, secs
+}
+
+func TestHistoryRead(t *testing.T) {
+	h := newTestHistory(3)
+	c, stop := newHistoryTestClient(t, h)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	at := func(sec int) time.Time {
+		return testHistoryStart.Add(time.Duration(sec) * time.Second)
+	}
+
+	t.Run("raw", func(t *testing.T) {
+		values, secs := historyValues(t, c.HistoryReadRaw(ctx, testVariable, at(1), at(8)))
+		verify.Values(t, "", values, []float64{1, 2, 3, 4, 5, 6, 7, 8})
+		verify.Values(t, "", secs, []int{1, 2, 3, 4, 5, 6, 7, 8})
+	})
+
+	t.Run("raw reverse", func(t *testing.T) {
+		values, _ := historyValues(t, c.HistoryReadRaw(ctx, testVariable, at(4), at(0)))
+		verify.Values(t, "", values, []float64{4, 3, 2, 1, 0})
+	})
+
+	t.Run("processed", func(t *testing.T) {
+		values, secs := historyValues(t, c.HistoryReadProcessed(ctx, testVariable, at(0), at(8), 2*time.Second, id.AggregateFunction_Average))
+		verify.Values(t, "", values, []float64{0.5, 2.5, 4.5, 6.5})
+		verify.Values(t, "", secs, []int{0, 2, 4, 6})
+	})
+
+	t.Run("processed unsupported", func(t *testing.T) {
+		it := c.HistoryReadProcessed(ctx, testVariable, at(0), at(8), time.Second, id.AggregateFunction_Total)
+		defer it.Close()
+		if it.Next() {
+			t.Fatal("got value want none")
+		}
+		if got, want := it.Err(), error(ua.StatusBadAggregateNotSupported); got != want {
+			t.Fatalf("got error %v want %v", got, want)
+		}
+	})
+
+	t.Run("at time", func(t *testing.T) {
+		values, secs := historyValues(t, c.HistoryReadAtTime(ctx, testVariable, at(7), at(2), at(5)))
+		verify.Values(t, "", values, []float64{7, 2, 5})
+		verify.Values(t, "", secs, []int{7, 2, 5})
+	})
+
+	t.Run("events", func(t *testing.T) {
+		filter := &EventFilter{Select: []*ua.SimpleAttributeOperand{ua.EventField("EventId"), ua.EventField("Severity")}}
+		it := c.HistoryReadEvents(ctx, ua.NewNumericNodeID(0, id.Server), at(3), at(7), filter)
+		defer it.Close()
+		var events []map[string]string
+		for it.Next() {
+			ev := map[string]string{}
+			for k, v := range it.Fields() {
+				ev[k] = v.Value.(string)
+			}
+			events = append(events, ev)
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, "", events, []map[string]string{
+			{"EventId": "3.0", "Severity": "3.1"},
+			{"EventId": "4.0", "Severity": "4.1"},
+			{"EventId": "5.0", "Severity": "5.1"},
+			{"EventId": "6.0", "Severity": "6.1"},
+		})
+	})
+
+	t.Run("events without fields", func(t *testing.T) {
+		it := c.HistoryReadEvents(ctx, ua.NewNumericNodeID(0, id.Server), at(3), at(7), &EventFilter{})
+		if it.Next() || it.Err() == nil {
+			t.Fatal("want error")
+		}
+		if err := it.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("release continuation point", func(t *testing.T) {
+		it := c.HistoryReadRaw(ctx, testVariable, at(0), at(9))
+		for i := 0; i < 4; i++ {
+			if !it.Next() {
+				t.Fatal(it.Err())
+			}
+		}
+		if err := it.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if it.Next() {
+			t.Fatal("got value after close")
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if got, want := len(h.pending), 0; got != want {
+			t.Fatalf("got %d pending continuation points want %d", got, want)
+		}
+		if got, want := len(h.released), 1; got != want {
+			t.Fatalf("got %d released continuation points want %d", got, want)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package logger defines the leveled and structured logger which is used
+// by the opcua packages.
+//
+// The Logger interface is implemented by *slog.Logger from the log/slog
+// package. By default nothing is logged.
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+// Logger is a leveled and structured logger. The args are alternating
+// keys and values which describe the event, e.g.
+//
+//	l.Debug("recv", "conn", 1, "reqid", 2, "size", 8192)
+//
+// *slog.Logger implements this interface.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Nop is a Logger which discards all messages.
+var Nop Logger = nop{}
+
+type nop struct{}
+
+func (nop) Debug(string, ...interface{}) {}
+func (nop) Info(string, ...interface{})  {}
+func (nop) Warn(string, ...interface{})  {}
+func (nop) Error(string, ...interface{}) {}
+
+// Or returns l or Nop if l is nil.
+func Or(l Logger) Logger {
+	if l == nil {
+		return Nop
+	}
+	return l
+}
+
+// Level is the severity of a log message.
+type Level int
+
+// Log levels. The values match the levels of log/slog.
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// Std is a Logger which writes all messages with at least the
+// given level to a standard library logger in the format
+//
+//	LEVEL msg key=value key=value
+type Std struct {
+	Logger *log.Logger
+	Level  Level
+}
+
+// NewStd returns a logger which writes the messages with at least
+// the given level to l.
+func NewStd(l *log.Logger, level Level) *Std {
+	return &Std{Logger: l, Level: level}
+}
+
+func (l *Std) Debug(msg string, args ...interface{}) { l.log(LevelDebug, msg, args) }
+func (l *Std) Info(msg string, args ...interface{})  { l.log(LevelInfo, msg, args) }
+func (l *Std) Warn(msg string, args ...interface{})  { l.log(LevelWarn, msg, args) }
+func (l *Std) Error(msg string, args ...interface{}) { l.log(LevelError, msg, args) }
+
+func (l *Std) log(level Level, msg string, args []interface{}) {
+	if level < l.Level {
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fmt.Fprintf(&b, " !BADKEY=%v", args[i])
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	l.Logger.Output(3, b.String())
+}
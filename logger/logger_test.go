@@ -0,0 +1,65 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package logger
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestStd(t *testing.T) {
+	cases := []struct {
+		name  string
+		level Level
+		log   func(l Logger)
+		out   string
+	}{
+		{
+			name:  "debug",
+			level: LevelDebug,
+			log:   func(l Logger) { l.Debug("recv", "conn", 1, "size", 8192) },
+			out:   "DEBUG recv conn=1 size=8192\n",
+		},
+		{
+			name:  "filtered",
+			level: LevelInfo,
+			log:   func(l Logger) { l.Debug("recv", "conn", 1) },
+			out:   "",
+		},
+		{
+			name:  "error",
+			level: LevelInfo,
+			log:   func(l Logger) { l.Error("read failed", "err", "EOF") },
+			out:   "ERROR read failed err=EOF\n",
+		},
+		{
+			name:  "missing value",
+			level: LevelInfo,
+			log:   func(l Logger) { l.Warn("close", "conn") },
+			out:   "WARN close !BADKEY=conn\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var b bytes.Buffer
+			c.log(NewStd(log.New(&b, "", 0), c.level))
+			if got, want := b.String(), c.out; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestOr(t *testing.T) {
+	if got, want := Or(nil), Nop; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	l := NewStd(log.New(&bytes.Buffer{}, "", 0), LevelInfo)
+	if got, want := Or(l), Logger(l); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import "log/slog"
+
+// verify that *slog.Logger can be used as Logger.
+var _ Logger = (*slog.Logger)(nil)
@@ -1,11 +1,26 @@
 package opcua
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/logger"
 	"github.com/gopcua/opcua/ua"
 )
 
+const (
+	// browseMaxReferences is the maximum number of references which the
+	// server should return for a node at once.
+	browseMaxReferences = 1000
+
+	// releaseTimeout is the time to wait for the release of a
+	// continuation point.
+	releaseTimeout = 5 * time.Second
+)
+
 // Node is a high-level object to interact with a node in the
 // address space. It provides common convenience functions to
 // access and manipulate the common attributes of a node.
@@ -17,8 +32,8 @@ type Node struct {
 }
 
 // NodeClass returns the node class attribute.
-func (a *Node) NodeClass() (ua.NodeClass, error) {
-	v, err := a.Attribute(ua.IntegerIDNodeClass)
+func (a *Node) NodeClass(ctx context.Context) (ua.NodeClass, error) {
+	v, err := a.Attribute(ctx, ua.IntegerIDNodeClass)
 	if err != nil {
 		return 0, err
 	}
@@ -26,8 +41,8 @@ func (a *Node) NodeClass() (ua.NodeClass, error) {
 }
 
 // BrowseName returns the browse name of the node.
-func (a *Node) BrowseName() (*ua.QualifiedName, error) {
-	v, err := a.Attribute(ua.IntegerIDBrowseName)
+func (a *Node) BrowseName(ctx context.Context) (*ua.QualifiedName, error) {
+	v, err := a.Attribute(ctx, ua.IntegerIDBrowseName)
 	if err != nil {
 		return nil, err
 	}
@@ -35,8 +50,8 @@ func (a *Node) BrowseName() (*ua.QualifiedName, error) {
 }
 
 // DisplayName returns the display name of the node.
-func (a *Node) DisplayName() (*ua.LocalizedText, error) {
-	v, err := a.Attribute(ua.IntegerIDDisplayName)
+func (a *Node) DisplayName(ctx context.Context) (*ua.LocalizedText, error) {
+	v, err := a.Attribute(ctx, ua.IntegerIDDisplayName)
 	if err != nil {
 		return nil, err
 	}
@@ -44,46 +59,330 @@ func (a *Node) DisplayName() (*ua.LocalizedText, error) {
 }
 
 // Value returns the value of the node.
-func (a *Node) Value() (*ua.Variant, error) {
-	return a.Attribute(ua.IntegerIDValue)
+func (a *Node) Value(ctx context.Context) (*ua.Variant, error) {
+	return a.Attribute(ctx, ua.IntegerIDValue)
 }
 
 // Attribute returns the attribute of the node. with the given id.
-func (a *Node) Attribute(attrID uint32) (*ua.Variant, error) {
+func (a *Node) Attribute(ctx context.Context, attrID uint32) (*ua.Variant, error) {
 	rv := &ua.ReadValueID{NodeID: a.ID, AttributeID: attrID, DataEncoding: &ua.QualifiedName{}}
 	req := &ua.ReadRequest{NodesToRead: []*ua.ReadValueID{rv}}
-	res, err := a.c.Read(req)
+	res, err := a.c.Read(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 	if len(res.Results) == 0 {
 		return nil, nil
 	}
+	if err := ua.DataValuesError(res.Results); err != nil {
+		return nil, err.(ua.MultiError)[0]
+	}
 	return res.Results[0].Value, nil
 }
 
-// References retrns all references for the node.
-// todo(fs): this is not complete since it only returns the
-// todo(fs): top-level reference at this point.
-func (a *Node) References(refs *ua.NodeID) (*ua.BrowseResponse, error) {
-	desc := &ua.BrowseDescription{
-		NodeID:          a.ID,
-		BrowseDirection: ua.BrowseDirectionBoth,
-		ReferenceTypeID: refs,
-		IncludeSubtypes: true,
-		NodeClassMask:   uint32(ua.NodeClassAll),
-		ResultMask:      uint32(ua.BrowseResultMaskAll),
+// SetValue writes the value of the node. v is converted with ua.NewVariant
+// unless it is a *ua.Variant and must have the data type of the node.
+func (a *Node) SetValue(ctx context.Context, v interface{}) error {
+	val, ok := v.(*ua.Variant)
+	if !ok {
+		var err error
+		if val, err = ua.NewVariant(v); err != nil {
+			return err
+		}
+	}
+	req := &ua.WriteRequest{
+		NodesToWrite: []*ua.WriteValue{
+			{
+				NodeID:      a.ID,
+				AttributeID: ua.IntegerIDValue,
+				Value:       &ua.DataValue{EncodingMask: ua.DataValueValue, Value: val},
+			},
+		},
+	}
+	res, err := a.c.Write(ctx, req)
+	if err != nil {
+		return err
+	}
+	if len(res.Results) != 1 {
+		return fmt.Errorf("invalid response: got %d results", len(res.Results))
+	}
+	if err := ua.StatusCodesError(res.Results); err != nil {
+		return err.(ua.MultiError)[0]
+	}
+	return nil
+}
+
+// Call calls the method of the object node and returns the output
+// arguments. The arguments are converted to the data types of the
+// InputArguments property of the method with ua.NewVariantAs, e.g. an int
+// to an Int32. Arguments which are not of a built-in data type, e.g.
+// structures and enumerations, are converted with ua.NewVariant and must
+// have the right Go type.
+//
+// If the server rejects some of the arguments the error is a
+// ua.MultiError with their status codes.
+//
+// Specification: Part 4, 5.11.2
+func (a *Node) Call(ctx context.Context, methodID *ua.NodeID, args ...interface{}) ([]*ua.Variant, error) {
+	inputs, err := a.c.Node(methodID).inputArguments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(inputs) {
+		return nil, fmt.Errorf("opcua: method %s has %d input arguments, got %d", methodID, len(inputs), len(args))
+	}
+	vals := make([]*ua.Variant, len(args))
+	for i, arg := range inputs {
+		if vals[i], err = argumentValue(arg, args[i]); err != nil {
+			return nil, fmt.Errorf("opcua: invalid argument %q: %s", arg.Name, err)
+		}
 	}
 
+	req := &ua.CallRequest{
+		MethodsToCall: []*ua.CallMethodRequest{
+			{ObjectID: a.ID, MethodID: methodID, InputArguments: vals},
+		},
+	}
+	res, err := a.c.Call(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Results) != 1 {
+		return nil, fmt.Errorf("invalid response: got %d results", len(res.Results))
+	}
+	r := res.Results[0]
+	if err := ua.StatusCodesError(r.InputArgumentResults); err != nil {
+		return nil, err
+	}
+	if r.StatusCode.IsBad() {
+		return nil, r.StatusCode
+	}
+	return r.OutputArguments, nil
+}
+
+// inputArguments returns the value of the InputArguments property of a
+// method node. A method without the property has no input arguments.
+func (a *Node) inputArguments(ctx context.Context) ([]*ua.Argument, error) {
+	req := &ua.BrowseRequest{
+		View: &ua.ViewDescription{ViewID: ua.NewTwoByteNodeID(0)},
+		NodesToBrowse: []*ua.BrowseDescription{
+			{
+				NodeID:          a.ID,
+				BrowseDirection: ua.BrowseDirectionForward,
+				ReferenceTypeID: ua.NewNumericNodeID(0, id.HasProperty),
+				IncludeSubtypes: true,
+				NodeClassMask:   uint32(ua.NodeClassVariable),
+				ResultMask:      uint32(ua.BrowseResultMaskBrowseName),
+			},
+		},
+	}
+	res, err := a.c.Browse(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Results) != 1 {
+		return nil, fmt.Errorf("invalid response: got %d results", len(res.Results))
+	}
+	if err := ua.BrowseResultsError(res.Results); err != nil {
+		return nil, err.(ua.MultiError)[0]
+	}
+
+	for _, ref := range res.Results[0].References {
+		if ref.BrowseName == nil || ref.BrowseName.NamespaceIndex != 0 || ref.BrowseName.Name != "InputArguments" {
+			continue
+		}
+		v, err := a.c.Node(ref.NodeID.NodeID).Value(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, nil
+		}
+		objs, ok := v.Value.([]*ua.ExtensionObject)
+		if !ok {
+			return nil, fmt.Errorf("opcua: invalid input arguments of method %s: %T", a.ID, v.Value)
+		}
+		args := make([]*ua.Argument, len(objs))
+		for i, o := range objs {
+			if args[i], ok = o.Value.(*ua.Argument); !ok {
+				return nil, fmt.Errorf("opcua: invalid input argument %d of method %s: %T", i, a.ID, o.Value)
+			}
+		}
+		return args, nil
+	}
+	return nil, nil
+}
+
+// argumentValue converts v to the data type and the value rank of the
+// argument.
+func argumentValue(arg *ua.Argument, v interface{}) (*ua.Variant, error) {
+	var val *ua.Variant
+	var err error
+	dt := arg.DataType
+	if dt != nil && dt.Namespace() == 0 && dt.IntID() >= id.Boolean && dt.IntID() <= id.DiagnosticInfo && dt.IntID() != id.BaseDataType {
+		// the ids of the built-in data types are the type ids
+		val, err = ua.NewVariantAs(byte(dt.IntID()), v)
+	} else {
+		val, err = ua.NewVariant(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	isArray := val.Has(ua.VariantArrayValues)
+	switch {
+	case arg.ValueRank == -1 && isArray:
+		return nil, fmt.Errorf("got array want scalar")
+	case arg.ValueRank >= 0 && !isArray:
+		return nil, fmt.Errorf("got scalar want array")
+	}
+	return val, nil
+}
+
+// References returns all references of the node of the reference type
+// and its subtypes in both directions. The continuation points of the
+// server are followed until all references have been received.
+func (a *Node) References(ctx context.Context, refs *ua.NodeID) (*ua.BrowseResponse, error) {
+	res := &ua.BrowseResult{StatusCode: ua.StatusOK}
+	err := a.browse(ctx, ua.BrowseDirectionBoth, refs, ua.NodeClassAll, func(r *ua.ReferenceDescription) error {
+		res.References = append(res.References, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ua.BrowseResponse{Results: []*ua.BrowseResult{res}}, nil
+}
+
+// Children returns the nodes which the node references with a forward
+// hierarchical reference, e.g. the nodes of a folder or the components
+// and properties of an object.
+func (a *Node) Children(ctx context.Context) ([]*Node, error) {
+	var nodes []*Node
+	err := a.browse(ctx, ua.BrowseDirectionForward, ua.NewNumericNodeID(0, id.HierarchicalReferences), ua.NodeClassAll, func(r *ua.ReferenceDescription) error {
+		if n := a.c.refNode(r); n != nil {
+			nodes = append(nodes, n)
+		}
+		return nil
+	})
+	return nodes, err
+}
+
+// Parent returns the node which references the node with a hierarchical
+// reference or nil if there is none, e.g. for the Root folder. If there
+// are several parents one of them is returned.
+func (a *Node) Parent(ctx context.Context) (*Node, error) {
+	return a.first(ctx, ua.BrowseDirectionInverse, id.HierarchicalReferences)
+}
+
+// TypeDefinition returns the type definition of an object or a variable
+// node or nil if the node has none.
+func (a *Node) TypeDefinition(ctx context.Context) (*Node, error) {
+	return a.first(ctx, ua.BrowseDirectionForward, id.HasTypeDefinition)
+}
+
+// errBrowseDone stops browsing after the first reference.
+var errBrowseDone = errors.New("browse done")
+
+// first returns the target of the first local reference of the type and
+// its subtypes in the direction or nil if there is none.
+func (a *Node) first(ctx context.Context, dir ua.BrowseDirection, refType uint32) (*Node, error) {
+	var n *Node
+	err := a.browse(ctx, dir, ua.NewNumericNodeID(0, refType), ua.NodeClassAll, func(r *ua.ReferenceDescription) error {
+		if n = a.c.refNode(r); n != nil {
+			return errBrowseDone
+		}
+		return nil
+	})
+	if err != nil && err != errBrowseDone {
+		return nil, err
+	}
+	return n, nil
+}
+
+// refNode returns the target node of the reference or nil if it is on
+// another server.
+func (c *Client) refNode(r *ua.ReferenceDescription) *Node {
+	if r.NodeID == nil || r.NodeID.ServerIndex != 0 || r.NodeID.NodeID == nil {
+		return nil
+	}
+	return c.Node(r.NodeID.NodeID)
+}
+
+// browse calls fn for the references of the node of the reference type
+// and its subtypes in the direction to nodes of the classes in the mask.
+// It follows the continuation points of the server with BrowseNext
+// requests. If fn returns an error browsing stops, the pending
+// continuation point is released and the error is returned.
+//
+// Specification: Part 4, 5.8.2 and 5.8.3
+func (a *Node) browse(ctx context.Context, dir ua.BrowseDirection, refType *ua.NodeID, mask ua.NodeClass, fn func(*ua.ReferenceDescription) error) error {
 	req := &ua.BrowseRequest{
 		View: &ua.ViewDescription{
 			ViewID:    ua.NewTwoByteNodeID(0),
 			Timestamp: time.Now(),
 		},
-		RequestedMaxReferencesPerNode: 1000,
-		NodesToBrowse:                 []*ua.BrowseDescription{desc},
+		RequestedMaxReferencesPerNode: browseMaxReferences,
+		NodesToBrowse: []*ua.BrowseDescription{
+			{
+				NodeID:          a.ID,
+				BrowseDirection: dir,
+				ReferenceTypeID: refType,
+				IncludeSubtypes: true,
+				NodeClassMask:   uint32(mask),
+				ResultMask:      uint32(ua.BrowseResultMaskAll),
+			},
+		},
 	}
+	res, err := a.c.Browse(ctx, req)
+	if err != nil {
+		return err
+	}
+	results := res.Results
+
+	for {
+		if len(results) != 1 {
+			return fmt.Errorf("invalid response: got %d results", len(results))
+		}
+		r := results[0]
+		if r.StatusCode.IsBad() {
+			return r.StatusCode
+		}
+		for _, ref := range r.References {
+			if err := fn(ref); err != nil {
+				a.c.releaseContinuationPoint(r.ContinuationPoint)
+				return err
+			}
+		}
+		if len(r.ContinuationPoint) == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			a.c.releaseContinuationPoint(r.ContinuationPoint)
+			return err
+		}
 
-	return a.c.Browse(req)
-	// implement browse_next
+		next, err := a.c.BrowseNext(ctx, &ua.BrowseNextRequest{ContinuationPoints: [][]byte{r.ContinuationPoint}})
+		if err != nil {
+			return err
+		}
+		results = next.Results
+	}
+}
+
+// releaseContinuationPoint releases the continuation point of an
+// incomplete browse on the server. Errors are only logged since the
+// server releases it with the session anyway.
+func (c *Client) releaseContinuationPoint(cp []byte) {
+	if len(cp) == 0 {
+		return
+	}
+	// the context of the browse may be done already
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	req := &ua.BrowseNextRequest{ReleaseContinuationPoints: true, ContinuationPoints: [][]byte{cp}}
+	if _, err := c.BrowseNext(ctx, req); err != nil {
+		logger.Or(c.Logger).Debug("opcua: release continuation point failed", "err", err)
+	}
 }
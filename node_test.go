@@ -0,0 +1,441 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+var (
+	testObject     = ua.NewStringNodeID(1, "object")
+	testMethod     = ua.NewStringNodeID(1, "scale")
+	testMethodArgs = ua.NewStringNodeID(1, "scale.InputArguments")
+	testVariable   = ua.NewStringNodeID(1, "variable")
+)
+
+// newNodeTestServer starts a server with the method testMethod which
+// multiplies an array of doubles with an Int16 factor and the writable
+// variable testVariable. The returned function stops it.
+func newNodeTestServer(t *testing.T) (value func() *ua.Variant, stop func()) {
+	t.Helper()
+	srv := &Server{EndpointURL: testServerEndpoint}
+
+	srv.Handle(&ua.BrowseRequest{}, func(v interface{}) (interface{}, error) {
+		req := v.(*ua.BrowseRequest)
+		res := &ua.BrowseResponse{}
+		for _, d := range req.NodesToBrowse {
+			r := &ua.BrowseResult{}
+			if d.NodeID.String() == testMethod.String() && d.ReferenceTypeID.IntID() == id.HasProperty {
+				r.References = []*ua.ReferenceDescription{{
+					ReferenceTypeID: ua.NewNumericNodeID(0, id.HasProperty),
+					IsForward:       true,
+					NodeID:          ua.NewExpandedNodeID(false, false, testMethodArgs, "", 0),
+					BrowseName:      &ua.QualifiedName{Name: "InputArguments"},
+					DisplayName:     &ua.LocalizedText{},
+					TypeDefinition:  ua.NewExpandedNodeID(false, false, ua.NewTwoByteNodeID(0), "", 0),
+				}}
+			}
+			res.Results = append(res.Results, r)
+		}
+		return res, nil
+	})
+
+	args := ua.MustVariant([]*ua.ExtensionObject{
+		ua.NewExtensionObject(&ua.Argument{
+			Name:        "values",
+			DataType:    ua.NewNumericNodeID(0, id.Double),
+			ValueRank:   1,
+			Description: &ua.LocalizedText{},
+		}),
+		ua.NewExtensionObject(&ua.Argument{
+			Name:        "factor",
+			DataType:    ua.NewNumericNodeID(0, id.Int16),
+			ValueRank:   -1,
+			Description: &ua.LocalizedText{},
+		}),
+	})
+	srv.Handle(&ua.ReadRequest{}, func(v interface{}) (interface{}, error) {
+		req := v.(*ua.ReadRequest)
+		res := &ua.ReadResponse{}
+		for _, n := range req.NodesToRead {
+			if n.NodeID.String() != testMethodArgs.String() {
+				res.Results = append(res.Results, &ua.DataValue{EncodingMask: ua.DataValueStatus, Status: uint32(ua.StatusBadNodeIDUnknown)})
+				continue
+			}
+			res.Results = append(res.Results, &ua.DataValue{EncodingMask: ua.DataValueValue, Value: args})
+		}
+		return res, nil
+	})
+
+	srv.Handle(&ua.CallRequest{}, func(v interface{}) (interface{}, error) {
+		req := v.(*ua.CallRequest)
+		res := &ua.CallResponse{}
+		for _, m := range req.MethodsToCall {
+			r := &ua.CallMethodResult{}
+			res.Results = append(res.Results, r)
+			if m.MethodID.String() != testMethod.String() {
+				r.StatusCode = ua.StatusBadMethodInvalid
+				continue
+			}
+			values, ok0 := m.InputArguments[0].Value.([]float64)
+			factor, ok1 := m.InputArguments[1].Value.(int16)
+			if !ok0 || !ok1 {
+				r.StatusCode = ua.StatusBadInvalidArgument
+				r.InputArgumentResults = []ua.StatusCode{ua.StatusOK, ua.StatusOK}
+				if !ok0 {
+					r.InputArgumentResults[0] = ua.StatusBadTypeMismatch
+				}
+				if !ok1 {
+					r.InputArgumentResults[1] = ua.StatusBadTypeMismatch
+				}
+				continue
+			}
+			out := make([]float64, len(values))
+			for i, x := range values {
+				out[i] = x * float64(factor)
+			}
+			r.OutputArguments = []*ua.Variant{ua.MustVariant(out)}
+		}
+		return res, nil
+	})
+
+	var val *ua.Variant
+	done := make(chan struct{}, 1)
+	srv.Handle(&ua.WriteRequest{}, func(v interface{}) (interface{}, error) {
+		req := v.(*ua.WriteRequest)
+		res := &ua.WriteResponse{}
+		for _, w := range req.NodesToWrite {
+			if w.NodeID.String() != testVariable.String() || w.AttributeID != ua.IntegerIDValue {
+				res.Results = append(res.Results, ua.StatusBadNodeIDUnknown)
+				continue
+			}
+			val = w.Value.Value
+			res.Results = append(res.Results, ua.StatusOK)
+		}
+		done <- struct{}{}
+		return res, nil
+	})
+
+	// the value is read after the write has been handled
+	value = func() *ua.Variant {
+		<-done
+		return val
+	}
+	return value, startTestServer(t, srv)
+}
+
+func TestNodeCall(t *testing.T) {
+	_, stop := newNodeTestServer(t)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c := NewClient(testServerEndpoint, nil)
+	if err := c.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	obj := c.Node(testObject)
+
+	out, err := obj.Call(ctx, testMethod, []int{1, 2}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "", out, []*ua.Variant{ua.MustVariant([]float64{3, 6})})
+
+	cases := []struct {
+		name string
+		args []interface{}
+	}{
+		{"missing argument", []interface{}{[]float64{1}}},
+		{"scalar for array", []interface{}{1.5, 3}},
+		{"array for scalar", []interface{}{[]float64{1}, []int{3}}},
+		{"overflow", []interface{}{[]float64{1}, 70000}},
+		{"wrong type", []interface{}{[]float64{1}, "3"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := obj.Call(ctx, testMethod, tc.args...); err == nil {
+				t.Fatal("got nil want error")
+			}
+		})
+	}
+
+	t.Run("rejected argument", func(t *testing.T) {
+		// variants are not converted
+		_, err := obj.Call(ctx, testMethod, []float64{1}, ua.MustVariant(int32(3)))
+		verify.Values(t, "", err, ua.MultiError{nil, ua.StatusBadTypeMismatch})
+	})
+}
+
+func TestNodeSetValue(t *testing.T) {
+	value, stop := newNodeTestServer(t)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c := NewClient(testServerEndpoint, nil)
+	if err := c.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Node(testVariable).SetValue(ctx, []int32{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "", value(), ua.MustVariant([]int32{1, 2}))
+
+	if err := c.Node(testObject).SetValue(ctx, int32(1)); err != ua.StatusBadNodeIDUnknown {
+		t.Fatalf("got error %v want %v", err, ua.StatusBadNodeIDUnknown)
+	}
+	if err := c.Node(testVariable).SetValue(ctx, struct{}{}); err == nil {
+		t.Fatal("got nil want error")
+	}
+}
+
+// testTree implements the Browse and the BrowseNext service of the test
+// server for a tree of folders with string ids in namespace 1. It returns
+// at most pageSize references at once.
+type testTree struct {
+	mu       sync.Mutex
+	children map[string][]string
+	pageSize int
+
+	// pending are the remaining references of the continuation points
+	// and released are the released continuation points.
+	pending  map[string][]*ua.ReferenceDescription
+	released []string
+	next     int
+}
+
+func newTestTree(pageSize int) *testTree {
+	return &testTree{
+		// b references a and the root which must not be visited again
+		children: map[string][]string{
+			"root": {"a", "b", "c"},
+			"a":    {"a1", "a2", "a3"},
+			"b":    {"a", "root", "b.1"},
+		},
+		pageSize: pageSize,
+		pending:  make(map[string][]*ua.ReferenceDescription),
+	}
+}
+
+func (tr *testTree) register(srv *Server) {
+	srv.Handle(&ua.BrowseRequest{}, tr.browse)
+	srv.Handle(&ua.BrowseNextRequest{}, tr.browseNext)
+}
+
+func (tr *testTree) ref(refType uint32, forward bool, target *ua.NodeID, name string) *ua.ReferenceDescription {
+	return &ua.ReferenceDescription{
+		ReferenceTypeID: ua.NewNumericNodeID(0, refType),
+		IsForward:       forward,
+		NodeID:          ua.NewExpandedNodeID(false, false, target, "", 0),
+		BrowseName:      &ua.QualifiedName{NamespaceIndex: uint16(target.Namespace()), Name: name},
+		DisplayName:     &ua.LocalizedText{},
+		NodeClass:       ua.NodeClassObject,
+		TypeDefinition:  ua.NewExpandedNodeID(false, false, ua.NewNumericNodeID(0, id.FolderType), "", 0),
+	}
+}
+
+// refs returns the references of the node which match the description.
+// Only the reference types used by the client are supported.
+func (tr *testTree) refs(d *ua.BrowseDescription) []*ua.ReferenceDescription {
+	node := d.NodeID.StringID()
+	var refs []*ua.ReferenceDescription
+	switch d.ReferenceTypeID.IntID() {
+	case id.HasTypeDefinition:
+		if node != "c" && d.BrowseDirection == ua.BrowseDirectionForward {
+			refs = append(refs, tr.ref(id.HasTypeDefinition, true, ua.NewNumericNodeID(0, id.FolderType), "FolderType"))
+		}
+	case id.HierarchicalReferences:
+		if d.BrowseDirection != ua.BrowseDirectionInverse {
+			for _, c := range tr.children[node] {
+				refs = append(refs, tr.ref(id.Organizes, true, ua.NewStringNodeID(1, c), c))
+			}
+		}
+		if d.BrowseDirection == ua.BrowseDirectionForward {
+			break
+		}
+		var parents []string
+		for p, cs := range tr.children {
+			for _, c := range cs {
+				if c == node {
+					parents = append(parents, p)
+				}
+			}
+		}
+		sort.Strings(parents)
+		for _, p := range parents {
+			refs = append(refs, tr.ref(id.Organizes, false, ua.NewStringNodeID(1, p), p))
+		}
+	}
+	return refs
+}
+
+// page returns the result with the first page of the references.
+// tr.mu must be held.
+func (tr *testTree) page(refs []*ua.ReferenceDescription) *ua.BrowseResult {
+	r := &ua.BrowseResult{StatusCode: ua.StatusOK, References: refs}
+	if len(refs) > tr.pageSize {
+		tr.next++
+		cp := fmt.Sprintf("cp%d", tr.next)
+		tr.pending[cp] = refs[tr.pageSize:]
+		r.References = refs[:tr.pageSize]
+		r.ContinuationPoint = []byte(cp)
+	}
+	return r
+}
+
+func (tr *testTree) browse(v interface{}) (interface{}, error) {
+	req := v.(*ua.BrowseRequest)
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	res := &ua.BrowseResponse{}
+	for _, d := range req.NodesToBrowse {
+		res.Results = append(res.Results, tr.page(tr.refs(d)))
+	}
+	return res, nil
+}
+
+func (tr *testTree) browseNext(v interface{}) (interface{}, error) {
+	req := v.(*ua.BrowseNextRequest)
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	res := &ua.BrowseNextResponse{}
+	for _, b := range req.ContinuationPoints {
+		cp := string(b)
+		refs, ok := tr.pending[cp]
+		delete(tr.pending, cp)
+		switch {
+		case !ok:
+			res.Results = append(res.Results, &ua.BrowseResult{StatusCode: ua.StatusBadContinuationPointInvalid})
+		case req.ReleaseContinuationPoints:
+			tr.released = append(tr.released, cp)
+			res.Results = append(res.Results, &ua.BrowseResult{StatusCode: ua.StatusOK})
+		default:
+			res.Results = append(res.Results, tr.page(refs))
+		}
+	}
+	return res, nil
+}
+
+// newBrowseTestClient starts a server with the tree and returns a client
+// which is connected to it. The returned function closes both.
+func newBrowseTestClient(t *testing.T, tr *testTree) (c *Client, stop func()) {
+	t.Helper()
+	srv := &Server{EndpointURL: testServerEndpoint}
+	tr.register(srv)
+	stopServer := startTestServer(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c = NewClient(testServerEndpoint, nil)
+	if err := c.Open(ctx); err != nil {
+		stopServer()
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close()
+		stopServer()
+	}
+}
+
+func nodeIDs(nodes []*Node) []string {
+	var ids []string
+	for _, n := range nodes {
+		if n == nil {
+			ids = append(ids, "<nil>")
+			continue
+		}
+		ids = append(ids, n.ID.String())
+	}
+	return ids
+}
+
+func TestNodeBrowse(t *testing.T) {
+	tr := newTestTree(2)
+	c, stop := newBrowseTestClient(t, tr)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	node := func(s string) *Node { return c.Node(ua.NewStringNodeID(1, s)) }
+
+	t.Run("references", func(t *testing.T) {
+		res, err := node("root").References(ctx, ua.NewNumericNodeID(0, id.HierarchicalReferences))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, r := range res.Results[0].References {
+			names = append(names, r.BrowseName.Name)
+		}
+		// b references the root as well
+		verify.Values(t, "", names, []string{"a", "b", "c", "b"})
+	})
+
+	t.Run("children", func(t *testing.T) {
+		children, err := node("a").Children(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, "", nodeIDs(children), []string{"ns=1;s=a1", "ns=1;s=a2", "ns=1;s=a3"})
+	})
+
+	cases := []struct {
+		name string
+		f    func(context.Context) (*Node, error)
+		want *ua.NodeID
+	}{
+		{"parent", node("a1").Parent, ua.NewStringNodeID(1, "a")},
+		{"no parent", node("x").Parent, nil},
+		{"type definition", node("a").TypeDefinition, ua.NewNumericNodeID(0, id.FolderType)},
+		{"no type definition", node("c").TypeDefinition, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := tc.f(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []*Node{nil}
+			if tc.want != nil {
+				want = []*Node{c.Node(tc.want)}
+			}
+			verify.Values(t, "", nodeIDs([]*Node{n}), nodeIDs(want))
+		})
+	}
+
+	t.Run("release continuation point", func(t *testing.T) {
+		tr.mu.Lock()
+		tr.pageSize = 1
+		tr.mu.Unlock()
+
+		// a has the parents b and root
+		n, err := node("a").Parent(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, "", nodeIDs([]*Node{n}), []string{"ns=1;s=b"})
+
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		if len(tr.released) != 1 || len(tr.pending) != 0 {
+			t.Fatalf("got released %v pending %v want one released", tr.released, tr.pending)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+)
+
+// maxPathsPerRequest is the maximum number of browse paths which are
+// resolved with a single TranslateBrowsePathsToNodeIDs request.
+var maxPathsPerRequest = 100
+
+// NodeFromPath returns the node at the relative path from the root
+// folder, e.g. "/Objects/2:PLC1/2:Speed". The path has the text format of
+// ua.ParseRelativePath. The resolved node ids are cached by the client
+// until a new session is created.
+//
+// If the path has more than one target the first one is returned. The
+// status ua.StatusBadNoMatch is returned if the path has no target on
+// this server.
+func (c *Client) NodeFromPath(ctx context.Context, path string) (*Node, error) {
+	nodes, err := c.NodesFromPaths(ctx, path)
+	if err != nil {
+		if errs, ok := err.(ua.MultiError); ok {
+			return nil, errs[0]
+		}
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+// NodesFromPaths returns the nodes at the relative paths from the root
+// folder like NodeFromPath. The paths which are not cached are resolved
+// in batches of TranslateBrowsePathsToNodeIDs requests.
+//
+// If some of the paths cannot be resolved their nodes are nil and a
+// ua.MultiError with the errors of the failed paths is returned.
+func (c *Client) NodesFromPaths(ctx context.Context, paths ...string) ([]*Node, error) {
+	nodes := make([]*Node, len(paths))
+	errs := make(ua.MultiError, len(paths))
+	failed := false
+
+	// parse the paths which are not cached
+	var todo []int
+	var bps []*ua.BrowsePath
+	c.pathMu.Lock()
+	for i, p := range paths {
+		if nid, ok := c.paths[p]; ok {
+			nodes[i] = c.Node(nid)
+			continue
+		}
+		rp, err := ua.ParseRelativePath(p)
+		if err != nil {
+			errs[i], failed = err, true
+			continue
+		}
+		todo = append(todo, i)
+		bps = append(bps, &ua.BrowsePath{
+			StartingNode: ua.NewNumericNodeID(0, id.RootFolder),
+			RelativePath: rp,
+		})
+	}
+	c.pathMu.Unlock()
+
+	for len(bps) > 0 {
+		n := len(bps)
+		if n > maxPathsPerRequest {
+			n = maxPathsPerRequest
+		}
+		res, err := c.TranslateBrowsePathsToNodeIDs(ctx, &ua.TranslateBrowsePathsToNodeIDsRequest{
+			BrowsePaths: bps[:n],
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Results) != n {
+			return nil, fmt.Errorf("opcua: got %d browse path results for %d paths", len(res.Results), n)
+		}
+
+		c.pathMu.Lock()
+		for k, r := range res.Results {
+			i := todo[k]
+			nid, err := browsePathTarget(r)
+			if err != nil {
+				errs[i], failed = err, true
+				continue
+			}
+			if c.paths == nil {
+				c.paths = make(map[string]*ua.NodeID)
+			}
+			c.paths[paths[i]] = nid
+			nodes[i] = c.Node(nid)
+		}
+		c.pathMu.Unlock()
+
+		todo, bps = todo[n:], bps[n:]
+	}
+
+	if failed {
+		return nodes, errs
+	}
+	return nodes, nil
+}
+
+// browsePathTarget returns the id of the first target of the browse path
+// result which is on this server and matches the complete path.
+func browsePathTarget(r *ua.BrowsePathResult) (*ua.NodeID, error) {
+	if r.StatusCode.IsBad() {
+		return nil, r.StatusCode
+	}
+	for _, t := range r.Targets {
+		if t.TargetID == nil || t.TargetID.ServerIndex != 0 || t.RemainingPathIndex != ^uint32(0) {
+			continue
+		}
+		return t.TargetID.NodeID, nil
+	}
+	return nil, ua.StatusBadNoMatch
+}
+
+// clearPaths removes the cached node ids of the paths.
+func (c *Client) clearPaths() {
+	c.pathMu.Lock()
+	c.paths = nil
+	c.pathMu.Unlock()
+}
@@ -0,0 +1,136 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// testPaths resolves browse paths in the tree of a testTree and records
+// the number of paths of every request.
+type testPaths struct {
+	tr *testTree
+
+	mu       sync.Mutex
+	requests []int
+}
+
+func (tp *testPaths) translate(v interface{}) (interface{}, error) {
+	req := v.(*ua.TranslateBrowsePathsToNodeIDsRequest)
+	tp.mu.Lock()
+	tp.requests = append(tp.requests, len(req.BrowsePaths))
+	tp.mu.Unlock()
+
+	res := &ua.TranslateBrowsePathsToNodeIDsResponse{}
+	for _, bp := range req.BrowsePaths {
+		res.Results = append(res.Results, tp.resolve(bp))
+	}
+	return res, nil
+}
+
+// resolve supports only forward hierarchical references from the root
+// folder which is the "root" node of the tree.
+func (tp *testPaths) resolve(bp *ua.BrowsePath) *ua.BrowsePathResult {
+	if bp.StartingNode.IntID() != id.RootFolder {
+		return &ua.BrowsePathResult{StatusCode: ua.StatusBadNodeIDUnknown}
+	}
+	node := "root"
+	for _, e := range bp.RelativePath.Elements {
+		if e.ReferenceTypeID.IntID() != id.HierarchicalReferences || e.IsInverse {
+			return &ua.BrowsePathResult{StatusCode: ua.StatusBadNoMatch}
+		}
+		next := ""
+		for _, c := range tp.tr.children[node] {
+			if e.TargetName.NamespaceIndex == 1 && e.TargetName.Name == c {
+				next = c
+			}
+		}
+		if next == "" {
+			return &ua.BrowsePathResult{StatusCode: ua.StatusBadNoMatch}
+		}
+		node = next
+	}
+	return &ua.BrowsePathResult{
+		StatusCode: ua.StatusOK,
+		Targets: []*ua.BrowsePathTarget{
+			{
+				TargetID:           ua.NewExpandedNodeID(false, false, ua.NewStringNodeID(1, node), "", 0),
+				RemainingPathIndex: ^uint32(0),
+			},
+		},
+	}
+}
+
+func TestNodeFromPath(t *testing.T) {
+	tr := newTestTree(10)
+	tp := &testPaths{tr: tr}
+	srv := &Server{EndpointURL: testServerEndpoint}
+	srv.Handle(&ua.TranslateBrowsePathsToNodeIDsRequest{}, tp.translate)
+	stopServer := startTestServer(t, srv)
+	defer stopServer()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := NewClient(testServerEndpoint, nil)
+	if err := c.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	defer func(n int) { maxPathsPerRequest = n }(maxPathsPerRequest)
+	maxPathsPerRequest = 2
+
+	t.Run("single", func(t *testing.T) {
+		n, err := c.NodeFromPath(ctx, "/1:b/1:b&.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := n.ID.String(), "ns=1;s=b.1"; got != want {
+			t.Fatalf("got %s want %s", got, want)
+		}
+		if _, err := c.NodeFromPath(ctx, "/1:x"); err != ua.StatusBadNoMatch {
+			t.Fatalf("got error %v want %v", err, ua.StatusBadNoMatch)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		tp.mu.Lock()
+		tp.requests = nil
+		tp.mu.Unlock()
+
+		nodes, err := c.NodesFromPaths(ctx, "/1:a", "/1:a/1:a2", "/1:b/1:b&.1", "/1:a/1:x", "1:c", "/1:c")
+		errs, ok := err.(ua.MultiError)
+		if !ok {
+			t.Fatalf("got error %v want ua.MultiError", err)
+		}
+		verify.Values(t, "", nodeIDs(nodes), []string{"ns=1;s=a", "ns=1;s=a2", "ns=1;s=b.1", "<nil>", "<nil>", "ns=1;s=c"})
+		if got, want := errs[3], error(ua.StatusBadNoMatch); got != want {
+			t.Fatalf("got error %v want %v", got, want)
+		}
+		if errs[4] == nil {
+			t.Fatal("want parse error")
+		}
+
+		// "/1:b/1:b&.1" is cached and "1:c" is not sent
+		tp.mu.Lock()
+		verify.Values(t, "", tp.requests, []int{2, 2})
+		tp.mu.Unlock()
+
+		// the failed path is resolved again
+		if _, err := c.NodesFromPaths(ctx, "/1:a", "/1:a/1:a2", "/1:c", "/1:a/1:x"); err == nil {
+			t.Fatal("want error")
+		}
+		tp.mu.Lock()
+		verify.Values(t, "", tp.requests, []int{2, 2, 1})
+		tp.mu.Unlock()
+	})
+}
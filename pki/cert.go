@@ -0,0 +1,222 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gopcua/opcua/securitypolicy"
+)
+
+// DefaultLifetime is the lifetime of a certificate if none is given.
+const DefaultLifetime = 5 * 365 * 24 * time.Hour
+
+// CertificateOptions describe a self-signed application instance
+// certificate.
+type CertificateOptions struct {
+	// ApplicationURI is the URI of the application which is added to the
+	// subject alternative name. It must match the ApplicationURI of the
+	// ApplicationDescription of the application.
+	ApplicationURI string
+
+	// ApplicationName is the common name of the subject. If it is empty
+	// the ApplicationURI is used.
+	ApplicationName string
+
+	// Organization is the organization of the subject.
+	Organization string
+
+	// Hosts are the DNS names and IP addresses of the host of the
+	// application which are added to the subject alternative name.
+	Hosts []string
+
+	// SecurityPolicyURI is the security policy the certificate is used
+	// with. It limits the key length.
+	SecurityPolicyURI string
+
+	// KeyLength is the length of the RSA key in bits which is either 2048
+	// or 4096. If it is 0 the shortest of them which fits the security
+	// policy is used.
+	KeyLength int
+
+	// Lifetime is the period the certificate is valid for. If it is 0
+	// DefaultLifetime is used.
+	Lifetime time.Duration
+}
+
+// KeyLength returns the length in bits of an RSA key for the security
+// policy. It is the requested length or the shortest of 2048 and 4096 bits
+// which fits the security policy if bits is 0.
+func KeyLength(policyURI string, bits int) (int, error) {
+	min, max, err := securitypolicy.KeyLength(policyURI)
+	if err != nil {
+		return 0, err
+	}
+	if max == 0 {
+		return 0, fmt.Errorf("pki: security policy %s does not use certificates", policyURI)
+	}
+
+	lengths := []int{2048, 4096}
+	if bits != 0 {
+		if bits != 2048 && bits != 4096 {
+			return 0, fmt.Errorf("pki: invalid key length %d bits, want 2048 or 4096", bits)
+		}
+		lengths = []int{bits}
+	}
+	for _, n := range lengths {
+		if n >= min && n <= max {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("pki: security policy %s requires a key length of %d-%d bits", policyURI, min, max)
+}
+
+// NewCertificate creates a private key and a self-signed application
+// instance certificate with it. The certificate is DER encoded.
+//
+// Specification: Part 6, 6.2.2
+func NewCertificate(opts CertificateOptions) ([]byte, *rsa.PrivateKey, error) {
+	if opts.ApplicationURI == "" {
+		return nil, nil, errors.New("pki: application uri missing")
+	}
+	appURI, err := url.Parse(opts.ApplicationURI)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pki: invalid application uri: %s", err)
+	}
+	bits, err := KeyLength(opts.SecurityPolicyURI, opts.KeyLength)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	keyID := sha1.Sum(x509.MarshalPKCS1PublicKey(&key.PublicKey))
+
+	name := opts.ApplicationName
+	if name == "" {
+		name = opts.ApplicationURI
+	}
+	lifetime := opts.Lifetime
+	if lifetime == 0 {
+		lifetime = DefaultLifetime
+	}
+	now := time.Now()
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(lifetime),
+		SubjectKeyId:          keyID[:],
+		AuthorityKeyId:        keyID[:],
+		SignatureAlgorithm:    x509.SHA256WithRSA,
+		BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment |
+			x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		URIs:        []*url.URL{appURI},
+	}
+	if opts.Organization != "" {
+		tmpl.Subject.Organization = []string{opts.Organization}
+	}
+	for _, h := range opts.Hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// SaveOwn stores the DER encoded certificate and the private key of the
+// application. The certificate is written to the own certificates and the
+// PEM encoded key with the same name to the own private keys. The key is
+// only readable by the owner.
+func (s *Store) SaveOwn(cert []byte, key *rsa.PrivateKey) error {
+	name := certFileName(cert)
+	b := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(filepath.Join(s.Path(OwnPrivateDir), keyFileName(name)), b, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.Path(OwnCertsDir), name), cert, 0644)
+}
+
+// Own returns the DER encoded certificate and the private key of the
+// application which have been stored with SaveOwn. If there are several
+// certificates the one which expires last is returned.
+func (s *Store) Own() ([]byte, *rsa.PrivateKey, error) {
+	files, err := ioutil.ReadDir(s.Path(OwnCertsDir))
+	if err != nil {
+		return nil, nil, err
+	}
+	var cert *x509.Certificate
+	var name string
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".der") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(s.Path(OwnCertsDir), fi.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		c, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pki: invalid certificate %s: %s", fi.Name(), err)
+		}
+		if cert == nil || c.NotAfter.After(cert.NotAfter) {
+			cert, name = c, fi.Name()
+		}
+	}
+	if cert == nil {
+		return nil, nil, errors.New("pki: no own certificate")
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(s.Path(OwnPrivateDir), keyFileName(name)))
+	if err != nil {
+		return nil, nil, err
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil || blk.Type != "RSA PRIVATE KEY" {
+		return nil, nil, fmt.Errorf("pki: invalid private key for %s", name)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(blk.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pki: invalid private key for %s: %s", name, err)
+	}
+	return cert.Raw, key, nil
+}
+
+// keyFileName returns the name of the file of the private key of the
+// certificate in the file with the given name.
+func keyFileName(certName string) string {
+	return strings.TrimSuffix(certName, ".der") + ".pem"
+}
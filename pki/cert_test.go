@@ -0,0 +1,129 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package pki
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestKeyLength(t *testing.T) {
+	const (
+		basic128Rsa15  = "http://opcfoundation.org/UA/SecurityPolicy#Basic128Rsa15"
+		basic256Sha256 = "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+	)
+
+	tests := []struct {
+		policy string
+		bits   int
+		want   int
+		err    bool
+	}{
+		{policy: basic128Rsa15, want: 2048},
+		{policy: basic128Rsa15, bits: 4096, err: true},
+		{policy: basic256Sha256, want: 2048},
+		{policy: basic256Sha256, bits: 2048, want: 2048},
+		{policy: basic256Sha256, bits: 4096, want: 4096},
+		{policy: basic256Sha256, bits: 1024, err: true},
+		{policy: basic256Sha256, bits: 3072, err: true},
+		{policy: "http://opcfoundation.org/UA/SecurityPolicy#None", err: true},
+		{policy: "unknown", err: true},
+	}
+	for _, tt := range tests {
+		got, err := KeyLength(tt.policy, tt.bits)
+		if gotErr := err != nil; gotErr != tt.err {
+			t.Fatalf("%s %d: got error %v want %v", tt.policy, tt.bits, err, tt.err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s %d: got %d bits want %d", tt.policy, tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestNewCertificate(t *testing.T) {
+	const appURI = "urn:localhost:gopcua:test"
+
+	opts := CertificateOptions{
+		ApplicationURI:    appURI,
+		ApplicationName:   "gopcua test",
+		Organization:      "gopcua",
+		Hosts:             []string{"localhost", "127.0.0.1"},
+		SecurityPolicyURI: "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256",
+		Lifetime:          24 * time.Hour,
+	}
+	b, key, err := NewCertificate(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := key.N.BitLen(), 2048; got != want {
+		t.Fatalf("got %d bits want %d", got, want)
+	}
+	if !bytes.Equal(x509.MarshalPKCS1PublicKey(&key.PublicKey), x509.MarshalPKCS1PublicKey(cert.PublicKey.(*rsa.PublicKey))) {
+		t.Fatal("certificate has a different key")
+	}
+	verify.Values(t, "", cert.Subject.CommonName, "gopcua test")
+	verify.Values(t, "", cert.Subject.Organization, []string{"gopcua"})
+	verify.Values(t, "", cert.DNSNames, []string{"localhost"})
+	verify.Values(t, "", cert.IPAddresses, []net.IP{net.ParseIP("127.0.0.1").To4()})
+	if got, want := cert.NotAfter.Sub(cert.NotBefore), 25*time.Hour; got != want {
+		t.Fatalf("got lifetime %s want %s", got, want)
+	}
+
+	s, remove := newTestStore(t)
+	defer remove()
+
+	if err := s.Validate(b, appURI); err == nil {
+		t.Fatal("untrusted certificate is valid")
+	}
+	if err := s.Trust(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Validate(b, appURI); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		for _, o := range []CertificateOptions{
+			{SecurityPolicyURI: opts.SecurityPolicyURI},
+			{ApplicationURI: appURI},
+			{ApplicationURI: appURI, SecurityPolicyURI: opts.SecurityPolicyURI, KeyLength: 1024},
+		} {
+			if _, _, err := NewCertificate(o); err == nil {
+				t.Fatalf("%#v: got nil want error", o)
+			}
+		}
+	})
+}
+
+func TestSaveOwn(t *testing.T) {
+	s, remove := newTestStore(t)
+	defer remove()
+
+	if _, _, err := s.Own(); err == nil {
+		t.Fatal("got nil want error")
+	}
+
+	ca := newTestCert(t, "ca", true, nil, nil)
+	if err := s.SaveOwn(ca.Raw, ca.key); err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := s.Own()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "", cert, ca.Raw)
+	verify.Values(t, "", key, ca.key)
+}
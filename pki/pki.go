@@ -0,0 +1,169 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package pki implements a certificate store for the application instance
+// certificates of OPC UA applications. It uses the directory layout of
+// the certificate stores of other OPC UA stacks:
+//
+//	<root>/own/certs        certificate of the application
+//	<root>/own/private      private key of the application
+//	<root>/trusted/certs    trusted certificates
+//	<root>/trusted/crl      revocation lists of the trusted CAs
+//	<root>/issuers/certs    CA certificates for building the chains
+//	<root>/issuers/crl      revocation lists of the issuers
+//	<root>/rejected/certs   certificates which failed the validation
+//
+// The certificates are read from the directories on every validation so
+// that changes by an operator are effective immediately. A certificate is
+// approved by moving it from the rejected to the trusted directory.
+//
+// Specification: Part 4, 6.1.3 and Part 12, F.1
+package pki
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The directories of the store relative to its root.
+var (
+	OwnCertsDir      = filepath.Join("own", "certs")
+	OwnPrivateDir    = filepath.Join("own", "private")
+	TrustedCertsDir  = filepath.Join("trusted", "certs")
+	TrustedCRLDir    = filepath.Join("trusted", "crl")
+	IssuersCertsDir  = filepath.Join("issuers", "certs")
+	IssuersCRLDir    = filepath.Join("issuers", "crl")
+	RejectedCertsDir = filepath.Join("rejected", "certs")
+)
+
+// Store is a certificate store in a directory.
+type Store struct {
+	root string
+
+	// now returns the current time. It is replaced by the tests.
+	now func() time.Time
+}
+
+// Open opens the certificate store in the directory root and creates the
+// directories which do not exist.
+func Open(root string) (*Store, error) {
+	dirs := []string{OwnCertsDir, TrustedCertsDir, TrustedCRLDir, IssuersCertsDir, IssuersCRLDir, RejectedCertsDir}
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, OwnPrivateDir), 0700); err != nil {
+		return nil, err
+	}
+	return &Store{root: root, now: time.Now}, nil
+}
+
+// Path returns the path of the directory of the store, e.g.
+// Path(TrustedCertsDir).
+func (s *Store) Path(dir string) string {
+	return filepath.Join(s.root, dir)
+}
+
+// Trust adds the DER encoded certificate to the trusted certificates and
+// removes it from the rejected certificates.
+func (s *Store) Trust(cert []byte) error {
+	name := certFileName(cert)
+	if err := ioutil.WriteFile(filepath.Join(s.Path(TrustedCertsDir), name), cert, 0644); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(s.Path(RejectedCertsDir), name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// reject copies the DER encoded certificate to the rejected certificates.
+func (s *Store) reject(cert []byte) error {
+	return ioutil.WriteFile(filepath.Join(s.Path(RejectedCertsDir), certFileName(cert)), cert, 0644)
+}
+
+// certFileName returns the name of the file of a certificate in the store
+// which is its SHA1 thumbprint.
+func certFileName(cert []byte) string {
+	return fmt.Sprintf("%X.der", sha1.Sum(cert))
+}
+
+// readCerts returns the certificates in the files of the directory of the
+// store. The files contain DER or PEM encoded certificates.
+func (s *Store) readCerts(dir string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	err := readFiles(s.Path(dir), "CERTIFICATE", func(name string, b []byte) error {
+		c, err := x509.ParseCertificates(b)
+		if err != nil {
+			return fmt.Errorf("pki: invalid certificate %s: %s", name, err)
+		}
+		certs = append(certs, c...)
+		return nil
+	})
+	return certs, err
+}
+
+// readCRLs returns the revocation lists in the files of the directory of
+// the store. The files contain DER or PEM encoded revocation lists.
+func (s *Store) readCRLs(dir string) ([]*pkix.CertificateList, error) {
+	var crls []*pkix.CertificateList
+	err := readFiles(s.Path(dir), "X509 CRL", func(name string, b []byte) error {
+		crl, err := x509.ParseDERCRL(b)
+		if err != nil {
+			return fmt.Errorf("pki: invalid revocation list %s: %s", name, err)
+		}
+		crls = append(crls, crl)
+		return nil
+	})
+	return crls, err
+}
+
+// readFiles calls f with the contents of every file in the directory. PEM
+// blocks of the given type are decoded and passed one by one.
+func readFiles(dir, pemType string, f func(name string, b []byte) error) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		name := filepath.Join(dir, fi.Name())
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("-----BEGIN")) {
+			if err := f(name, b); err != nil {
+				return err
+			}
+			continue
+		}
+		for {
+			var blk *pem.Block
+			blk, b = pem.Decode(b)
+			if blk == nil {
+				break
+			}
+			if blk.Type != pemType {
+				continue
+			}
+			if err := f(name, blk.Bytes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,420 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uacp"
+)
+
+var testKeys = map[string]*rsa.PrivateKey{}
+
+// testKey returns the private key with the given name. The keys are
+// shared by the tests since generating them is slow.
+func testKey(t *testing.T, name string) *rsa.PrivateKey {
+	t.Helper()
+	if k := testKeys[name]; k != nil {
+		return k
+	}
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKeys[name] = k
+	return k
+}
+
+// testCert is a certificate with its private key.
+type testCert struct {
+	*x509.Certificate
+	key *rsa.PrivateKey
+}
+
+// newTestCert creates a certificate with the key of the given name which
+// is signed by the parent or self-signed if parent is nil. The template
+// is modified by f if it is not nil.
+func newTestCert(t *testing.T, name string, ca bool, parent *testCert, f func(*x509.Certificate)) *testCert {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ca {
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		tmpl.ExtKeyUsage = nil
+		tmpl.BasicConstraintsValid = true
+		tmpl.IsCA = true
+	}
+	if f != nil {
+		f(tmpl)
+	}
+	key := testKey(t, name)
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.Certificate, parent.key
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{c, key}
+}
+
+// crl returns a revocation list of the CA with the revoked certificates.
+func (ca *testCert) crl(t *testing.T, revoked ...*testCert) []byte {
+	t.Helper()
+	var list []pkix.RevokedCertificate
+	for _, c := range revoked {
+		list = append(list, pkix.RevokedCertificate{SerialNumber: c.SerialNumber, RevocationTime: time.Now()})
+	}
+	b, err := ca.CreateCRL(rand.Reader, ca.key, list, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// newTestStore opens a store in a temporary directory. The returned
+// function removes it.
+func newTestStore(t *testing.T) (*Store, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gopcua-pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+// add writes the file to the directory of the store.
+func (s *Store) add(t *testing.T, dir, name string, b []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(s.Path(dir), name), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	s, remove := newTestStore(t)
+	defer remove()
+
+	for _, d := range []string{OwnCertsDir, OwnPrivateDir, TrustedCertsDir, TrustedCRLDir, IssuersCertsDir, IssuersCRLDir, RejectedCertsDir} {
+		fi, err := os.Stat(s.Path(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.IsDir() {
+			t.Fatalf("%s is not a directory", d)
+		}
+	}
+
+	// opening an existing store does not fail
+	if _, err := Open(s.root); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	root := newTestCert(t, "root", true, nil, nil)
+	inter := newTestCert(t, "intermediate", true, root, nil)
+	leaf := newTestCert(t, "leaf", false, inter, func(c *x509.Certificate) {
+		u, _ := url.Parse("urn:gopcua:client")
+		c.URIs = []*url.URL{u}
+	})
+	self := newTestCert(t, "self", false, nil, nil)
+	expired := newTestCert(t, "self", false, nil, func(c *x509.Certificate) { c.NotAfter = time.Now().Add(-time.Minute) })
+
+	type file struct {
+		dir, name string
+		b         []byte
+	}
+	// pki is a complete store with a trusted root CA and an intermediate
+	// CA in the issuers.
+	pki := func(files ...file) []file {
+		return append([]file{
+			{TrustedCertsDir, "root.der", root.Raw},
+			{TrustedCRLDir, "root.crl", root.crl(t)},
+			{IssuersCertsDir, "intermediate.der", inter.Raw},
+			{IssuersCRLDir, "intermediate.crl", inter.crl(t)},
+		}, files...)
+	}
+
+	// long is a chain of intermediate CAs below the root which is longer
+	// than maxChainLength.
+	var long []file
+	parent := root
+	for i := 0; i < maxChainLength; i++ {
+		name := fmt.Sprintf("intermediate%d", i)
+		parent = newTestCert(t, "intermediate", true, parent, func(c *x509.Certificate) { c.Subject.CommonName = name })
+		long = append(long, file{IssuersCertsDir, name + ".der", parent.Raw})
+	}
+	longLeaf := newTestCert(t, "leaf", false, parent, nil)
+
+	tests := []struct {
+		name   string
+		files  []file
+		cert   []byte
+		appURI string
+		code   ua.StatusCode
+	}{
+		{
+			name: "invalid certificate",
+			cert: []byte("cert"),
+			code: uacp.BadCertificateInvalid,
+		},
+		{
+			name: "untrusted self-signed",
+			cert: self.Raw,
+			code: uacp.BadCertificateUntrusted,
+		},
+		{
+			name:  "trusted self-signed",
+			files: []file{{TrustedCertsDir, "self.der", self.Raw}},
+			cert:  self.Raw,
+		},
+		{
+			name:  "trusted self-signed pem",
+			files: []file{{TrustedCertsDir, "self.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: self.Raw})}},
+			cert:  self.Raw,
+		},
+		{
+			name:  "trusted root",
+			files: pki(),
+			cert:  leaf.Raw,
+		},
+		{
+			name:  "trusted intermediate",
+			files: pki(file{TrustedCertsDir, "intermediate.der", inter.Raw}),
+			cert:  leaf.Raw,
+		},
+		{
+			name:  "trusted leaf",
+			files: pki(file{TrustedCertsDir, "leaf.der", leaf.Raw})[1:],
+			cert:  leaf.Raw,
+		},
+		{
+			name: "untrusted root",
+			files: []file{
+				{IssuersCertsDir, "root.der", root.Raw},
+				{IssuersCertsDir, "intermediate.der", inter.Raw},
+			},
+			cert: leaf.Raw,
+			code: uacp.BadCertificateUntrusted,
+		},
+		{
+			name: "incomplete chain",
+			files: []file{
+				{TrustedCertsDir, "root.der", root.Raw},
+			},
+			cert: leaf.Raw,
+			code: uacp.BadCertificateChainIncomplete,
+		},
+		{
+			name: "incomplete chain without root",
+			files: []file{
+				{IssuersCertsDir, "intermediate.der", inter.Raw},
+			},
+			cert: leaf.Raw,
+			code: uacp.BadCertificateChainIncomplete,
+		},
+		{
+			name:  "chain too long",
+			files: append([]file{{TrustedCertsDir, "root.der", root.Raw}}, long...),
+			cert:  longLeaf.Raw,
+			code:  uacp.BadCertificateChainIncomplete,
+		},
+		{
+			name: "chain from the peer",
+			files: []file{
+				{TrustedCertsDir, "root.der", root.Raw},
+				{TrustedCRLDir, "root.crl", root.crl(t)},
+				{IssuersCRLDir, "intermediate.crl", inter.crl(t)},
+			},
+			cert: append(append([]byte{}, leaf.Raw...), inter.Raw...),
+		},
+		{
+			name:  "expired",
+			files: []file{{TrustedCertsDir, "expired.der", expired.Raw}},
+			cert:  expired.Raw,
+			code:  uacp.BadCertificateTimeInvalid,
+		},
+		{
+			name:  "not yet valid issuer",
+			files: []file{{TrustedCertsDir, "root.der", newTestCert(t, "root", true, nil, func(c *x509.Certificate) { c.NotBefore = time.Now().Add(time.Minute) }).Raw}},
+			cert:  newTestCert(t, "leaf", false, root, nil).Raw,
+			code:  uacp.BadCertificateIssuerTimeInvalid,
+		},
+		{
+			name:   "application uri",
+			files:  pki(),
+			cert:   leaf.Raw,
+			appURI: "urn:gopcua:client",
+		},
+		{
+			name:   "wrong application uri",
+			files:  pki(),
+			cert:   leaf.Raw,
+			appURI: "urn:gopcua:server",
+			code:   uacp.BadCertificateURIInvalid,
+		},
+		{
+			name:  "key usage",
+			files: pki(),
+			cert:  newTestCert(t, "leaf", false, inter, func(c *x509.Certificate) { c.KeyUsage = x509.KeyUsageDigitalSignature }).Raw,
+			code:  uacp.BadCertificateUseNotAllowed,
+		},
+		{
+			name:  "extended key usage",
+			files: pki(),
+			cert:  newTestCert(t, "leaf", false, inter, func(c *x509.Certificate) { c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning} }).Raw,
+			code:  uacp.BadCertificateUseNotAllowed,
+		},
+		{
+			name:  "issuer is no CA",
+			files: []file{{TrustedCertsDir, "self.der", self.Raw}},
+			cert:  newTestCert(t, "leaf", false, self, nil).Raw,
+			code:  uacp.BadCertificateIssuerUseNotAllowed,
+		},
+		{
+			name:  "revocation unknown",
+			files: pki()[:3],
+			cert:  leaf.Raw,
+			code:  uacp.BadCertificateRevocationUnknown,
+		},
+		{
+			name: "issuer revocation unknown",
+			files: []file{
+				{TrustedCertsDir, "root.der", root.Raw},
+				{IssuersCertsDir, "intermediate.der", inter.Raw},
+				{IssuersCRLDir, "intermediate.crl", inter.crl(t)},
+			},
+			cert: leaf.Raw,
+			code: uacp.BadCertificateIssuerRevocationUnknown,
+		},
+		{
+			name: "revoked",
+			files: []file{
+				{TrustedCertsDir, "root.der", root.Raw},
+				{TrustedCRLDir, "root.crl", root.crl(t)},
+				{IssuersCertsDir, "intermediate.der", inter.Raw},
+				{IssuersCRLDir, "intermediate.crl", inter.crl(t, leaf)},
+			},
+			cert: leaf.Raw,
+			code: uacp.BadCertificateRevoked,
+		},
+		{
+			name: "issuer revoked",
+			files: []file{
+				{TrustedCertsDir, "root.der", root.Raw},
+				{TrustedCRLDir, "root.crl", root.crl(t, inter)},
+				{IssuersCertsDir, "intermediate.der", inter.Raw},
+				{IssuersCRLDir, "intermediate.crl", inter.crl(t)},
+			},
+			cert: leaf.Raw,
+			code: uacp.BadCertificateIssuerRevoked,
+		},
+		{
+			name: "revocation list of other CA",
+			files: []file{
+				{TrustedCertsDir, "root.der", root.Raw},
+				{TrustedCRLDir, "root.crl", root.crl(t)},
+				{IssuersCertsDir, "intermediate.der", inter.Raw},
+				{IssuersCRLDir, "intermediate.crl", root.crl(t, leaf)},
+			},
+			cert: leaf.Raw,
+			code: uacp.BadCertificateRevocationUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, remove := newTestStore(t)
+			defer remove()
+			for _, f := range tt.files {
+				s.add(t, f.dir, f.name, f.b)
+			}
+
+			err := s.Validate(tt.cert, tt.appURI)
+			rejected, rerr := ioutil.ReadDir(s.Path(RejectedCertsDir))
+			if rerr != nil {
+				t.Fatal(rerr)
+			}
+
+			if tt.code == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(rejected) != 0 {
+					t.Fatalf("got %d rejected certificates want 0", len(rejected))
+				}
+				return
+			}
+
+			perr, ok := err.(*uacp.ProtocolError)
+			if !ok {
+				t.Fatalf("got %T %v, want *uacp.ProtocolError", err, err)
+			}
+			if got, want := perr.Code, tt.code; got != want {
+				t.Fatalf("got code %v want %v: %s", got, want, perr.Reason)
+			}
+			if len(rejected) != 1 {
+				t.Fatalf("got %d rejected certificates want 1", len(rejected))
+			}
+		})
+	}
+}
+
+func TestTrust(t *testing.T) {
+	s, remove := newTestStore(t)
+	defer remove()
+
+	cert := newTestCert(t, "self", false, nil, nil).Raw
+	if err := s.Verify(cert); err == nil {
+		t.Fatal("want error")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(s.Path(RejectedCertsDir), certFileName(cert)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(cert) {
+		t.Fatal("rejected certificate differs")
+	}
+
+	// the operator approves the rejected certificate
+	if err := s.Trust(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify(cert); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Path(RejectedCertsDir), certFileName(cert))); !os.IsNotExist(err) {
+		t.Fatalf("got %v want not exist", err)
+	}
+}
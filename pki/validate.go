@@ -0,0 +1,242 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uacp"
+)
+
+// maxChainLength is the maximum number of certificates in a chain.
+const maxChainLength = 10
+
+// Verify validates the DER encoded certificate of a peer without checking
+// its application URI. It can be used for uasc.Config.VerifyCertificate.
+func (s *Store) Verify(cert []byte) error {
+	return s.Validate(cert, "")
+}
+
+// Validate validates the DER encoded certificate of a peer, e.g. the
+// SenderCertificate of an OpenSecureChannel request, which may be followed
+// by the certificates of its issuers. If appURI is not empty the
+// certificate must contain it in the subject alternative name. It can be
+// used for uasc.Config.VerifyApplicationURI.
+//
+// The certificate is valid if the certificate or one of its issuers is
+// trusted, the chain to a self-signed certificate can be built from the
+// trusted and the issuer certificates unless it ends at a trusted one,
+// all certificates of the chain are valid now, may be used for their
+// purpose and are not revoked by the revocation list of their issuer.
+//
+// Validate returns a *uacp.ProtocolError with the status code of the
+// failed check, e.g. uacp.BadCertificateUntrusted, which is sent to the
+// peer. A certificate which is not valid is copied to the rejected
+// certificates.
+//
+// Specification: Part 4, 6.1.3
+func (s *Store) Validate(cert []byte, appURI string) error {
+	err := s.validate(cert, appURI)
+	if err != nil {
+		if certs, perr := x509.ParseCertificates(cert); perr == nil && len(certs) > 0 {
+			cert = certs[0].Raw
+		}
+		if rerr := s.reject(cert); rerr != nil {
+			return fmt.Errorf("pki: %s and rejecting the certificate failed: %s", err, rerr)
+		}
+	}
+	return err
+}
+
+func (s *Store) validate(cert []byte, appURI string) error {
+	certs, err := x509.ParseCertificates(cert)
+	if err != nil || len(certs) == 0 {
+		return certError(uacp.BadCertificateInvalid, "invalid certificate: %v", err)
+	}
+	leaf := certs[0]
+
+	trusted, err := s.readCerts(TrustedCertsDir)
+	if err != nil {
+		return err
+	}
+	issuers, err := s.readCerts(IssuersCertsDir)
+	if err != nil {
+		return err
+	}
+	crls, err := s.readCRLs(TrustedCRLDir)
+	if err != nil {
+		return err
+	}
+	issuerCRLs, err := s.readCRLs(IssuersCRLDir)
+	if err != nil {
+		return err
+	}
+	crls = append(crls, issuerCRLs...)
+
+	pool := append(append(append([]*x509.Certificate{}, trusted...), issuers...), certs[1:]...)
+	chain, err := buildChain(leaf, pool)
+	if err != nil {
+		return err
+	}
+
+	// trust list check
+	ok := false
+	for _, c := range chain {
+		if contains(trusted, c) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		// the chain ends early if an issuer is missing
+		if last := chain[len(chain)-1]; !isSelfSigned(last) {
+			return certError(uacp.BadCertificateChainIncomplete, "issuer of certificate %q not found", last.Subject.CommonName)
+		}
+		return certError(uacp.BadCertificateUntrusted, "certificate %q is not trusted", leaf.Subject.CommonName)
+	}
+
+	// validity period
+	now := s.now()
+	for i, c := range chain {
+		if now.Before(c.NotBefore) || now.After(c.NotAfter) {
+			code := ua.StatusCode(uacp.BadCertificateIssuerTimeInvalid)
+			if i == 0 {
+				code = uacp.BadCertificateTimeInvalid
+			}
+			return certError(code, "certificate %q is only valid from %s to %s", c.Subject.CommonName, c.NotBefore, c.NotAfter)
+		}
+	}
+
+	// application uri
+	if appURI != "" && !hasURI(leaf, appURI) {
+		return certError(uacp.BadCertificateURIInvalid, "certificate %q has no application uri %s", leaf.Subject.CommonName, appURI)
+	}
+
+	// certificate usage
+	if err := checkUsage(leaf); err != nil {
+		return err
+	}
+	for _, c := range chain[1:] {
+		if !c.BasicConstraintsValid || !c.IsCA || (c.KeyUsage != 0 && c.KeyUsage&x509.KeyUsageCertSign == 0) {
+			return certError(uacp.BadCertificateIssuerUseNotAllowed, "certificate %q may not issue certificates", c.Subject.CommonName)
+		}
+	}
+
+	// revocation check. The certificates which are not issued by a CA
+	// cannot be revoked.
+	for i := 0; i+1 < len(chain); i++ {
+		if err := checkRevoked(chain[i], chain[i+1], crls, i == 0, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildChain returns the chain of the certificate to a self-signed
+// certificate with the issuers from the pool. The chain ends early if an
+// issuer is not in the pool which is only accepted if one of the
+// certificates of the chain is trusted.
+func buildChain(leaf *x509.Certificate, pool []*x509.Certificate) ([]*x509.Certificate, error) {
+	chain := []*x509.Certificate{leaf}
+	for c := leaf; !isSelfSigned(c); {
+		var issuer *x509.Certificate
+		for _, p := range pool {
+			if bytes.Equal(p.RawSubject, c.RawIssuer) && p.CheckSignature(c.SignatureAlgorithm, c.RawTBSCertificate, c.Signature) == nil {
+				issuer = p
+				break
+			}
+		}
+		if issuer == nil || contains(chain, issuer) {
+			break
+		}
+		if len(chain) == maxChainLength {
+			return nil, certError(uacp.BadCertificateChainIncomplete, "certificate chain of %q is too long", leaf.Subject.CommonName)
+		}
+		chain = append(chain, issuer)
+		c = issuer
+	}
+	return chain, nil
+}
+
+// checkUsage returns an error if the application instance certificate may
+// not be used to sign and to encrypt or for the authentication of clients
+// or servers.
+func checkUsage(c *x509.Certificate) error {
+	const usage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	if c.KeyUsage != 0 && c.KeyUsage&usage != usage {
+		return certError(uacp.BadCertificateUseNotAllowed, "certificate %q may not be used to sign and to encrypt", c.Subject.CommonName)
+	}
+	if len(c.ExtKeyUsage) == 0 {
+		return nil
+	}
+	for _, u := range c.ExtKeyUsage {
+		switch u {
+		case x509.ExtKeyUsageAny, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth:
+			return nil
+		}
+	}
+	return certError(uacp.BadCertificateUseNotAllowed, "certificate %q may not be used by clients or servers", c.Subject.CommonName)
+}
+
+// checkRevoked returns an error if the certificate is revoked or if there
+// is no valid revocation list of its issuer.
+func checkRevoked(c, issuer *x509.Certificate, crls []*pkix.CertificateList, leaf bool, now time.Time) error {
+	unknown, revoked := ua.StatusCode(uacp.BadCertificateIssuerRevocationUnknown), ua.StatusCode(uacp.BadCertificateIssuerRevoked)
+	if leaf {
+		unknown, revoked = uacp.BadCertificateRevocationUnknown, uacp.BadCertificateRevoked
+	}
+
+	found := false
+	for _, crl := range crls {
+		if issuer.CheckCRLSignature(crl) != nil || crl.HasExpired(now) {
+			continue
+		}
+		found = true
+		for _, r := range crl.TBSCertList.RevokedCertificates {
+			if r.SerialNumber.Cmp(c.SerialNumber) == 0 {
+				return certError(revoked, "certificate %q is revoked", c.Subject.CommonName)
+			}
+		}
+	}
+	if !found {
+		return certError(unknown, "no revocation list for certificate %q", c.Subject.CommonName)
+	}
+	return nil
+}
+
+// isSelfSigned returns true if the certificate is signed by its own key.
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawSubject, c.RawIssuer) && c.CheckSignature(c.SignatureAlgorithm, c.RawTBSCertificate, c.Signature) == nil
+}
+
+// contains returns true if the certificate is in the list.
+func contains(certs []*x509.Certificate, c *x509.Certificate) bool {
+	for _, x := range certs {
+		if x.Equal(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasURI returns true if the subject alternative name of the certificate
+// contains the uri.
+func hasURI(c *x509.Certificate, uri string) bool {
+	for _, u := range c.URIs {
+		if u.String() == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func certError(code ua.StatusCode, format string, args ...interface{}) error {
+	return &uacp.ProtocolError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
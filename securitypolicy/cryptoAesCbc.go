@@ -23,7 +23,7 @@ func decryptAES(keyLength int, iv, secret []byte) func(src []byte) ([]byte, erro
 		paddedKey := make([]byte, keyLength/8)
 		copy(paddedKey, secret)
 
-		block, err := aes.NewCipher(secret)
+		block, err := aes.NewCipher(paddedKey)
 		if err != nil {
 			return nil, err
 		}
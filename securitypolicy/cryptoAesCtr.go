@@ -0,0 +1,51 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package securitypolicy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+)
+
+// counterBlockAESCTR returns the initial counter block for AES-CTR as
+// defined in RFC 3686, section 4. It is the 4 byte key nonce, followed by
+// the 8 byte message nonce and a 4 byte big endian block counter which
+// starts at one.
+func counterBlockAESCTR(keyNonce, messageNonce []byte) []byte {
+	b := make([]byte, aes.BlockSize)
+	copy(b, keyNonce[:4])
+	copy(b[4:], messageNonce[:8])
+	binary.BigEndian.PutUint32(b[12:], 1)
+	return b
+}
+
+// cryptAESCTR encrypts or decrypts with AES in counter mode which are the
+// same operation. CTR mode does not need padding.
+func cryptAESCTR(keyLength int, keyNonce, messageNonce, secret []byte) func(src []byte) ([]byte, error) {
+	return func(src []byte) ([]byte, error) {
+		if len(secret) != keyLength/8 {
+			return nil, errors.New("invalid key length")
+		}
+
+		// The block counter must not wrap around into the nonces.
+		if uint64(len(src)) > (1<<32-1)*aes.BlockSize {
+			return nil, errors.New("message too long")
+		}
+
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, err
+		}
+
+		stream := cipher.NewCTR(block, counterBlockAESCTR(keyNonce, messageNonce))
+
+		dst := make([]byte, len(src))
+		stream.XORKeyStream(dst, src)
+
+		return dst, nil
+	}
+}
@@ -23,7 +23,7 @@ func minPaddingRsaOAEP(hash crypto.Hash) int {
 	case crypto.SHA1:
 		hLen = 20
 	case crypto.SHA256:
-		hLen = 64
+		hLen = 32
 	}
 
 	return (2 * hLen) + 2
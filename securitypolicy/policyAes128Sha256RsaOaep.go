@@ -71,6 +71,7 @@ func newAes128Sha256RsaOaepSymmetric(localNonce []byte, remoteNonce []byte) (*En
 	e.signature = computeHmac(crypto.SHA256, remoteKeys.signing)      // HMAC-SHA2-256
 	e.verifySignature = verifyHmac(crypto.SHA256, localKeys.signing)  // HMAC-SHA2-256
 	e.signatureLength = 256 / 8
+	e.remoteSignatureLength = 256 / 8
 	e.encryptionURI = "http://www.w3.org/2001/04/xmlenc#aes128-cbc"
 	e.signatureURI = "http://www.w3.org/2000/09/xmldsig#hmac-sha256"
 
@@ -102,6 +103,8 @@ func newAes128Sha256RsaOaepAsymmetric(localKey *rsa.PrivateKey, remoteKey *rsa.P
 	e.signature = signPKCS1v15(crypto.SHA256, localKey)          // RSA-PKCS15-SHA2-256
 	e.verifySignature = verifyPKCS1v15(crypto.SHA256, remoteKey) // RSA-PKCS15-SHA2-256
 	e.signatureLength = localKey.PublicKey.Size()
+	e.remoteSignatureLength = remoteKey.Size()
+	e.nonceLength = 32
 	e.encryptionURI = "http://opcfoundation.org/ua/security/rsa-oaep-sha1"
 	e.signatureURI = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
 
@@ -75,6 +75,7 @@ func newAes256Sha256RsaPssSymmetric(localNonce []byte, remoteNonce []byte) (*Enc
 	e.signature = computeHmac(crypto.SHA256, remoteKeys.signing)      // HMAC-SHA2-256
 	e.verifySignature = verifyHmac(crypto.SHA256, localKeys.signing)  // HMAC-SHA2-256
 	e.signatureLength = 256 / 8
+	e.remoteSignatureLength = 256 / 8
 	e.encryptionURI = "http://opcfoundation.org/UA/security/rsa-oaep-sha2-256"
 	e.signatureURI = "http://www.w3.org/2000/09/xmldsig#hmac-sha256"
 
@@ -100,12 +101,14 @@ func newAes256Sha256RsaPssAsymmetric(localKey *rsa.PrivateKey, remoteKey *rsa.Pu
 	e := new(EncryptionAlgorithm)
 
 	e.blockSize = remoteKey.Size()
-	e.minPadding = minPaddingRsaOAEP(crypto.SHA1)
-	e.encrypt = encryptRsaOAEP(crypto.SHA1, remoteKey)         // RSA-OAEP-SHA1
-	e.decrypt = decryptRsaOAEP(crypto.SHA1, localKey)          // RSA-OAEP-SHA1
+	e.minPadding = minPaddingRsaOAEP(crypto.SHA256)
+	e.encrypt = encryptRsaOAEP(crypto.SHA256, remoteKey)       // RSA-OAEP-SHA2-256
+	e.decrypt = decryptRsaOAEP(crypto.SHA256, localKey)        // RSA-OAEP-SHA2-256
 	e.signature = signRsaPss(crypto.SHA256, localKey)          // RSA-PSS-SHA2-256
 	e.verifySignature = verifyRsaPss(crypto.SHA256, remoteKey) // RSA-PSS-SHA2-256
 	e.signatureLength = localKey.PublicKey.Size()
+	e.remoteSignatureLength = remoteKey.Size()
+	e.nonceLength = 32
 	e.encryptionURI = "http://opcfoundation.org/UA/security/rsa-oaep-sha2-256"
 	e.signatureURI = "http://opcfoundation.org/UA/security/rsa-pss-sha2-256"
 
@@ -59,6 +59,7 @@ func newBasic128Rsa15Symmetric(localNonce []byte, remoteNonce []byte) (*Encrypti
 	e.signature = computeHmac(crypto.SHA1, remoteKeys.signing)        // HMAC-SHA1
 	e.verifySignature = verifyHmac(crypto.SHA1, localKeys.signing)    // HMAC-SHA1
 	e.signatureLength = 160 / 8
+	e.remoteSignatureLength = 160 / 8
 	e.encryptionURI = "http://www.w3.org/2001/04/xmlenc#aes128-cbc"
 	e.signatureURI = "http://www.w3.org/2000/09/xmldsig#hmac-sha1"
 
@@ -90,6 +91,8 @@ func newBasic128Rsa15Asymmetric(localKey *rsa.PrivateKey, remoteKey *rsa.PublicK
 	e.signature = signPKCS1v15(crypto.SHA1, localKey)          // RSA-SHA1
 	e.verifySignature = verifyPKCS1v15(crypto.SHA1, remoteKey) // RSA-SHA1
 	e.signatureLength = localKey.PublicKey.Size()
+	e.remoteSignatureLength = remoteKey.Size()
+	e.nonceLength = 16
 	e.encryptionURI = "http://www.w3.org/2001/04/xmlenc#rsa-1_5"
 	e.signatureURI = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
 
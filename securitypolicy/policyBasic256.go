@@ -59,6 +59,7 @@ func newBasic256Symmetric(localNonce []byte, remoteNonce []byte) (*EncryptionAlg
 	e.signature = computeHmac(crypto.SHA1, remoteKeys.signing)        // HMAC-SHA1
 	e.verifySignature = verifyHmac(crypto.SHA1, localKeys.signing)    // HMAC-SHA1
 	e.signatureLength = 160 / 8
+	e.remoteSignatureLength = 160 / 8
 	e.encryptionURI = "http://www.w3.org/2001/04/xmlenc#aes256-cbc"
 	e.signatureURI = "http://www.w3.org/2000/09/xmldsig#hmac-sha1"
 
@@ -84,12 +85,14 @@ func newBasic256Asymmetric(localKey *rsa.PrivateKey, remoteKey *rsa.PublicKey) (
 	e := new(EncryptionAlgorithm)
 
 	e.blockSize = remoteKey.Size()
-	e.minPadding = minPaddingRsaPKCS1v15()
+	e.minPadding = minPaddingRsaOAEP(crypto.SHA1)
 	e.encrypt = encryptRsaOAEP(crypto.SHA1, remoteKey)         // RSA-OAEP
 	e.decrypt = decryptRsaOAEP(crypto.SHA1, localKey)          // RSA-OAEP
 	e.signature = signPKCS1v15(crypto.SHA1, localKey)          // RSA-SHA1
 	e.verifySignature = verifyPKCS1v15(crypto.SHA1, remoteKey) // RSA-SHA1
 	e.signatureLength = localKey.PublicKey.Size()
+	e.remoteSignatureLength = remoteKey.Size()
+	e.nonceLength = 32
 	e.encryptionURI = "http://www.w3.org/2001/04/xmlenc#rsa-oaep"
 	e.signatureURI = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
 
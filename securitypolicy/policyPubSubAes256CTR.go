@@ -4,6 +4,10 @@
 
 package securitypolicy
 
+import (
+	"crypto"
+)
+
 /*
 
 "SecurityPolicy - PubSub-Aes256-CTR" Profile
@@ -32,3 +36,30 @@ The URI is http://docs.oasis-open.org/ws-sx/ws-secureconversation/200512/dk/p_sh
 
 
 */
+
+func newPubSubAes256CTR(key, messageNonce []byte) (*EncryptionAlgorithm, error) {
+	const (
+		signatureKeyLength  = 32
+		encryptionKeyLength = 256 / 8
+	)
+
+	keys, err := splitPubSubKey(key, messageNonce, signatureKeyLength, encryptionKeyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	e := new(EncryptionAlgorithm)
+
+	e.blockSize = 1
+	e.minPadding = 0
+	e.encrypt = cryptAESCTR(256, keys.iv, messageNonce, keys.encryption) // AES256-CTR
+	e.decrypt = cryptAESCTR(256, keys.iv, messageNonce, keys.encryption) // AES256-CTR
+	e.signature = computeHmac(crypto.SHA256, keys.signing)               // HMAC-SHA2-256
+	e.verifySignature = verifyHmac(crypto.SHA256, keys.signing)          // HMAC-SHA2-256
+	e.signatureLength = 256 / 8
+	e.remoteSignatureLength = 256 / 8
+	e.encryptionURI = "http://opcfoundation.org/UA/security/aes256-ctr"
+	e.signatureURI = "http://www.w3.org/2000/09/xmldsig#hmac-sha256"
+
+	return e, nil
+}
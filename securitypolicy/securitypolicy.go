@@ -32,6 +32,9 @@ type EncryptionAlgorithm struct {
 	signatureLength int
 	encryptionURI   string
 	signatureURI    string
+
+	remoteSignatureLength int
+	nonceLength           int
 }
 
 // Asymmetric returns the EncryptionAlgorithm struct seeded with the required public
@@ -125,6 +128,20 @@ func (e *EncryptionAlgorithm) SignatureLength() int {
 	return e.signatureLength
 }
 
+// RemoteSignatureLength returns the length in bytes of the signatures
+// created by the remote end which are checked by VerifySignature.
+// For asymmetric algorithms this is the size of the remote key.
+func (e *EncryptionAlgorithm) RemoteSignatureLength() int {
+	return e.remoteSignatureLength
+}
+
+// NonceLength returns the length in bytes of the nonces exchanged in the
+// OpenSecureChannel service which seed the symmetric keys.
+// It is only set for asymmetric algorithms and zero for Security Policy "None".
+func (e *EncryptionAlgorithm) NonceLength() int {
+	return e.nonceLength
+}
+
 // EncryptionURI returns the URI for the encryption algorithm as defined
 // by the OPC-UA profiles in Part 7
 func (e *EncryptionAlgorithm) EncryptionURI() string {
@@ -28,6 +28,33 @@ func TestSupportedPolicies(t *testing.T) {
 
 }
 
+func TestKeyLength(t *testing.T) {
+	cases := []struct {
+		policy   string
+		min, max int
+	}{
+		{"http://opcfoundation.org/UA/SecurityPolicy#None", 0, 0},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Basic128Rsa15", 1024, 2048},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Basic256", 1024, 2048},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256", 2048, 4096},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Aes128_Sha256_RsaOaep", 2048, 4096},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Aes256_Sha256_RsaPss", 2048, 4096},
+	}
+	for _, c := range cases {
+		min, max, err := KeyLength(c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if min != c.min || max != c.max {
+			t.Errorf("%s: got %d-%d bits want %d-%d bits", c.policy, min, max, c.min, c.max)
+		}
+	}
+
+	if _, _, err := KeyLength("http://example.com/SecurityPolicy#Unknown"); err == nil {
+		t.Error("want error for unknown policy")
+	}
+}
+
 func TestGenerateKeysLength(t *testing.T) {
 	localNonce := make([]byte, 32)
 	remoteNonce := make([]byte, 32)
@@ -228,6 +255,92 @@ func TestEncryptionAlgorithms(t *testing.T) {
 
 }
 
+// TestPubSub checks the PubSub policies against the AES-CTR test vectors
+// of RFC 3686, section 6 and an HMAC-SHA2-256 signature.
+func TestPubSub(t *testing.T) {
+	signingKey := []byte("\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0A\x0B\x0C\x0D\x0E\x0F\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1A\x1B\x1C\x1D\x1E\x1F")
+	plaintext := []byte("\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0A\x0B\x0C\x0D\x0E\x0F\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1A\x1B\x1C\x1D\x1E\x1F")
+
+	cases := []struct {
+		policy        string
+		encryptionKey []byte
+		keyNonce      []byte
+		messageNonce  []byte
+		ciphertext    []byte
+	}{
+		{ // RFC 3686 test vector #2
+			policy:        "http://opcfoundation.org/UA/SecurityPolicy#PubSub-Aes128-CTR",
+			encryptionKey: []byte("\x7E\x24\x06\x78\x17\xFA\xE0\xD7\x43\xD6\xCE\x1F\x32\x53\x91\x63"),
+			keyNonce:      []byte("\x00\x6C\xB6\xDB"),
+			messageNonce:  []byte("\xC0\x54\x3B\x59\xDA\x48\xD9\x0B"),
+			ciphertext:    []byte("\x51\x04\xA1\x06\x16\x8A\x72\xD9\x79\x0D\x41\xEE\x8E\xDA\xD3\x88\xEB\x2E\x1E\xFC\x46\xDA\x57\xC8\xFC\xE6\x30\xDF\x91\x41\xBE\x28"),
+		},
+		{ // RFC 3686 test vector #8
+			policy:        "http://opcfoundation.org/UA/SecurityPolicy#PubSub-Aes256-CTR",
+			encryptionKey: []byte("\xF6\xD6\x6D\x6B\xD5\x2D\x59\xBB\x07\x96\x36\x58\x79\xEF\xF8\x86\xC6\x6D\xD5\x1A\x5B\x6A\x99\x74\x4B\x50\x59\x0C\x87\xA2\x38\x84"),
+			keyNonce:      []byte("\x00\xFA\xAC\x24"),
+			messageNonce:  []byte("\xC1\x58\x5E\xF1\x5A\x43\xD8\x75"),
+			ciphertext:    []byte("\xF0\x5E\x23\x1B\x38\x94\x61\x2C\x49\xEE\x00\x0B\x80\x4E\xB2\xA9\xB8\x30\x6B\x50\x8F\x83\x9D\x6A\x55\x30\x83\x1D\x93\x44\xAF\x1C"),
+		},
+	}
+
+	message := []byte("what do ya want for nothing?")
+	signature := []byte("\x09\x98\x05\xF4\xAC\x31\x07\x86\x96\x85\x65\xC0\x98\xDB\x51\x5C\xC5\x08\x62\xB4\x20\xAE\x31\xE2\x02\x38\x31\x23\x44\xBE\xD3\x6A")
+
+	if got, want := len(PubSubPolicies()), len(cases); got != want {
+		t.Fatalf("got %d PubSub policies want %d", got, want)
+	}
+
+	for _, c := range cases {
+		key := append(append(append([]byte{}, signingKey...), c.encryptionKey...), c.keyNonce...)
+		e, err := PubSub(c.policy, key, c.messageNonce)
+		if err != nil {
+			t.Fatalf("%s: %s", c.policy, err)
+		}
+
+		ciphertext, err := e.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("%s: encryption failed: %s", c.policy, err)
+		}
+		if diff := cmp.Diff(ciphertext, c.ciphertext, nil); diff != "" {
+			t.Errorf("%s: encryption failed:\n%s\n", c.policy, diff)
+		}
+
+		cleartext, err := e.Decrypt(c.ciphertext)
+		if err != nil {
+			t.Fatalf("%s: decryption failed: %s", c.policy, err)
+		}
+		if diff := cmp.Diff(cleartext, plaintext, nil); diff != "" {
+			t.Errorf("%s: decryption failed:\n%s\n", c.policy, diff)
+		}
+
+		sig, err := e.Signature(message)
+		if err != nil {
+			t.Fatalf("%s: signature failed: %s", c.policy, err)
+		}
+		if diff := cmp.Diff(sig, signature, nil); diff != "" {
+			t.Errorf("%s: signature failed:\n%s\n", c.policy, diff)
+		}
+		if err := e.VerifySignature(message, signature); err != nil {
+			t.Errorf("%s: signature validation failed: %s", c.policy, err)
+		}
+		if len(sig) != e.SignatureLength() {
+			t.Errorf("%s: got signature length %d want %d", c.policy, len(sig), e.SignatureLength())
+		}
+
+		if _, err := PubSub(c.policy, key[1:], c.messageNonce); err == nil {
+			t.Errorf("%s: want error for short key", c.policy)
+		}
+		if _, err := PubSub(c.policy, key, c.messageNonce[1:]); err == nil {
+			t.Errorf("%s: want error for short message nonce", c.policy)
+		}
+	}
+
+	if _, err := PubSub("http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256", nil, nil); err == nil {
+		t.Error("want error for secure channel policy")
+	}
+}
+
 func TestZeroStruct(t *testing.T) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -248,6 +361,8 @@ func TestZeroStruct(t *testing.T) {
 	_, _ = ze.Signature(plaintext)
 	_ = ze.VerifySignature(plaintext, plaintext)
 	_ = ze.SignatureLength()
+	_ = ze.RemoteSignatureLength()
+	_ = ze.NonceLength()
 	_ = ze.EncryptionURI()
 	_ = ze.SignatureURI()
 
@@ -4,7 +4,11 @@
 
 package securitypolicy
 
-import "crypto/rsa"
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+)
 
 var supportedPolicies = map[string]policyInitFuncs{
 	"http://opcfoundation.org/UA/SecurityPolicy#None": {
@@ -14,25 +18,40 @@ var supportedPolicies = map[string]policyInitFuncs{
 	"http://opcfoundation.org/UA/SecurityPolicy#Basic128Rsa15": { // Obsolete in OPC-UA 1.04
 		asymmetricInitFunc: newBasic128Rsa15Asymmetric,
 		symmetricInitFunc:  newBasic128Rsa15Symmetric,
+		minKeyLength:       1024,
+		maxKeyLength:       2048,
 	},
 	"http://opcfoundation.org/UA/SecurityPolicy#Basic256": { // Obsolete in OPC-UA 1.04
 		asymmetricInitFunc: newBasic256Asymmetric,
 		symmetricInitFunc:  newBasic256Symmetric,
+		minKeyLength:       1024,
+		maxKeyLength:       2048,
 	},
 	"http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256": {
 		asymmetricInitFunc: newBasic256Rsa256Asymmetric,
 		symmetricInitFunc:  newBasic256Rsa256Symmetric,
+		minKeyLength:       2048,
+		maxKeyLength:       4096,
 	},
 	"http://opcfoundation.org/UA/SecurityPolicy#Aes128_Sha256_RsaOaep": {
 		asymmetricInitFunc: newAes128Sha256RsaOaepAsymmetric,
 		symmetricInitFunc:  newAes128Sha256RsaOaepSymmetric,
+		minKeyLength:       2048,
+		maxKeyLength:       4096,
 	},
 	"http://opcfoundation.org/UA/SecurityPolicy#Aes256_Sha256_RsaPss": {
 		asymmetricInitFunc: newAes256Sha256RsaPssAsymmetric,
 		symmetricInitFunc:  newAes256Sha256RsaPssSymmetric,
+		minKeyLength:       2048,
+		maxKeyLength:       4096,
 	},
-	// http://opcfoundation.org/UA/SecurityPolicy#PubSub_Aes128_CTR
-	// http://opcfoundation.org/UA/SecurityPolicy#PubSub_Aes256_CTR
+}
+
+// pubSubPolicies are the Security Policies for PubSub messages. They are
+// not used for secure channels and therefore not in supportedPolicies.
+var pubSubPolicies = map[string]func(key, messageNonce []byte) (*EncryptionAlgorithm, error){
+	"http://opcfoundation.org/UA/SecurityPolicy#PubSub-Aes128-CTR": newPubSubAes128CTR,
+	"http://opcfoundation.org/UA/SecurityPolicy#PubSub-Aes256-CTR": newPubSubAes256CTR,
 }
 
 // SupportedPolicies returns all supported Security Policies
@@ -49,7 +68,72 @@ func SupportedPolicies() []string {
 	return p
 }
 
+// PubSubPolicies returns all supported Security Policies for PubSub
+// messages (and therefore, valid inputs to PubSub(...))
+func PubSubPolicies() []string {
+	p := make([]string, 0, len(pubSubPolicies))
+	for k := range pubSubPolicies {
+		p = append(p, k)
+	}
+	return p
+}
+
+// KeyLength returns the minimum and the maximum length in bits of the
+// asymmetric keys for the Security Policy. Both are zero for Security
+// Policy "None".
+func KeyLength(policyURI string) (min, max int, err error) {
+	policy, ok := supportedPolicies[policyURI]
+	if !ok {
+		return 0, 0, errors.New("unknown security policy")
+	}
+	return policy.minKeyLength, policy.maxKeyLength, nil
+}
+
+// PubSubMessageNonceLength is the length in bytes of the nonce of a PubSub
+// message.
+const PubSubMessageNonceLength = 8
+
+// pubSubKeyNonceLength is the length in bytes of the nonce of a PubSub key.
+const pubSubKeyNonceLength = 4
+
+// PubSub returns the EncryptionAlgorithm struct for a PubSub message
+// seeded with the key of the security group and the nonce of the message.
+// The key is provided by the Security Key Service and is the signing key,
+// followed by the encrypting key and the key nonce. The message nonce
+// must be unique for every message encrypted with the same key. All
+// publishers and subscribers of the group use the same algorithm.
+//
+// Specification: Part 14, 7.2.2.2.3 and 8.3.2
+func PubSub(policyURI string, key, messageNonce []byte) (*EncryptionAlgorithm, error) {
+	initFunc, ok := pubSubPolicies[policyURI]
+	if !ok {
+		return nil, errors.New("unknown security policy")
+	}
+	return initFunc(key, messageNonce)
+}
+
+// splitPubSubKey splits the key of a security group into the signing key,
+// the encrypting key and the key nonce which is returned as the iv.
+func splitPubSubKey(key, messageNonce []byte, signingLength, encryptingLength int) (*derivedKeys, error) {
+	if len(key) != signingLength+encryptingLength+pubSubKeyNonceLength {
+		return nil, fmt.Errorf("key should be %d bytes, got %d bytes", signingLength+encryptingLength+pubSubKeyNonceLength, len(key))
+	}
+	if len(messageNonce) != PubSubMessageNonceLength {
+		return nil, fmt.Errorf("message nonce should be %d bytes, got %d bytes", PubSubMessageNonceLength, len(messageNonce))
+	}
+	return &derivedKeys{
+		signing:    key[:signingLength],
+		encryption: key[signingLength : signingLength+encryptingLength],
+		iv:         key[signingLength+encryptingLength:],
+	}, nil
+}
+
 type policyInitFuncs struct {
 	asymmetricInitFunc func(localKey *rsa.PrivateKey, remoteKey *rsa.PublicKey) (*EncryptionAlgorithm, error)
 	symmetricInitFunc  func(localNonce []byte, remoteNonce []byte) (*EncryptionAlgorithm, error)
+
+	// minKeyLength and maxKeyLength are the limits of the asymmetric key
+	// length in bits.
+	minKeyLength int
+	maxKeyLength int
 }
@@ -1,16 +1,460 @@
 package opcua
 
-// Server is a high-level OPC-UA Server
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/logger"
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uacp"
+	"github.com/gopcua/opcua/uasc"
+)
+
+// ErrServerClosed is returned by ListenAndServe and Serve after the
+// server has been closed.
+var ErrServerClosed = errors.New("opcua: server closed")
+
+const (
+	// openTimeout is the time in which a client must open the secure
+	// channel after the connection has been established.
+	openTimeout = 10 * time.Second
+
+	// minSessionTimeout and maxSessionTimeout are the limits for the
+	// session timeout requested by a client.
+	minSessionTimeout = 10 * time.Second
+	maxSessionTimeout = time.Hour
+)
+
+// ServiceHandler handles a service request of an activated session and
+// returns the response. If it returns an error the client receives a
+// ServiceFault with the status code of a ua.StatusCode or *ua.ServiceError
+// and StatusBadInternalError otherwise.
+type ServiceHandler func(req interface{}) (interface{}, error)
+
+// Server is a high-level OPC-UA Server. It accepts connections from
+// clients, establishes secure channels and sessions and dispatches the
+// service requests of the activated sessions to the registered handlers.
 type Server struct {
 	EndpointURL string
 
-	// conn *uasc.ServerConn
+	// Config is the configuration of the secure channels. Every
+	// connection gets its own copy with a new secure channel id.
+	// If nil the secure channels use the security policy None.
+	Config *uasc.Config
+
+	// Acknowledge contains the limits for the connections. If nil the
+	// defaults of uacp.Listen are used.
+	Acknowledge *uacp.Acknowledge
+
+	// Logger is the logger for the connections and the secure channels
+	// unless the configuration has its own. If nil nothing is logged.
+	Logger logger.Logger
+
+	// mu guards the fields below.
+	mu       sync.Mutex
+	handlers map[uint16]ServiceHandler
+	sessions map[string]*serverSession
+	listener *uacp.Listener
+	conns    map[*uacp.Conn]bool
+	chanID   uint32
+	sessID   uint32
+	closed   bool
 }
 
-func (a *Server) Open() error {
-	return nil
+// serverSession is a session which a client has created on the server.
+type serverSession struct {
+	id      *ua.NodeID
+	token   *ua.NodeID
+	timeout time.Duration
+
+	// chanID is the id of the secure channel the session is bound to.
+	// It changes when the client activates the session on a new
+	// secure channel.
+	chanID    uint32
+	activated bool
+	lastSeen  time.Time
+
+	// identity identifies the user who has activated the session.
+	// It must not change when the session is activated on another
+	// secure channel.
+	identity string
+
+	// nonce is the last nonce sent to the client. The client signs it
+	// with the certificate of the server when it activates the session.
+	nonce []byte
+}
+
+// Handle registers the handler for the service requests of the same type
+// as req, e.g. &ua.ReadRequest{}. The session services are handled by the
+// server. Handle panics if req is not a known service request.
+func (s *Server) Handle(req interface{}, h ServiceHandler) {
+	typeID := ua.TypeID(req)
+	if typeID == 0 {
+		panic(fmt.Sprintf("opcua: unknown service %T", req))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[uint16]ServiceHandler)
+	}
+	s.handlers[typeID] = h
+}
+
+// ListenAndServe listens on the endpoint URL of the server and serves the
+// connections of the clients until the server is closed. It always
+// returns a non-nil error.
+func (s *Server) ListenAndServe() error {
+	l, err := uacp.Listen(s.EndpointURL, s.Acknowledge)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts the connections on l and serves each of them in a separate
+// goroutine until the server is closed. The listener is closed when Serve
+// returns. It always returns a non-nil error.
+func (s *Server) Serve(l *uacp.Listener) error {
+	l.SetLogger(s.Logger)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+	defer l.Close()
+
+	lg := logger.Or(s.Logger)
+	lg.Info("opcua: server listening", "endpoint", l.Endpoint())
+	for {
+		c, err := l.Accept(context.Background())
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			// a failed handshake only affects this connection
+			lg.Warn("opcua: accept failed", "err", err)
+			continue
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return ErrServerClosed
+		}
+		if s.conns == nil {
+			s.conns = make(map[*uacp.Conn]bool)
+		}
+		s.conns[c] = true
+		s.chanID++
+		chanID := s.chanID
+		s.mu.Unlock()
+
+		go s.serveConn(c, chanID)
+	}
+}
+
+// Close stops the listener and closes all connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// serveConn opens the secure channel on the connection and serves
+// its requests until the client disconnects.
+func (s *Server) serveConn(c *uacp.Conn, chanID uint32) {
+	defer func() {
+		c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	sechan := uasc.NewServerSecureChannel(c, s.channelConfig(chanID))
+	sechan.EndpointURL = s.EndpointURL
+
+	ctx, cancel := context.WithTimeout(context.Background(), openTimeout)
+	err := sechan.Accept(ctx)
+	cancel()
+	if err != nil {
+		c.Logger().Warn("opcua: open secure channel failed", "conn", c.ID(), "err", err)
+		if perr, ok := err.(*uacp.ProtocolError); ok {
+			c.SendError(perr.Code, perr.Reason)
+		}
+		return
+	}
+	c.Logger().Info("opcua: secure channel opened", "conn", c.ID(), "chanid", chanID, "remote", c.RemoteAddr())
+
+	if err := sechan.Serve(s.handler(sechan, chanID)); err != nil {
+		c.Logger().Debug("opcua: secure channel closed", "conn", c.ID(), "chanid", chanID, "err", err)
+	}
+}
+
+// channelConfig returns the configuration for the secure channel with
+// the given id.
+func (s *Server) channelConfig(chanID uint32) *uasc.Config {
+	var cfg uasc.Config
+	if s.Config != nil {
+		cfg = *s.Config
+	} else {
+		cfg = *uasc.NewServerConfig("http://opcfoundation.org/UA/SecurityPolicy#None", nil, nil, 0, ua.MessageSecurityModeNone, 0, 3600000)
+	}
+
+	// the certificate of the client is learned when the
+	// client opens the secure channel.
+	cfg.RemoteCertificate = nil
+	cfg.Thumbprint = nil
+	cfg.SecureChannelID = chanID
+	cfg.SecurityTokenID = 0
+	cfg.SequenceNumber = 0
+	cfg.RequestID = 0
+	return &cfg
 }
 
-func (a *Server) Close() error {
+// handler returns the handler for the requests on the secure channel
+// with the given id.
+func (s *Server) handler(sechan *uasc.SecureChannel, chanID uint32) uasc.Handler {
+	return func(req interface{}) (interface{}, error) {
+		switch r := req.(type) {
+		case *ua.GetEndpointsRequest:
+			return &ua.GetEndpointsResponse{Endpoints: s.endpoints(sechan)}, nil
+		case *ua.FindServersRequest:
+			return &ua.FindServersResponse{Servers: []*ua.ApplicationDescription{s.endpoints(sechan)[0].Server}}, nil
+		case *ua.CreateSessionRequest:
+			return s.createSession(sechan, chanID, r)
+		case *ua.ActivateSessionRequest:
+			return s.activateSession(sechan, chanID, r)
+		case *ua.CloseSessionRequest:
+			return s.closeSession(chanID, r)
+		}
+
+		if err := s.checkSession(chanID, req); err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		h := s.handlers[ua.TypeID(req)]
+		s.mu.Unlock()
+		if h == nil {
+			return nil, ua.StatusBadServiceUnsupported
+		}
+		return h(req)
+	}
+}
+
+// endpoints returns the endpoint of the server.
+func (s *Server) endpoints(sechan *uasc.SecureChannel) []*ua.EndpointDescription {
+	return uasc.ServerEndpoints(sechan)
+}
+
+func (s *Server) createSession(sechan *uasc.SecureChannel, chanID uint32, req *ua.CreateSessionRequest) (*ua.CreateSessionResponse, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(req.RequestedSessionTimeout) * time.Millisecond
+	switch {
+	case timeout < minSessionTimeout:
+		timeout = minSessionTimeout
+	case timeout > maxSessionTimeout:
+		timeout = maxSessionTimeout
+	}
+
+	// the client certificate must contain the application uri
+	// of the client.
+	if s.Config != nil && s.Config.VerifyApplicationURI != nil && len(req.ClientCertificate) > 0 {
+		if req.ClientDescription == nil || req.ClientDescription.ApplicationURI == "" {
+			return nil, ua.StatusBadCertificateURIInvalid
+		}
+		if err := s.Config.VerifyApplicationURI(req.ClientCertificate, req.ClientDescription.ApplicationURI); err != nil {
+			if perr, ok := err.(*uacp.ProtocolError); ok {
+				return nil, perr.Code
+			}
+			return nil, err
+		}
+	}
+
+	cfg, err := uasc.NewServerSessionConfig(sechan)
+	if err != nil {
+		return nil, ua.StatusBadInternalError
+	}
+
+	// prove the possession of the private key of the server certificate
+	sig, err := sechan.NewSessionSignature(req.ClientCertificate, req.ClientNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireSessions(time.Now())
+	if s.sessions == nil {
+		s.sessions = make(map[string]*serverSession)
+	}
+	s.sessID++
+	sess := &serverSession{
+		id:       ua.NewNumericNodeID(1, s.sessID),
+		token:    cfg.AuthenticationToken,
+		timeout:  timeout,
+		chanID:   chanID,
+		lastSeen: time.Now(),
+		nonce:    nonce,
+	}
+	s.sessions[sess.token.String()] = sess
+
+	return &ua.CreateSessionResponse{
+		SessionID:             sess.id,
+		AuthenticationToken:   sess.token,
+		RevisedSessionTimeout: float64(timeout / time.Millisecond),
+		ServerNonce:           nonce,
+		ServerCertificate:     cfg.ServerEndpoints[0].ServerCertificate,
+		ServerEndpoints:       cfg.ServerEndpoints,
+		ServerSignature:       sig,
+	}, nil
+}
+
+func (s *Server) activateSession(sechan *uasc.SecureChannel, chanID uint32, req *ua.ActivateSessionRequest) (*ua.ActivateSessionResponse, error) {
+	sess, err := s.session(req.RequestHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	// the client proves the possession of the private key of its
+	// certificate with the signature of the server certificate and
+	// the last nonce.
+	s.mu.Lock()
+	lastNonce := sess.nonce
+	s.mu.Unlock()
+	cert := s.endpoints(sechan)[0].ServerCertificate
+	if err := sechan.VerifySessionSignature(cert, lastNonce, req.ClientSignature); err != nil {
+		return nil, err
+	}
+
+	// a session which moves to another secure channel
+	// must keep its user.
+	identity := userIdentity(req.UserIdentityToken)
+	s.mu.Lock()
+	changed := sess.activated && sess.chanID != chanID && sess.identity != identity
+	s.mu.Unlock()
+	if changed {
+		return nil, ua.StatusBadIdentityChangeNotSupported
+	}
+
+	// only anonymous users are supported. A missing identity
+	// token is an anonymous user as well.
+	if tok := req.UserIdentityToken; tok != nil && tok.Value != nil {
+		if _, ok := tok.Value.(*ua.AnonymousIdentityToken); !ok {
+			return nil, ua.StatusBadIdentityTokenRejected
+		}
+	}
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess.chanID = chanID
+	sess.activated = true
+	sess.identity = identity
+	sess.lastSeen = time.Now()
+	sess.nonce = nonce
+
+	return &ua.ActivateSessionResponse{ServerNonce: nonce}, nil
+}
+
+// userIdentity returns a key for the user of the identity token which is
+// equal for the same user. A missing token is an anonymous user.
+func userIdentity(tok *ua.ExtensionObject) string {
+	if tok == nil || tok.Value == nil {
+		return "anonymous"
+	}
+	switch t := tok.Value.(type) {
+	case *ua.AnonymousIdentityToken:
+		return "anonymous"
+	case *ua.UserNameIdentityToken:
+		return "username:" + t.UserName
+	case *ua.X509IdentityToken:
+		return "x509:" + string(t.CertificateData)
+	default:
+		return fmt.Sprintf("%T", t)
+	}
+}
+
+func (s *Server) closeSession(chanID uint32, req *ua.CloseSessionRequest) (*ua.CloseSessionResponse, error) {
+	if err := s.checkSession(chanID, req); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, req.RequestHeader.AuthenticationToken.String())
+	return &ua.CloseSessionResponse{}, nil
+}
+
+// session returns the session for the authentication token in the
+// request header.
+func (s *Server) session(hdr *ua.RequestHeader) (*serverSession, error) {
+	if hdr == nil || hdr.AuthenticationToken == nil {
+		return nil, ua.StatusBadSessionIDInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireSessions(time.Now())
+	sess := s.sessions[hdr.AuthenticationToken.String()]
+	if sess == nil {
+		return nil, ua.StatusBadSessionIDInvalid
+	}
+	return sess, nil
+}
+
+// checkSession verifies that the request belongs to an activated session
+// on the secure channel with the given id.
+func (s *Server) checkSession(chanID uint32, req interface{}) error {
+	sess, err := s.session(uasc.RequestHeader(req))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case !sess.activated:
+		return ua.StatusBadSessionNotActivated
+	case sess.chanID != chanID:
+		return ua.StatusBadSecureChannelIDInvalid
+	}
+	sess.lastSeen = time.Now()
 	return nil
 }
+
+// expireSessions removes the sessions which have not been used within
+// their timeout. s.mu must be held.
+func (s *Server) expireSessions(now time.Time) {
+	for k, sess := range s.sessions {
+		if now.Sub(sess.lastSeen) > sess.timeout {
+			delete(s.sessions, k)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package addrspace implements an in-memory address space for a server.
+//
+// The address space contains the nodes of the standard namespace 0. They
+// are generated by cmd/addrspace which reads the attributes and references
+// of the nodes from the official NodeSet schema/Opc.Ua.NodeSet2.xml and the
+// names of the constants of the id package from schema/NodeIds.csv. The
+// nodes of the application are added with AddNode and AddReference.
+package addrspace
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+)
+
+// AddressSpace is a set of nodes and the references between them.
+// It is safe for concurrent use.
+type AddressSpace struct {
+	// mu guards nodes and the attributes and references of the nodes.
+	mu sync.RWMutex
+
+	// nodes maps the string form of the node id to the node
+	// since node ids of different encodings can be equal.
+	nodes map[string]Node
+
+	// history stores the values of the historizing variables. It is
+	// guarded by mu.
+	history HistoryBackend
+
+	// cpMu guards cps which are the continuation points of the
+	// HistoryRead service.
+	cpMu sync.Mutex
+	cps  map[string]*historyContinuation
+}
+
+// New returns an address space with the nodes of namespace 0.
+func New() *AddressSpace {
+	as := &AddressSpace{nodes: make(map[string]Node)}
+	for _, n := range ns0Nodes {
+		as.nodes[ns0ID(n.ID).String()] = n.node()
+	}
+	for _, r := range ns0References {
+		as.addReference(ns0ID(r.Source), ns0ID(r.Type), ns0ID(r.Target))
+	}
+	return as
+}
+
+// Node returns the node with the given id or nil.
+func (as *AddressSpace) Node(id *ua.NodeID) Node {
+	if id == nil {
+		return nil
+	}
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+	return as.nodes[id.String()]
+}
+
+// AddNode adds the node to the address space. It fails with
+// StatusBadNodeIDInvalid if the node has no id and with
+// StatusBadNodeIDExists if a node with the same id exists.
+func (as *AddressSpace) AddNode(n Node) error {
+	id := n.Base().ID
+	if id == nil {
+		return ua.StatusBadNodeIDInvalid
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if as.nodes[id.String()] != nil {
+		return ua.StatusBadNodeIDExists
+	}
+	as.nodes[id.String()] = n
+	return nil
+}
+
+// AddReference adds a reference of the given type from the source to
+// the target node. The source node gets the forward reference and the
+// target node the inverse reference.
+func (as *AddressSpace) AddReference(source, refType, target *ua.NodeID) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	switch {
+	case source == nil || as.nodes[source.String()] == nil:
+		return ua.StatusBadSourceNodeIDInvalid
+	case target == nil || as.nodes[target.String()] == nil:
+		return ua.StatusBadTargetNodeIDInvalid
+	case refType == nil:
+		return ua.StatusBadReferenceTypeIDInvalid
+	}
+	if _, ok := as.nodes[refType.String()].(*ReferenceTypeNode); !ok {
+		return ua.StatusBadReferenceTypeIDInvalid
+	}
+	for _, r := range as.nodes[source.String()].Base().refs {
+		if r.IsForward && r.ReferenceTypeID.String() == refType.String() && r.TargetID.String() == target.String() {
+			return ua.StatusBadDuplicateReferenceNotAllowed
+		}
+	}
+	as.addReference(source, refType, target)
+	return nil
+}
+
+// addReference adds the reference to the source and the target node if
+// they exist. as.mu must be held.
+func (as *AddressSpace) addReference(source, refType, target *ua.NodeID) {
+	if n := as.nodes[source.String()]; n != nil {
+		b := n.Base()
+		b.refs = append(b.refs, &Reference{ReferenceTypeID: refType, IsForward: true, TargetID: target})
+	}
+	if n := as.nodes[target.String()]; n != nil {
+		b := n.Base()
+		b.refs = append(b.refs, &Reference{ReferenceTypeID: refType, IsForward: false, TargetID: source})
+	}
+}
+
+// SetValue sets the value of a variable node. If the variable has the
+// Historizing attribute set the value is recorded in the history backend
+// with the current time as source and server timestamp. The address space
+// is not locked while the backend records the value.
+func (as *AddressSpace) SetValue(id *ua.NodeID, v *ua.Variant) error {
+	if id == nil {
+		return ua.StatusBadNodeIDUnknown
+	}
+	as.mu.Lock()
+	n, ok := as.nodes[id.String()].(*VariableNode)
+	if !ok {
+		as.mu.Unlock()
+		return ua.StatusBadNodeIDUnknown
+	}
+	n.Value = v
+	b, historizing := as.history, n.Historizing
+	now := time.Now()
+	as.mu.Unlock()
+
+	if !historizing || b == nil {
+		return nil
+	}
+	dv := &ua.DataValue{
+		EncodingMask:    ua.DataValueValue | ua.DataValueSourceTimestamp | ua.DataValueServerTimestamp,
+		Value:           v,
+		SourceTimestamp: now,
+		ServerTimestamp: now,
+	}
+	if code := updateValue(b, id, ua.PerformUpdateTypeUpdate, dv); code.IsBad() {
+		return code
+	}
+	return nil
+}
+
+// Read executes the Read service on the address space. The status of the
+// individual values is returned in the results.
+func (as *AddressSpace) Read(req *ua.ReadRequest) (*ua.ReadResponse, error) {
+	if len(req.NodesToRead) == 0 {
+		return nil, ua.StatusBadNothingToDo
+	}
+
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+
+	now := time.Now()
+	resp := &ua.ReadResponse{}
+	for _, rv := range req.NodesToRead {
+		dv := &ua.DataValue{}
+		if v, err := as.attribute(rv.NodeID, rv.AttributeID); err != nil {
+			dv.EncodingMask = ua.DataValueStatus
+			dv.Status = uint32(statusCode(err))
+		} else {
+			dv.EncodingMask = ua.DataValueValue
+			dv.Value = v
+		}
+		switch req.TimestampsToReturn {
+		case ua.TimestampsToReturnServer, ua.TimestampsToReturnBoth:
+			dv.EncodingMask |= ua.DataValueServerTimestamp
+			dv.ServerTimestamp = now
+		}
+		resp.Results = append(resp.Results, dv)
+	}
+	return resp, nil
+}
+
+// attribute returns the attribute of the node. as.mu must be held.
+func (as *AddressSpace) attribute(id *ua.NodeID, attrID uint32) (*ua.Variant, error) {
+	if id == nil {
+		return nil, ua.StatusBadNodeIDInvalid
+	}
+	n := as.nodes[id.String()]
+	if n == nil {
+		return nil, ua.StatusBadNodeIDUnknown
+	}
+	return n.Attribute(attrID)
+}
+
+// Browse executes the Browse service on the address space. The status of
+// the individual results is returned in the results. All references are
+// returned regardless of RequestedMaxReferencesPerNode since continuation
+// points are not supported.
+func (as *AddressSpace) Browse(req *ua.BrowseRequest) (*ua.BrowseResponse, error) {
+	if len(req.NodesToBrowse) == 0 {
+		return nil, ua.StatusBadNothingToDo
+	}
+
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+
+	if v := req.View; v != nil && v.ViewID != nil && !isNull(v.ViewID) {
+		if _, ok := as.nodes[v.ViewID.String()].(*ViewNode); !ok {
+			return nil, ua.StatusBadViewIDUnknown
+		}
+	}
+
+	resp := &ua.BrowseResponse{}
+	for _, d := range req.NodesToBrowse {
+		resp.Results = append(resp.Results, as.browse(d))
+	}
+	return resp, nil
+}
+
+// browse returns the references of a node which match the description.
+// as.mu must be held.
+func (as *AddressSpace) browse(d *ua.BrowseDescription) *ua.BrowseResult {
+	if d.NodeID == nil {
+		return &ua.BrowseResult{StatusCode: ua.StatusBadNodeIDInvalid}
+	}
+	n := as.nodes[d.NodeID.String()]
+	if n == nil {
+		return &ua.BrowseResult{StatusCode: ua.StatusBadNodeIDUnknown}
+	}
+	if d.BrowseDirection > ua.BrowseDirectionBoth {
+		return &ua.BrowseResult{StatusCode: ua.StatusBadBrowseDirectionInvalid}
+	}
+
+	var refTypes map[string]bool
+	if d.ReferenceTypeID != nil && !isNull(d.ReferenceTypeID) {
+		if _, ok := as.nodes[d.ReferenceTypeID.String()].(*ReferenceTypeNode); !ok {
+			return &ua.BrowseResult{StatusCode: ua.StatusBadReferenceTypeIDInvalid}
+		}
+		refTypes = map[string]bool{d.ReferenceTypeID.String(): true}
+		if d.IncludeSubtypes {
+			as.subtypes(d.ReferenceTypeID, refTypes)
+		}
+	}
+
+	res := &ua.BrowseResult{StatusCode: ua.StatusOK}
+	for _, r := range n.Base().refs {
+		switch {
+		case d.BrowseDirection == ua.BrowseDirectionForward && !r.IsForward:
+			continue
+		case d.BrowseDirection == ua.BrowseDirectionInverse && r.IsForward:
+			continue
+		case refTypes != nil && !refTypes[r.ReferenceTypeID.String()]:
+			continue
+		}
+
+		target := as.nodes[r.TargetID.String()]
+		if target != nil && d.NodeClassMask != 0 && uint32(target.NodeClass())&d.NodeClassMask == 0 {
+			continue
+		}
+		res.References = append(res.References, as.referenceDescription(r, target, ua.BrowseResultMask(d.ResultMask)))
+	}
+	return res
+}
+
+// referenceDescription returns the fields of the reference and its target
+// which are selected by the mask. as.mu must be held.
+func (as *AddressSpace) referenceDescription(r *Reference, target Node, mask ua.BrowseResultMask) *ua.ReferenceDescription {
+	rd := &ua.ReferenceDescription{
+		ReferenceTypeID: ua.NewTwoByteNodeID(0),
+		NodeID:          ua.NewExpandedNodeID(false, false, r.TargetID, "", 0),
+		BrowseName:      &ua.QualifiedName{},
+		DisplayName:     &ua.LocalizedText{},
+		TypeDefinition:  ua.NewTwoByteExpandedNodeID(0),
+	}
+	if mask&ua.BrowseResultMaskReferenceTypeID != 0 {
+		rd.ReferenceTypeID = r.ReferenceTypeID
+	}
+	if mask&ua.BrowseResultMaskIsForward != 0 {
+		rd.IsForward = r.IsForward
+	}
+	if target == nil {
+		return rd
+	}
+
+	b := target.Base()
+	if mask&ua.BrowseResultMaskNodeClass != 0 {
+		rd.NodeClass = target.NodeClass()
+	}
+	if mask&ua.BrowseResultMaskBrowseName != 0 && b.BrowseName != nil {
+		rd.BrowseName = b.BrowseName
+	}
+	if mask&ua.BrowseResultMaskDisplayName != 0 && b.DisplayName != nil {
+		rd.DisplayName = &ua.LocalizedText{Locale: b.DisplayName.Locale, Text: b.DisplayName.Text}
+		rd.DisplayName.UpdateMask()
+	}
+	if mask&ua.BrowseResultMaskTypeDefinition != 0 {
+		switch target.(type) {
+		case *ObjectNode, *VariableNode:
+			for _, tr := range b.refs {
+				if tr.IsForward && tr.ReferenceTypeID.IntID() == id.HasTypeDefinition && tr.ReferenceTypeID.Namespace() == 0 {
+					rd.TypeDefinition = ua.NewExpandedNodeID(false, false, tr.TargetID, "", 0)
+					break
+				}
+			}
+		}
+	}
+	return rd
+}
+
+// subtypes adds the ids of all subtypes of the type to ids.
+// as.mu must be held.
+func (as *AddressSpace) subtypes(typeID *ua.NodeID, ids map[string]bool) {
+	n := as.nodes[typeID.String()]
+	if n == nil {
+		return
+	}
+	for _, r := range n.Base().refs {
+		if !r.IsForward || r.ReferenceTypeID.Namespace() != 0 || r.ReferenceTypeID.IntID() != id.HasSubtype {
+			continue
+		}
+		if k := r.TargetID.String(); !ids[k] {
+			ids[k] = true
+			as.subtypes(r.TargetID, ids)
+		}
+	}
+}
+
+// Register registers the handlers for the Read, the Browse and the
+// history services of the address space with the server.
+func (as *AddressSpace) Register(srv *opcua.Server) {
+	srv.Handle(&ua.ReadRequest{}, func(req interface{}) (interface{}, error) {
+		return as.Read(req.(*ua.ReadRequest))
+	})
+	srv.Handle(&ua.BrowseRequest{}, func(req interface{}) (interface{}, error) {
+		return as.Browse(req.(*ua.BrowseRequest))
+	})
+	srv.Handle(&ua.HistoryReadRequest{}, func(req interface{}) (interface{}, error) {
+		return as.HistoryRead(req.(*ua.HistoryReadRequest))
+	})
+	srv.Handle(&ua.HistoryUpdateRequest{}, func(req interface{}) (interface{}, error) {
+		return as.HistoryUpdate(req.(*ua.HistoryUpdateRequest))
+	})
+}
+
+// isNull returns true for the null node id.
+func isNull(id *ua.NodeID) bool {
+	return id.Namespace() == 0 && id.Type() <= ua.NodeIDTypeNumeric && id.IntID() == 0
+}
+
+// statusCode returns the status code of the error or
+// StatusBadInternalError.
+func statusCode(err error) ua.StatusCode {
+	if code, ok := err.(ua.StatusCode); ok {
+		return code
+	}
+	return ua.StatusBadInternalError
+}
@@ -0,0 +1,231 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package addrspace
+
+import (
+	"testing"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func browse(t *testing.T, as *AddressSpace, d *ua.BrowseDescription) *ua.BrowseResult {
+	t.Helper()
+	resp, err := as.Browse(&ua.BrowseRequest{NodesToBrowse: []*ua.BrowseDescription{d}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Results[0]
+}
+
+func browseNames(res *ua.BrowseResult) []string {
+	var names []string
+	for _, r := range res.References {
+		names = append(names, r.BrowseName.Name)
+	}
+	return names
+}
+
+func TestBrowseRootFolder(t *testing.T) {
+	as := New()
+	res := browse(t, as, &ua.BrowseDescription{
+		NodeID:          ua.NewNumericNodeID(0, id.RootFolder),
+		BrowseDirection: ua.BrowseDirectionForward,
+		ReferenceTypeID: ua.NewNumericNodeID(0, id.HierarchicalReferences),
+		IncludeSubtypes: true,
+		ResultMask:      uint32(ua.BrowseResultMaskAll),
+	})
+	if got, want := res.StatusCode, ua.StatusOK; got != want {
+		t.Fatalf("got status %v want %v", got, want)
+	}
+	verify.Values(t, "", browseNames(res), []string{"Objects", "Types", "Views"})
+
+	r := res.References[0]
+	if got, want := r.ReferenceTypeID.IntID(), id.Organizes; got != want {
+		t.Fatalf("got reference type %d want %d", got, want)
+	}
+	if !r.IsForward {
+		t.Fatal("got inverse reference")
+	}
+	if got, want := r.NodeClass, ua.NodeClassObject; got != want {
+		t.Fatalf("got node class %v want %v", got, want)
+	}
+	if got, want := r.TypeDefinition.NodeID.IntID(), id.FolderType; got != want {
+		t.Fatalf("got type definition %d want %d", got, want)
+	}
+}
+
+func TestBrowseFilter(t *testing.T) {
+	as := New()
+	server := ua.NewNumericNodeID(0, id.Server)
+
+	cases := []struct {
+		name string
+		d    *ua.BrowseDescription
+		want []string
+	}{
+		{
+			name: "without subtypes",
+			d: &ua.BrowseDescription{
+				NodeID:          server,
+				ReferenceTypeID: ua.NewNumericNodeID(0, id.HierarchicalReferences),
+				ResultMask:      uint32(ua.BrowseResultMaskAll),
+			},
+			want: nil,
+		},
+		{
+			name: "inverse",
+			d: &ua.BrowseDescription{
+				NodeID:          server,
+				BrowseDirection: ua.BrowseDirectionInverse,
+				ResultMask:      uint32(ua.BrowseResultMaskAll),
+			},
+			want: []string{"Objects"},
+		},
+		{
+			name: "node class",
+			d: &ua.BrowseDescription{
+				NodeID:          ua.NewNumericNodeID(0, id.Server_ServerStatus),
+				ReferenceTypeID: ua.NewNumericNodeID(0, id.HasTypeDefinition),
+				NodeClassMask:   uint32(ua.NodeClassVariableType),
+				ResultMask:      uint32(ua.BrowseResultMaskAll),
+			},
+			want: []string{"ServerStatusType"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			verify.Values(t, "", browseNames(browse(t, as, c.d)), c.want)
+		})
+	}
+}
+
+func TestBrowseErrors(t *testing.T) {
+	as := New()
+
+	cases := []struct {
+		name string
+		d    *ua.BrowseDescription
+		want ua.StatusCode
+	}{
+		{"unknown node", &ua.BrowseDescription{NodeID: ua.NewNumericNodeID(1, 1)}, ua.StatusBadNodeIDUnknown},
+		{"invalid reference type", &ua.BrowseDescription{NodeID: ua.NewNumericNodeID(0, id.RootFolder), ReferenceTypeID: ua.NewNumericNodeID(0, id.RootFolder)}, ua.StatusBadReferenceTypeIDInvalid},
+		{"invalid direction", &ua.BrowseDescription{NodeID: ua.NewNumericNodeID(0, id.RootFolder), BrowseDirection: ua.BrowseDirectionInvalid}, ua.StatusBadBrowseDirectionInvalid},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := browse(t, as, c.d).StatusCode; got != c.want {
+				t.Fatalf("got %v want %v", got, c.want)
+			}
+		})
+	}
+
+	if _, err := as.Browse(&ua.BrowseRequest{}); err != ua.StatusBadNothingToDo {
+		t.Fatalf("got %v want %v", err, ua.StatusBadNothingToDo)
+	}
+}
+
+func TestAddNode(t *testing.T) {
+	as := New()
+
+	dev := &ObjectNode{BaseNode: BaseNode{
+		ID:          ua.NewStringNodeID(1, "device"),
+		BrowseName:  &ua.QualifiedName{NamespaceIndex: 1, Name: "Device"},
+		DisplayName: &ua.LocalizedText{Text: "Device"},
+	}}
+	temp := &VariableNode{
+		BaseNode: BaseNode{
+			ID:         ua.NewStringNodeID(1, "device.temp"),
+			BrowseName: &ua.QualifiedName{NamespaceIndex: 1, Name: "Temperature"},
+		},
+		Value:       ua.MustVariant(21.5),
+		DataType:    ua.NewNumericNodeID(0, id.Double),
+		ValueRank:   -1,
+		AccessLevel: 1,
+	}
+	for _, n := range []Node{dev, temp} {
+		if err := as.AddNode(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := as.AddNode(dev); err != ua.StatusBadNodeIDExists {
+		t.Fatalf("got %v want %v", err, ua.StatusBadNodeIDExists)
+	}
+
+	objects := ua.NewNumericNodeID(0, id.ObjectsFolder)
+	organizes := ua.NewNumericNodeID(0, id.Organizes)
+	hasComponent := ua.NewNumericNodeID(0, id.HasComponent)
+	if err := as.AddReference(objects, organizes, dev.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.AddReference(dev.ID, hasComponent, temp.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name                    string
+		source, refType, target *ua.NodeID
+		want                    error
+	}{
+		{"duplicate", objects, organizes, dev.ID, ua.StatusBadDuplicateReferenceNotAllowed},
+		{"unknown source", ua.NewStringNodeID(1, "x"), organizes, dev.ID, ua.StatusBadSourceNodeIDInvalid},
+		{"unknown target", objects, organizes, ua.NewStringNodeID(1, "x"), ua.StatusBadTargetNodeIDInvalid},
+		{"invalid reference type", objects, objects, dev.ID, ua.StatusBadReferenceTypeIDInvalid},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := as.AddReference(c.source, c.refType, c.target); got != c.want {
+				t.Fatalf("got %v want %v", got, c.want)
+			}
+		})
+	}
+
+	// the references are bidirectional
+	res := browse(t, as, &ua.BrowseDescription{NodeID: temp.ID, BrowseDirection: ua.BrowseDirectionInverse, ResultMask: uint32(ua.BrowseResultMaskAll)})
+	verify.Values(t, "", browseNames(res), []string{"Device"})
+	res = browse(t, as, &ua.BrowseDescription{NodeID: objects, ReferenceTypeID: organizes, ResultMask: uint32(ua.BrowseResultMaskAll)})
+	verify.Values(t, "", browseNames(res), []string{"Server", "Device"})
+}
+
+func TestRead(t *testing.T) {
+	as := New()
+	temp := &VariableNode{
+		BaseNode: BaseNode{ID: ua.NewStringNodeID(1, "temp")},
+		Value:    ua.MustVariant(21.5),
+	}
+	if err := as.AddNode(temp); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.SetValue(temp.ID, ua.MustVariant(22.5)); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(id *ua.NodeID, attrID uint32) *ua.ReadValueID {
+		return &ua.ReadValueID{NodeID: id, AttributeID: attrID, DataEncoding: &ua.QualifiedName{}}
+	}
+	resp, err := as.Read(&ua.ReadRequest{
+		NodesToRead: []*ua.ReadValueID{
+			read(ua.NewNumericNodeID(0, id.RootFolder), ua.IntegerIDBrowseName),
+			read(ua.NewNumericNodeID(0, id.RootFolder), ua.IntegerIDNodeClass),
+			read(temp.ID, ua.IntegerIDValue),
+			read(ua.NewNumericNodeID(0, id.RootFolder), ua.IntegerIDValue),
+			read(ua.NewStringNodeID(1, "unknown"), ua.IntegerIDValue),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*ua.DataValue{
+		{EncodingMask: ua.DataValueValue, Value: ua.MustVariant(&ua.QualifiedName{Name: "Root"})},
+		{EncodingMask: ua.DataValueValue, Value: ua.MustVariant(int32(ua.NodeClassObject))},
+		{EncodingMask: ua.DataValueValue, Value: ua.MustVariant(22.5)},
+		{EncodingMask: ua.DataValueStatus, Status: uint32(ua.StatusBadAttributeIDInvalid)},
+		{EncodingMask: ua.DataValueStatus, Status: uint32(ua.StatusBadNodeIDUnknown)},
+	}
+	verify.Values(t, "", resp.Results, want)
+}
@@ -0,0 +1,356 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package addrspace
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+const (
+	// maxHistoryValues is the maximum number of values which are
+	// returned for a node by a HistoryRead request. The remaining values
+	// are returned with a continuation point.
+	maxHistoryValues = 1000
+
+	// maxHistoryContinuationPoints is the maximum number of continuation
+	// points of a session.
+	maxHistoryContinuationPoints = 16
+
+	// historyContinuationTimeout is the time after which an unused
+	// continuation point is released.
+	historyContinuationTimeout = 10 * time.Minute
+)
+
+// HistoryBackend stores the history of the values of the variables which
+// have the Historizing attribute set. The values of a variable are
+// identified by their source timestamp. The continuation points of the
+// HistoryRead service are handled by the address space.
+//
+// A HistoryBackend must be safe for concurrent use.
+type HistoryBackend interface {
+	// ReadRaw returns the values of the variable with a source timestamp
+	// between start and end including both in ascending order. start or
+	// end can be the zero time for an open range.
+	ReadRaw(id *ua.NodeID, start, end time.Time) ([]*ua.DataValue, error)
+
+	// Insert adds the value. It fails with StatusBadEntryExists if a
+	// value with the same source timestamp exists.
+	Insert(id *ua.NodeID, v *ua.DataValue) error
+
+	// Replace replaces the value with the same source timestamp. It fails
+	// with StatusBadNoEntryExists if there is no such value.
+	Replace(id *ua.NodeID, v *ua.DataValue) error
+
+	// Delete removes the values with a source timestamp between start
+	// and end excluding end. start or end can be the zero time for an
+	// open range.
+	Delete(id *ua.NodeID, start, end time.Time) error
+}
+
+// historyContinuation is the state of a HistoryRead which the client
+// continues with a continuation point.
+type historyContinuation struct {
+	session string
+	values  []*ua.DataValue
+	created time.Time
+}
+
+// SetHistory sets the backend which stores the history of the variables
+// with the Historizing attribute. SetValue records the values of these
+// variables in the backend. If the backend is nil the history services
+// fail with StatusBadHistoryOperationUnsupported.
+func (as *AddressSpace) SetHistory(b HistoryBackend) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.history = b
+}
+
+// historizing returns the history backend if the node is a variable with
+// the Historizing attribute.
+func (as *AddressSpace) historizing(id *ua.NodeID) (HistoryBackend, error) {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+	if id == nil {
+		return nil, ua.StatusBadNodeIDInvalid
+	}
+	n := as.nodes[id.String()]
+	if n == nil {
+		return nil, ua.StatusBadNodeIDUnknown
+	}
+	if v, ok := n.(*VariableNode); !ok || !v.Historizing || as.history == nil {
+		return nil, ua.StatusBadHistoryOperationUnsupported
+	}
+	return as.history, nil
+}
+
+// HistoryRead executes the HistoryRead service on the address space. Only
+// raw reads are supported and the bounding values are not returned. The
+// status of the individual nodes is returned in the results.
+//
+// Specification: Part 11, 6.4.3
+func (as *AddressSpace) HistoryRead(req *ua.HistoryReadRequest) (*ua.HistoryReadResponse, error) {
+	if len(req.NodesToRead) == 0 {
+		return nil, ua.StatusBadNothingToDo
+	}
+	if req.TimestampsToReturn >= ua.TimestampsToReturnNeither {
+		return nil, ua.StatusBadTimestampsToReturnInvalid
+	}
+	if req.HistoryReadDetails == nil || req.HistoryReadDetails.Value == nil {
+		return nil, ua.StatusBadHistoryOperationInvalid
+	}
+	session := ""
+	if hdr := req.RequestHeader; hdr != nil && hdr.AuthenticationToken != nil {
+		session = hdr.AuthenticationToken.String()
+	}
+
+	resp := &ua.HistoryReadResponse{}
+	for _, n := range req.NodesToRead {
+		var r *ua.HistoryReadResult
+		switch d := req.HistoryReadDetails.Value.(type) {
+		case *ua.ReadRawModifiedDetails:
+			if d.IsReadModified {
+				r = &ua.HistoryReadResult{StatusCode: ua.StatusBadHistoryOperationUnsupported}
+				break
+			}
+			r = as.historyReadRaw(session, n, d, req.ReleaseContinuationPoints)
+		default:
+			r = &ua.HistoryReadResult{StatusCode: ua.StatusBadHistoryOperationUnsupported}
+		}
+		if r.HistoryData == nil {
+			r.HistoryData = ua.NewExtensionObject(nil)
+		}
+		if r.HistoryData.Value != nil {
+			filterTimestamps(r.HistoryData.Value.(*ua.HistoryData).DataValues, req.TimestampsToReturn)
+		}
+		resp.Results = append(resp.Results, r)
+	}
+	return resp, nil
+}
+
+// historyReadRaw returns the next page of values of the raw read of the
+// node or the first page if the read has no continuation point.
+func (as *AddressSpace) historyReadRaw(session string, n *ua.HistoryReadValueID, d *ua.ReadRawModifiedDetails, release bool) *ua.HistoryReadResult {
+	if len(n.ContinuationPoint) > 0 {
+		values, err := as.continuation(session, n.ContinuationPoint)
+		if err != nil {
+			return &ua.HistoryReadResult{StatusCode: statusCode(err)}
+		}
+		if release {
+			return &ua.HistoryReadResult{StatusCode: ua.StatusOK}
+		}
+		return as.historyPage(session, values, int(d.NumValuesPerNode))
+	}
+	if release {
+		return &ua.HistoryReadResult{StatusCode: ua.StatusOK}
+	}
+
+	start, end := d.StartTime, d.EndTime
+	if start.IsZero() && end.IsZero() {
+		return &ua.HistoryReadResult{StatusCode: ua.StatusBadInvalidTimestampArgument}
+	}
+	if start.IsZero() && d.NumValuesPerNode == 0 {
+		return &ua.HistoryReadResult{StatusCode: ua.StatusBadInvalidTimestampArgument}
+	}
+
+	b, err := as.historizing(n.NodeID)
+	if err != nil {
+		return &ua.HistoryReadResult{StatusCode: statusCode(err)}
+	}
+
+	// the values are returned in reverse order if the end is before the
+	// start or only the end is given.
+	reverse := start.IsZero() || !end.IsZero() && end.Before(start)
+	if reverse && !start.IsZero() {
+		start, end = end, start
+	}
+	values, err := b.ReadRaw(n.NodeID, start, end)
+	if err != nil {
+		return &ua.HistoryReadResult{StatusCode: statusCode(err)}
+	}
+	if reverse {
+		for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+	if len(values) == 0 {
+		return &ua.HistoryReadResult{StatusCode: ua.StatusGoodNoData}
+	}
+	return as.historyPage(session, values, int(d.NumValuesPerNode))
+}
+
+// historyPage returns a result with the first values and a continuation
+// point for the remaining values.
+func (as *AddressSpace) historyPage(session string, values []*ua.DataValue, max int) *ua.HistoryReadResult {
+	if max <= 0 || max > maxHistoryValues {
+		max = maxHistoryValues
+	}
+	r := &ua.HistoryReadResult{StatusCode: ua.StatusOK}
+	if len(values) > max {
+		cp, err := as.addContinuation(session, values[max:])
+		if err != nil {
+			return &ua.HistoryReadResult{StatusCode: statusCode(err)}
+		}
+		values, r.ContinuationPoint = values[:max], cp
+	}
+	r.HistoryData = ua.NewExtensionObject(&ua.HistoryData{DataValues: values})
+	return r
+}
+
+// addContinuation stores the remaining values of a read and returns the
+// continuation point for them. It fails with StatusBadNoContinuationPoints
+// if the session has too many continuation points.
+func (as *AddressSpace) addContinuation(session string, values []*ua.DataValue) ([]byte, error) {
+	cp := make([]byte, 16)
+	if _, err := rand.Read(cp); err != nil {
+		return nil, err
+	}
+
+	as.cpMu.Lock()
+	defer as.cpMu.Unlock()
+	now := time.Now()
+	count := 0
+	for k, c := range as.cps {
+		switch {
+		case now.Sub(c.created) > historyContinuationTimeout:
+			delete(as.cps, k)
+		case c.session == session:
+			count++
+		}
+	}
+	if count >= maxHistoryContinuationPoints {
+		return nil, ua.StatusBadNoContinuationPoints
+	}
+	if as.cps == nil {
+		as.cps = make(map[string]*historyContinuation)
+	}
+	as.cps[string(cp)] = &historyContinuation{session: session, values: values, created: now}
+	return cp, nil
+}
+
+// continuation removes the continuation point of the session and returns
+// its remaining values.
+func (as *AddressSpace) continuation(session string, cp []byte) ([]*ua.DataValue, error) {
+	as.cpMu.Lock()
+	defer as.cpMu.Unlock()
+	c := as.cps[string(cp)]
+	if c == nil || c.session != session {
+		return nil, ua.StatusBadContinuationPointInvalid
+	}
+	delete(as.cps, string(cp))
+	return c.values, nil
+}
+
+// filterTimestamps replaces the values with copies which only have the
+// requested timestamps.
+func filterTimestamps(values []*ua.DataValue, ts ua.TimestampsToReturn) {
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		c := *v
+		switch ts {
+		case ua.TimestampsToReturnSource:
+			c.EncodingMask &^= ua.DataValueServerTimestamp | ua.DataValueServerPicoseconds
+			c.ServerTimestamp, c.ServerPicoseconds = time.Time{}, 0
+		case ua.TimestampsToReturnServer:
+			c.EncodingMask &^= ua.DataValueSourceTimestamp | ua.DataValueSourcePicoseconds
+			c.SourceTimestamp, c.SourcePicoseconds = time.Time{}, 0
+		}
+		values[i] = &c
+	}
+}
+
+// HistoryUpdate executes the HistoryUpdate service on the address space.
+// The values of variables can be inserted, replaced, updated and deleted.
+// The status of the individual operations is returned in the results.
+//
+// Specification: Part 11, 6.8
+func (as *AddressSpace) HistoryUpdate(req *ua.HistoryUpdateRequest) (*ua.HistoryUpdateResponse, error) {
+	if len(req.HistoryUpdateDetails) == 0 {
+		return nil, ua.StatusBadNothingToDo
+	}
+
+	resp := &ua.HistoryUpdateResponse{}
+	for _, eo := range req.HistoryUpdateDetails {
+		var r *ua.HistoryUpdateResult
+		switch d := eo.Value.(type) {
+		case *ua.UpdateDataDetails:
+			r = as.updateData(d)
+		case *ua.DeleteRawModifiedDetails:
+			r = as.deleteRaw(d)
+		default:
+			r = &ua.HistoryUpdateResult{StatusCode: ua.StatusBadHistoryOperationUnsupported}
+		}
+		resp.Results = append(resp.Results, r)
+	}
+	return resp, nil
+}
+
+func (as *AddressSpace) updateData(d *ua.UpdateDataDetails) *ua.HistoryUpdateResult {
+	b, err := as.historizing(d.NodeID)
+	if err != nil {
+		return &ua.HistoryUpdateResult{StatusCode: statusCode(err)}
+	}
+	switch d.PerformInsertReplace {
+	case ua.PerformUpdateTypeInsert, ua.PerformUpdateTypeReplace, ua.PerformUpdateTypeUpdate:
+	default:
+		return &ua.HistoryUpdateResult{StatusCode: ua.StatusBadHistoryOperationInvalid}
+	}
+
+	r := &ua.HistoryUpdateResult{StatusCode: ua.StatusOK}
+	for _, v := range d.UpdateValues {
+		r.OperationResults = append(r.OperationResults, updateValue(b, d.NodeID, d.PerformInsertReplace, v))
+	}
+	return r
+}
+
+// updateValue inserts or replaces the value and returns the status of the
+// operation.
+func updateValue(b HistoryBackend, id *ua.NodeID, op ua.PerformUpdateType, v *ua.DataValue) ua.StatusCode {
+	if v == nil || v.SourceTimestamp.IsZero() {
+		return ua.StatusBadInvalidTimestamp
+	}
+	switch op {
+	case ua.PerformUpdateTypeInsert:
+		if err := b.Insert(id, v); err != nil {
+			return statusCode(err)
+		}
+		return ua.StatusGoodEntryInserted
+	case ua.PerformUpdateTypeReplace:
+		if err := b.Replace(id, v); err != nil {
+			return statusCode(err)
+		}
+		return ua.StatusGoodEntryReplaced
+	default:
+		switch err := b.Replace(id, v); err {
+		case nil:
+			return ua.StatusGoodEntryReplaced
+		case ua.StatusBadNoEntryExists:
+			return updateValue(b, id, ua.PerformUpdateTypeInsert, v)
+		default:
+			return statusCode(err)
+		}
+	}
+}
+
+func (as *AddressSpace) deleteRaw(d *ua.DeleteRawModifiedDetails) *ua.HistoryUpdateResult {
+	if d.IsDeleteModified {
+		return &ua.HistoryUpdateResult{StatusCode: ua.StatusBadHistoryOperationUnsupported}
+	}
+	if d.StartTime.IsZero() && d.EndTime.IsZero() {
+		return &ua.HistoryUpdateResult{StatusCode: ua.StatusBadInvalidTimestampArgument}
+	}
+	b, err := as.historizing(d.NodeID)
+	if err != nil {
+		return &ua.HistoryUpdateResult{StatusCode: statusCode(err)}
+	}
+	if err := b.Delete(d.NodeID, d.StartTime, d.EndTime); err != nil {
+		return &ua.HistoryUpdateResult{StatusCode: statusCode(err)}
+	}
+	return &ua.HistoryUpdateResult{StatusCode: ua.StatusOK}
+}
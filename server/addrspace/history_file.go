@@ -0,0 +1,203 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package addrspace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+// The operations of the records of a FileHistory.
+const (
+	recordInsert byte = iota + 1
+	recordReplace
+	recordDelete
+)
+
+// maxRecordSize is the maximum size of a record of a FileHistory.
+const maxRecordSize = 16 << 20
+
+// historyRecord is a change of the history of a variable. It is stored
+// in the file with its length as uint32 in front.
+type historyRecord struct {
+	Op        byte
+	NodeID    *ua.NodeID
+	Value     *ua.DataValue
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// FileHistory is a HistoryBackend which appends every change of the
+// history to a file. The file is read when it is opened and all values
+// are kept in memory. A record which has not been written completely,
+// e.g. after a crash, is removed from the end of the file.
+type FileHistory struct {
+	mu     sync.RWMutex
+	f      historyFile
+	values map[string]*ring
+
+	// off is the end of the last complete record in the file. A record
+	// is always written at off so that a failed write does not leave an
+	// incomplete record in front of the next one.
+	off int64
+
+	// torn is set when an incomplete record could not be removed after
+	// a failed write. It is removed before the next record is written.
+	torn bool
+}
+
+// historyFile is the file of a FileHistory. It is replaced by the tests.
+type historyFile interface {
+	io.Reader
+	io.WriterAt
+	io.Closer
+	Truncate(size int64) error
+}
+
+// OpenFileHistory opens the history in the file with the given name. The
+// file is created if it does not exist.
+func OpenFileHistory(name string) (*FileHistory, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	h := &FileHistory{f: f, values: make(map[string]*ring)}
+	if err := h.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// load applies the records of the file and truncates it after the last
+// complete record.
+func (h *FileHistory) load() error {
+	r := bufio.NewReader(h.f)
+	var off int64
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		n := binary.LittleEndian.Uint32(hdr[:])
+		if n > maxRecordSize {
+			return fmt.Errorf("addrspace: invalid history record at offset %d", off)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		rec := new(historyRecord)
+		if _, err := ua.Decode(b, rec); err != nil {
+			return fmt.Errorf("addrspace: invalid history record at offset %d: %s", off, err)
+		}
+		h.apply(rec)
+		off += int64(len(hdr)) + int64(n)
+	}
+
+	h.off = off
+	return h.f.Truncate(off)
+}
+
+// apply applies the record to the values in memory. h.mu must be held.
+func (h *FileHistory) apply(rec *historyRecord) {
+	k := rec.NodeID.String()
+	r := h.values[k]
+	if r == nil {
+		r = &ring{}
+		h.values[k] = r
+	}
+	switch rec.Op {
+	case recordInsert:
+		r.insert(rec.Value)
+	case recordReplace:
+		r.replace(rec.Value)
+	case recordDelete:
+		r.delete(rec.StartTime, rec.EndTime)
+	}
+}
+
+// append writes the record to the file and applies it. h.mu must be held.
+func (h *FileHistory) append(rec *historyRecord) error {
+	b, err := ua.Encode(rec)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4+len(b))
+	binary.LittleEndian.PutUint32(buf, uint32(len(b)))
+	copy(buf[4:], b)
+
+	if h.torn {
+		if err := h.f.Truncate(h.off); err != nil {
+			return err
+		}
+		h.torn = false
+	}
+	if _, err := h.f.WriteAt(buf, h.off); err != nil {
+		h.torn = h.f.Truncate(h.off) != nil
+		return err
+	}
+	h.off += int64(len(buf))
+	h.apply(rec)
+	return nil
+}
+
+// ReadRaw implements HistoryBackend.
+func (h *FileHistory) ReadRaw(id *ua.NodeID, start, end time.Time) ([]*ua.DataValue, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	r := h.values[id.String()]
+	if r == nil {
+		return nil, nil
+	}
+	return r.read(start, end), nil
+}
+
+// Insert implements HistoryBackend.
+func (h *FileHistory) Insert(id *ua.NodeID, v *ua.DataValue) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r := h.values[id.String()]; r != nil && r.has(v.SourceTimestamp) {
+		return ua.StatusBadEntryExists
+	}
+	return h.append(&historyRecord{Op: recordInsert, NodeID: id, Value: v})
+}
+
+// Replace implements HistoryBackend.
+func (h *FileHistory) Replace(id *ua.NodeID, v *ua.DataValue) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r := h.values[id.String()]; r == nil || !r.has(v.SourceTimestamp) {
+		return ua.StatusBadNoEntryExists
+	}
+	return h.append(&historyRecord{Op: recordReplace, NodeID: id, Value: v})
+}
+
+// Delete implements HistoryBackend.
+func (h *FileHistory) Delete(id *ua.NodeID, start, end time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.append(&historyRecord{Op: recordDelete, NodeID: id, Value: &ua.DataValue{}, StartTime: start, EndTime: end})
+}
+
+// Close closes the file.
+func (h *FileHistory) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.f.Close()
+}
@@ -0,0 +1,200 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package addrspace
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+// MemoryHistory is a HistoryBackend which keeps the last values of every
+// variable in a ring buffer. When the buffer is full the oldest value is
+// dropped. A value which is older than all values of a full buffer is
+// rejected with StatusBadOutOfRange.
+type MemoryHistory struct {
+	size int
+
+	mu     sync.RWMutex
+	values map[string]*ring
+}
+
+// NewMemoryHistory returns a backend which keeps the last size values of
+// every variable.
+func NewMemoryHistory(size int) *MemoryHistory {
+	if size <= 0 {
+		panic("addrspace: invalid history size")
+	}
+	return &MemoryHistory{size: size, values: make(map[string]*ring)}
+}
+
+// ReadRaw implements HistoryBackend.
+func (h *MemoryHistory) ReadRaw(id *ua.NodeID, start, end time.Time) ([]*ua.DataValue, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	r := h.values[id.String()]
+	if r == nil {
+		return nil, nil
+	}
+	return r.read(start, end), nil
+}
+
+// Insert implements HistoryBackend.
+func (h *MemoryHistory) Insert(id *ua.NodeID, v *ua.DataValue) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := h.values[id.String()]
+	if r == nil {
+		r = &ring{max: h.size}
+		h.values[id.String()] = r
+	}
+	return r.insert(v)
+}
+
+// Replace implements HistoryBackend.
+func (h *MemoryHistory) Replace(id *ua.NodeID, v *ua.DataValue) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := h.values[id.String()]
+	if r == nil {
+		return ua.StatusBadNoEntryExists
+	}
+	return r.replace(v)
+}
+
+// Delete implements HistoryBackend.
+func (h *MemoryHistory) Delete(id *ua.NodeID, start, end time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r := h.values[id.String()]; r != nil {
+		r.delete(start, end)
+	}
+	return nil
+}
+
+// ring is a ring buffer of values in ascending order of their source
+// timestamps. If max is 0 the buffer grows without limit.
+type ring struct {
+	buf  []*ua.DataValue
+	head int
+	n    int
+	max  int
+}
+
+// at returns the i-th oldest value.
+func (r *ring) at(i int) *ua.DataValue {
+	return r.buf[(r.head+i)%len(r.buf)]
+}
+
+func (r *ring) set(i int, v *ua.DataValue) {
+	r.buf[(r.head+i)%len(r.buf)] = v
+}
+
+// search returns the index of the first value with a source timestamp
+// which is not before t.
+func (r *ring) search(t time.Time) int {
+	return sort.Search(r.n, func(i int) bool {
+		return !r.at(i).SourceTimestamp.Before(t)
+	})
+}
+
+// has returns true if there is a value with the source timestamp t.
+func (r *ring) has(t time.Time) bool {
+	i := r.search(t)
+	return i < r.n && r.at(i).SourceTimestamp.Equal(t)
+}
+
+// read returns the values between start and end including both.
+func (r *ring) read(start, end time.Time) []*ua.DataValue {
+	i, j := 0, r.n
+	if !start.IsZero() {
+		i = r.search(start)
+	}
+	if !end.IsZero() {
+		j = r.search(end.Add(1))
+	}
+	var values []*ua.DataValue
+	for ; i < j; i++ {
+		values = append(values, r.at(i))
+	}
+	return values
+}
+
+func (r *ring) insert(v *ua.DataValue) error {
+	if r.has(v.SourceTimestamp) {
+		return ua.StatusBadEntryExists
+	}
+	i := r.search(v.SourceTimestamp)
+
+	if r.n == len(r.buf) {
+		if r.max > 0 && r.n == r.max {
+			// drop the oldest value unless v is older
+			if i == 0 {
+				return ua.StatusBadOutOfRange
+			}
+			r.buf[r.head] = nil
+			r.head = (r.head + 1) % len(r.buf)
+			r.n--
+			i--
+		} else {
+			r.grow()
+		}
+	}
+
+	r.n++
+	for k := r.n - 1; k > i; k-- {
+		r.set(k, r.at(k-1))
+	}
+	r.set(i, v)
+	return nil
+}
+
+// grow doubles the capacity of the buffer up to max.
+func (r *ring) grow() {
+	size := 2 * len(r.buf)
+	if size == 0 {
+		size = 16
+	}
+	if r.max > 0 && size > r.max {
+		size = r.max
+	}
+	buf := make([]*ua.DataValue, size)
+	for i := 0; i < r.n; i++ {
+		buf[i] = r.at(i)
+	}
+	r.buf, r.head = buf, 0
+}
+
+func (r *ring) replace(v *ua.DataValue) error {
+	if !r.has(v.SourceTimestamp) {
+		return ua.StatusBadNoEntryExists
+	}
+	r.set(r.search(v.SourceTimestamp), v)
+	return nil
+}
+
+// delete removes the values between start and end excluding end.
+func (r *ring) delete(start, end time.Time) {
+	i, j := 0, r.n
+	if !start.IsZero() {
+		i = r.search(start)
+	}
+	if !end.IsZero() {
+		j = r.search(end)
+	}
+	if i >= j {
+		return
+	}
+	d := j - i
+	for k := i; k+d < r.n; k++ {
+		r.set(k, r.at(k+d))
+	}
+	for k := r.n - d; k < r.n; k++ {
+		r.set(k, nil)
+	}
+	r.n -= d
+}
@@ -0,0 +1,447 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package addrspace
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+var testHistoryStart = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// at returns the time sec seconds after testHistoryStart.
+func at(sec int) time.Time {
+	return testHistoryStart.Add(time.Duration(sec) * time.Second)
+}
+
+// historyValue returns the value sec with the source timestamp at(sec).
+func historyValue(sec int) *ua.DataValue {
+	return &ua.DataValue{
+		EncodingMask:    ua.DataValueValue | ua.DataValueSourceTimestamp | ua.DataValueServerTimestamp,
+		Value:           ua.MustVariant(float64(sec)),
+		SourceTimestamp: at(sec),
+		ServerTimestamp: at(sec),
+	}
+}
+
+// seconds returns the values of the data values.
+func seconds(values []*ua.DataValue) []float64 {
+	var secs []float64
+	for _, v := range values {
+		secs = append(secs, v.Value.Value.(float64))
+	}
+	return secs
+}
+
+// testBackend runs the tests which all history backends must pass.
+func testBackend(t *testing.T, b HistoryBackend) {
+	t.Helper()
+	id := ua.NewStringNodeID(1, "temp")
+
+	read := func(start, end time.Time) []float64 {
+		t.Helper()
+		values, err := b.ReadRaw(id, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return seconds(values)
+	}
+
+	for _, sec := range []int{3, 1, 2, 5, 4} {
+		if err := b.Insert(id, historyValue(sec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := b.Insert(id, historyValue(3)), error(ua.StatusBadEntryExists); got != want {
+		t.Fatalf("got error %v want %v", got, want)
+	}
+	verify.Values(t, "", read(at(2), at(4)), []float64{2, 3, 4})
+	verify.Values(t, "", read(at(4), time.Time{}), []float64{4, 5})
+	verify.Values(t, "", read(time.Time{}, at(1)), []float64{1})
+
+	v := historyValue(3)
+	v.Value = ua.MustVariant(30.0)
+	if err := b.Replace(id, v); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.Replace(id, historyValue(6)), error(ua.StatusBadNoEntryExists); got != want {
+		t.Fatalf("got error %v want %v", got, want)
+	}
+	verify.Values(t, "", read(time.Time{}, at(9)), []float64{1, 2, 30, 4, 5})
+
+	if err := b.Delete(id, at(2), at(4)); err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "", read(time.Time{}, at(9)), []float64{1, 4, 5})
+	if vs, err := b.ReadRaw(ua.NewStringNodeID(1, "unknown"), at(0), at(9)); err != nil || len(vs) != 0 {
+		t.Fatalf("got %v, %v want no values", vs, err)
+	}
+}
+
+func TestMemoryHistory(t *testing.T) {
+	t.Run("backend", func(t *testing.T) {
+		testBackend(t, NewMemoryHistory(10))
+	})
+
+	t.Run("ring", func(t *testing.T) {
+		h := NewMemoryHistory(3)
+		id := ua.NewStringNodeID(1, "temp")
+		for _, sec := range []int{2, 1, 3, 5, 4} {
+			if err := h.Insert(id, historyValue(sec)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// the value is older than all values of the full buffer
+		if got, want := h.Insert(id, historyValue(0)), error(ua.StatusBadOutOfRange); got != want {
+			t.Fatalf("got error %v want %v", got, want)
+		}
+		if got, want := updateValue(h, id, ua.PerformUpdateTypeUpdate, historyValue(0)), ua.StatusBadOutOfRange; got != want {
+			t.Fatalf("got status %v want %v", got, want)
+		}
+		values, err := h.ReadRaw(id, time.Time{}, at(9))
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, "", seconds(values), []float64{3, 4, 5})
+	})
+}
+
+func TestFileHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "history")
+
+	h, err := OpenFileHistory(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, h)
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// an incomplete record at the end of the file is removed
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{0x10, 0x00, 0x00, 0x00, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	h, err = OpenFileHistory(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := ua.NewStringNodeID(1, "temp")
+	if err := h.Insert(id, historyValue(6)); err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+
+	h, err = OpenFileHistory(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	values, err := h.ReadRaw(id, time.Time{}, at(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "", values, []*ua.DataValue{historyValue(1), historyValue(4), historyValue(5), historyValue(6)})
+}
+
+// failingFile is a history file which writes only the first half of a
+// record and fails while fail is set.
+type failingFile struct {
+	*os.File
+	fail bool
+}
+
+func (f *failingFile) WriteAt(b []byte, off int64) (int, error) {
+	if !f.fail {
+		return f.File.WriteAt(b, off)
+	}
+	n, err := f.File.WriteAt(b[:len(b)/2], off)
+	if err != nil {
+		return n, err
+	}
+	return n, errors.New("disk full")
+}
+
+func TestFileHistoryFailedWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "history")
+
+	h, err := OpenFileHistory(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &failingFile{File: h.f.(*os.File)}
+	h.f = f
+
+	id := ua.NewStringNodeID(1, "temp")
+	if err := h.Insert(id, historyValue(1)); err != nil {
+		t.Fatal(err)
+	}
+	f.fail = true
+	if err := h.Insert(id, historyValue(2)); err == nil {
+		t.Fatal("got nil want error")
+	}
+	f.fail = false
+	if err := h.Insert(id, historyValue(3)); err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+
+	// the incomplete record has been removed
+	h, err = OpenFileHistory(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	values, err := h.ReadRaw(id, time.Time{}, at(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "", values, []*ua.DataValue{historyValue(1), historyValue(3)})
+}
+
+func newHistoryAddressSpace(t *testing.T) (as *AddressSpace, temp, other *ua.NodeID) {
+	t.Helper()
+	as = New()
+	as.SetHistory(NewMemoryHistory(100))
+	temp, other = ua.NewStringNodeID(1, "temp"), ua.NewStringNodeID(1, "other")
+	if err := as.AddNode(&VariableNode{BaseNode: BaseNode{ID: temp}, Historizing: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.AddNode(&VariableNode{BaseNode: BaseNode{ID: other}}); err != nil {
+		t.Fatal(err)
+	}
+	return as, temp, other
+}
+
+func TestHistoryUpdate(t *testing.T) {
+	as, temp, other := newHistoryAddressSpace(t)
+	replaced := historyValue(2)
+	replaced.Value = ua.MustVariant(20.0)
+
+	resp, err := as.HistoryUpdate(&ua.HistoryUpdateRequest{
+		HistoryUpdateDetails: []*ua.ExtensionObject{
+			ua.NewExtensionObject(&ua.UpdateDataDetails{
+				NodeID:               temp,
+				PerformInsertReplace: ua.PerformUpdateTypeInsert,
+				UpdateValues:         []*ua.DataValue{historyValue(1), historyValue(2), historyValue(3), historyValue(1), {}},
+			}),
+			ua.NewExtensionObject(&ua.UpdateDataDetails{
+				NodeID:               temp,
+				PerformInsertReplace: ua.PerformUpdateTypeUpdate,
+				UpdateValues:         []*ua.DataValue{replaced, historyValue(4)},
+			}),
+			ua.NewExtensionObject(&ua.DeleteRawModifiedDetails{NodeID: temp, StartTime: at(3), EndTime: at(4)}),
+			ua.NewExtensionObject(&ua.UpdateDataDetails{NodeID: other, PerformInsertReplace: ua.PerformUpdateTypeInsert}),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "", resp.Results, []*ua.HistoryUpdateResult{
+		{
+			StatusCode: ua.StatusOK,
+			OperationResults: []ua.StatusCode{
+				ua.StatusGoodEntryInserted,
+				ua.StatusGoodEntryInserted,
+				ua.StatusGoodEntryInserted,
+				ua.StatusBadEntryExists,
+				ua.StatusBadInvalidTimestamp,
+			},
+		},
+		{
+			StatusCode:       ua.StatusOK,
+			OperationResults: []ua.StatusCode{ua.StatusGoodEntryReplaced, ua.StatusGoodEntryInserted},
+		},
+		{StatusCode: ua.StatusOK},
+		{StatusCode: ua.StatusBadHistoryOperationUnsupported},
+	})
+
+	values, err := as.history.ReadRaw(temp, time.Time{}, at(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "", seconds(values), []float64{1, 20, 4})
+}
+
+func TestHistoryRead(t *testing.T) {
+	as, temp, other := newHistoryAddressSpace(t)
+	for i := 0; i < 5; i++ {
+		if err := as.history.Insert(temp, historyValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session := &ua.RequestHeader{AuthenticationToken: ua.NewStringNodeID(0, "session")}
+
+	read := func(hdr *ua.RequestHeader, d *ua.ReadRawModifiedDetails, release bool, nodes ...*ua.HistoryReadValueID) []*ua.HistoryReadResult {
+		t.Helper()
+		resp, err := as.HistoryRead(&ua.HistoryReadRequest{
+			RequestHeader:             hdr,
+			HistoryReadDetails:        ua.NewExtensionObject(d),
+			TimestampsToReturn:        ua.TimestampsToReturnSource,
+			ReleaseContinuationPoints: release,
+			NodesToRead:               nodes,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Results
+	}
+	values := func(r *ua.HistoryReadResult) []float64 {
+		t.Helper()
+		if r.StatusCode.IsBad() {
+			t.Fatalf("got status %v", r.StatusCode)
+		}
+		d := r.HistoryData.Value.(*ua.HistoryData)
+		for _, v := range d.DataValues {
+			if v.EncodingMask&ua.DataValueServerTimestamp != 0 {
+				t.Fatal("got server timestamp")
+			}
+		}
+		return seconds(d.DataValues)
+	}
+	node := func(id *ua.NodeID, cp []byte) *ua.HistoryReadValueID {
+		return &ua.HistoryReadValueID{NodeID: id, DataEncoding: &ua.QualifiedName{}, ContinuationPoint: cp}
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		d := &ua.ReadRawModifiedDetails{StartTime: at(0), EndTime: at(9), NumValuesPerNode: 2}
+		var got []float64
+		var cp []byte
+		for i := 0; i < 3; i++ {
+			r := read(session, d, false, node(temp, cp))[0]
+			got = append(got, values(r)...)
+			cp = r.ContinuationPoint
+		}
+		verify.Values(t, "", got, []float64{0, 1, 2, 3, 4})
+		if cp != nil {
+			t.Fatalf("got continuation point %q after the last page", cp)
+		}
+	})
+
+	t.Run("reverse", func(t *testing.T) {
+		r := read(session, &ua.ReadRawModifiedDetails{StartTime: at(3), EndTime: at(1)}, false, node(temp, nil))[0]
+		verify.Values(t, "", values(r), []float64{3, 2, 1})
+		r = read(session, &ua.ReadRawModifiedDetails{EndTime: at(3), NumValuesPerNode: 2}, false, node(temp, nil))[0]
+		verify.Values(t, "", values(r), []float64{3, 2})
+	})
+
+	t.Run("release", func(t *testing.T) {
+		d := &ua.ReadRawModifiedDetails{StartTime: at(0), EndTime: at(9), NumValuesPerNode: 2}
+		cp := read(session, d, false, node(temp, nil))[0].ContinuationPoint
+		if cp == nil {
+			t.Fatal("got no continuation point")
+		}
+
+		// the continuation point belongs to the session
+		if got, want := read(nil, d, false, node(temp, cp))[0].StatusCode, ua.StatusBadContinuationPointInvalid; got != want {
+			t.Fatalf("got status %v want %v", got, want)
+		}
+		if got, want := read(session, d, true, node(temp, cp))[0].StatusCode, ua.StatusOK; got != want {
+			t.Fatalf("got status %v want %v", got, want)
+		}
+		if got, want := read(session, d, false, node(temp, cp))[0].StatusCode, ua.StatusBadContinuationPointInvalid; got != want {
+			t.Fatalf("got status %v want %v", got, want)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		results := read(session, &ua.ReadRawModifiedDetails{StartTime: at(0), EndTime: at(9)}, false,
+			node(other, nil),
+			node(ua.NewStringNodeID(1, "unknown"), nil),
+		)
+		codes := []ua.StatusCode{results[0].StatusCode, results[1].StatusCode}
+		verify.Values(t, "", codes, []ua.StatusCode{ua.StatusBadHistoryOperationUnsupported, ua.StatusBadNodeIDUnknown})
+
+		r := read(session, &ua.ReadRawModifiedDetails{}, false, node(temp, nil))[0]
+		if got, want := r.StatusCode, ua.StatusBadInvalidTimestampArgument; got != want {
+			t.Fatalf("got status %v want %v", got, want)
+		}
+		r = read(session, &ua.ReadRawModifiedDetails{StartTime: at(7), EndTime: at(9)}, false, node(temp, nil))[0]
+		if got, want := r.StatusCode, ua.StatusGoodNoData; got != want {
+			t.Fatalf("got status %v want %v", got, want)
+		}
+	})
+}
+
+func TestSetValueHistory(t *testing.T) {
+	as, temp, other := newHistoryAddressSpace(t)
+	start := time.Now()
+	for _, id := range []*ua.NodeID{temp, other} {
+		if err := as.SetValue(id, ua.MustVariant(21.5)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	values, err := as.history.ReadRaw(temp, start, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := seconds(values), []float64{21.5}; !verify.Values(t, "", got, want) {
+		return
+	}
+	if values[0].SourceTimestamp.Before(start) {
+		t.Fatalf("got source timestamp %v before %v", values[0].SourceTimestamp, start)
+	}
+	if values, _ := as.history.ReadRaw(other, start, time.Time{}); len(values) != 0 {
+		t.Fatalf("got %d values for a variable which is not historizing", len(values))
+	}
+}
+
+// blockingHistory is a history backend which blocks an insert until
+// release is closed.
+type blockingHistory struct {
+	*MemoryHistory
+	inserting, release chan struct{}
+}
+
+func (h *blockingHistory) Insert(id *ua.NodeID, v *ua.DataValue) error {
+	close(h.inserting)
+	<-h.release
+	return h.MemoryHistory.Insert(id, v)
+}
+
+func TestSetValueHistoryUnlocked(t *testing.T) {
+	as, temp, _ := newHistoryAddressSpace(t)
+	b := &blockingHistory{MemoryHistory: NewMemoryHistory(10), inserting: make(chan struct{}), release: make(chan struct{})}
+	as.SetHistory(b)
+
+	done := make(chan error, 1)
+	go func() { done <- as.SetValue(temp, ua.MustVariant(21.5)) }()
+	<-b.inserting
+
+	// the address space can be read while the value is recorded
+	resp, err := as.Read(&ua.ReadRequest{NodesToRead: []*ua.ReadValueID{{NodeID: temp, AttributeID: ua.IntegerIDValue}}})
+	close(b.release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "", resp.Results[0].Value, ua.MustVariant(21.5))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package addrspace
+
+import (
+	"github.com/gopcua/opcua/ua"
+)
+
+// Node is a node in the address space. The node types of this package
+// implement it for each node class.
+type Node interface {
+	// Base returns the attributes and references which all node
+	// classes have in common.
+	Base() *BaseNode
+
+	// NodeClass returns the node class of the node.
+	NodeClass() ua.NodeClass
+
+	// Attribute returns the value of the attribute with the given id.
+	// It fails with StatusBadAttributeIDInvalid if the node class does
+	// not have the attribute.
+	Attribute(attrID uint32) (*ua.Variant, error)
+}
+
+// Reference is a reference from a node to a target node. Every reference
+// is stored in forward direction on the source node and in inverse
+// direction on the target node.
+type Reference struct {
+	ReferenceTypeID *ua.NodeID
+	IsForward       bool
+	TargetID        *ua.NodeID
+}
+
+// BaseNode contains the attributes of all node classes and the
+// references of the node.
+//
+// Specification: Part 3, 5.2
+type BaseNode struct {
+	ID            *ua.NodeID
+	BrowseName    *ua.QualifiedName
+	DisplayName   *ua.LocalizedText
+	Description   *ua.LocalizedText
+	WriteMask     uint32
+	UserWriteMask uint32
+
+	refs []*Reference
+}
+
+// Base returns the node itself.
+func (n *BaseNode) Base() *BaseNode {
+	return n
+}
+
+// References returns the references of the node.
+func (n *BaseNode) References() []*Reference {
+	return n.refs
+}
+
+// attribute returns the value of one of the common attributes.
+func (n *BaseNode) attribute(attrID uint32, class ua.NodeClass) (*ua.Variant, error) {
+	switch attrID {
+	case ua.IntegerIDNodeID:
+		return ua.NewVariant(n.ID)
+	case ua.IntegerIDNodeClass:
+		return ua.NewVariant(int32(class))
+	case ua.IntegerIDBrowseName:
+		if n.BrowseName == nil {
+			return ua.NewVariant(&ua.QualifiedName{})
+		}
+		return ua.NewVariant(n.BrowseName)
+	case ua.IntegerIDDisplayName:
+		return localizedText(n.DisplayName)
+	case ua.IntegerIDDescription:
+		return localizedText(n.Description)
+	case ua.IntegerIDWriteMask:
+		return ua.NewVariant(n.WriteMask)
+	case ua.IntegerIDUserWriteMask:
+		return ua.NewVariant(n.UserWriteMask)
+	default:
+		return nil, ua.StatusBadAttributeIDInvalid
+	}
+}
+
+// localizedText returns the variant for the localized text with the
+// encoding mask set. A nil text is returned as empty text.
+func localizedText(t *ua.LocalizedText) (*ua.Variant, error) {
+	v := &ua.LocalizedText{}
+	if t != nil {
+		*v = *t
+	}
+	v.UpdateMask()
+	return ua.NewVariant(v)
+}
+
+// ObjectNode is a node of the Object node class.
+//
+// Specification: Part 3, 5.5.1
+type ObjectNode struct {
+	BaseNode
+	EventNotifier byte
+}
+
+func (n *ObjectNode) NodeClass() ua.NodeClass {
+	return ua.NodeClassObject
+}
+
+func (n *ObjectNode) Attribute(attrID uint32) (*ua.Variant, error) {
+	switch attrID {
+	case ua.IntegerIDEventNotifier:
+		return ua.NewVariant(n.EventNotifier)
+	default:
+		return n.attribute(attrID, n.NodeClass())
+	}
+}
+
+// VariableNode is a node of the Variable node class.
+//
+// Specification: Part 3, 5.6.2
+type VariableNode struct {
+	BaseNode
+	Value                   *ua.Variant
+	DataType                *ua.NodeID
+	ValueRank               int32
+	AccessLevel             byte
+	UserAccessLevel         byte
+	MinimumSamplingInterval float64
+	Historizing             bool
+}
+
+func (n *VariableNode) NodeClass() ua.NodeClass {
+	return ua.NodeClassVariable
+}
+
+func (n *VariableNode) Attribute(attrID uint32) (*ua.Variant, error) {
+	switch attrID {
+	case ua.IntegerIDValue:
+		if n.Value == nil {
+			return &ua.Variant{}, nil
+		}
+		return n.Value, nil
+	case ua.IntegerIDDataType:
+		return ua.NewVariant(n.DataType)
+	case ua.IntegerIDValueRank:
+		return ua.NewVariant(n.ValueRank)
+	case ua.IntegerIDAccessLevel:
+		return ua.NewVariant(n.AccessLevel)
+	case ua.IntegerIDUserAccessLevel:
+		return ua.NewVariant(n.UserAccessLevel)
+	case ua.IntegerIDMinimumSamplingInterval:
+		return ua.NewVariant(n.MinimumSamplingInterval)
+	case ua.IntegerIDHistorizing:
+		return ua.NewVariant(n.Historizing)
+	default:
+		return n.attribute(attrID, n.NodeClass())
+	}
+}
+
+// MethodNode is a node of the Method node class.
+//
+// Specification: Part 3, 5.7
+type MethodNode struct {
+	BaseNode
+	Executable     bool
+	UserExecutable bool
+}
+
+func (n *MethodNode) NodeClass() ua.NodeClass {
+	return ua.NodeClassMethod
+}
+
+func (n *MethodNode) Attribute(attrID uint32) (*ua.Variant, error) {
+	switch attrID {
+	case ua.IntegerIDExecutable:
+		return ua.NewVariant(n.Executable)
+	case ua.IntegerIDUserExecutable:
+		return ua.NewVariant(n.UserExecutable)
+	default:
+		return n.attribute(attrID, n.NodeClass())
+	}
+}
+
+// ObjectTypeNode is a node of the ObjectType node class.
+//
+// Specification: Part 3, 5.5.2
+type ObjectTypeNode struct {
+	BaseNode
+	IsAbstract bool
+}
+
+func (n *ObjectTypeNode) NodeClass() ua.NodeClass {
+	return ua.NodeClassObjectType
+}
+
+func (n *ObjectTypeNode) Attribute(attrID uint32) (*ua.Variant, error) {
+	switch attrID {
+	case ua.IntegerIDIsAbstract:
+		return ua.NewVariant(n.IsAbstract)
+	default:
+		return n.attribute(attrID, n.NodeClass())
+	}
+}
+
+// VariableTypeNode is a node of the VariableType node class.
+//
+// Specification: Part 3, 5.6.5
+type VariableTypeNode struct {
+	BaseNode
+	Value      *ua.Variant
+	DataType   *ua.NodeID
+	ValueRank  int32
+	IsAbstract bool
+}
+
+func (n *VariableTypeNode) NodeClass() ua.NodeClass {
+	return ua.NodeClassVariableType
+}
+
+func (n *VariableTypeNode) Attribute(attrID uint32) (*ua.Variant, error) {
+	switch attrID {
+	case ua.IntegerIDValue:
+		if n.Value == nil {
+			return &ua.Variant{}, nil
+		}
+		return n.Value, nil
+	case ua.IntegerIDDataType:
+		return ua.NewVariant(n.DataType)
+	case ua.IntegerIDValueRank:
+		return ua.NewVariant(n.ValueRank)
+	case ua.IntegerIDIsAbstract:
+		return ua.NewVariant(n.IsAbstract)
+	default:
+		return n.attribute(attrID, n.NodeClass())
+	}
+}
+
+// ReferenceTypeNode is a node of the ReferenceType node class.
+//
+// Specification: Part 3, 5.3
+type ReferenceTypeNode struct {
+	BaseNode
+	IsAbstract  bool
+	Symmetric   bool
+	InverseName *ua.LocalizedText
+}
+
+func (n *ReferenceTypeNode) NodeClass() ua.NodeClass {
+	return ua.NodeClassReferenceType
+}
+
+func (n *ReferenceTypeNode) Attribute(attrID uint32) (*ua.Variant, error) {
+	switch attrID {
+	case ua.IntegerIDIsAbstract:
+		return ua.NewVariant(n.IsAbstract)
+	case ua.IntegerIDSymmetric:
+		return ua.NewVariant(n.Symmetric)
+	case ua.IntegerIDInverseName:
+		return localizedText(n.InverseName)
+	default:
+		return n.attribute(attrID, n.NodeClass())
+	}
+}
+
+// DataTypeNode is a node of the DataType node class.
+//
+// Specification: Part 3, 5.8.3
+type DataTypeNode struct {
+	BaseNode
+	IsAbstract bool
+}
+
+func (n *DataTypeNode) NodeClass() ua.NodeClass {
+	return ua.NodeClassDataType
+}
+
+func (n *DataTypeNode) Attribute(attrID uint32) (*ua.Variant, error) {
+	switch attrID {
+	case ua.IntegerIDIsAbstract:
+		return ua.NewVariant(n.IsAbstract)
+	default:
+		return n.attribute(attrID, n.NodeClass())
+	}
+}
+
+// ViewNode is a node of the View node class.
+//
+// Specification: Part 3, 5.4
+type ViewNode struct {
+	BaseNode
+	ContainsNoLoops bool
+	EventNotifier   byte
+}
+
+func (n *ViewNode) NodeClass() ua.NodeClass {
+	return ua.NodeClassView
+}
+
+func (n *ViewNode) Attribute(attrID uint32) (*ua.Variant, error) {
+	switch attrID {
+	case ua.IntegerIDContainsNoLoops:
+		return ua.NewVariant(n.ContainsNoLoops)
+	case ua.IntegerIDEventNotifier:
+		return ua.NewVariant(n.EventNotifier)
+	default:
+		return n.attribute(attrID, n.NodeClass())
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package addrspace
+
+import (
+	"github.com/gopcua/opcua/ua"
+)
+
+//go:generate go run ../../cmd/addrspace -in ../../schema/NodeIds.csv -nodeset ../../schema/Opc.Ua.NodeSet2.xml -out ns0_gen.go
+
+// ns0Node contains the attributes of a node of namespace 0. Only the
+// attributes of the node class are set.
+type ns0Node struct {
+	ID              uint32
+	Class           ua.NodeClass
+	Name            string
+	DisplayName     string
+	Description     string
+	IsAbstract      bool
+	Symmetric       bool
+	InverseName     string
+	DataType        uint32
+	ValueRank       int32
+	AccessLevel     byte
+	EventNotifier   byte
+	ContainsNoLoops bool
+	Historizing     bool
+}
+
+// ns0Reference is a forward reference between two nodes of namespace 0.
+type ns0Reference struct {
+	Source, Type, Target uint32
+}
+
+// ns0ID returns the node id of namespace 0 in its most compact encoding.
+func ns0ID(id uint32) *ua.NodeID {
+	switch {
+	case id <= 0xff:
+		return ua.NewTwoByteNodeID(uint8(id))
+	case id <= 0xffff:
+		return ua.NewFourByteNodeID(0, uint16(id))
+	default:
+		return ua.NewNumericNodeID(0, id)
+	}
+}
+
+// node creates the node for the node class.
+func (n ns0Node) node() Node {
+	display := n.DisplayName
+	if display == "" {
+		display = n.Name
+	}
+	b := BaseNode{
+		ID:          ns0ID(n.ID),
+		BrowseName:  &ua.QualifiedName{Name: n.Name},
+		DisplayName: &ua.LocalizedText{Text: display},
+	}
+	if n.Description != "" {
+		b.Description = &ua.LocalizedText{Text: n.Description}
+	}
+
+	switch n.Class {
+	case ua.NodeClassObject:
+		return &ObjectNode{BaseNode: b, EventNotifier: n.EventNotifier}
+	case ua.NodeClassVariable:
+		return &VariableNode{
+			BaseNode:        b,
+			DataType:        ns0ID(n.DataType),
+			ValueRank:       n.ValueRank,
+			AccessLevel:     n.AccessLevel,
+			UserAccessLevel: n.AccessLevel,
+			Historizing:     n.Historizing,
+		}
+	case ua.NodeClassMethod:
+		return &MethodNode{BaseNode: b, Executable: true, UserExecutable: true}
+	case ua.NodeClassObjectType:
+		return &ObjectTypeNode{BaseNode: b, IsAbstract: n.IsAbstract}
+	case ua.NodeClassVariableType:
+		return &VariableTypeNode{BaseNode: b, DataType: ns0ID(n.DataType), ValueRank: n.ValueRank, IsAbstract: n.IsAbstract}
+	case ua.NodeClassReferenceType:
+		t := &ReferenceTypeNode{BaseNode: b, IsAbstract: n.IsAbstract, Symmetric: n.Symmetric}
+		if n.InverseName != "" {
+			t.InverseName = &ua.LocalizedText{Text: n.InverseName}
+		}
+		return t
+	case ua.NodeClassDataType:
+		return &DataTypeNode{BaseNode: b, IsAbstract: n.IsAbstract}
+	case ua.NodeClassView:
+		return &ViewNode{BaseNode: b, ContainsNoLoops: n.ContainsNoLoops, EventNotifier: n.EventNotifier}
+	default:
+		panic("addrspace: invalid node class")
+	}
+}
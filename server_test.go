@@ -0,0 +1,394 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/pki"
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uacp"
+	"github.com/gopcua/opcua/uasc"
+)
+
+const testServerEndpoint = "opc.tcp://127.0.0.1:48403"
+
+// newTestServer starts a server with a handler for the Read service which
+// returns the numeric ids of the nodes. The returned function stops it.
+func newTestServer(t *testing.T) (stop func()) {
+	t.Helper()
+	srv := &Server{EndpointURL: testServerEndpoint}
+	srv.Handle(&ua.ReadRequest{}, func(v interface{}) (interface{}, error) {
+		req := v.(*ua.ReadRequest)
+		resp := &ua.ReadResponse{}
+		for _, n := range req.NodesToRead {
+			resp.Results = append(resp.Results, &ua.DataValue{
+				EncodingMask: ua.DataValueValue,
+				Value:        ua.MustVariant(uint32(n.NodeID.IntID())),
+			})
+		}
+		return resp, nil
+	})
+	return startTestServer(t, srv)
+}
+
+// startTestServer serves the server on its endpoint. The returned function
+// stops it.
+func startTestServer(t *testing.T, srv *Server) (stop func()) {
+	t.Helper()
+	l, err := uacp.Listen(srv.EndpointURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(l) }()
+	return func() {
+		srv.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Errorf("got %v want %v", err, ErrServerClosed)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	defer newTestServer(t)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// several clients are served concurrently
+	var clients []*Client
+	for i := 0; i < 3; i++ {
+		c := NewClient(testServerEndpoint, nil)
+		if err := c.Open(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		clients = append(clients, c)
+	}
+
+	for i, c := range clients {
+		v, err := c.Node(ua.NewNumericNodeID(0, uint32(i+1))).Value(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := v.Value, uint32(i+1); got != want {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	}
+
+	t.Run("unsupported service", func(t *testing.T) {
+		_, err := clients[0].Browse(ctx, &ua.BrowseRequest{View: &ua.ViewDescription{ViewID: ua.NewTwoByteNodeID(0)}})
+		serr, ok := err.(*ua.ServiceError)
+		if !ok {
+			t.Fatalf("got %T, want *ua.ServiceError", err)
+		}
+		if got, want := serr.StatusCode, ua.StatusBadServiceUnsupported; got != want {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("user name not supported", func(t *testing.T) {
+		// the server has no user token policy for user names
+		c := NewClient(testServerEndpoint, nil, AuthUsername("user", "pass"))
+		if err := c.Open(ctx); err == nil {
+			c.Close()
+			t.Fatal("want error")
+		}
+	})
+}
+
+func TestServerNoSession(t *testing.T) {
+	defer newTestServer(t)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := uacp.Dial(ctx, testServerEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sechan := uasc.NewSecureChannel(c, nil)
+	if err := sechan.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer sechan.Close()
+
+	req := &ua.ReadRequest{
+		NodesToRead: []*ua.ReadValueID{
+			{NodeID: ua.NewNumericNodeID(0, 1), AttributeID: ua.IntegerIDValue, DataEncoding: &ua.QualifiedName{}},
+		},
+	}
+	err = sechan.SendWithContext(ctx, req, func(interface{}) error { return nil })
+	serr, ok := err.(*ua.ServiceError)
+	if !ok {
+		t.Fatalf("got %T, want *ua.ServiceError", err)
+	}
+	if got, want := serr.StatusCode, ua.StatusBadSessionIDInvalid; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestServerActivateOnNewChannel(t *testing.T) {
+	defer newTestServer(t)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := NewClient(testServerEndpoint, nil)
+	if err := c.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// activate moves the session to a new secure channel
+	activate := func() error {
+		sechan, err := c.dial(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sechan.Close()
+		return c.session.Activate(ctx, sechan)
+	}
+
+	if err := activate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the user of the session must not change
+	c.sessionCfg.UserIdentityToken = &ua.IssuedIdentityToken{PolicyID: "issued", TokenData: []byte("token")}
+	err := activate()
+	serr, ok := err.(*ua.ServiceError)
+	if !ok {
+		t.Fatalf("got %T %v, want *ua.ServiceError", err, err)
+	}
+	if got, want := serr.StatusCode, ua.StatusBadIdentityChangeNotSupported; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestServerSecured(t *testing.T) {
+	const (
+		endpoint = "opc.tcp://127.0.0.1:48408"
+		policy   = "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+	)
+	serverKey, serverCert := newTestCert(t)
+	clientKey, clientCert := newTestCert(t)
+
+	cfg := uasc.NewServerConfig(policy, serverCert, nil, 0, ua.MessageSecurityModeSignAndEncrypt, 0, 3600000)
+	cfg.LocalKey = serverKey
+	srv := &Server{EndpointURL: endpoint, Config: cfg}
+	srv.Handle(&ua.ReadRequest{}, func(v interface{}) (interface{}, error) {
+		return &ua.ReadResponse{Results: []*ua.DataValue{{EncodingMask: ua.DataValueValue, Value: ua.MustVariant("ok")}}}, nil
+	})
+	defer startTestServer(t, srv)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newClient := func(opts ...Option) *Client {
+		cfg := uasc.NewClientConfigSignAndEncryptBasic256Sha256(clientCert, clientKey, serverCert, 1, 3600000)
+		return NewClient(endpoint, cfg, opts...)
+	}
+
+	// client and server sign the certificate and the nonce of each other
+	c := newClient()
+	if err := c.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	v, err := c.Node(ua.NewNumericNodeID(0, 1)).Value(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := v.Value, "ok"; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	t.Run("discovery endpoints", func(t *testing.T) {
+		c := newClient(DiscoveryEndpoints(c.sessionCfg.ServerEndpoints))
+		if err := c.Open(ctx); err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	})
+
+	t.Run("modified discovery endpoints", func(t *testing.T) {
+		ep := *c.sessionCfg.ServerEndpoints[0]
+		ep.SecurityMode = ua.MessageSecurityModeNone
+		ep.SecurityPolicyURI = "http://opcfoundation.org/UA/SecurityPolicy#None"
+		c := newClient(DiscoveryEndpoints([]*ua.EndpointDescription{&ep}))
+		if err := c.Open(ctx); err == nil {
+			c.Close()
+			t.Fatal("want error")
+		}
+	})
+}
+
+func TestServerTrustStore(t *testing.T) {
+	const (
+		endpoint = "opc.tcp://127.0.0.1:48409"
+		policy   = "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+	)
+	serverKey, serverCert := newTestCert(t)
+	clientKey, clientCert := newTestCert(t)
+
+	dir, err := ioutil.TempDir("", "gopcua-pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := pki.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := uasc.NewServerConfig(policy, serverCert, nil, 0, ua.MessageSecurityModeSign, 0, 3600000)
+	cfg.LocalKey = serverKey
+	cfg.VerifyCertificate = store.Verify
+	defer startTestServer(t, &Server{EndpointURL: endpoint, Config: cfg})()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	open := func() error {
+		c := NewClient(endpoint, uasc.NewClientConfigSignBasic256Sha256(clientCert, clientKey, serverCert, 1, 3600000))
+		if err := c.Open(ctx); err != nil {
+			return err
+		}
+		return c.Close()
+	}
+
+	// the client certificate is rejected until it is trusted
+	err = open()
+	perr, ok := err.(*uacp.ProtocolError)
+	if !ok {
+		t.Fatalf("got %T %v, want *uacp.ProtocolError", err, err)
+	}
+	if got, want := perr.Code, ua.StatusCode(uacp.BadCertificateUntrusted); got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	if err := store.Trust(clientCert); err != nil {
+		t.Fatal(err)
+	}
+	if err := open(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerApplicationURI(t *testing.T) {
+	const (
+		endpoint      = "opc.tcp://127.0.0.1:48410"
+		otherEndpoint = "opc.tcp://127.0.0.1:48411"
+		policy        = "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+	)
+
+	dir, err := ioutil.TempDir("", "gopcua-pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := pki.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// newCert returns a trusted certificate with the application uri.
+	newCert := func(appURI string) ([]byte, *rsa.PrivateKey) {
+		cert, key, err := pki.NewCertificate(pki.CertificateOptions{ApplicationURI: appURI, SecurityPolicyURI: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Trust(cert); err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+	serverCert, serverKey := newCert("urn:gopcua:server")
+	otherCert, otherKey := newCert("urn:gopcua:other")
+	clientCert, clientKey := newCert("urn:gopcua:client")
+
+	newServer := func(endpoint string, cert []byte, key *rsa.PrivateKey) *Server {
+		cfg := uasc.NewServerConfig(policy, cert, nil, 0, ua.MessageSecurityModeSign, 0, 3600000)
+		cfg.LocalKey = key
+		cfg.VerifyApplicationURI = store.Validate
+		return &Server{EndpointURL: endpoint, Config: cfg}
+	}
+	defer startTestServer(t, newServer(endpoint, serverCert, serverKey))()
+	defer startTestServer(t, newServer(otherEndpoint, otherCert, otherKey))()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	open := func(endpoint string, cert []byte, key *rsa.PrivateKey, remote []byte) error {
+		cfg := uasc.NewClientConfigSignBasic256Sha256(cert, key, remote, 1, 3600000)
+		cfg.VerifyApplicationURI = store.Validate
+		c := NewClient(endpoint, cfg)
+		if err := c.Open(ctx); err != nil {
+			return err
+		}
+		return c.Close()
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		if err := open(endpoint, clientCert, clientKey, serverCert); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("client uri mismatch", func(t *testing.T) {
+		// the client describes itself as urn:gopcua:client
+		err := open(endpoint, otherCert, otherKey, serverCert)
+		serr, ok := err.(*ua.ServiceError)
+		if !ok {
+			t.Fatalf("got %T %v, want *ua.ServiceError", err, err)
+		}
+		if got, want := serr.StatusCode, ua.StatusBadCertificateURIInvalid; got != want {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("server uri mismatch", func(t *testing.T) {
+		// the server describes itself as urn:gopcua:server
+		err := open(otherEndpoint, clientCert, clientKey, otherCert)
+		perr, ok := err.(*uacp.ProtocolError)
+		if !ok {
+			t.Fatalf("got %T %v, want *uacp.ProtocolError", err, err)
+		}
+		if got, want := perr.Code, ua.StatusCode(uacp.BadCertificateURIInvalid); got != want {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	})
+}
+
+// newTestCert returns a private key and a self-signed certificate.
+func newTestCert(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gopcua test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
@@ -0,0 +1,994 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gopcua/opcua/logger"
+	"github.com/gopcua/opcua/ua"
+)
+
+const (
+	// DefaultPublishingInterval is the publishing interval of a
+	// subscription if none is requested.
+	DefaultPublishingInterval = time.Second
+
+	// DefaultMaxKeepAliveCount is the number of publishing intervals
+	// without notifications after which the server sends a keep-alive
+	// message if none is requested.
+	DefaultMaxKeepAliveCount = 20
+
+	// notifyQueueSize is the capacity of the channel of a subscription.
+	notifyQueueSize = 64
+
+	// minPublishTimeout is the minimum time to wait for the response to
+	// a Publish request.
+	minPublishTimeout = 10 * time.Second
+
+	// publishRetryDelay is the time to wait before a failed Publish
+	// request is repeated.
+	publishRetryDelay = time.Second
+
+	// maxSeqGap is the largest distance between two sequence numbers
+	// which is considered a gap. Larger distances are old messages.
+	maxSeqGap = 1 << 31
+)
+
+// SubscriptionParameters are the parameters for creating or modifying a
+// subscription. Fields with the zero value are set to their default.
+//
+// Specification: Part 4, 5.13.2
+type SubscriptionParameters struct {
+	// Interval is the requested publishing interval. The default is
+	// DefaultPublishingInterval.
+	Interval time.Duration
+
+	// LifetimeCount is the number of publishing intervals without a
+	// Publish request after which the server deletes the subscription.
+	// The default is three times MaxKeepAliveCount.
+	LifetimeCount uint32
+
+	// MaxKeepAliveCount is the number of publishing intervals without
+	// notifications after which the server sends a keep-alive message.
+	// The default is DefaultMaxKeepAliveCount.
+	MaxKeepAliveCount uint32
+
+	// MaxNotificationsPerPublish limits the number of notifications in a
+	// single Publish response. Zero means no limit.
+	MaxNotificationsPerPublish uint32
+
+	// Priority is the priority of the subscription relative to the other
+	// subscriptions of the session.
+	Priority uint8
+}
+
+// withDefaults returns a copy of the parameters with the defaults set.
+// p can be nil.
+func (p *SubscriptionParameters) withDefaults() SubscriptionParameters {
+	var v SubscriptionParameters
+	if p != nil {
+		v = *p
+	}
+	if v.Interval == 0 {
+		v.Interval = DefaultPublishingInterval
+	}
+	if v.MaxKeepAliveCount == 0 {
+		v.MaxKeepAliveCount = DefaultMaxKeepAliveCount
+	}
+	if v.LifetimeCount == 0 {
+		v.LifetimeCount = 3 * v.MaxKeepAliveCount
+	}
+	return v
+}
+
+// Message is a notification which is delivered on the channel of a
+// subscription. It is one of *DataChangeMessage, *EventMessage,
+// *StatusChangeMessage, *GapMessage or *ErrorMessage.
+type Message interface {
+	isMessage()
+}
+
+// DataChangeMessage contains a new value of a monitored item.
+type DataChangeMessage struct {
+	Item  *MonitoredItem
+	Value *ua.DataValue
+}
+
+// EventMessage contains the fields of an event of a monitored item. The
+// fields are keyed by the browse paths of the event filter, e.g.
+// "Severity" or "EnabledState/Id".
+type EventMessage struct {
+	Item   *MonitoredItem
+	Fields map[string]*ua.Variant
+}
+
+// StatusChangeMessage reports a change of the status of the subscription,
+// e.g. StatusBadTimeout if the server has deleted the subscription since
+// its lifetime has expired.
+//
+// The client reports StatusBadSubscriptionIDInvalid if the subscription
+// was lost after a reconnect and has been created again with the same
+// monitored items. Notifications may have been missed and the server
+// sends the current values of the items again.
+type StatusChangeMessage struct {
+	Status ua.StatusCode
+}
+
+// GapMessage reports lost notification messages which the server could
+// not republish. From and To are the first and the last missing sequence
+// number. The messages which are delivered before it precede the gap.
+type GapMessage struct {
+	From, To uint32
+}
+
+// ErrorMessage reports a failed Publish request. The request is repeated
+// until the subscription is cancelled.
+type ErrorMessage struct {
+	Err error
+}
+
+func (*DataChangeMessage) isMessage()   {}
+func (*EventMessage) isMessage()        {}
+func (*StatusChangeMessage) isMessage() {}
+func (*GapMessage) isMessage()          {}
+func (*ErrorMessage) isMessage()        {}
+
+// MonitoredItem is an attribute of a node which is monitored by a
+// subscription.
+type MonitoredItem struct {
+	// ID is the id of the monitored item on the server.
+	ID uint32
+
+	// Handle is the client handle which identifies the notifications of
+	// the monitored item.
+	Handle uint32
+
+	NodeID      *ua.NodeID
+	AttributeID uint32
+
+	// RevisedSamplingInterval and RevisedQueueSize are the parameters
+	// the server has granted.
+	RevisedSamplingInterval time.Duration
+	RevisedQueueSize        uint32
+
+	// fields are the browse paths of the selected event fields.
+	fields []string
+
+	// req is the request which created the item. It is sent again when
+	// the subscription is restored after a reconnect.
+	req *ua.MonitoredItemCreateRequest
+}
+
+// Subscription delivers the notifications of its monitored items as
+// messages on the channel C. It is created with Client.Subscribe.
+//
+// The notifications of all subscriptions of a client are requested by a
+// background loop which sends Publish requests to the server and
+// acknowledges the received notification messages with the next request.
+// The loop blocks while C is full.
+//
+// Specification: Part 4, 5.13
+type Subscription struct {
+	// ID is the id of the subscription on the server. It changes when
+	// the subscription is created again after a reconnect.
+	ID uint32
+
+	// RevisedPublishingInterval, RevisedLifetimeCount and
+	// RevisedMaxKeepAliveCount are the parameters the server has granted.
+	// They are updated by Modify.
+	RevisedPublishingInterval time.Duration
+	RevisedLifetimeCount      uint32
+	RevisedMaxKeepAliveCount  uint32
+
+	// C receives the notifications of the subscription. It is closed
+	// when the subscription is cancelled, the client is closed or the
+	// subscription could not be restored after a reconnect.
+	C <-chan Message
+
+	// params and enabled are the requested parameters and the
+	// publishing mode which are used to create the subscription
+	// again after a reconnect.
+	params  SubscriptionParameters
+	enabled bool
+
+	c    *Client
+	ch   chan Message
+	done chan struct{}
+	once sync.Once
+
+	// sendMu serializes sending on ch with closing it.
+	sendMu sync.Mutex
+	closed bool
+
+	// mu guards items and handle.
+	mu sync.Mutex
+
+	// items maps the client handles to the monitored items.
+	items  map[uint32]*MonitoredItem
+	handle uint32
+
+	// seq is the sequence number of the last received notification
+	// message. It is only used by the publish loop.
+	seq uint32
+}
+
+// Subscribe creates a subscription with the given parameters which can be
+// nil to use the defaults.
+func (c *Client) Subscribe(ctx context.Context, params *SubscriptionParameters) (*Subscription, error) {
+	p := params.withDefaults()
+	res, err := c.createSubscription(ctx, p, true)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Message, notifyQueueSize)
+	sub := &Subscription{
+		ID:                        res.SubscriptionID,
+		RevisedPublishingInterval: millisDuration(res.RevisedPublishingInterval),
+		RevisedLifetimeCount:      res.RevisedLifetimeCount,
+		RevisedMaxKeepAliveCount:  res.RevisedMaxKeepAliveCount,
+		C:                         ch,
+		params:                    p,
+		enabled:                   true,
+		c:                         c,
+		ch:                        ch,
+		done:                      make(chan struct{}),
+		items:                     make(map[uint32]*MonitoredItem),
+	}
+
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	if c.subs == nil {
+		c.subs = make(map[uint32]*Subscription)
+	}
+	c.subs[sub.ID] = sub
+	if c.stopPublish == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.stopPublish = cancel
+		go c.publish(ctx)
+	}
+	return sub, nil
+}
+
+// createSubscription sends a CreateSubscription request with the
+// parameters.
+func (c *Client) createSubscription(ctx context.Context, p SubscriptionParameters, enabled bool) (*ua.CreateSubscriptionResponse, error) {
+	req := &ua.CreateSubscriptionRequest{
+		RequestedPublishingInterval: durationMillis(p.Interval),
+		RequestedLifetimeCount:      p.LifetimeCount,
+		RequestedMaxKeepAliveCount:  p.MaxKeepAliveCount,
+		MaxNotificationsPerPublish:  p.MaxNotificationsPerPublish,
+		PublishingEnabled:           enabled,
+		Priority:                    p.Priority,
+	}
+
+	var res *ua.CreateSubscriptionResponse
+	err := c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.CreateSubscriptionResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		res = r
+		return nil
+	})
+	return res, err
+}
+
+// Monitor creates monitored items for the values of the nodes. The new
+// values are delivered as *DataChangeMessage and the server sends the
+// current value of each item first.
+//
+// The items are returned in the order of the nodes. If the server rejects
+// some of the nodes the error is a ua.MultiError with their status codes
+// and their items are nil. The other items are created nonetheless.
+func (s *Subscription) Monitor(ctx context.Context, nodes ...*ua.NodeID) ([]*MonitoredItem, error) {
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+
+	items := make([]*MonitoredItem, len(nodes))
+	reqs := make([]*ua.MonitoredItemCreateRequest, len(nodes))
+	for i, n := range nodes {
+		items[i] = &MonitoredItem{NodeID: n, AttributeID: ua.IntegerIDValue}
+		reqs[i] = &ua.MonitoredItemCreateRequest{
+			RequestedParameters: &ua.MonitoringParameters{
+				// -1 requests the publishing interval
+				SamplingInterval: -1,
+				Filter:           ua.NewExtensionObject(nil),
+				QueueSize:        1,
+				DiscardOldest:    true,
+			},
+		}
+	}
+	results, err := s.createItems(ctx, items, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]ua.StatusCode, len(items))
+	for i, r := range results {
+		codes[i] = r.StatusCode
+		if r.StatusCode.IsBad() {
+			items[i] = nil
+		}
+	}
+	return items, ua.StatusCodesError(codes)
+}
+
+// EventFilter selects the events of an event notifier and their fields.
+type EventFilter struct {
+	// Select are the fields of the events, e.g. ua.EventField("Severity").
+	Select []*ua.SimpleAttributeOperand
+
+	// Where selects the events which are delivered. If it is nil all
+	// events are delivered.
+	Where *ua.WhereClause
+}
+
+// MonitorEvents creates a monitored item for the events of a node which
+// is an event notifier, e.g. the Server object. The events are delivered
+// as *EventMessage with the fields of the filter.
+//
+// If the server rejects some of the fields the item is returned with a
+// ua.MultiError which has the status codes of the fields. The values of
+// the rejected fields are null.
+//
+// Specification: Part 4, 7.17.3
+func (s *Subscription) MonitorEvents(ctx context.Context, node *ua.NodeID, filter *EventFilter) (*MonitoredItem, error) {
+	if filter == nil || len(filter.Select) == 0 {
+		return nil, fmt.Errorf("opcua: event filter has no fields")
+	}
+	where, err := filter.Where.ContentFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	item := &MonitoredItem{NodeID: node, AttributeID: ua.IntegerIDEventNotifier}
+	for _, o := range filter.Select {
+		item.fields = append(item.fields, o.FieldPath())
+	}
+	req := &ua.MonitoredItemCreateRequest{
+		RequestedParameters: &ua.MonitoringParameters{
+			Filter: ua.NewExtensionObject(&ua.EventFilter{
+				SelectClauses: filter.Select,
+				WhereClause:   where,
+			}),
+			// 0 requests the default queue size of the server for events
+			QueueSize:     0,
+			DiscardOldest: true,
+		},
+	}
+	results, err := s.createItems(ctx, []*MonitoredItem{item}, []*ua.MonitoredItemCreateRequest{req})
+	if err != nil {
+		return nil, err
+	}
+	if code := results[0].StatusCode; code.IsBad() {
+		return nil, code
+	}
+	if fr, ok := results[0].FilterResult.Value.(*ua.EventFilterResult); ok {
+		return item, ua.StatusCodesError(fr.SelectClauseResults)
+	}
+	return item, nil
+}
+
+// createItems creates the monitored items with the requests which only
+// need the monitoring parameters without the client handle. The results
+// are returned in the order of the items. Rejected items are not
+// registered.
+func (s *Subscription) createItems(ctx context.Context, items []*MonitoredItem, reqs []*ua.MonitoredItemCreateRequest) ([]*ua.MonitoredItemCreateResult, error) {
+	// the items are registered before the request is sent since the
+	// first notifications can arrive before the response.
+	s.mu.Lock()
+	for i, item := range items {
+		s.handle++
+		item.Handle = s.handle
+		s.items[item.Handle] = item
+
+		reqs[i].ItemToMonitor = &ua.ReadValueID{
+			NodeID:       item.NodeID,
+			AttributeID:  item.AttributeID,
+			DataEncoding: &ua.QualifiedName{},
+		}
+		reqs[i].MonitoringMode = ua.MonitoringModeReporting
+		reqs[i].RequestedParameters.ClientHandle = item.Handle
+		item.req = reqs[i]
+	}
+	s.mu.Unlock()
+
+	results, err := s.sendItems(ctx, items)
+	if err != nil {
+		s.forget(items...)
+		return nil, err
+	}
+	return results, nil
+}
+
+// sendItems sends the create requests of the monitored items and updates
+// them with the results. Rejected items are forgotten.
+func (s *Subscription) sendItems(ctx context.Context, items []*MonitoredItem) ([]*ua.MonitoredItemCreateResult, error) {
+	req := &ua.CreateMonitoredItemsRequest{
+		SubscriptionID:     s.ID,
+		TimestampsToReturn: ua.TimestampsToReturnBoth,
+	}
+	for _, item := range items {
+		req.ItemsToCreate = append(req.ItemsToCreate, item.req)
+	}
+	var res *ua.CreateMonitoredItemsResponse
+	err := s.c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.CreateMonitoredItemsResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		if len(r.Results) != len(items) {
+			return fmt.Errorf("invalid response: got %d results for %d items", len(r.Results), len(items))
+		}
+		res = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range res.Results {
+		if r.StatusCode.IsBad() {
+			s.forget(items[i])
+			continue
+		}
+		items[i].ID = r.MonitoredItemID
+		items[i].RevisedSamplingInterval = millisDuration(r.RevisedSamplingInterval)
+		items[i].RevisedQueueSize = r.RevisedQueueSize
+	}
+	return res.Results, nil
+}
+
+// Unmonitor deletes the monitored items. No more messages are delivered
+// for them even if the request fails. The status of the individual items
+// is returned as ua.MultiError.
+func (s *Subscription) Unmonitor(ctx context.Context, items ...*MonitoredItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	s.forget(items...)
+
+	req := &ua.DeleteMonitoredItemsRequest{SubscriptionID: s.ID}
+	for _, item := range items {
+		req.MonitoredItemIDs = append(req.MonitoredItemIDs, item.ID)
+	}
+	return s.c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.DeleteMonitoredItemsResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		return ua.StatusCodesError(r.Results)
+	})
+}
+
+// SetPublishingMode enables or disables the sending of notifications by
+// the server. A disabled subscription only sends keep-alive messages.
+func (s *Subscription) SetPublishingMode(ctx context.Context, enabled bool) error {
+	req := &ua.SetPublishingModeRequest{
+		PublishingEnabled: enabled,
+		SubscriptionIDs:   []uint32{s.ID},
+	}
+	return s.c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.SetPublishingModeResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		if err := firstError(r.Results); err != nil {
+			return err
+		}
+		s.c.subMu.Lock()
+		defer s.c.subMu.Unlock()
+		s.enabled = enabled
+		return nil
+	})
+}
+
+// Modify changes the parameters of the subscription. The parameters
+// granted by the server are stored in the Revised fields.
+func (s *Subscription) Modify(ctx context.Context, params *SubscriptionParameters) error {
+	p := params.withDefaults()
+	req := &ua.ModifySubscriptionRequest{
+		SubscriptionID:              s.ID,
+		RequestedPublishingInterval: durationMillis(p.Interval),
+		RequestedLifetimeCount:      p.LifetimeCount,
+		RequestedMaxKeepAliveCount:  p.MaxKeepAliveCount,
+		MaxNotificationsPerPublish:  p.MaxNotificationsPerPublish,
+		Priority:                    p.Priority,
+	}
+	return s.c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.ModifySubscriptionResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		// the publish loop reads the parameters
+		s.c.subMu.Lock()
+		defer s.c.subMu.Unlock()
+		s.params = p
+		s.RevisedPublishingInterval = millisDuration(r.RevisedPublishingInterval)
+		s.RevisedLifetimeCount = r.RevisedLifetimeCount
+		s.RevisedMaxKeepAliveCount = r.RevisedMaxKeepAliveCount
+		return nil
+	})
+}
+
+// Cancel deletes the subscription on the server and closes C. No more
+// messages are delivered even if the request fails.
+func (s *Subscription) Cancel(ctx context.Context) error {
+	s.c.removeSubscription(s.ID)
+	s.close()
+
+	req := &ua.DeleteSubscriptionsRequest{SubscriptionIDs: []uint32{s.ID}}
+	return s.c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.DeleteSubscriptionsResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		return firstError(r.Results)
+	})
+}
+
+// forget removes the monitored items so that their notifications are
+// dropped.
+func (s *Subscription) forget(items ...*MonitoredItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range items {
+		delete(s.items, item.Handle)
+	}
+}
+
+// monitoredItems returns the monitored items in the order of their
+// client handles.
+func (s *Subscription) monitoredItems() []*MonitoredItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]*MonitoredItem, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Handle < items[j].Handle })
+	return items
+}
+
+// item returns the monitored item with the client handle or nil.
+func (s *Subscription) item(handle uint32) *MonitoredItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.items[handle]
+}
+
+// notify delivers the notifications of the message.
+func (s *Subscription) notify(msg *ua.NotificationMessage) {
+	log := logger.Or(s.c.Logger)
+	for _, data := range msg.NotificationData {
+		if data == nil {
+			continue
+		}
+		switch n := data.Value.(type) {
+		case *ua.DataChangeNotification:
+			for _, mn := range n.MonitoredItems {
+				item := s.item(mn.ClientHandle)
+				if item == nil {
+					log.Debug("opcua: dropping notification of unknown monitored item", "sub", s.ID, "handle", mn.ClientHandle)
+					continue
+				}
+				s.deliver(&DataChangeMessage{Item: item, Value: mn.Value})
+			}
+		case *ua.EventNotificationList:
+			for _, ev := range n.Events {
+				item := s.item(ev.ClientHandle)
+				if item == nil {
+					log.Debug("opcua: dropping event of unknown monitored item", "sub", s.ID, "handle", ev.ClientHandle)
+					continue
+				}
+				fields := make(map[string]*ua.Variant, len(item.fields))
+				for i, v := range ev.EventFields {
+					if i < len(item.fields) {
+						fields[item.fields[i]] = v
+					}
+				}
+				s.deliver(&EventMessage{Item: item, Fields: fields})
+			}
+		case *ua.StatusChangeNotification:
+			s.deliver(&StatusChangeMessage{Status: n.Status})
+		default:
+			log.Debug("opcua: dropping unsupported notification", "sub", s.ID, "type", fmt.Sprintf("%T", data.Value))
+		}
+	}
+}
+
+// deliver sends the message on the channel unless the subscription is
+// closed.
+func (s *Subscription) deliver(m Message) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- m:
+	case <-s.done:
+	}
+}
+
+// close closes the channel of the subscription. A blocked deliver is
+// released first.
+func (s *Subscription) close() {
+	s.once.Do(func() { close(s.done) })
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// removeSubscription removes the subscription from the client and stops
+// the publish loop when it was the last one.
+func (c *Client) removeSubscription(id uint32) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	delete(c.subs, id)
+	if len(c.subs) == 0 && c.stopPublish != nil {
+		c.stopPublish()
+		c.stopPublish = nil
+	}
+}
+
+// closeSubscriptions stops the publish loop and closes the channels of all
+// subscriptions.
+func (c *Client) closeSubscriptions() {
+	c.subMu.Lock()
+	subs := c.subs
+	c.subs = nil
+	if c.stopPublish != nil {
+		c.stopPublish()
+		c.stopPublish = nil
+	}
+	c.subMu.Unlock()
+
+	for _, sub := range subs {
+		sub.close()
+	}
+}
+
+// restoreSubscriptions transfers the subscriptions to the new session
+// after a reconnect. The publish loop republishes the notification
+// messages which were lost in the meantime. The subscriptions which
+// cannot be transferred are created again and the ones which cannot be
+// created are closed.
+//
+// Specification: Part 4, 5.13.7
+func (c *Client) restoreSubscriptions(ctx context.Context) {
+	subs := c.subscriptions()
+	if len(subs) == 0 {
+		return
+	}
+
+	log := logger.Or(c.Logger)
+	req := &ua.TransferSubscriptionsRequest{}
+	for _, sub := range subs {
+		req.SubscriptionIDs = append(req.SubscriptionIDs, sub.ID)
+	}
+	var results []*ua.TransferResult
+	err := c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.TransferSubscriptionsResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		if len(r.Results) != len(subs) {
+			return fmt.Errorf("invalid response: got %d results for %d subscriptions", len(r.Results), len(subs))
+		}
+		results = r.Results
+		return nil
+	})
+	if err != nil {
+		log.Info("opcua: subscriptions not transferred", "err", err)
+	}
+
+	for i, sub := range subs {
+		if results != nil && results[i].StatusCode.IsGood() {
+			continue
+		}
+		if err := c.recreate(ctx, sub); err != nil {
+			log.Warn("opcua: subscription lost", "sub", sub.ID, "err", err)
+			c.removeSubscription(sub.ID)
+			sub.deliver(&ErrorMessage{Err: err})
+			sub.close()
+		}
+	}
+}
+
+// recreate creates the subscription and its monitored items again with
+// the original requests.
+func (c *Client) recreate(ctx context.Context, sub *Subscription) error {
+	c.subMu.Lock()
+	p, enabled := sub.params, sub.enabled
+	c.subMu.Unlock()
+
+	res, err := c.createSubscription(ctx, p, enabled)
+	if err != nil {
+		return err
+	}
+
+	c.subMu.Lock()
+	delete(c.subs, sub.ID)
+	sub.ID = res.SubscriptionID
+	sub.RevisedPublishingInterval = millisDuration(res.RevisedPublishingInterval)
+	sub.RevisedLifetimeCount = res.RevisedLifetimeCount
+	sub.RevisedMaxKeepAliveCount = res.RevisedMaxKeepAliveCount
+	sub.seq = 0
+	c.subs[sub.ID] = sub
+	c.subMu.Unlock()
+
+	if items := sub.monitoredItems(); len(items) > 0 {
+		results, err := sub.sendItems(ctx, items)
+		if err != nil {
+			return err
+		}
+		for i, r := range results {
+			if r.StatusCode.IsBad() {
+				logger.Or(c.Logger).Warn("opcua: monitored item lost", "sub", sub.ID, "node", items[i].NodeID, "err", r.StatusCode)
+			}
+		}
+	}
+	sub.deliver(&StatusChangeMessage{Status: ua.StatusBadSubscriptionIDInvalid})
+	return nil
+}
+
+// subscription returns the subscription with the id or nil.
+func (c *Client) subscription(id uint32) *Subscription {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	return c.subs[id]
+}
+
+// subscriptions returns all subscriptions of the client.
+func (c *Client) subscriptions() []*Subscription {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	subs := make([]*Subscription, 0, len(c.subs))
+	for _, sub := range c.subs {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// publishTimeout returns the time to wait for a Publish response which is
+// twice the longest keep-alive interval of the subscriptions.
+func (c *Client) publishTimeout() time.Duration {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	timeout := minPublishTimeout
+	for _, sub := range c.subs {
+		if d := 2 * sub.RevisedPublishingInterval * time.Duration(sub.RevisedMaxKeepAliveCount); d > timeout {
+			timeout = d
+		}
+	}
+	return timeout
+}
+
+// publish sends Publish requests until ctx is done and delivers the
+// notifications to the subscriptions. The received notification messages
+// are acknowledged with the next request.
+func (c *Client) publish(ctx context.Context) {
+	log := logger.Or(c.Logger)
+	var acks []*ua.SubscriptionAcknowledgement
+	for {
+		res, err := c.sendPublish(ctx, acks)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !c.isConnected() {
+			// the reconnect loop restores the subscriptions
+			if !c.waitConnected(ctx) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			log.Warn("opcua: publish failed", "err", err)
+			for _, sub := range c.subscriptions() {
+				sub.deliver(&ErrorMessage{Err: err})
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(publishRetryDelay):
+			}
+			continue
+		}
+		acks = nil
+
+		for i, code := range res.Results {
+			if code.IsBad() {
+				log.Debug("opcua: acknowledgement failed", "err", code, "ack", i)
+			}
+		}
+
+		msg := res.NotificationMessage
+		if msg == nil {
+			continue
+		}
+		sub := c.subscription(res.SubscriptionID)
+		if sub == nil {
+			log.Debug("opcua: dropping notifications of unknown subscription", "sub", res.SubscriptionID)
+			if len(msg.NotificationData) > 0 {
+				acks = append(acks, &ua.SubscriptionAcknowledgement{
+					SubscriptionID: res.SubscriptionID,
+					SequenceNumber: msg.SequenceNumber,
+				})
+			}
+			continue
+		}
+
+		recovered, ok := c.recover(ctx, sub, msg.SequenceNumber, res.AvailableSequenceNumbers)
+		acks = append(acks, recovered...)
+		if len(msg.NotificationData) == 0 {
+			// keep-alive messages have the sequence number of the
+			// next notification message.
+			if ok {
+				sub.seq = seqBefore(msg.SequenceNumber)
+			}
+			continue
+		}
+		acks = append(acks, &ua.SubscriptionAcknowledgement{
+			SubscriptionID: res.SubscriptionID,
+			SequenceNumber: msg.SequenceNumber,
+		})
+		if !ok {
+			log.Debug("opcua: dropping duplicate notification message", "sub", sub.ID, "seq", msg.SequenceNumber)
+			continue
+		}
+		sub.seq = msg.SequenceNumber
+		sub.notify(msg)
+	}
+}
+
+// recover republishes the notification messages which are missing between
+// the last received message of the subscription and the message with the
+// sequence number seq. The messages which the server cannot republish are
+// reported as *GapMessage. recover returns the acknowledgements for the
+// republished messages and false if seq is not newer than the last
+// received message.
+//
+// Specification: Part 4, 5.13.1.1
+func (c *Client) recover(ctx context.Context, sub *Subscription, seq uint32, available []uint32) ([]*ua.SubscriptionAcknowledgement, bool) {
+	n := seqDistance(sub.seq, seq)
+	switch {
+	case n == 0 || n > maxSeqGap:
+		return nil, false
+	case n == 1:
+		return nil, true
+	}
+
+	// the available messages of the gap in sequence
+	var missing []uint32
+	for _, m := range available {
+		if d := seqDistance(sub.seq, m); d > 0 && d < n {
+			missing = append(missing, m)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return seqDistance(sub.seq, missing[i]) < seqDistance(sub.seq, missing[j])
+	})
+
+	log := logger.Or(c.Logger)
+	var acks []*ua.SubscriptionAcknowledgement
+	next := seqAfter(sub.seq)
+	for _, m := range missing {
+		msg, err := c.republish(ctx, sub.ID, m)
+		if err != nil {
+			log.Warn("opcua: republish failed", "sub", sub.ID, "seq", m, "err", err)
+			continue
+		}
+		if m != next {
+			log.Warn("opcua: notification messages lost", "sub", sub.ID, "from", next, "to", seqBefore(m))
+			sub.deliver(&GapMessage{From: next, To: seqBefore(m)})
+		}
+		sub.notify(msg)
+		acks = append(acks, &ua.SubscriptionAcknowledgement{SubscriptionID: sub.ID, SequenceNumber: m})
+		next = seqAfter(m)
+	}
+	if next != seq {
+		log.Warn("opcua: notification messages lost", "sub", sub.ID, "from", next, "to", seqBefore(seq))
+		sub.deliver(&GapMessage{From: next, To: seqBefore(seq)})
+	}
+	sub.seq = seqBefore(seq)
+	return acks, true
+}
+
+// republish requests the notification message with the sequence number
+// from the retransmission queue of the server.
+func (c *Client) republish(ctx context.Context, subID, seq uint32) (*ua.NotificationMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, minPublishTimeout)
+	defer cancel()
+
+	req := &ua.RepublishRequest{SubscriptionID: subID, RetransmitSequenceNumber: seq}
+	var msg *ua.NotificationMessage
+	err := c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.RepublishResponse)
+		if !ok || r.NotificationMessage == nil {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		msg = r.NotificationMessage
+		return nil
+	})
+	return msg, err
+}
+
+// sendPublish sends a Publish request with the acknowledgements and waits
+// for the response.
+func (c *Client) sendPublish(ctx context.Context, acks []*ua.SubscriptionAcknowledgement) (*ua.PublishResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.publishTimeout())
+	defer cancel()
+
+	req := &ua.PublishRequest{SubscriptionAcknowledgements: acks}
+	var res *ua.PublishResponse
+	err := c.send(ctx, req, func(v interface{}) error {
+		r, ok := v.(*ua.PublishResponse)
+		if !ok {
+			return fmt.Errorf("invalid response: %T", v)
+		}
+		res = r
+		return nil
+	})
+	return res, err
+}
+
+// firstError returns the first status code if it is bad.
+func firstError(codes []ua.StatusCode) error {
+	if len(codes) == 0 {
+		return fmt.Errorf("invalid response: no results")
+	}
+	if codes[0].IsBad() {
+		return codes[0]
+	}
+	return nil
+}
+
+// seqAfter returns the sequence number which follows n. Sequence numbers
+// wrap around to 1 since 0 is never used.
+func seqAfter(n uint32) uint32 {
+	if n == math.MaxUint32 {
+		return 1
+	}
+	return n + 1
+}
+
+// seqBefore returns the sequence number which precedes n.
+func seqBefore(n uint32) uint32 {
+	if n <= 1 {
+		return math.MaxUint32
+	}
+	return n - 1
+}
+
+// seqDistance returns the number of steps from the sequence number a to b.
+func seqDistance(a, b uint32) uint32 {
+	if b < a {
+		// skip 0
+		return b - a - 1
+	}
+	return b - a
+}
+
+// durationMillis returns the duration in milliseconds.
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// millisDuration returns the duration for the milliseconds.
+func millisDuration(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
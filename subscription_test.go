@@ -0,0 +1,632 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+const testSubscriptionID = 7
+
+// testSubscriptions implements the subscription services of the test
+// server for a single subscription. Every Publish request is answered
+// after the publishing interval with the next value of all monitored items
+// or with a keep-alive message if there are none.
+type testSubscriptions struct {
+	mu       sync.Mutex
+	id       uint32
+	created  uint32
+	interval float64
+	enabled  bool
+	seq      uint32
+	nextItem uint32
+
+	// items maps the monitored item ids to the items.
+	items map[uint32]*testItem
+
+	// acks are the acknowledged sequence numbers.
+	acks []uint32
+
+	// filter is the last event filter.
+	filter *ua.EventFilter
+
+	// retained are the unacknowledged notification messages which can
+	// be republished.
+	retained map[uint32]*ua.NotificationMessage
+
+	// drop are the sequence numbers of the messages which are not sent
+	// and discard the ones which are not retained.
+	drop, discard map[uint32]bool
+
+	// transfers is the number of TransferSubscriptions requests and
+	// rejectTransfer rejects them.
+	transfers      int
+	rejectTransfer bool
+}
+
+// testItem is a monitored item of the test server. Event items have the
+// browse paths of the selected fields.
+type testItem struct {
+	handle uint32
+	fields []string
+}
+
+func (s *testSubscriptions) register(srv *Server) {
+	srv.Handle(&ua.CreateSubscriptionRequest{}, s.createSubscription)
+	srv.Handle(&ua.ModifySubscriptionRequest{}, s.modifySubscription)
+	srv.Handle(&ua.SetPublishingModeRequest{}, s.setPublishingMode)
+	srv.Handle(&ua.DeleteSubscriptionsRequest{}, s.deleteSubscriptions)
+	srv.Handle(&ua.CreateMonitoredItemsRequest{}, s.createMonitoredItems)
+	srv.Handle(&ua.DeleteMonitoredItemsRequest{}, s.deleteMonitoredItems)
+	srv.Handle(&ua.PublishRequest{}, s.publish)
+	srv.Handle(&ua.RepublishRequest{}, s.republish)
+	srv.Handle(&ua.TransferSubscriptionsRequest{}, s.transferSubscriptions)
+}
+
+func (s *testSubscriptions) createSubscription(v interface{}) (interface{}, error) {
+	req := v.(*ua.CreateSubscriptionRequest)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// every subscription gets a new id
+	s.id = testSubscriptionID + s.created
+	s.created++
+	s.seq = 0
+	s.interval = req.RequestedPublishingInterval
+	s.enabled = req.PublishingEnabled
+	s.items = make(map[uint32]*testItem)
+	s.retained = make(map[uint32]*ua.NotificationMessage)
+	return &ua.CreateSubscriptionResponse{
+		SubscriptionID:            s.id,
+		RevisedPublishingInterval: s.interval,
+		RevisedLifetimeCount:      req.RequestedLifetimeCount,
+		RevisedMaxKeepAliveCount:  req.RequestedMaxKeepAliveCount,
+	}, nil
+}
+
+func (s *testSubscriptions) modifySubscription(v interface{}) (interface{}, error) {
+	req := v.(*ua.ModifySubscriptionRequest)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.SubscriptionID != s.id {
+		return nil, ua.StatusBadSubscriptionIDInvalid
+	}
+	s.interval = req.RequestedPublishingInterval
+	return &ua.ModifySubscriptionResponse{
+		RevisedPublishingInterval: s.interval,
+		RevisedLifetimeCount:      req.RequestedLifetimeCount,
+		RevisedMaxKeepAliveCount:  req.RequestedMaxKeepAliveCount,
+	}, nil
+}
+
+func (s *testSubscriptions) setPublishingMode(v interface{}) (interface{}, error) {
+	req := v.(*ua.SetPublishingModeRequest)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enabled = req.PublishingEnabled
+	return &ua.SetPublishingModeResponse{Results: s.results(req.SubscriptionIDs)}, nil
+}
+
+func (s *testSubscriptions) deleteSubscriptions(v interface{}) (interface{}, error) {
+	req := v.(*ua.DeleteSubscriptionsRequest)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := &ua.DeleteSubscriptionsResponse{Results: s.results(req.SubscriptionIDs)}
+	s.id = 0
+	return res, nil
+}
+
+// results returns the status codes for the subscription ids.
+func (s *testSubscriptions) results(ids []uint32) []ua.StatusCode {
+	var codes []ua.StatusCode
+	for _, id := range ids {
+		if id != s.id {
+			codes = append(codes, ua.StatusBadSubscriptionIDInvalid)
+		} else {
+			codes = append(codes, ua.StatusOK)
+		}
+	}
+	return codes
+}
+
+func (s *testSubscriptions) createMonitoredItems(v interface{}) (interface{}, error) {
+	req := v.(*ua.CreateMonitoredItemsRequest)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.SubscriptionID != s.id {
+		return nil, ua.StatusBadSubscriptionIDInvalid
+	}
+	res := &ua.CreateMonitoredItemsResponse{}
+	for _, item := range req.ItemsToCreate {
+		// only the nodes of namespace 0 exist
+		if item.ItemToMonitor.NodeID.Namespace() != 0 {
+			res.Results = append(res.Results, &ua.MonitoredItemCreateResult{
+				StatusCode:   ua.StatusBadNodeIDUnknown,
+				FilterResult: ua.NewExtensionObject(nil),
+			})
+			continue
+		}
+		s.nextItem++
+		ti := &testItem{handle: item.RequestedParameters.ClientHandle}
+		r := &ua.MonitoredItemCreateResult{
+			MonitoredItemID:         s.nextItem,
+			RevisedSamplingInterval: s.interval,
+			RevisedQueueSize:        1,
+			FilterResult:            ua.NewExtensionObject(nil),
+		}
+		if f, ok := item.RequestedParameters.Filter.Value.(*ua.EventFilter); ok {
+			// fields with the name Unknown do not exist
+			fr := &ua.EventFilterResult{WhereClauseResult: &ua.ContentFilterResult{}}
+			for _, o := range f.SelectClauses {
+				ti.fields = append(ti.fields, o.FieldPath())
+				code := ua.StatusOK
+				if o.BrowsePath[len(o.BrowsePath)-1].Name == "Unknown" {
+					code = ua.StatusBadBrowseNameInvalid
+				}
+				fr.SelectClauseResults = append(fr.SelectClauseResults, code)
+			}
+			r.FilterResult = ua.NewExtensionObject(fr)
+			s.filter = f
+		}
+		s.items[s.nextItem] = ti
+		res.Results = append(res.Results, r)
+	}
+	return res, nil
+}
+
+func (s *testSubscriptions) deleteMonitoredItems(v interface{}) (interface{}, error) {
+	req := v.(*ua.DeleteMonitoredItemsRequest)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := &ua.DeleteMonitoredItemsResponse{}
+	for _, id := range req.MonitoredItemIDs {
+		if _, ok := s.items[id]; !ok {
+			res.Results = append(res.Results, ua.StatusBadMonitoredItemIDInvalid)
+			continue
+		}
+		delete(s.items, id)
+		res.Results = append(res.Results, ua.StatusOK)
+	}
+	return res, nil
+}
+
+func (s *testSubscriptions) publish(v interface{}) (interface{}, error) {
+	req := v.(*ua.PublishRequest)
+	s.mu.Lock()
+	res := &ua.PublishResponse{}
+	for _, ack := range req.SubscriptionAcknowledgements {
+		s.acks = append(s.acks, ack.SequenceNumber)
+		delete(s.retained, ack.SequenceNumber)
+		res.Results = append(res.Results, ua.StatusOK)
+	}
+	interval := millisDuration(s.interval)
+	s.mu.Unlock()
+
+	time.Sleep(interval)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id == 0 {
+		return nil, ua.StatusBadNoSubscription
+	}
+	res.SubscriptionID = s.id
+	res.NotificationMessage = &ua.NotificationMessage{SequenceNumber: s.seq + 1, PublishTime: time.Now()}
+	if !s.enabled || len(s.items) == 0 {
+		return res, nil
+	}
+
+	for {
+		s.seq++
+		msg := s.message()
+		if !s.discard[s.seq] {
+			s.retained[s.seq] = msg
+		}
+		if !s.drop[s.seq] {
+			res.NotificationMessage = msg
+			break
+		}
+	}
+	for seq := range s.retained {
+		res.AvailableSequenceNumbers = append(res.AvailableSequenceNumbers, seq)
+	}
+	return res, nil
+}
+
+// message returns the notification message with the current sequence
+// number. s.mu must be held.
+func (s *testSubscriptions) message() *ua.NotificationMessage {
+	var ids []int
+	for id := range s.items {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	dc, ev := &ua.DataChangeNotification{}, &ua.EventNotificationList{}
+	for _, id := range ids {
+		item := s.items[uint32(id)]
+		if item.fields == nil {
+			dc.MonitoredItems = append(dc.MonitoredItems, &ua.MonitoredItemNotification{
+				ClientHandle: item.handle,
+				Value:        &ua.DataValue{EncodingMask: ua.DataValueValue, Value: ua.MustVariant(int32(s.seq))},
+			})
+			continue
+		}
+		// the value of an event field is its path and the sequence number
+		e := &ua.EventFieldList{ClientHandle: item.handle}
+		for _, f := range item.fields {
+			e.EventFields = append(e.EventFields, ua.MustVariant(fmt.Sprintf("%s %d", f, s.seq)))
+		}
+		ev.Events = append(ev.Events, e)
+	}
+	msg := &ua.NotificationMessage{SequenceNumber: s.seq, PublishTime: time.Now()}
+	if len(dc.MonitoredItems) > 0 {
+		msg.NotificationData = append(msg.NotificationData, ua.NewExtensionObject(dc))
+	}
+	if len(ev.Events) > 0 {
+		msg.NotificationData = append(msg.NotificationData, ua.NewExtensionObject(ev))
+	}
+	return msg
+}
+
+func (s *testSubscriptions) republish(v interface{}) (interface{}, error) {
+	req := v.(*ua.RepublishRequest)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.SubscriptionID != s.id {
+		return nil, ua.StatusBadSubscriptionIDInvalid
+	}
+	msg, ok := s.retained[req.RetransmitSequenceNumber]
+	if !ok {
+		return nil, ua.StatusBadMessageNotAvailable
+	}
+	return &ua.RepublishResponse{NotificationMessage: msg}, nil
+}
+
+func (s *testSubscriptions) transferSubscriptions(v interface{}) (interface{}, error) {
+	req := v.(*ua.TransferSubscriptionsRequest)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transfers++
+	res := &ua.TransferSubscriptionsResponse{}
+	for _, id := range req.SubscriptionIDs {
+		if id != s.id || s.rejectTransfer {
+			res.Results = append(res.Results, &ua.TransferResult{StatusCode: ua.StatusBadSubscriptionIDInvalid})
+			continue
+		}
+		r := &ua.TransferResult{StatusCode: ua.StatusOK}
+		for seq := range s.retained {
+			r.AvailableSequenceNumbers = append(r.AvailableSequenceNumbers, seq)
+		}
+		res.Results = append(res.Results, r)
+	}
+	return res, nil
+}
+
+// nextDataChange returns the next message of the subscription which must
+// be a data change.
+func nextDataChange(t *testing.T, sub *Subscription) *DataChangeMessage {
+	t.Helper()
+	select {
+	case m, ok := <-sub.C:
+		if !ok {
+			t.Fatal("channel closed")
+		}
+		dc, ok := m.(*DataChangeMessage)
+		if !ok {
+			t.Fatalf("got %#v want *DataChangeMessage", m)
+		}
+		return dc
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+		return nil
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	subs := &testSubscriptions{}
+	srv := &Server{EndpointURL: testServerEndpoint}
+	subs.register(srv)
+	defer startTestServer(t, srv)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c := NewClient(testServerEndpoint, nil)
+	if err := c.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sub, err := c.Subscribe(ctx, &SubscriptionParameters{Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sub.ID, uint32(testSubscriptionID); got != want {
+		t.Fatalf("got id %d want %d", got, want)
+	}
+	if got, want := sub.RevisedPublishingInterval, 10*time.Millisecond; got != want {
+		t.Fatalf("got interval %v want %v", got, want)
+	}
+	if got, want := sub.RevisedLifetimeCount, uint32(3*DefaultMaxKeepAliveCount); got != want {
+		t.Fatalf("got lifetime count %d want %d", got, want)
+	}
+
+	nodes := []*ua.NodeID{ua.NewNumericNodeID(0, 1), ua.NewNumericNodeID(0, 2), ua.NewStringNodeID(1, "unknown")}
+	items, err := sub.Monitor(ctx, nodes...)
+	verify.Values(t, "", err, ua.MultiError{nil, nil, ua.StatusBadNodeIDUnknown})
+	if items[0] == nil || items[1] == nil || items[2] != nil {
+		t.Fatalf("got items %v", items)
+	}
+
+	// every publish response has the next value for both items
+	for seq := int32(1); seq <= 2; seq++ {
+		for _, item := range items[:2] {
+			m := nextDataChange(t, sub)
+			if m.Item != item {
+				t.Fatalf("got item %v want %v", m.Item.NodeID, item.NodeID)
+			}
+			if got, want := m.Value.Value.Value, seq; got != want {
+				t.Fatalf("got value %v want %v", got, want)
+			}
+		}
+	}
+
+	// the second value was requested with the acknowledgement of the first
+	subs.mu.Lock()
+	acks := subs.acks
+	subs.mu.Unlock()
+	if len(acks) == 0 || acks[0] != 1 {
+		t.Fatalf("got acks %v want [1 ...]", acks)
+	}
+
+	t.Run("unmonitor", func(t *testing.T) {
+		if err := sub.Unmonitor(ctx, items[0]); err != nil {
+			t.Fatal(err)
+		}
+		subs.mu.Lock()
+		last, n := int32(subs.seq), len(subs.items)
+		subs.mu.Unlock()
+		if n != 1 {
+			t.Fatalf("got %d items want 1", n)
+		}
+
+		// queued messages can still contain the removed item
+		for {
+			m := nextDataChange(t, sub)
+			v := m.Value.Value.Value.(int32)
+			if m.Item == items[0] && v > last {
+				t.Fatalf("got value %d for removed item", v)
+			}
+			if v > last+1 {
+				break
+			}
+		}
+
+		err := sub.Unmonitor(ctx, items[0])
+		verify.Values(t, "", err, ua.MultiError{ua.StatusBadMonitoredItemIDInvalid})
+	})
+
+	t.Run("set publishing mode", func(t *testing.T) {
+		if err := sub.SetPublishingMode(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+		subs.mu.Lock()
+		enabled := subs.enabled
+		subs.mu.Unlock()
+		if enabled {
+			t.Fatal("publishing still enabled")
+		}
+	})
+
+	t.Run("modify", func(t *testing.T) {
+		if err := sub.Modify(ctx, &SubscriptionParameters{Interval: 20 * time.Millisecond}); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := sub.RevisedPublishingInterval, 20*time.Millisecond; got != want {
+			t.Fatalf("got interval %v want %v", got, want)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		if err := sub.Cancel(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for range sub.C {
+			// drain until closed
+		}
+		subs.mu.Lock()
+		id := subs.id
+		subs.mu.Unlock()
+		if id != 0 {
+			t.Fatal("subscription not deleted")
+		}
+
+		err := sub.SetPublishingMode(ctx, true)
+		if got, want := err, ua.StatusBadSubscriptionIDInvalid; got != want {
+			t.Fatalf("got %v want %v", got, want)
+		}
+	})
+}
+
+func TestSubscriptionEvents(t *testing.T) {
+	subs := &testSubscriptions{}
+	srv := &Server{EndpointURL: testServerEndpoint}
+	subs.register(srv)
+	defer startTestServer(t, srv)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c := NewClient(testServerEndpoint, nil)
+	if err := c.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sub, err := c.Subscribe(ctx, &SubscriptionParameters{Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel(ctx)
+
+	server := ua.NewNumericNodeID(0, id.Server)
+	if _, err := sub.MonitorEvents(ctx, server, &EventFilter{}); err == nil {
+		t.Fatal("got nil want error for filter without fields")
+	}
+
+	filter := &EventFilter{
+		Select: []*ua.SimpleAttributeOperand{
+			ua.EventField("Severity"),
+			ua.EventField("EnabledState/Id"),
+			ua.EventField("2:Unknown"),
+		},
+		Where: ua.OfType(ua.NewNumericNodeID(0, id.AlarmConditionType)),
+	}
+	item, err := sub.MonitorEvents(ctx, server, filter)
+	verify.Values(t, "", err, ua.MultiError{nil, nil, ua.StatusBadBrowseNameInvalid})
+	if item == nil {
+		t.Fatal("got nil item")
+	}
+	if got, want := item.AttributeID, uint32(ua.IntegerIDEventNotifier); got != want {
+		t.Fatalf("got attribute %d want %d", got, want)
+	}
+
+	subs.mu.Lock()
+	where := subs.filter.WhereClause
+	subs.mu.Unlock()
+	if got, want := where.Elements[0].FilterOperator, ua.FilterOperatorOfType; got != want {
+		t.Fatalf("got operator %v want %v", got, want)
+	}
+
+	select {
+	case m := <-sub.C:
+		ev, ok := m.(*EventMessage)
+		if !ok {
+			t.Fatalf("got %#v want *EventMessage", m)
+		}
+		if ev.Item != item {
+			t.Fatalf("got item %v want %v", ev.Item.NodeID, item.NodeID)
+		}
+		want := map[string]*ua.Variant{
+			"Severity":        ua.MustVariant("Severity 1"),
+			"EnabledState/Id": ua.MustVariant("EnabledState/Id 1"),
+			"2:Unknown":       ua.MustVariant("2:Unknown 1"),
+		}
+		verify.Values(t, "", ev.Fields, want)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestSubscriptionRepublish(t *testing.T) {
+	// message 2 is lost but can be republished and message 4 is lost
+	subs := &testSubscriptions{
+		drop:    map[uint32]bool{2: true, 4: true},
+		discard: map[uint32]bool{4: true},
+	}
+	srv := &Server{EndpointURL: testServerEndpoint}
+	subs.register(srv)
+	defer startTestServer(t, srv)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c := NewClient(testServerEndpoint, nil)
+	if err := c.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	sub, err := c.Subscribe(ctx, &SubscriptionParameters{Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel(ctx)
+	if _, err := sub.Monitor(ctx, ua.NewNumericNodeID(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []interface{}
+	for len(got) < 5 {
+		select {
+		case m := <-sub.C:
+			switch x := m.(type) {
+			case *DataChangeMessage:
+				got = append(got, x.Value.Value.Value)
+			case *GapMessage:
+				got = append(got, *x)
+			default:
+				t.Fatalf("got %#v", m)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout after %v", got)
+		}
+	}
+	verify.Values(t, "", got, []interface{}{int32(1), int32(2), int32(3), GapMessage{From: 4, To: 4}, int32(5)})
+
+	// the republished message is acknowledged
+	subs.mu.Lock()
+	_, retained := subs.retained[2]
+	subs.mu.Unlock()
+	if retained {
+		t.Fatal("message 2 not acknowledged")
+	}
+}
+
+func TestSeqDistance(t *testing.T) {
+	cases := []struct {
+		a, b, want uint32
+	}{
+		{0, 1, 1},
+		{1, 1, 0},
+		{1, 5, 4},
+		{5, 1, 0xfffffffb},
+		{0xffffffff, 1, 1},
+		{0xfffffffe, 2, 3},
+		{seqBefore(1), seqAfter(0xffffffff), 1},
+	}
+	for _, c := range cases {
+		if got := seqDistance(c.a, c.b); got != c.want {
+			t.Errorf("seqDistance(%d, %d) got %d want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestSubscriptionClientClose(t *testing.T) {
+	subs := &testSubscriptions{}
+	srv := &Server{EndpointURL: testServerEndpoint}
+	subs.register(srv)
+	defer startTestServer(t, srv)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c := NewClient(testServerEndpoint, nil)
+	if err := c.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := c.Subscribe(ctx, &SubscriptionParameters{Interval: 10 * time.Millisecond})
+	if err != nil {
+		c.Close()
+		t.Fatal(err)
+	}
+	c.Close()
+
+	select {
+	case _, ok := <-sub.C:
+		if ok {
+			t.Fatal("got message after close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed")
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gopcua/opcua/id"
+)
+
+// WhereClause is an expression of a content filter, e.g. the where clause
+// of an event filter. It is created with the functions for the filter
+// operators:
+//
+//	ua.And(
+//	    ua.OfType(ua.NewNumericNodeID(0, id.AlarmConditionType)),
+//	    ua.GreaterThanOrEqual(ua.EventField("Severity"), uint16(500)),
+//	)
+//
+// An operand is either a *WhereClause, one of the operand types
+// *SimpleAttributeOperand, *AttributeOperand, *LiteralOperand and
+// *ElementOperand or a value which is converted to a *LiteralOperand.
+//
+// Specification: Part 4, 7.4
+type WhereClause struct {
+	Operator FilterOperator
+	Operands []interface{}
+}
+
+// NewWhereClause returns the expression for the operator. It can be used
+// for the operators which have no function of their own.
+func NewWhereClause(op FilterOperator, operands ...interface{}) *WhereClause {
+	return &WhereClause{Operator: op, Operands: operands}
+}
+
+// Equals returns the expression a == b.
+func Equals(a, b interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorEquals, a, b)
+}
+
+// IsNull returns the expression which is true if a is null.
+func IsNull(a interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorIsNull, a)
+}
+
+// GreaterThan returns the expression a > b.
+func GreaterThan(a, b interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorGreaterThan, a, b)
+}
+
+// LessThan returns the expression a < b.
+func LessThan(a, b interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorLessThan, a, b)
+}
+
+// GreaterThanOrEqual returns the expression a >= b.
+func GreaterThanOrEqual(a, b interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorGreaterThanOrEqual, a, b)
+}
+
+// LessThanOrEqual returns the expression a <= b.
+func LessThanOrEqual(a, b interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorLessThanOrEqual, a, b)
+}
+
+// Like returns the expression which is true if a matches the pattern.
+func Like(a interface{}, pattern string) *WhereClause {
+	return NewWhereClause(FilterOperatorLike, a, pattern)
+}
+
+// Not returns the expression !a.
+func Not(a interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorNot, a)
+}
+
+// Between returns the expression lo <= a <= hi.
+func Between(a, lo, hi interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorBetween, a, lo, hi)
+}
+
+// InList returns the expression which is true if a is equal to one of
+// the values.
+func InList(a interface{}, values ...interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorInList, append([]interface{}{a}, values...)...)
+}
+
+// And returns the expression a && b.
+func And(a, b interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorAnd, a, b)
+}
+
+// Or returns the expression a || b.
+func Or(a, b interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorOr, a, b)
+}
+
+// OfType returns the expression which is true if the event is of the type
+// or one of its subtypes.
+func OfType(typeID *NodeID) *WhereClause {
+	return NewWhereClause(FilterOperatorOfType, typeID)
+}
+
+// BitwiseAnd returns the expression a & b.
+func BitwiseAnd(a, b interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorBitwiseAnd, a, b)
+}
+
+// BitwiseOr returns the expression a | b.
+func BitwiseOr(a, b interface{}) *WhereClause {
+	return NewWhereClause(FilterOperatorBitwiseOr, a, b)
+}
+
+// ContentFilter returns the elements of the expression. The expression
+// itself is the first element and the nested expressions follow in
+// breadth-first order and are referenced by an ElementOperand. A nil
+// expression returns an empty filter.
+func (w *WhereClause) ContentFilter() (*ContentFilter, error) {
+	f := &ContentFilter{}
+	if w == nil {
+		return f, nil
+	}
+
+	clauses := []*WhereClause{w}
+	for i := 0; i < len(clauses); i++ {
+		el := &ContentFilterElement{FilterOperator: clauses[i].Operator}
+		for _, op := range clauses[i].Operands {
+			var v interface{}
+			switch x := op.(type) {
+			case *WhereClause:
+				clauses = append(clauses, x)
+				v = &ElementOperand{Index: uint32(len(clauses) - 1)}
+			case *SimpleAttributeOperand, *AttributeOperand, *LiteralOperand, *ElementOperand:
+				v = x
+			default:
+				val, err := NewVariant(op)
+				if err != nil {
+					return nil, fmt.Errorf("invalid operand of element %d: %s", i, err)
+				}
+				v = &LiteralOperand{Value: val}
+			}
+			el.FilterOperands = append(el.FilterOperands, NewExtensionObject(v))
+		}
+		f.Elements = append(f.Elements, el)
+	}
+	return f, nil
+}
+
+// EventField returns the operand for the value of an event field. The path
+// is the browse path of the field relative to the BaseEventType. Its
+// elements are separated by "/" and can have a namespace index prefix,
+// e.g. "Severity", "EnabledState/Id" or "2:Temperature".
+func EventField(path string) *SimpleAttributeOperand {
+	o := &SimpleAttributeOperand{
+		TypeDefinitionID: NewNumericNodeID(0, id.BaseEventType),
+		AttributeID:      IntegerIDValue,
+	}
+	for _, name := range strings.Split(path, "/") {
+		qn := &QualifiedName{Name: name}
+		if i := strings.Index(name, ":"); i > 0 {
+			if ns, err := strconv.ParseUint(name[:i], 10, 16); err == nil {
+				qn.NamespaceIndex, qn.Name = uint16(ns), name[i+1:]
+			}
+		}
+		o.BrowsePath = append(o.BrowsePath, qn)
+	}
+	return o
+}
+
+// FieldPath returns the browse path of the operand in the format of
+// EventField.
+func (o *SimpleAttributeOperand) FieldPath() string {
+	var names []string
+	for _, qn := range o.BrowsePath {
+		if qn.NamespaceIndex != 0 {
+			names = append(names, fmt.Sprintf("%d:%s", qn.NamespaceIndex, qn.Name))
+		} else {
+			names = append(names, qn.Name)
+		}
+	}
+	return strings.Join(names, "/")
+}
@@ -0,0 +1,82 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ua
+
+import (
+	"testing"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestWhereClauseContentFilter(t *testing.T) {
+	severity := EventField("Severity")
+	alarm := NewNumericNodeID(0, id.AlarmConditionType)
+	w := Or(
+		And(OfType(alarm), GreaterThanOrEqual(severity, uint16(500))),
+		IsNull(severity),
+	)
+
+	got, err := w.ContentFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	el := func(op FilterOperator, operands ...interface{}) *ContentFilterElement {
+		e := &ContentFilterElement{FilterOperator: op}
+		for _, o := range operands {
+			e.FilterOperands = append(e.FilterOperands, NewExtensionObject(o))
+		}
+		return e
+	}
+	want := &ContentFilter{
+		Elements: []*ContentFilterElement{
+			el(FilterOperatorOr, &ElementOperand{Index: 1}, &ElementOperand{Index: 2}),
+			el(FilterOperatorAnd, &ElementOperand{Index: 3}, &ElementOperand{Index: 4}),
+			el(FilterOperatorIsNull, severity),
+			el(FilterOperatorOfType, &LiteralOperand{Value: MustVariant(alarm)}),
+			el(FilterOperatorGreaterThanOrEqual, severity, &LiteralOperand{Value: MustVariant(uint16(500))}),
+		},
+	}
+	verify.Values(t, "", got, want)
+
+	t.Run("nil", func(t *testing.T) {
+		var w *WhereClause
+		got, err := w.ContentFilter()
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, "", got, &ContentFilter{})
+	})
+
+	t.Run("invalid literal", func(t *testing.T) {
+		if _, err := Equals(severity, struct{}{}).ContentFilter(); err == nil {
+			t.Fatal("got nil want error")
+		}
+	})
+}
+
+func TestEventField(t *testing.T) {
+	cases := []struct {
+		path string
+		want []*QualifiedName
+	}{
+		{"Severity", []*QualifiedName{{Name: "Severity"}}},
+		{"EnabledState/Id", []*QualifiedName{{Name: "EnabledState"}, {Name: "Id"}}},
+		{"2:Temperature", []*QualifiedName{{NamespaceIndex: 2, Name: "Temperature"}}},
+		{"a:b", []*QualifiedName{{Name: "a:b"}}},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			o := EventField(c.path)
+			verify.Values(t, "", o.BrowsePath, c.want)
+			if got, want := o.TypeDefinitionID.IntID(), id.BaseEventType; got != want {
+				t.Fatalf("got type definition %d want %d", got, want)
+			}
+			if got, want := o.FieldPath(), c.path; got != want {
+				t.Fatalf("got path %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -74,6 +74,63 @@ func (e *ExtensionObject) Decode(b []byte) (int, error) {
 	case id.IssuedIdentityToken_Encoding_DefaultBinary:
 		e.Value = new(IssuedIdentityToken)
 		body.ReadStruct(e.Value)
+	case id.DataChangeNotification_Encoding_DefaultBinary:
+		e.Value = new(DataChangeNotification)
+		body.ReadStruct(e.Value)
+	case id.EventNotificationList_Encoding_DefaultBinary:
+		e.Value = new(EventNotificationList)
+		body.ReadStruct(e.Value)
+	case id.StatusChangeNotification_Encoding_DefaultBinary:
+		e.Value = new(StatusChangeNotification)
+		body.ReadStruct(e.Value)
+	case id.EventFilter_Encoding_DefaultBinary:
+		e.Value = new(EventFilter)
+		body.ReadStruct(e.Value)
+	case id.EventFilterResult_Encoding_DefaultBinary:
+		e.Value = new(EventFilterResult)
+		body.ReadStruct(e.Value)
+	case id.ElementOperand_Encoding_DefaultBinary:
+		e.Value = new(ElementOperand)
+		body.ReadStruct(e.Value)
+	case id.LiteralOperand_Encoding_DefaultBinary:
+		e.Value = new(LiteralOperand)
+		body.ReadStruct(e.Value)
+	case id.AttributeOperand_Encoding_DefaultBinary:
+		e.Value = new(AttributeOperand)
+		body.ReadStruct(e.Value)
+	case id.SimpleAttributeOperand_Encoding_DefaultBinary:
+		e.Value = new(SimpleAttributeOperand)
+		body.ReadStruct(e.Value)
+	case id.Argument_Encoding_DefaultBinary:
+		e.Value = new(Argument)
+		body.ReadStruct(e.Value)
+	case id.ReadEventDetails_Encoding_DefaultBinary:
+		e.Value = new(ReadEventDetails)
+		body.ReadStruct(e.Value)
+	case id.ReadRawModifiedDetails_Encoding_DefaultBinary:
+		e.Value = new(ReadRawModifiedDetails)
+		body.ReadStruct(e.Value)
+	case id.ReadProcessedDetails_Encoding_DefaultBinary:
+		e.Value = new(ReadProcessedDetails)
+		body.ReadStruct(e.Value)
+	case id.ReadAtTimeDetails_Encoding_DefaultBinary:
+		e.Value = new(ReadAtTimeDetails)
+		body.ReadStruct(e.Value)
+	case id.HistoryData_Encoding_DefaultBinary:
+		e.Value = new(HistoryData)
+		body.ReadStruct(e.Value)
+	case id.HistoryModifiedData_Encoding_DefaultBinary:
+		e.Value = new(HistoryModifiedData)
+		body.ReadStruct(e.Value)
+	case id.HistoryEvent_Encoding_DefaultBinary:
+		e.Value = new(HistoryEvent)
+		body.ReadStruct(e.Value)
+	case id.UpdateDataDetails_Encoding_DefaultBinary:
+		e.Value = new(UpdateDataDetails)
+		body.ReadStruct(e.Value)
+	case id.DeleteRawModifiedDetails_Encoding_DefaultBinary:
+		e.Value = new(DeleteRawModifiedDetails)
+		body.ReadStruct(e.Value)
 	default:
 		e.Value = body.ReadBytes()
 	}
@@ -118,6 +175,44 @@ func ExtensionObjectTypeID(v interface{}) *ExpandedNodeID {
 		return NewFourByteExpandedNodeID(0, id.X509IdentityToken_Encoding_DefaultBinary)
 	case *IssuedIdentityToken:
 		return NewFourByteExpandedNodeID(0, id.IssuedIdentityToken_Encoding_DefaultBinary)
+	case *DataChangeNotification:
+		return NewFourByteExpandedNodeID(0, id.DataChangeNotification_Encoding_DefaultBinary)
+	case *EventNotificationList:
+		return NewFourByteExpandedNodeID(0, id.EventNotificationList_Encoding_DefaultBinary)
+	case *StatusChangeNotification:
+		return NewFourByteExpandedNodeID(0, id.StatusChangeNotification_Encoding_DefaultBinary)
+	case *EventFilter:
+		return NewFourByteExpandedNodeID(0, id.EventFilter_Encoding_DefaultBinary)
+	case *EventFilterResult:
+		return NewFourByteExpandedNodeID(0, id.EventFilterResult_Encoding_DefaultBinary)
+	case *ElementOperand:
+		return NewFourByteExpandedNodeID(0, id.ElementOperand_Encoding_DefaultBinary)
+	case *LiteralOperand:
+		return NewFourByteExpandedNodeID(0, id.LiteralOperand_Encoding_DefaultBinary)
+	case *AttributeOperand:
+		return NewFourByteExpandedNodeID(0, id.AttributeOperand_Encoding_DefaultBinary)
+	case *SimpleAttributeOperand:
+		return NewFourByteExpandedNodeID(0, id.SimpleAttributeOperand_Encoding_DefaultBinary)
+	case *Argument:
+		return NewFourByteExpandedNodeID(0, id.Argument_Encoding_DefaultBinary)
+	case *ReadEventDetails:
+		return NewFourByteExpandedNodeID(0, id.ReadEventDetails_Encoding_DefaultBinary)
+	case *ReadRawModifiedDetails:
+		return NewFourByteExpandedNodeID(0, id.ReadRawModifiedDetails_Encoding_DefaultBinary)
+	case *ReadProcessedDetails:
+		return NewFourByteExpandedNodeID(0, id.ReadProcessedDetails_Encoding_DefaultBinary)
+	case *ReadAtTimeDetails:
+		return NewFourByteExpandedNodeID(0, id.ReadAtTimeDetails_Encoding_DefaultBinary)
+	case *HistoryData:
+		return NewFourByteExpandedNodeID(0, id.HistoryData_Encoding_DefaultBinary)
+	case *HistoryModifiedData:
+		return NewFourByteExpandedNodeID(0, id.HistoryModifiedData_Encoding_DefaultBinary)
+	case *HistoryEvent:
+		return NewFourByteExpandedNodeID(0, id.HistoryEvent_Encoding_DefaultBinary)
+	case *UpdateDataDetails:
+		return NewFourByteExpandedNodeID(0, id.UpdateDataDetails_Encoding_DefaultBinary)
+	case *DeleteRawModifiedDetails:
+		return NewFourByteExpandedNodeID(0, id.DeleteRawModifiedDetails_Encoding_DefaultBinary)
 	default:
 		return NewTwoByteExpandedNodeID(0)
 	}
@@ -6,6 +6,7 @@ package ua
 
 import (
 	"testing"
+	"time"
 )
 
 func TestExtensionObject(t *testing.T) {
@@ -24,6 +25,45 @@ func TestExtensionObject(t *testing.T) {
 				0x09, 0x00, 0x00, 0x00, 0x61, 0x6e, 0x6f, 0x6e, 0x79, 0x6d, 0x6f, 0x75, 0x73,
 			},
 		},
+		{
+			Name: "status-change-notification",
+			Struct: NewExtensionObject(&StatusChangeNotification{
+				Status:         StatusBadTimeout,
+				DiagnosticInfo: &DiagnosticInfo{},
+			}),
+			Bytes: []byte{
+				// TypeID
+				0x01, 0x00, 0x34, 0x03,
+				// EncodingMask
+				0x01,
+				// Length
+				0x05, 0x00, 0x00, 0x00,
+				// Status
+				0x00, 0x00, 0x0a, 0x80,
+				// DiagnosticInfo
+				0x00,
+			},
+		},
+		{
+			Name: "read-at-time-details",
+			Struct: NewExtensionObject(&ReadAtTimeDetails{
+				ReqTimes:        []time.Time{time.Date(2018, time.August, 10, 23, 0, 0, 0, time.UTC)},
+				UseSimpleBounds: true,
+			}),
+			Bytes: []byte{
+				// TypeID
+				0x01, 0x00, 0x8f, 0x02,
+				// EncodingMask
+				0x01,
+				// Length
+				0x0d, 0x00, 0x00, 0x00,
+				// ReqTimes
+				0x01, 0x00, 0x00, 0x00,
+				0x00, 0x98, 0x67, 0xdd, 0xfd, 0x30, 0xd4, 0x01,
+				// UseSimpleBounds
+				0x01,
+			},
+		},
 	}
 	RunCodecTest(t, cases)
 }
@@ -0,0 +1,96 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ua
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPublishResponse(t *testing.T) {
+	cases := []CodecTestCase{
+		{
+			Name: "data change notification",
+			Struct: &PublishResponse{
+				ResponseHeader: &ResponseHeader{
+					Timestamp:          time.Date(2018, time.August, 10, 23, 0, 0, 0, time.UTC),
+					RequestHandle:      1,
+					ServiceDiagnostics: &DiagnosticInfo{},
+					StringTable:        []string{},
+					AdditionalHeader:   NewExtensionObject(nil),
+				},
+				SubscriptionID:           1,
+				AvailableSequenceNumbers: []uint32{1},
+				NotificationMessage: &NotificationMessage{
+					SequenceNumber: 1,
+					PublishTime:    time.Date(2018, time.August, 10, 23, 0, 0, 0, time.UTC),
+					NotificationData: []*ExtensionObject{
+						NewExtensionObject(&DataChangeNotification{
+							MonitoredItems: []*MonitoredItemNotification{
+								{
+									ClientHandle: 1,
+									Value: &DataValue{
+										EncodingMask: DataValueValue,
+										Value:        MustVariant(int32(42)),
+									},
+								},
+							},
+							DiagnosticInfos: []*DiagnosticInfo{},
+						}),
+					},
+				},
+				Results:         []StatusCode{},
+				DiagnosticInfos: []*DiagnosticInfo{},
+			},
+			Bytes: []byte{
+				// Timestamp
+				0x00, 0x98, 0x67, 0xdd, 0xfd, 0x30, 0xd4, 0x01,
+				// RequestHandle
+				0x01, 0x00, 0x00, 0x00,
+				// ServiceResult
+				0x00, 0x00, 0x00, 0x00,
+				// ServiceDiagnostics
+				0x00,
+				// StringTable
+				0x00, 0x00, 0x00, 0x00,
+				// AdditionalHeader
+				0x00, 0x00, 0x00,
+				// SubscriptionID
+				0x01, 0x00, 0x00, 0x00,
+				// AvailableSequenceNumbers
+				0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+				// MoreNotifications
+				0x00,
+				// NotificationMessage
+				// SequenceNumber
+				0x01, 0x00, 0x00, 0x00,
+				// PublishTime
+				0x00, 0x98, 0x67, 0xdd, 0xfd, 0x30, 0xd4, 0x01,
+				// NotificationData
+				// ArraySize
+				0x01, 0x00, 0x00, 0x00,
+				// TypeID
+				0x01, 0x00, 0x2b, 0x03,
+				// EncodingMask
+				0x01,
+				// Length
+				0x12, 0x00, 0x00, 0x00,
+				// MonitoredItems
+				0x01, 0x00, 0x00, 0x00,
+				// ClientHandle
+				0x01, 0x00, 0x00, 0x00,
+				// Value
+				0x01, 0x06, 0x2a, 0x00, 0x00, 0x00,
+				// DiagnosticInfos
+				0x00, 0x00, 0x00, 0x00,
+				// Results
+				0x00, 0x00, 0x00, 0x00,
+				// DiagnosticInfos
+				0x00, 0x00, 0x00, 0x00,
+			},
+		},
+	}
+	RunCodecTest(t, cases)
+}
@@ -0,0 +1,227 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gopcua/opcua/id"
+)
+
+// referenceTypes maps the browse names of the reference types of
+// namespace 0 to their ids.
+var referenceTypes = map[string]uint32{
+	"References":                 id.References,
+	"NonHierarchicalReferences":  id.NonHierarchicalReferences,
+	"HierarchicalReferences":     id.HierarchicalReferences,
+	"HasChild":                   id.HasChild,
+	"Organizes":                  id.Organizes,
+	"HasEventSource":             id.HasEventSource,
+	"HasModellingRule":           id.HasModellingRule,
+	"HasEncoding":                id.HasEncoding,
+	"HasDescription":             id.HasDescription,
+	"HasTypeDefinition":          id.HasTypeDefinition,
+	"GeneratesEvent":             id.GeneratesEvent,
+	"AlwaysGeneratesEvent":       id.AlwaysGeneratesEvent,
+	"Aggregates":                 id.Aggregates,
+	"HasSubtype":                 id.HasSubtype,
+	"HasProperty":                id.HasProperty,
+	"HasComponent":               id.HasComponent,
+	"HasNotifier":                id.HasNotifier,
+	"HasOrderedComponent":        id.HasOrderedComponent,
+	"FromState":                  id.FromState,
+	"ToState":                    id.ToState,
+	"HasCause":                   id.HasCause,
+	"HasEffect":                  id.HasEffect,
+	"HasHistoricalConfiguration": id.HasHistoricalConfiguration,
+	"HasSubStateMachine":         id.HasSubStateMachine,
+	"HasTrueSubState":            id.HasTrueSubState,
+	"HasFalseSubState":           id.HasFalseSubState,
+	"HasCondition":               id.HasCondition,
+}
+
+// referenceTypeNames is the inverse of referenceTypes.
+var referenceTypeNames = func() map[uint32]string {
+	m := make(map[uint32]string, len(referenceTypes))
+	for name, id := range referenceTypes {
+		m[id] = name
+	}
+	return m
+}()
+
+// pathReserved are the characters which must be escaped with '&' in the
+// browse names of a relative path.
+const pathReserved = "/.<>:#!&"
+
+// ParseRelativePath parses the text format of a relative path, e.g.
+// "/Objects/2:PLC1/2:Speed". Every element starts with the reference type
+// which is followed by the browse name of the target:
+//
+//	/name            forward hierarchical reference or subtype
+//	.name            forward aggregates reference or subtype
+//	<Type>name       forward reference of the type or a subtype
+//	<#Type>name      forward reference of exactly the type
+//	<!Type>name      inverse reference of the type or a subtype
+//
+// The browse names have an optional namespace index prefix, e.g. "2:PLC1",
+// and the reserved characters "/.<>:#!&" are escaped with '&'. Only the
+// reference types of namespace 0 can be used in angle brackets. The
+// browse name of the last element can be empty to match all targets.
+//
+// Specification: Part 4, A.2
+func ParseRelativePath(s string) (*RelativePath, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty relative path")
+	}
+	p := &RelativePath{}
+	for i := 0; i < len(s); {
+		e := &RelativePathElement{IncludeSubtypes: true}
+		switch s[i] {
+		case '/':
+			e.ReferenceTypeID = NewNumericNodeID(0, id.HierarchicalReferences)
+			i++
+		case '.':
+			e.ReferenceTypeID = NewNumericNodeID(0, id.Aggregates)
+			i++
+		case '<':
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				return nil, fmt.Errorf("missing '>' in relative path %q", s)
+			}
+			ref := s[i+1 : i+end]
+			i += end + 1
+			for len(ref) > 0 && (ref[0] == '#' || ref[0] == '!') {
+				if ref[0] == '#' {
+					e.IncludeSubtypes = false
+				} else {
+					e.IsInverse = true
+				}
+				ref = ref[1:]
+			}
+			qn, err := parseBrowseName(ref)
+			if err != nil {
+				return nil, err
+			}
+			typeID, ok := referenceTypes[qn.Name]
+			if !ok || qn.NamespaceIndex != 0 {
+				return nil, fmt.Errorf("unknown reference type %q in relative path %q", ref, s)
+			}
+			e.ReferenceTypeID = NewNumericNodeID(0, typeID)
+		default:
+			return nil, fmt.Errorf("invalid reference type %q at offset %d of relative path %q", s[i], i, s)
+		}
+
+		// the browse name ends at the next unescaped reference type
+		end := i
+		for end < len(s) && !strings.ContainsRune("/.<", rune(s[end])) {
+			if s[end] == '&' {
+				end++
+			}
+			end++
+		}
+		if end > len(s) {
+			return nil, fmt.Errorf("incomplete escape sequence in relative path %q", s)
+		}
+		qn, err := parseBrowseName(s[i:end])
+		if err != nil {
+			return nil, err
+		}
+		if qn.Name == "" && end < len(s) {
+			return nil, fmt.Errorf("missing browse name at offset %d of relative path %q", i, s)
+		}
+		e.TargetName = qn
+		p.Elements = append(p.Elements, e)
+		i = end
+	}
+	return p, nil
+}
+
+// parseBrowseName parses a browse name with an optional namespace index
+// prefix and escaped reserved characters.
+func parseBrowseName(s string) (*QualifiedName, error) {
+	qn := &QualifiedName{}
+	var name strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '&':
+			i++
+			if i == len(s) {
+				return nil, fmt.Errorf("incomplete escape sequence in browse name %q", s)
+			}
+			name.WriteByte(s[i])
+		case c == ':' && qn.NamespaceIndex == 0 && name.Len() > 0 && i == name.Len():
+			// the text before the first unescaped ':' is the namespace
+			ns, err := strconv.ParseUint(name.String(), 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid namespace index in browse name %q", s)
+			}
+			qn.NamespaceIndex = uint16(ns)
+			name.Reset()
+		case strings.IndexByte(pathReserved, c) >= 0:
+			return nil, fmt.Errorf("unescaped %q in browse name %q", c, s)
+		default:
+			name.WriteByte(c)
+		}
+	}
+	qn.Name = name.String()
+	return qn, nil
+}
+
+// String returns the relative path in the format of ParseRelativePath.
+func (p *RelativePath) String() string {
+	var b strings.Builder
+	for _, e := range p.Elements {
+		b.WriteString(e.String())
+	}
+	return b.String()
+}
+
+// String returns the element in the format of ParseRelativePath.
+func (e *RelativePathElement) String() string {
+	var b strings.Builder
+	switch {
+	case e.isType(id.HierarchicalReferences):
+		b.WriteByte('/')
+	case e.isType(id.Aggregates):
+		b.WriteByte('.')
+	default:
+		b.WriteByte('<')
+		if !e.IncludeSubtypes {
+			b.WriteByte('#')
+		}
+		if e.IsInverse {
+			b.WriteByte('!')
+		}
+		if e.ReferenceTypeID != nil && e.ReferenceTypeID.Namespace() == 0 && referenceTypeNames[uint32(e.ReferenceTypeID.IntID())] != "" {
+			b.WriteString(referenceTypeNames[uint32(e.ReferenceTypeID.IntID())])
+		} else {
+			// not parseable but better than nothing
+			fmt.Fprintf(&b, "%s", e.ReferenceTypeID)
+		}
+		b.WriteByte('>')
+	}
+	if qn := e.TargetName; qn != nil {
+		if qn.NamespaceIndex != 0 {
+			fmt.Fprintf(&b, "%d:", qn.NamespaceIndex)
+		}
+		for i := 0; i < len(qn.Name); i++ {
+			if strings.IndexByte(pathReserved, qn.Name[i]) >= 0 {
+				b.WriteByte('&')
+			}
+			b.WriteByte(qn.Name[i])
+		}
+	}
+	return b.String()
+}
+
+// isType returns true if the element is a forward reference of the type
+// of namespace 0 or one of its subtypes.
+func (e *RelativePathElement) isType(typeID uint32) bool {
+	return e.ReferenceTypeID != nil && e.ReferenceTypeID.Namespace() == 0 &&
+		e.ReferenceTypeID.IntID() == int(typeID) && !e.IsInverse && e.IncludeSubtypes
+}
@@ -0,0 +1,131 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ua
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestParseRelativePath(t *testing.T) {
+	elem := func(typeID uint32, inverse, subtypes bool, ns uint16, name string) *RelativePathElement {
+		return &RelativePathElement{
+			ReferenceTypeID: NewNumericNodeID(0, typeID),
+			IsInverse:       inverse,
+			IncludeSubtypes: subtypes,
+			TargetName:      &QualifiedName{NamespaceIndex: ns, Name: name},
+		}
+	}
+
+	cases := []struct {
+		s   string
+		p   *RelativePath
+		err error
+	}{
+		// happy flows
+		{
+			s: "/Objects/2:PLC1/2:Speed",
+			p: &RelativePath{Elements: []*RelativePathElement{
+				elem(id.HierarchicalReferences, false, true, 0, "Objects"),
+				elem(id.HierarchicalReferences, false, true, 2, "PLC1"),
+				elem(id.HierarchicalReferences, false, true, 2, "Speed"),
+			}},
+		},
+		{
+			s: "/2:Block.2:Temperature",
+			p: &RelativePath{Elements: []*RelativePathElement{
+				elem(id.HierarchicalReferences, false, true, 2, "Block"),
+				elem(id.Aggregates, false, true, 2, "Temperature"),
+			}},
+		},
+		{
+			s: "<HasProperty>1:Unit<#HasComponent>Value<!Organizes>Objects",
+			p: &RelativePath{Elements: []*RelativePathElement{
+				elem(id.HasProperty, false, true, 1, "Unit"),
+				elem(id.HasComponent, false, false, 0, "Value"),
+				elem(id.Organizes, true, true, 0, "Objects"),
+			}},
+		},
+		{
+			s: "<#!HasChild>Parent",
+			p: &RelativePath{Elements: []*RelativePathElement{
+				elem(id.HasChild, true, false, 0, "Parent"),
+			}},
+		},
+		{
+			s: "/a&/b&.c&<d&>e&:f&#g&!h&&i",
+			p: &RelativePath{Elements: []*RelativePathElement{
+				elem(id.HierarchicalReferences, false, true, 0, "a/b.c<d>e:f#g!h&i"),
+			}},
+		},
+		{
+			s: "/Objects/",
+			p: &RelativePath{Elements: []*RelativePathElement{
+				elem(id.HierarchicalReferences, false, true, 0, "Objects"),
+				elem(id.HierarchicalReferences, false, true, 0, ""),
+			}},
+		},
+
+		// error flows
+		{s: "", err: errors.New("empty relative path")},
+		{s: "Objects", err: errors.New(`invalid reference type 'O' at offset 0 of relative path "Objects"`)},
+		{s: "//Objects", err: errors.New(`missing browse name at offset 1 of relative path "//Objects"`)},
+		{s: "<HasProperty", err: errors.New(`missing '>' in relative path "<HasProperty"`)},
+		{s: "<HasFoo>a", err: errors.New(`unknown reference type "HasFoo" in relative path "<HasFoo>a"`)},
+		{s: "/a#b", err: errors.New(`unescaped '#' in browse name "a#b"`)},
+		{s: "/a:b", err: errors.New(`invalid namespace index in browse name "a:b"`)},
+		{s: "/1:a:b", err: errors.New(`unescaped ':' in browse name "1:a:b"`)},
+		{s: "/a&", err: errors.New(`incomplete escape sequence in relative path "/a&"`)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.s, func(t *testing.T) {
+			p, err := ParseRelativePath(c.s)
+			if got, want := err, c.err; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got error %v want %v", got, want)
+			}
+			verify.Values(t, "", p, c.p)
+			if p == nil {
+				return
+			}
+			if got, want := p.String(), c.s; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRelativePathElementString(t *testing.T) {
+	cases := []struct {
+		e    *RelativePathElement
+		want string
+	}{
+		{
+			&RelativePathElement{ReferenceTypeID: NewNumericNodeID(0, id.HierarchicalReferences), IncludeSubtypes: true},
+			"/",
+		},
+		{
+			&RelativePathElement{ReferenceTypeID: NewNumericNodeID(0, id.HierarchicalReferences), TargetName: &QualifiedName{Name: "a"}},
+			"<#HierarchicalReferences>a",
+		},
+		{
+			&RelativePathElement{ReferenceTypeID: NewNumericNodeID(0, id.Aggregates), IsInverse: true, IncludeSubtypes: true, TargetName: &QualifiedName{Name: "a"}},
+			"<!Aggregates>a",
+		},
+		{
+			&RelativePathElement{ReferenceTypeID: NewNumericNodeID(2, 5001), IncludeSubtypes: true, TargetName: &QualifiedName{Name: "a"}},
+			"<ns=2;i=5001>a",
+		},
+	}
+	for _, c := range cases {
+		if got := c.e.String(); got != c.want {
+			t.Errorf("got %q want %q", got, c.want)
+		}
+	}
+}
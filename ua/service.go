@@ -7,6 +7,8 @@ package ua
 import (
 	"fmt"
 	"reflect"
+
+	"github.com/gopcua/opcua/id"
 )
 
 var (
@@ -14,6 +16,14 @@ var (
 	serviceTypeID = map[reflect.Type]uint16{}
 )
 
+var responseHeaderType = reflect.TypeOf(&ResponseHeader{})
+
+func init() {
+	// a ServiceFault is sent instead of the response when a
+	// service fails and consists only of the response header.
+	register(id.ServiceFault_Encoding_DefaultBinary, new(ServiceFault))
+}
+
 func register(typeID uint16, v interface{}) {
 	typ := reflect.TypeOf(v) // *ServiceObject
 
@@ -50,3 +60,29 @@ func DecodeService(b []byte) (*ExpandedNodeID, interface{}, error) {
 	_, err = Decode(b, v)
 	return typeID, v, err
 }
+
+// DecodeResponse decodes a service message like DecodeService. If the
+// service is a response the response header is decoded first and a bad
+// ServiceResult is returned as *ServiceError without decoding the rest
+// of the response since it might be incomplete.
+func DecodeResponse(b []byte) (*ExpandedNodeID, interface{}, error) {
+	typeID := new(ExpandedNodeID)
+	n, err := typeID.Decode(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	typ := serviceType[uint16(typeID.NodeID.IntID())]
+	if typ == nil || typ.Elem().NumField() == 0 || typ.Elem().Field(0).Type != responseHeaderType {
+		return DecodeService(b)
+	}
+
+	h := new(ResponseHeader)
+	if _, err := Decode(b[n:], h); err != nil {
+		return typeID, nil, err
+	}
+	if err := NewServiceError(h); err != nil {
+		return typeID, nil, err
+	}
+	return DecodeService(b)
+}
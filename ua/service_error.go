@@ -0,0 +1,76 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ua
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ServiceError is returned when the server has rejected a service request
+// with a bad ServiceResult in the response header. It carries the
+// diagnostics and the string table of the response header which hold the
+// details of the failure.
+type ServiceError struct {
+	StatusCode
+
+	// Diagnostics are the ServiceDiagnostics of the response header.
+	Diagnostics *DiagnosticInfo
+
+	// StringTable contains the strings referenced by the Diagnostics.
+	StringTable []string
+}
+
+// NewServiceError returns the error for the response header or nil if the
+// ServiceResult is not bad.
+func NewServiceError(h *ResponseHeader) error {
+	if h == nil || !h.ServiceResult.IsBad() {
+		return nil
+	}
+	return &ServiceError{
+		StatusCode:  h.ServiceResult,
+		Diagnostics: h.ServiceDiagnostics,
+		StringTable: h.StringTable,
+	}
+}
+
+func (e *ServiceError) Error() string {
+	msg := e.StatusCode.Error()
+	d := e.Diagnostics
+	if d == nil {
+		return msg
+	}
+
+	var details []string
+	if d.Has(DiagnosticInfoSymbolicID) {
+		details = append(details, e.str(d.SymbolicID))
+	}
+	if d.Has(DiagnosticInfoLocalizedText) {
+		details = append(details, e.str(d.LocalizedText))
+	}
+	if d.Has(DiagnosticInfoAdditionalInfo) {
+		details = append(details, d.AdditionalInfo)
+	}
+	if d.Has(DiagnosticInfoInnerStatusCode) {
+		details = append(details, d.InnerStatusCode.Error())
+	}
+	if len(details) == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s: %s", msg, strings.Join(details, ", "))
+}
+
+// Unwrap returns the StatusCode of the ServiceResult.
+func (e *ServiceError) Unwrap() error {
+	return e.StatusCode
+}
+
+// str returns the string with the index i from the string table.
+func (e *ServiceError) str(i int32) string {
+	if i < 0 || int(i) >= len(e.StringTable) {
+		return fmt.Sprintf("string %d", i)
+	}
+	return e.StringTable[i]
+}
@@ -0,0 +1,147 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ua
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestServiceError(t *testing.T) {
+	cases := []struct {
+		name string
+		h    *ResponseHeader
+		err  error
+		msg  string
+	}{
+		{
+			name: "good",
+			h:    &ResponseHeader{ServiceResult: StatusOK},
+		},
+		{
+			name: "uncertain",
+			h:    &ResponseHeader{ServiceResult: StatusUncertainDataSubNormal},
+		},
+		{
+			name: "bad",
+			h:    &ResponseHeader{ServiceResult: StatusBadSessionIDInvalid},
+			err:  &ServiceError{StatusCode: StatusBadSessionIDInvalid},
+			msg:  StatusBadSessionIDInvalid.Error(),
+		},
+		{
+			name: "diagnostics",
+			h: &ResponseHeader{
+				ServiceResult: StatusBadSessionIDInvalid,
+				ServiceDiagnostics: &DiagnosticInfo{
+					EncodingMask:   DiagnosticInfoSymbolicID | DiagnosticInfoLocalizedText | DiagnosticInfoAdditionalInfo,
+					SymbolicID:     1,
+					LocalizedText:  0,
+					AdditionalInfo: "session 42",
+				},
+				StringTable: []string{"session expired", "BadSessionIdInvalid"},
+			},
+			err: &ServiceError{
+				StatusCode: StatusBadSessionIDInvalid,
+				Diagnostics: &DiagnosticInfo{
+					EncodingMask:   DiagnosticInfoSymbolicID | DiagnosticInfoLocalizedText | DiagnosticInfoAdditionalInfo,
+					SymbolicID:     1,
+					LocalizedText:  0,
+					AdditionalInfo: "session 42",
+				},
+				StringTable: []string{"session expired", "BadSessionIdInvalid"},
+			},
+			msg: StatusBadSessionIDInvalid.Error() + ": BadSessionIdInvalid, session expired, session 42",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := NewServiceError(c.h)
+			verify.Values(t, "", err, c.err)
+			if err == nil {
+				return
+			}
+			if got, want := err.Error(), c.msg; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+			if got, want := err.(*ServiceError).Unwrap(), c.h.ServiceResult; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeResponse(t *testing.T) {
+	h := &ResponseHeader{
+		Timestamp:          time.Date(2018, time.August, 10, 23, 0, 0, 0, time.UTC),
+		RequestHandle:      1,
+		ServiceDiagnostics: &DiagnosticInfo{},
+		StringTable:        []string{},
+		AdditionalHeader:   NewExtensionObject(nil),
+	}
+
+	encode := func(typeID uint16, v interface{}) []byte {
+		b, err := Encode(NewFourByteExpandedNodeID(0, typeID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := Encode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(b, body...)
+	}
+
+	t.Run("good", func(t *testing.T) {
+		resp := &CancelResponse{ResponseHeader: h, CancelCount: 1}
+		_, v, err := DecodeResponse(encode(id.CancelResponse_Encoding_DefaultBinary, resp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, "", v, resp)
+	})
+
+	t.Run("service fault", func(t *testing.T) {
+		bad := *h
+		bad.ServiceResult = StatusBadSessionIDInvalid
+		_, _, err := DecodeResponse(encode(id.ServiceFault_Encoding_DefaultBinary, &ServiceFault{ResponseHeader: &bad}))
+		verify.Values(t, "", err, &ServiceError{
+			StatusCode:  StatusBadSessionIDInvalid,
+			Diagnostics: &DiagnosticInfo{},
+			StringTable: []string{},
+		})
+	})
+
+	t.Run("incomplete response", func(t *testing.T) {
+		// the ReadResponse only contains the response header
+		bad := *h
+		bad.ServiceResult = StatusBadTooManyOperations
+		b := encode(id.ReadResponse_Encoding_DefaultBinary, &ServiceFault{ResponseHeader: &bad})
+		_, _, err := DecodeResponse(b)
+		if e, ok := err.(*ServiceError); !ok || e.StatusCode != StatusBadTooManyOperations {
+			t.Fatalf("got error %v want %v", err, StatusBadTooManyOperations)
+		}
+	})
+
+	t.Run("request", func(t *testing.T) {
+		req := &CancelRequest{
+			RequestHeader: &RequestHeader{
+				AuthenticationToken: NewTwoByteNodeID(0),
+				Timestamp:           time.Date(2018, time.August, 10, 23, 0, 0, 0, time.UTC),
+				AdditionalHeader:    NewExtensionObject(nil),
+			},
+			RequestHandle: 1,
+		}
+		_, v, err := DecodeResponse(encode(id.CancelRequest_Encoding_DefaultBinary, req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, "", v, req)
+	})
+}
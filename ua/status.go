@@ -0,0 +1,104 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ua
+
+import (
+	"fmt"
+	"strings"
+)
+
+// severityMask selects the severity bits of a status code.
+//
+// Specification: Part 4, 7.34.1
+const severityMask = 0xC0000000
+
+const (
+	severityGood      = 0x00000000
+	severityUncertain = 0x40000000
+	severityBad       = 0x80000000
+)
+
+// IsGood returns true if the status code has the severity Good.
+func (n StatusCode) IsGood() bool {
+	return n&severityMask == severityGood
+}
+
+// IsUncertain returns true if the status code has the severity Uncertain.
+func (n StatusCode) IsUncertain() bool {
+	return n&severityMask == severityUncertain
+}
+
+// IsBad returns true if the status code has the severity Bad.
+func (n StatusCode) IsBad() bool {
+	return n&severityMask == severityBad
+}
+
+// MultiError is returned when one or more operations of a service request
+// have failed. It has one entry per operation in the order of the request
+// and the entries of the successful operations are nil.
+type MultiError []error
+
+func (e MultiError) Error() string {
+	var msgs []string
+	for i, err := range e {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%d: %s", i, err))
+		}
+	}
+	return fmt.Sprintf("%d of %d operations failed: %s", len(msgs), len(e), strings.Join(msgs, "; "))
+}
+
+// StatusCodesError returns a MultiError with the bad status codes of the
+// operations or nil if none of them is bad.
+func StatusCodesError(codes []StatusCode) error {
+	var failed bool
+	errs := make(MultiError, len(codes))
+	for i, code := range codes {
+		if code.IsBad() {
+			errs[i] = code
+			failed = true
+		}
+	}
+	if !failed {
+		return nil
+	}
+	return errs
+}
+
+// DataValuesError returns a MultiError with the bad status codes of the
+// data values or nil if none of them is bad.
+func DataValuesError(values []*DataValue) error {
+	codes := make([]StatusCode, len(values))
+	for i, v := range values {
+		if v != nil && v.Has(DataValueStatus) {
+			codes[i] = StatusCode(v.Status)
+		}
+	}
+	return StatusCodesError(codes)
+}
+
+// BrowseResultsError returns a MultiError with the bad status codes of the
+// browse results or nil if none of them is bad.
+func BrowseResultsError(results []*BrowseResult) error {
+	codes := make([]StatusCode, len(results))
+	for i, r := range results {
+		if r != nil {
+			codes[i] = r.StatusCode
+		}
+	}
+	return StatusCodesError(codes)
+}
+
+// BrowsePathResultsError returns a MultiError with the bad status codes
+// of the browse path results or nil if none of them is bad.
+func BrowsePathResultsError(results []*BrowsePathResult) error {
+	codes := make([]StatusCode, len(results))
+	for i, r := range results {
+		if r != nil {
+			codes[i] = r.StatusCode
+		}
+	}
+	return StatusCodesError(codes)
+}
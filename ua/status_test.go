@@ -0,0 +1,89 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ua
+
+import (
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestStatusCodeSeverity(t *testing.T) {
+	cases := []struct {
+		code                 StatusCode
+		good, uncertain, bad bool
+	}{
+		{StatusOK, true, false, false},
+		{StatusGoodMoreData, true, false, false},
+		{StatusUncertainDataSubNormal, false, true, false},
+		{StatusBadTimeout, false, false, true},
+		{StatusBadTimeout | 0x0400, false, false, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.code.Error(), func(t *testing.T) {
+			if got, want := c.code.IsGood(), c.good; got != want {
+				t.Fatalf("IsGood: got %v want %v", got, want)
+			}
+			if got, want := c.code.IsUncertain(), c.uncertain; got != want {
+				t.Fatalf("IsUncertain: got %v want %v", got, want)
+			}
+			if got, want := c.code.IsBad(), c.bad; got != want {
+				t.Fatalf("IsBad: got %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestStatusCodesError(t *testing.T) {
+	cases := []struct {
+		name  string
+		codes []StatusCode
+		err   error
+	}{
+		{"empty", nil, nil},
+		{"good", []StatusCode{StatusOK, StatusUncertainDataSubNormal}, nil},
+		{
+			"bad",
+			[]StatusCode{StatusOK, StatusBadNodeIDUnknown, StatusUncertainDataSubNormal, StatusBadTimeout},
+			MultiError{nil, StatusBadNodeIDUnknown, nil, StatusBadTimeout},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			verify.Values(t, "", StatusCodesError(c.codes), c.err)
+		})
+	}
+}
+
+func TestDataValuesError(t *testing.T) {
+	values := []*DataValue{
+		{EncodingMask: DataValueValue, Value: MustVariant(int32(1))},
+		{EncodingMask: DataValueStatus, Status: uint32(StatusBadNodeIDUnknown)},
+		nil,
+		// the status is ignored if it is not flagged in the encoding mask
+		{Status: uint32(StatusBadTimeout)},
+	}
+	verify.Values(t, "", DataValuesError(values), MultiError{nil, StatusBadNodeIDUnknown, nil, nil})
+	verify.Values(t, "", DataValuesError(values[:1]), nil)
+}
+
+func TestBrowseResultsError(t *testing.T) {
+	results := []*BrowseResult{
+		{StatusCode: StatusOK},
+		{StatusCode: StatusBadNodeIDUnknown},
+	}
+	verify.Values(t, "", BrowseResultsError(results), MultiError{nil, StatusBadNodeIDUnknown})
+	verify.Values(t, "", BrowseResultsError(results[:1]), nil)
+}
+
+func TestMultiError(t *testing.T) {
+	err := MultiError{nil, StatusBadNodeIDUnknown, nil, StatusBadTimeout}
+	want := "2 of 4 operations failed: 1: " + StatusBadNodeIDUnknown.Error() + "; 3: " + StatusBadTimeout.Error()
+	if got := err.Error(); got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
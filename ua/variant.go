@@ -6,6 +6,7 @@ package ua
 
 import (
 	"fmt"
+	"reflect"
 	"time"
 )
 
@@ -81,6 +82,14 @@ func (m *Variant) Decode(b []byte) (int, error) {
 	if m.Has(VariantArrayValues) {
 		m.ArrayLength = buf.ReadInt32()
 		elems = int(m.ArrayLength)
+		if elems < 0 {
+			// null array
+			elems = 0
+		}
+		// every element has at least one byte
+		if elems > buf.Len() {
+			return buf.Pos(), StatusBadEncodingLimitsExceeded
+		}
 	}
 
 	values := make([]interface{}, elems)
@@ -168,14 +177,32 @@ func (m *Variant) Decode(b []byte) (int, error) {
 		}
 	}
 
-	m.Value = values
-	if elems == 1 {
+	if m.Has(VariantArrayValues) {
+		m.Value = variantArray(m.TypeID(), values)
+	} else {
 		m.Value = values[0]
 	}
 
 	return buf.Pos(), buf.Error()
 }
 
+// variantArray returns the values as a slice of the Go type of the
+// built-in type, e.g. []int32. Values of unknown types are returned as
+// []interface{}.
+func variantArray(typeID byte, values []interface{}) interface{} {
+	t, ok := variantTypes[typeID]
+	if !ok {
+		return values
+	}
+	a := reflect.MakeSlice(reflect.SliceOf(t), len(values), len(values))
+	for i, v := range values {
+		if rv := reflect.ValueOf(v); rv.IsValid() && rv.Type() == t {
+			a.Index(i).Set(rv)
+		}
+	}
+	return a.Interface()
+}
+
 func (m *Variant) Encode() ([]byte, error) {
 	buf := NewBuffer(nil)
 
@@ -183,9 +210,28 @@ func (m *Variant) Encode() ([]byte, error) {
 
 	if m.Has(VariantArrayValues) {
 		buf.WriteInt32(m.ArrayLength)
+		if a := reflect.ValueOf(m.Value); a.Kind() == reflect.Slice {
+			for i := 0; i < a.Len(); i++ {
+				encodeVariantValue(buf, a.Index(i).Interface())
+			}
+		}
+	} else {
+		encodeVariantValue(buf, m.Value)
+	}
+
+	if m.Has(VariantArrayDimensions) {
+		buf.WriteInt32(m.ArrayDimensionsLength)
+		for i := 0; i < int(m.ArrayDimensionsLength); i++ {
+			buf.WriteInt32(m.ArrayDimensions[i])
+		}
 	}
 
-	switch v := m.Value.(type) {
+	return buf.Bytes(), buf.Error()
+}
+
+// encodeVariantValue encodes a single value of a built-in type.
+func encodeVariantValue(buf *Buffer, v interface{}) {
+	switch v := v.(type) {
 	case bool:
 		buf.WriteBool(v)
 	case int8:
@@ -237,74 +283,141 @@ func (m *Variant) Encode() ([]byte, error) {
 	case *DiagnosticInfo:
 		buf.WriteStruct(v)
 	}
+}
 
-	if m.Has(VariantArrayDimensions) {
-		buf.WriteInt32(m.ArrayDimensionsLength)
-		for i := 0; i < int(m.ArrayDimensionsLength); i++ {
-			buf.WriteInt32(m.ArrayDimensions[i])
+// variantTypes maps the built-in type ids to the Go types of their values.
+var variantTypes = map[byte]reflect.Type{
+	TypeBoolean:         reflect.TypeOf(false),
+	TypeSByte:           reflect.TypeOf(int8(0)),
+	TypeByte:            reflect.TypeOf(byte(0)),
+	TypeInt16:           reflect.TypeOf(int16(0)),
+	TypeUint16:          reflect.TypeOf(uint16(0)),
+	TypeInt32:           reflect.TypeOf(int32(0)),
+	TypeUint32:          reflect.TypeOf(uint32(0)),
+	TypeInt64:           reflect.TypeOf(int64(0)),
+	TypeUint64:          reflect.TypeOf(uint64(0)),
+	TypeFloat:           reflect.TypeOf(float32(0)),
+	TypeDouble:          reflect.TypeOf(float64(0)),
+	TypeString:          reflect.TypeOf(""),
+	TypeDateTime:        reflect.TypeOf(time.Time{}),
+	TypeGuid:            reflect.TypeOf(&GUID{}),
+	TypeByteString:      reflect.TypeOf([]byte{}),
+	TypeXmlElement:      reflect.TypeOf(XmlElement("")),
+	TypeNodeId:          reflect.TypeOf(&NodeID{}),
+	TypeExpandedNodeId:  reflect.TypeOf(&ExpandedNodeID{}),
+	TypeStatusCode:      reflect.TypeOf(StatusCode(0)),
+	TypeQualifiedName:   reflect.TypeOf(&QualifiedName{}),
+	TypeLocalizedText:   reflect.TypeOf(&LocalizedText{}),
+	TypeExtensionObject: reflect.TypeOf(&ExtensionObject{}),
+	TypeDataValue:       reflect.TypeOf(&DataValue{}),
+	TypeVariant:         reflect.TypeOf(&Variant{}),
+	TypeDiagnosticInfo:  reflect.TypeOf(&DiagnosticInfo{}),
+}
+
+// variantTypeIDs is the inverse of variantTypes.
+var variantTypeIDs = func() map[reflect.Type]byte {
+	m := make(map[reflect.Type]byte, len(variantTypes))
+	for id, t := range variantTypes {
+		m[t] = id
+	}
+	return m
+}()
+
+// Set sets the value of the variant which must be one of the Go types of
+// the built-in types, e.g. int32 or *LocalizedText, or a slice of one of
+// them for an array, e.g. []int32. []byte is a ByteString and not an
+// array.
+func (m *Variant) Set(v interface{}) error {
+	t := reflect.TypeOf(v)
+	if id, ok := variantTypeIDs[t]; ok {
+		m.EncodingMask = id
+		m.ArrayLength = 0
+		m.Value = v
+		return nil
+	}
+	if t != nil && t.Kind() == reflect.Slice {
+		if id, ok := variantTypeIDs[t.Elem()]; ok {
+			m.EncodingMask = id | VariantArrayValues
+			m.ArrayLength = int32(reflect.ValueOf(v).Len())
+			m.Value = v
+			return nil
 		}
 	}
+	return fmt.Errorf("opcua: cannot set variant to %T", v)
+}
 
-	return buf.Bytes(), buf.Error()
+// NewVariantAs returns a variant of the built-in type with the value
+// converted from v. Numbers are converted if the value fits into the
+// type, e.g. an int to an Int32, and strings to strings. A slice is
+// converted to an array of the type unless it is the []byte of a
+// ByteString. A *Variant is returned unchanged.
+func NewVariantAs(typeID byte, v interface{}) (*Variant, error) {
+	if va, ok := v.(*Variant); ok {
+		return va, nil
+	}
+	t, ok := variantTypes[typeID]
+	if !ok {
+		return nil, fmt.Errorf("opcua: invalid built-in type %d", typeID)
+	}
+
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil, fmt.Errorf("opcua: cannot convert nil to %s", t)
+	}
+	if rv.Kind() == reflect.Slice && rv.Type() != t {
+		a := reflect.MakeSlice(reflect.SliceOf(t), rv.Len(), rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			x, err := convertValue(rv.Index(i), t)
+			if err != nil {
+				return nil, err
+			}
+			a.Index(i).Set(x)
+		}
+		return NewVariant(a.Interface())
+	}
+
+	x, err := convertValue(rv, t)
+	if err != nil {
+		return nil, err
+	}
+	return NewVariant(x.Interface())
 }
 
-func (m *Variant) Set(v interface{}) error {
-	switch v.(type) {
-	case bool:
-		m.EncodingMask = TypeBoolean
-	case int8:
-		m.EncodingMask = TypeSByte
-	case byte:
-		m.EncodingMask = TypeByte
-	case int16:
-		m.EncodingMask = TypeInt16
-	case uint16:
-		m.EncodingMask = TypeUint16
-	case int32:
-		m.EncodingMask = TypeInt32
-	case uint32:
-		m.EncodingMask = TypeUint32
-	case int64:
-		m.EncodingMask = TypeInt64
-	case uint64:
-		m.EncodingMask = TypeUint64
-	case float32:
-		m.EncodingMask = TypeFloat
-	case float64:
-		m.EncodingMask = TypeDouble
-	case string:
-		m.EncodingMask = TypeString
-	case time.Time:
-		m.EncodingMask = TypeDateTime
-	case *GUID:
-		m.EncodingMask = TypeGuid
-	case []byte:
-		m.EncodingMask = TypeByteString
-	case XmlElement:
-		m.EncodingMask = TypeXmlElement
-	case *NodeID:
-		m.EncodingMask = TypeNodeId
-	case *ExpandedNodeID:
-		m.EncodingMask = TypeExpandedNodeId
-	case StatusCode:
-		m.EncodingMask = TypeStatusCode
-	case *QualifiedName:
-		m.EncodingMask = TypeQualifiedName
-	case *LocalizedText:
-		m.EncodingMask = TypeLocalizedText
-	case *ExtensionObject:
-		m.EncodingMask = TypeExtensionObject
-	case *DataValue:
-		m.EncodingMask = TypeDataValue
-	case *Variant:
-		m.EncodingMask = TypeVariant
-	case *DiagnosticInfo:
-		m.EncodingMask = TypeDiagnosticInfo
+// convertValue converts v to the type t if this is possible without
+// loss.
+func convertValue(v reflect.Value, t reflect.Type) (reflect.Value, error) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return v, fmt.Errorf("opcua: cannot convert nil to %s", t)
+	}
+	if v.Type() == t {
+		return v, nil
+	}
+	switch {
+	case isNumber(v.Kind()) && isNumber(t.Kind()):
+		x := v.Convert(t)
+		if x.Convert(v.Type()).Interface() != v.Interface() {
+			return v, fmt.Errorf("opcua: %v does not fit into %s", v, t)
+		}
+		return x, nil
+	case v.Kind() == t.Kind() && (t.Kind() == reflect.String || t.Kind() == reflect.Bool):
+		return v.Convert(t), nil
 	default:
-		return fmt.Errorf("opcua: cannot set variant to %T", v)
+		return v, fmt.Errorf("opcua: cannot convert %s to %s", v.Type(), t)
+	}
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
 	}
-	m.Value = v
-	return nil
 }
 
 func (m *Variant) String() string {
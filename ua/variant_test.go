@@ -7,6 +7,8 @@ package ua
 import (
 	"testing"
 	"time"
+
+	"github.com/pascaldekloe/goe/verify"
 )
 
 func TestVariant(t *testing.T) {
@@ -306,6 +308,108 @@ func TestVariant(t *testing.T) {
 				0x01, 0x01, 0x00, 0x00, 0x00,
 			},
 		},
+		{
+			Name:   "[]int32",
+			Struct: MustVariant([]int32{1, -1}),
+			Bytes: []byte{
+				// variant encoding mask
+				0x86,
+				// array length
+				0x02, 0x00, 0x00, 0x00,
+				// values
+				0x01, 0x00, 0x00, 0x00,
+				0xff, 0xff, 0xff, 0xff,
+			},
+		},
+		{
+			Name:   "[]string with one element",
+			Struct: MustVariant([]string{"abc"}),
+			Bytes: []byte{
+				// variant encoding mask
+				0x8c,
+				// array length
+				0x01, 0x00, 0x00, 0x00,
+				// value
+				0x03, 0x00, 0x00, 0x00,
+				'a', 'b', 'c',
+			},
+		},
+		{
+			Name:   "[]*LocalizedText",
+			Struct: MustVariant([]*LocalizedText{{EncodingMask: LocalizedTextText, Text: "a"}}),
+			Bytes: []byte{
+				// variant encoding mask
+				0x95,
+				// array length
+				0x01, 0x00, 0x00, 0x00,
+				// value
+				0x02,
+				0x01, 0x00, 0x00, 0x00,
+				'a',
+			},
+		},
 	}
 	RunCodecTest(t, cases)
 }
+
+func TestVariantArrayErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		b    []byte
+	}{
+		{"too long", []byte{0x86, 0xff, 0xff, 0xff, 0x7f, 0x01}},
+		{"truncated", []byte{0x86, 0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := Decode(c.b, new(Variant)); err == nil {
+				t.Fatal("got nil want error")
+			}
+		})
+	}
+
+	if _, err := NewVariant([]struct{}{}); err == nil {
+		t.Fatal("got nil want error for unsupported array")
+	}
+}
+
+func TestNewVariantAs(t *testing.T) {
+	cases := []struct {
+		name   string
+		typeID byte
+		v      interface{}
+		want   *Variant
+		err    bool
+	}{
+		{name: "same type", typeID: TypeInt32, v: int32(5), want: MustVariant(int32(5))},
+		{name: "int to int16", typeID: TypeInt16, v: -5, want: MustVariant(int16(-5))},
+		{name: "int to double", typeID: TypeDouble, v: 5, want: MustVariant(float64(5))},
+		{name: "float to float", typeID: TypeFloat, v: 1.5, want: MustVariant(float32(1.5))},
+		{name: "string to xml", typeID: TypeXmlElement, v: "<a/>", want: MustVariant(XmlElement("<a/>"))},
+		{name: "byte string", typeID: TypeByteString, v: []byte{1}, want: MustVariant([]byte{1})},
+		{name: "array", typeID: TypeUint16, v: []int{1, 2}, want: MustVariant([]uint16{1, 2})},
+		{name: "interface array", typeID: TypeString, v: []interface{}{"a", "b"}, want: MustVariant([]string{"a", "b"})},
+		{name: "variant", typeID: TypeInt32, v: MustVariant("a"), want: MustVariant("a")},
+		{name: "overflow", typeID: TypeByte, v: 256, err: true},
+		{name: "negative unsigned", typeID: TypeUint32, v: -1, err: true},
+		{name: "fraction", typeID: TypeInt32, v: 1.5, err: true},
+		{name: "string to int", typeID: TypeInt32, v: "1", err: true},
+		{name: "nil", typeID: TypeInt32, v: nil, err: true},
+		{name: "invalid type", typeID: 0, v: 1, err: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := NewVariantAs(c.typeID, c.v)
+			if c.err {
+				if err == nil {
+					t.Fatalf("got %#v want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			verify.Values(t, "", got, c.want)
+		})
+	}
+}
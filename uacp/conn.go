@@ -4,11 +4,11 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"sync/atomic"
 	"time"
 
+	"github.com/gopcua/opcua/logger"
 	"github.com/gopcua/opcua/ua"
 	"github.com/gopcua/opcua/utils"
 )
@@ -31,8 +31,23 @@ func nextid() uint32 {
 	return atomic.AddUint32(&connid, 1)
 }
 
+// Dial connects to the endpoint and performs the HEL/ACK handshake.
+// It does not log anything.
 func Dial(ctx context.Context, endpoint string) (*Conn, error) {
-	log.Printf("Connect to %s", endpoint)
+	var d Dialer
+	return d.Dial(ctx, endpoint)
+}
+
+// Dialer contains the options for connecting to an endpoint.
+type Dialer struct {
+	// Logger is the logger of the connection. If nil nothing is logged.
+	Logger logger.Logger
+}
+
+// Dial connects to the endpoint and performs the HEL/ACK handshake.
+func (d *Dialer) Dial(ctx context.Context, endpoint string) (*Conn, error) {
+	lg := logger.Or(d.Logger)
+	lg.Info("uacp: connect", "endpoint", endpoint)
 	network, raddr, err := utils.ResolveEndpoint(endpoint)
 	if err != nil {
 		return nil, err
@@ -43,8 +58,9 @@ func Dial(ctx context.Context, endpoint string) (*Conn, error) {
 	}
 
 	conn := &Conn{
-		id: nextid(),
-		c:  c,
+		id:  nextid(),
+		c:   c,
+		log: lg,
 		ack: &Acknowledge{
 			ReceiveBufSize: DefaultReceiveBufSize,
 			SendBufSize:    DefaultSendBufSize,
@@ -53,9 +69,9 @@ func Dial(ctx context.Context, endpoint string) (*Conn, error) {
 		},
 	}
 
-	log.Printf("conn %d: start HEL/ACK handshake", conn.id)
+	lg.Debug("uacp: start HEL/ACK handshake", "conn", conn.id)
 	if err := conn.handshake(endpoint); err != nil {
-		log.Printf("conn %d: HEL/ACK handshake failed: %s", conn.id, err)
+		lg.Warn("uacp: HEL/ACK handshake failed", "conn", conn.id, "err", err)
 		conn.Close()
 		return nil, err
 	}
@@ -67,6 +83,7 @@ type Listener struct {
 	l        net.Listener
 	ack      *Acknowledge
 	endpoint string
+	log      logger.Logger
 }
 
 // Listen acts like net.Listen for OPC UA Connection Protocol networks.
@@ -98,6 +115,7 @@ func Listen(endpoint string, ack *Acknowledge) (*Listener, error) {
 		l:        l,
 		ack:      ack,
 		endpoint: endpoint,
+		log:      logger.Nop,
 	}, nil
 }
 
@@ -110,7 +128,7 @@ func (l *Listener) Accept(ctx context.Context) (*Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	conn := &Conn{nextid(), c, l.ack}
+	conn := &Conn{id: nextid(), c: c, ack: l.ack, log: l.log}
 	if err := conn.srvhandshake(l.endpoint); err != nil {
 		c.Close()
 		return nil, err
@@ -118,6 +136,12 @@ func (l *Listener) Accept(ctx context.Context) (*Conn, error) {
 	return conn, nil
 }
 
+// SetLogger sets the logger of the listener and the accepted
+// connections. If lg is nil nothing is logged.
+func (l *Listener) SetLogger(lg logger.Logger) {
+	l.log = logger.Or(lg)
+}
+
 // Close closes the Listener.
 func (l *Listener) Close() error {
 	return l.l.Close()
@@ -137,12 +161,24 @@ type Conn struct {
 	id  uint32
 	c   net.Conn
 	ack *Acknowledge
+	log logger.Logger
 }
 
 func (c *Conn) ID() uint32 {
 	return c.id
 }
 
+// Logger returns the logger of the connection.
+func (c *Conn) Logger() logger.Logger {
+	return c.log
+}
+
+// SetLogger sets the logger of the connection. If lg is nil
+// nothing is logged.
+func (c *Conn) SetLogger(lg logger.Logger) {
+	c.log = logger.Or(lg)
+}
+
 func (c *Conn) ReceiveBufSize() uint32 {
 	return c.ack.ReceiveBufSize
 }
@@ -160,7 +196,7 @@ func (c *Conn) MaxChunkCount() uint32 {
 }
 
 func (c *Conn) Close() error {
-	log.Printf("conn %d: close", c.id)
+	c.log.Debug("uacp: close", "conn", c.id)
 	return c.c.Close()
 }
 
@@ -212,7 +248,11 @@ func (c *Conn) handshake(endpoint string) error {
 	}
 
 	msgtyp := string(b[:4])
-	if msgtyp != "ACKF" {
+	switch msgtyp {
+	case "ACKF":
+	case "ERRF":
+		return DecodeError(b[hdrlen:])
+	default:
 		return fmt.Errorf("got %s want ACK", msgtyp)
 	}
 
@@ -226,14 +266,22 @@ func (c *Conn) handshake(endpoint string) error {
 	}
 	if ack.MaxChunkCount == 0 {
 		ack.MaxChunkCount = DefaultMaxChunkCount
-		log.Printf("conn %d: server has no chunk limit. Using %d", c.id, ack.MaxChunkCount)
+		c.log.Debug("uacp: server has no chunk limit", "conn", c.id, "max_chunk_count", ack.MaxChunkCount)
 	}
 	if ack.MaxMessageSize == 0 {
 		ack.MaxMessageSize = DefaultMaxMessageSize
-		log.Printf("conn %d: server has no message size limit. Using %d", c.id, ack.MaxMessageSize)
+		c.log.Debug("uacp: server has no message size limit", "conn", c.id, "max_message_size", ack.MaxMessageSize)
 	}
+
+	// the buffer sizes in the ACK are from the point of view of the server.
+	// We can send what the server can receive and vice versa.
+	ack.ReceiveBufSize, ack.SendBufSize = min(c.ack.ReceiveBufSize, ack.SendBufSize), min(c.ack.SendBufSize, ack.ReceiveBufSize)
 	c.ack = ack
-	log.Printf("conn %d: recv ACK:%v", c.id, ack)
+	c.log.Debug("uacp: recv ACK", "conn", c.id,
+		"receive_buf_size", ack.ReceiveBufSize,
+		"send_buf_size", ack.SendBufSize,
+		"max_message_size", ack.MaxMessageSize,
+		"max_chunk_count", ack.MaxChunkCount)
 	return nil
 }
 
@@ -258,6 +306,12 @@ func (c *Conn) srvhandshake(endpoint string) error {
 			c.sendError(BadTCPEndpointURLInvalid)
 			return fmt.Errorf("invalid endpoint url %s", hel.EndPointURL)
 		}
+
+		// revise the buffer sizes for this connection
+		ack := *c.ack
+		ack.ReceiveBufSize = min(ack.ReceiveBufSize, hel.SendBufSize)
+		ack.SendBufSize = min(ack.SendBufSize, hel.ReceiveBufSize)
+		c.ack = &ack
 		if err := c.send("ACKF", c.ack); err != nil {
 			c.sendError(BadTCPInternalError)
 			return err
@@ -274,21 +328,31 @@ func (c *Conn) srvhandshake(endpoint string) error {
 			c.sendError(BadTCPEndpointURLInvalid)
 			return fmt.Errorf("invalid endpoint url %s", rhe.EndPointURL)
 		}
-		log.Printf("conn %d: connecting to %s", c.id, rhe.ServerURI)
+		c.log.Info("uacp: connecting to reverse hello server", "conn", c.id, "server_uri", rhe.ServerURI)
 		c.c.Close()
-		c, err := Dial(context.Background(), rhe.ServerURI)
+		d := &Dialer{Logger: c.log}
+		c, err := d.Dial(context.Background(), rhe.ServerURI)
 		if err != nil {
 			return err
 		}
 		c.c = c
 		return nil
 
+	case "ERRF":
+		return DecodeError(msg)
+
 	default:
 		c.sendError(BadTCPInternalError)
 		return fmt.Errorf("invalid handshake packet %q", msgtyp)
 	}
 }
 
+// SendError sends an ERR message with the status code and the reason to
+// the remote end. The caller must close the connection afterwards.
+func (c *Conn) SendError(code ua.StatusCode, reason string) error {
+	return c.send("ERRF", &Error{Error: uint32(code), Reason: reason})
+}
+
 func (c *Conn) sendError(code uint32) {
 	// we swallow the error to silence complaints from the linter
 	// since sending an error will close the connection and we
@@ -321,7 +385,7 @@ func (c *Conn) recv() ([]byte, error) {
 		return nil, fmt.Errorf("read msg failed: %s", err)
 	}
 
-	log.Printf("conn %d: recv %s%c with %d bytes", c.id, h.MessageType, h.ChunkType, len(b))
+	c.log.Debug("uacp: recv", "conn", c.id, "type", h.MessageType+string(h.ChunkType), "size", len(b))
 	return append(hdr, b...), nil
 }
 
@@ -354,7 +418,14 @@ func (c *Conn) send(typ string, msg interface{}) error {
 	if _, err := c.c.Write(b); err != nil {
 		return fmt.Errorf("write failed: %s", err)
 	}
-	log.Printf("conn %d: sent %s with %d bytes", c.id, typ, len(b))
+	c.log.Debug("uacp: send", "conn", c.id, "type", typ, "size", len(b))
 
 	return nil
 }
+
+func min(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -6,9 +6,12 @@ package uacp
 
 import (
 	"context"
+	"net"
 	"testing"
 	"time"
 
+	"github.com/gopcua/opcua/logger"
+
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -153,3 +156,30 @@ NEXT:
 		t.Error(diff)
 	}
 }
+
+func TestDialError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:48402")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := &Conn{id: nextid(), c: nc, ack: &Acknowledge{ReceiveBufSize: DefaultReceiveBufSize, SendBufSize: DefaultSendBufSize}, log: logger.Nop}
+		defer c.Close()
+		if _, err := c.recv(); err != nil {
+			return
+		}
+		c.send("ERRF", NewError(BadTCPEndpointURLInvalid, "unknown endpoint"))
+	}()
+
+	_, err = Dial(context.Background(), "opc.tcp://127.0.0.1:48402/foo/bar")
+	want := &ProtocolError{Code: BadTCPEndpointURLInvalid, Reason: "unknown endpoint"}
+	if diff := cmp.Diff(err, error(want)); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -6,6 +6,8 @@ package uacp
 
 import (
 	"fmt"
+
+	"github.com/gopcua/opcua/ua"
 )
 
 // Error definitions.
@@ -34,6 +36,9 @@ const (
 	BadCertificateIssuerRevocationUnknown        = 0x801c0000
 	BadCertificateRevoked                        = 0x801d0000
 	BadCertificateIssuerRevoked                  = 0x801e0000
+	BadCertificateInvalid                        = 0x80120000
+	BadCertificateURIInvalid                     = 0x80170000
+	BadCertificateChainIncomplete                = 0x810d0000
 	//BadCertificateUnknown = N/A
 )
 
@@ -61,3 +66,34 @@ func (e *Error) String() string {
 		e.Reason,
 	)
 }
+
+// Err returns the error reported by the ERR message.
+func (e *Error) Err() error {
+	return &ProtocolError{Code: ua.StatusCode(e.Error), Reason: e.Reason}
+}
+
+// ProtocolError is returned when the remote end reports an error with an
+// ERR message or aborts a message with an abort chunk. Code is one of the
+// error codes defined above. It is also returned for local errors which
+// are reported to the remote end, e.g. an invalid certificate.
+type ProtocolError struct {
+	Code   ua.StatusCode
+	Reason string
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Reason == "" {
+		return e.Code.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Code.Error(), e.Reason)
+}
+
+// DecodeError decodes the body of an ERR message or an abort chunk and
+// returns the reported error as *ProtocolError.
+func DecodeError(b []byte) error {
+	e := new(Error)
+	if _, err := ua.Decode(b, e); err != nil {
+		return fmt.Errorf("decode ERR failed: %s", err)
+	}
+	return e.Err()
+}
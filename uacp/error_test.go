@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"github.com/gopcua/opcua/ua"
+
+	"github.com/pascaldekloe/goe/verify"
 )
 
 func TestError(t *testing.T) {
@@ -27,3 +29,18 @@ func TestError(t *testing.T) {
 	}
 	ua.RunCodecTest(t, cases)
 }
+
+func TestDecodeError(t *testing.T) {
+	b := []byte{
+		// Error: BadSecureChannelClosed
+		0x00, 0x00, 0x86, 0x80,
+		// Reason: dummy
+		0x06, 0x00, 0x00, 0x00, 0x66, 0x6f, 0x6f, 0x62, 0x61, 0x72,
+	}
+	err := DecodeError(b)
+	want := &ProtocolError{Code: ua.StatusBadSecureChannelClosed, Reason: "foobar"}
+	verify.Values(t, "", err, want)
+	if got, want := err.Error(), ua.StatusBadSecureChannelClosed.Error()+": foobar"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
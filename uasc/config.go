@@ -6,9 +6,9 @@ package uasc
 
 import (
 	"crypto/rand"
-	"encoding/binary"
-	"time"
+	"crypto/rsa"
 
+	"github.com/gopcua/opcua/logger"
 	"github.com/gopcua/opcua/ua"
 
 	"github.com/pkg/errors"
@@ -47,8 +47,35 @@ type Config struct {
 	// Certificate.
 	// This indicates what public key was used to encrypt the MessageChunk.
 	// This field shall be null if the Message is not encrypted.
+	// If it is not set it is computed from the RemoteCertificate.
 	Thumbprint []byte
 
+	// LocalKey is the RSA private key of the Certificate. It is used to sign
+	// and decrypt the asymmetrically secured OpenSecureChannel messages.
+	// This field shall be nil if the SecurityPolicy is None.
+	LocalKey *rsa.PrivateKey
+
+	// RemoteCertificate is the DER encoded X.509 v3 Certificate of the
+	// remote application Instance. Its public key is used to encrypt and
+	// to verify the signature of the asymmetrically secured OpenSecureChannel
+	// messages. A client gets it from the ServerCertificate field of the
+	// EndpointDescription. A server takes it from the SenderCertificate of
+	// the OpenSecureChannel request.
+	RemoteCertificate []byte
+
+	// VerifyCertificate validates the RemoteCertificate before the
+	// secure channel is opened with security, e.g. with pki.Store.Verify.
+	// If it returns a *uacp.ProtocolError the server reports the status
+	// code to the client. If it is nil the certificate is not validated.
+	VerifyCertificate func(cert []byte) error
+
+	// VerifyApplicationURI validates the certificate of the remote
+	// application when a session is created and checks that it contains
+	// the ApplicationURI of the remote application in the subject
+	// alternative name, e.g. with pki.Store.Validate. If it is nil the
+	// application uri is not checked.
+	VerifyApplicationURI func(cert []byte, appURI string) error
+
 	// SequenceNumber is a monotonically increasing sequence number assigned by the sender to each
 	// MessageChunk sent over the SecureChannel.
 	SequenceNumber uint32
@@ -76,6 +103,10 @@ type Config struct {
 	// Lifetime can also be the revised lifetime, the lifetime of the SecurityToken in milliseconds.
 	// The UTC expiration time for the token may be calculated by adding the lifetime to the createdAt time.
 	Lifetime uint32
+
+	// Logger is the logger of the secure channel. If it is nil the logger
+	// of the underlying connection is used.
+	Logger logger.Logger
 }
 
 // // NewConfig creates a new Config.
@@ -122,80 +153,54 @@ func NewClientConfigSecurityNone(reqID, lifetime uint32) *Config {
 	}
 }
 
-/* XXX - to be uncommented when encryption is
 // NewClientConfigSignBasic256Sha256 creates a new Config for Client, with SecurityMode=Sign
 // and SecurityPolicy=Basic256Sha256.
-func NewClientConfigSignBasic256Sha256(cert, thumbprint []byte, reqID, lifetime uint32) *Config {
-	return NewClientConfig(
+func NewClientConfigSignBasic256Sha256(cert []byte, key *rsa.PrivateKey, remoteCert []byte, reqID, lifetime uint32) *Config {
+	c := NewClientConfig(
 		"http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256",
-		cert, thumbprint, reqID, ua.MessageSecurityModeSign, lifetime,
+		cert, nil, reqID, ua.MessageSecurityModeSign, lifetime,
 	)
+	c.LocalKey = key
+	c.RemoteCertificate = remoteCert
+	return c
 }
 
 // NewClientConfigSignAndEncryptBasic256Sha256 creates a new Config for Client, with SecurityMode=SignAndEncrypt
 // and SecurityPolicy=Basic256Sha256.
-func NewClientConfigSignAndEncryptBasic256Sha256(cert, thumbprint []byte, reqID, lifetime uint32) *Config {
-	return NewClientConfig(
+func NewClientConfigSignAndEncryptBasic256Sha256(cert []byte, key *rsa.PrivateKey, remoteCert []byte, reqID, lifetime uint32) *Config {
+	c := NewClientConfig(
 		"http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256",
-		cert, thumbprint, reqID, ua.MessageSecurityModeSignAndEncrypt, lifetime,
+		cert, nil, reqID, ua.MessageSecurityModeSignAndEncrypt, lifetime,
 	)
+	c.LocalKey = key
+	c.RemoteCertificate = remoteCert
+	return c
 }
 
 // NewClientConfigSignAes128Sha256RsaOaep creates a new Config for Client, with SecurityMode=Sign
 // and SecurityPolicy=Aes128_Sha256_RsaOaep.
-func NewClientConfigSignAes128Sha256RsaOaep(cert, thumbprint []byte, reqID, lifetime uint32) *Config {
-	return NewClientConfig(
+func NewClientConfigSignAes128Sha256RsaOaep(cert []byte, key *rsa.PrivateKey, remoteCert []byte, reqID, lifetime uint32) *Config {
+	c := NewClientConfig(
 		"http://opcfoundation.org/UA/SecurityPolicy#Aes128_Sha256_RsaOaep",
-		cert, thumbprint, reqID, ua.MessageSecurityModeSign, lifetime,
+		cert, nil, reqID, ua.MessageSecurityModeSign, lifetime,
 	)
+	c.LocalKey = key
+	c.RemoteCertificate = remoteCert
+	return c
 }
 
 // NewClientConfigSignAndEncryptAes128Sha256RsaOaep creates a new Config for Client, with SecurityMode=SignAndEncrypt
 // and SecurityPolicy=Aes128_Sha256_RsaOaep.
-func NewClientConfigSignAndEncryptAes128Sha256RsaOaep(cert, thumbprint []byte, reqID, lifetime uint32) *Config {
-	return NewClientConfig(
+func NewClientConfigSignAndEncryptAes128Sha256RsaOaep(cert []byte, key *rsa.PrivateKey, remoteCert []byte, reqID, lifetime uint32) *Config {
+	c := NewClientConfig(
 		"http://opcfoundation.org/UA/SecurityPolicy#Aes128_Sha256_RsaOaep",
-		cert, thumbprint, reqID, ua.MessageSecurityModeSignAndEncrypt, lifetime,
-	)
-}
-
-// NewClientConfigSignPubSubAes128CTR creates a new Config for Client, with SecurityMode=Sign
-// and SecurityPolicy=PubSub_Aes128_CTR.
-func NewClientConfigSignPubSubAes128CTR(cert, thumbprint []byte, reqID, lifetime uint32) *Config {
-	return NewClientConfig(
-		"http://opcfoundation.org/UA/SecurityPolicy#PubSub_Aes128_CTR",
-		cert, thumbprint, reqID, ua.MessageSecurityModeSign, lifetime,
+		cert, nil, reqID, ua.MessageSecurityModeSignAndEncrypt, lifetime,
 	)
+	c.LocalKey = key
+	c.RemoteCertificate = remoteCert
+	return c
 }
 
-// NewClientConfigSignAndEncryptPubSubAes128CTR creates a new Config for Client, with SecurityMode=SignAndEncrypt
-// and SecurityPolicy=PubSub_Aes128_CTR.
-func NewClientConfigSignAndEncryptPubSubAes128CTR(cert, thumbprint []byte, reqID, lifetime uint32) *Config {
-	return NewClientConfig(
-		"http://opcfoundation.org/UA/SecurityPolicy#PubSub_Aes128_CTR",
-		cert, thumbprint, reqID, ua.MessageSecurityModeSignAndEncrypt, lifetime,
-	)
-}
-
-// NewClientConfigSignPubSubAes256CTR creates a new Config for Client, with SecurityMode=Sign
-// and SecurityPolicy=PubSub_Aes256_CTR.
-func NewClientConfigSignPubSubAes256CTR(cert, thumbprint []byte, reqID, lifetime uint32) *Config {
-	return NewClientConfig(
-		"http://opcfoundation.org/UA/SecurityPolicy#PubSub_Aes256_CTR",
-		cert, thumbprint, reqID, ua.MessageSecurityModeSign, lifetime,
-	)
-}
-
-// NewClientConfigSignAndEncryptPubSubAes256CTR creates a new Config for Client, with SecurityMode=SignAndEncrypt
-// and SecurityPolicy=PubSub_Aes256_CTR.
-func NewClientConfigSignAndEncryptPubSubAes256CTR(cert, thumbprint []byte, reqID, lifetime uint32) *Config {
-	return NewClientConfig(
-		"http://opcfoundation.org/UA/SecurityPolicy#PubSub_Aes256_CTR",
-		cert, thumbprint, reqID, ua.MessageSecurityModeSignAndEncrypt, lifetime,
-	)
-}
-*/
-
 // NewServerConfig creates a new Config for Server.
 //
 // With all the parameter given, it is sufficient for server to accept SecureChannel.
@@ -265,6 +270,11 @@ type SessionConfig struct {
 	// parameters shall be verified by the client.
 	ServerEndpoints []*ua.EndpointDescription
 
+	// DiscoveryEndpoints is the list of Endpoints which the Client received from the
+	// GetEndpoints service. If it is set the ServerEndpoints returned by CreateSession
+	// must match it.
+	DiscoveryEndpoints []*ua.EndpointDescription
+
 	// LocaleIDs is the list of locale ids in priority order for localized strings. The first
 	// LocaleId in the list has the highest priority. If the Server returns a localized string
 	// to the Client, the Server shall return the translation with the highest priority that
@@ -289,8 +299,13 @@ type SessionConfig struct {
 	// shall create a signature and pass it as this parameter. Otherwise the parameter is null.
 	// The SignatureAlgorithm depends on the identity token type.
 	// The SignatureData type is defined in 7.32.
+	// The session computes it for an X509IdentityToken with the UserKey.
 	UserTokenSignature *ua.SignatureData
 
+	// UserKey is the RSA private key of the certificate of an X509IdentityToken.
+	// It is used to create the UserTokenSignature.
+	UserKey *rsa.PrivateKey
+
 	// If Session works as a client, SessionTimeout is the requested maximum number of milliseconds
 	// that a Session should remain open without activity. If the Client fails to issue a Service
 	// request within this interval, then the Server shall automatically terminate the Client Session.
@@ -328,28 +343,40 @@ func NewClientSessionConfig(locales []string, userToken interface{}) *SessionCon
 }
 
 // NewServerSessionConfig creates a new SessionConfigServer for server.
-func NewServerSessionConfig(secChan *SecureChannel) *SessionConfig {
-	rawToken := make([]byte, 2)
+// The authentication token is a random 32 byte opaque node id which cannot
+// be guessed by other clients. It fails if no random token can be created.
+func NewServerSessionConfig(secChan *SecureChannel) (*SessionConfig, error) {
+	rawToken := make([]byte, 32)
 	if _, err := rand.Read(rawToken); err != nil {
-		binary.LittleEndian.PutUint16(rawToken, uint16(time.Now().UnixNano()))
+		return nil, err
 	}
 	return &SessionConfig{
-		AuthenticationToken: ua.NewFourByteNodeID(0, binary.LittleEndian.Uint16(rawToken)),
+		AuthenticationToken: ua.NewByteStringNodeID(0, rawToken),
 		SessionTimeout:      0xffff,
-		ServerEndpoints: []*ua.EndpointDescription{
-			&ua.EndpointDescription{
-				EndpointURL: secChan.LocalEndpoint(),
-				Server: &ua.ApplicationDescription{
-					ApplicationURI:  "urn:gopcua:client",
-					ProductURI:      "urn:gopcua",
-					ApplicationName: &ua.LocalizedText{Text: "gopcua - OPC UA implementation in pure Golang"},
-					ApplicationType: ua.ApplicationTypeServer,
-				},
-				ServerCertificate: secChan.cfg.Certificate,
-				SecurityMode:      secChan.cfg.SecurityMode,
-				SecurityPolicyURI: secChan.cfg.SecurityPolicyURI,
-				// UserIdentityTokens: []*ua.UserTokenPolicy{&ua.UserTokenPolicy{}},
+		ServerEndpoints:     ServerEndpoints(secChan),
+	}, nil
+}
+
+// ServerEndpoints returns the endpoints of the server for the secure
+// channel.
+func ServerEndpoints(secChan *SecureChannel) []*ua.EndpointDescription {
+	return []*ua.EndpointDescription{
+		&ua.EndpointDescription{
+			EndpointURL: secChan.LocalEndpoint(),
+			Server: &ua.ApplicationDescription{
+				ApplicationURI:  "urn:gopcua:server",
+				ProductURI:      "urn:gopcua",
+				ApplicationName: &ua.LocalizedText{Text: "gopcua - OPC UA implementation in pure Golang"},
+				ApplicationType: ua.ApplicationTypeServer,
+				DiscoveryURLs:   []string{secChan.LocalEndpoint()},
+			},
+			ServerCertificate: secChan.cfg.Certificate,
+			SecurityMode:      secChan.cfg.SecurityMode,
+			SecurityPolicyURI: secChan.cfg.SecurityPolicyURI,
+			UserIdentityTokens: []*ua.UserTokenPolicy{
+				&ua.UserTokenPolicy{PolicyID: "anonymous", TokenType: ua.UserTokenTypeAnonymous},
 			},
+			TransportProfileURI: "http://opcfoundation.org/UA-Profile/Transport/uatcp-uasc-uabinary",
 		},
 	}
 }
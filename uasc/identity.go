@@ -0,0 +1,183 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package uasc
+
+import (
+	"crypto/rsa"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/gopcua/opcua/securitypolicy"
+	"github.com/gopcua/opcua/ua"
+)
+
+const securityPolicyNone = "http://opcfoundation.org/UA/SecurityPolicy#None"
+
+// identityToken returns the user identity token of the configuration and
+// the user token signature for the ActivateSession request. A token
+// without a policy id gets the id of the matching user token policy of the
+// endpoint. The password of a user name token is encrypted and a
+// certificate token is signed with the security policy of the user token
+// policy and the last server nonce.
+//
+// Specification: Part 4, 7.36
+func (s *Session) identityToken() (interface{}, *ua.SignatureData, error) {
+	tok := s.cfg.UserIdentityToken
+	if tok == nil {
+		tok = &ua.AnonymousIdentityToken{}
+	}
+
+	switch tok := tok.(type) {
+	case *ua.AnonymousIdentityToken:
+		t := *tok
+		if t.PolicyID == "" {
+			// servers may accept anonymous users without a policy id
+			if p, err := s.userTokenPolicy(ua.UserTokenTypeAnonymous, ""); err == nil {
+				t.PolicyID = p.PolicyID
+			}
+		}
+		return &t, s.cfg.UserTokenSignature, nil
+
+	case *ua.UserNameIdentityToken:
+		p, err := s.userTokenPolicy(ua.UserTokenTypeUserName, tok.PolicyID)
+		if err != nil {
+			return nil, nil, err
+		}
+		t := *tok
+		t.PolicyID = p.PolicyID
+		t.Password, t.EncryptionAlgorithm, err = s.encryptPassword(p.SecurityPolicyURI, tok.Password)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &t, s.cfg.UserTokenSignature, nil
+
+	case *ua.X509IdentityToken:
+		p, err := s.userTokenPolicy(ua.UserTokenTypeCertificate, tok.PolicyID)
+		if err != nil {
+			return nil, nil, err
+		}
+		t := *tok
+		t.PolicyID = p.PolicyID
+		sig, err := s.userTokenSignature(p.SecurityPolicyURI)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &t, sig, nil
+
+	default:
+		return tok, s.cfg.UserTokenSignature, nil
+	}
+}
+
+// userTokenPolicy returns the user token policy of the given type of the
+// endpoint which matches the security policy and the security mode of the
+// secure channel. If id is not empty the policy must have this id.
+func (s *Session) userTokenPolicy(tokenType ua.UserTokenType, id string) (*ua.UserTokenPolicy, error) {
+	cfg := s.sechan.cfg
+	for _, ep := range s.cfg.ServerEndpoints {
+		if ep == nil || ep.SecurityPolicyURI != cfg.SecurityPolicyURI || ep.SecurityMode != cfg.SecurityMode {
+			continue
+		}
+		for _, p := range ep.UserIdentityTokens {
+			if p == nil || p.TokenType != tokenType || (id != "" && p.PolicyID != id) {
+				continue
+			}
+			return p, nil
+		}
+	}
+	if id != "" {
+		return nil, fmt.Errorf("session: no user token policy %q for token type %d", id, tokenType)
+	}
+	return nil, fmt.Errorf("session: no user token policy for token type %d", tokenType)
+}
+
+// encryptPassword encrypts the password with the given security policy of
+// a user token policy and returns it together with the URI of the
+// encryption algorithm. An empty policy is the policy of the secure
+// channel. The password is only sent in clear text if the policy is None.
+// If the secure channel is not secured the server certificate has not been
+// verified and the password is not encrypted for it.
+//
+// Specification: Part 4, 7.36.3
+func (s *Session) encryptPassword(policyURI string, password []byte) ([]byte, string, error) {
+	cfg := s.sechan.cfg
+	if policyURI == "" {
+		policyURI = cfg.SecurityPolicyURI
+	}
+	if policyURI == securityPolicyNone {
+		return password, "", nil
+	}
+	if cfg.SecurityPolicyURI == securityPolicyNone || cfg.SecurityMode == ua.MessageSecurityModeNone {
+		return nil, "", fmt.Errorf("session: user token policy %s requires a secure channel", policyURI)
+	}
+
+	cert := s.serverCertificate
+	if len(cert) == 0 {
+		cert = cfg.RemoteCertificate
+	}
+	remoteKey, err := publicKey(cert)
+	if err != nil {
+		return nil, "", err
+	}
+	return encryptSecret(policyURI, cfg.LocalKey, remoteKey, password, s.serverNonce)
+}
+
+// userTokenSignature signs the certificate and the last nonce of the
+// server with the user key and the given security policy of a user token
+// policy. An empty policy is the policy of the secure channel.
+//
+// Specification: Part 4, 5.6.3.2
+func (s *Session) userTokenSignature(policyURI string) (*ua.SignatureData, error) {
+	cfg := s.sechan.cfg
+	if policyURI == "" {
+		policyURI = cfg.SecurityPolicyURI
+	}
+	if policyURI == securityPolicyNone {
+		return nil, fmt.Errorf("session: user token policy %s cannot sign the user token", policyURI)
+	}
+	if s.cfg.UserKey == nil {
+		return nil, fmt.Errorf("session: user key missing")
+	}
+
+	cert := s.serverCertificate
+	if len(cert) == 0 {
+		cert = cfg.RemoteCertificate
+	}
+	remoteKey, err := publicKey(cert)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := securitypolicy.Asymmetric(policyURI, s.cfg.UserKey, remoteKey)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := enc.Signature(concat(cert, s.serverNonce))
+	if err != nil {
+		return nil, err
+	}
+	return &ua.SignatureData{Algorithm: enc.SignatureURI(), Signature: sig}, nil
+}
+
+// encryptSecret encrypts the secret and the nonce with the public key of
+// the server. The length of both is prepended as uint32.
+//
+// Specification: Part 4, 7.36.2.2
+func encryptSecret(policyURI string, localKey *rsa.PrivateKey, remoteKey *rsa.PublicKey, secret, nonce []byte) ([]byte, string, error) {
+	enc, err := securitypolicy.Asymmetric(policyURI, localKey, remoteKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	b := make([]byte, 4, 4+len(secret)+len(nonce))
+	binary.LittleEndian.PutUint32(b, uint32(len(secret)+len(nonce)))
+	b = append(b, secret...)
+	b = append(b, nonce...)
+
+	c, err := enc.Encrypt(b)
+	if err != nil {
+		return nil, "", err
+	}
+	return c, enc.EncryptionURI(), nil
+}
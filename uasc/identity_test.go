@@ -0,0 +1,188 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package uasc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/gopcua/opcua/securitypolicy"
+	"github.com/gopcua/opcua/ua"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestIdentityToken(t *testing.T) {
+	const basic256Sha256 = "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+
+	clientKey, clientCert := newTestCert(t, 2048)
+	serverKey, serverCert := newTestCert(t, 2048)
+	userKey, userCert := newTestCert(t, 2048)
+	nonce := []byte("0123456789abcdef0123456789abcdef")
+
+	endpoints := func(policy string, mode ua.MessageSecurityMode, tokenPolicy string) []*ua.EndpointDescription {
+		return []*ua.EndpointDescription{
+			{
+				SecurityPolicyURI: policy,
+				SecurityMode:      mode,
+				UserIdentityTokens: []*ua.UserTokenPolicy{
+					{PolicyID: "anonymous", TokenType: ua.UserTokenTypeAnonymous},
+					{PolicyID: "username", TokenType: ua.UserTokenTypeUserName, SecurityPolicyURI: tokenPolicy},
+					{PolicyID: "certificate", TokenType: ua.UserTokenTypeCertificate, SecurityPolicyURI: tokenPolicy},
+				},
+			},
+		}
+	}
+	session := func(policy string, mode ua.MessageSecurityMode, eps []*ua.EndpointDescription, tok interface{}) *Session {
+		cfg := &Config{SecurityPolicyURI: policy, SecurityMode: mode}
+		if mode != ua.MessageSecurityModeNone {
+			cfg.Certificate = clientCert
+			cfg.LocalKey = clientKey
+			cfg.RemoteCertificate = serverCert
+		}
+		return &Session{
+			sechan:            &SecureChannel{cfg: cfg},
+			cfg:               &SessionConfig{ServerEndpoints: eps, UserIdentityToken: tok, UserTokenSignature: &ua.SignatureData{}, UserKey: userKey},
+			serverNonce:       nonce,
+			serverCertificate: serverCert,
+		}
+	}
+	user := func() *ua.UserNameIdentityToken {
+		return &ua.UserNameIdentityToken{UserName: "user", Password: []byte("pass")}
+	}
+	x509 := func() *ua.X509IdentityToken {
+		return &ua.X509IdentityToken{CertificateData: userCert}
+	}
+	noKey := func(s *Session) *Session {
+		s.cfg.UserKey = nil
+		return s
+	}
+
+	tests := []struct {
+		name string
+		s    *Session
+		tok  interface{}
+		enc  bool
+		sig  bool
+		err  bool
+	}{
+		{
+			name: "anonymous",
+			s:    session(securityPolicyNone, ua.MessageSecurityModeNone, endpoints(securityPolicyNone, ua.MessageSecurityModeNone, ""), &ua.AnonymousIdentityToken{}),
+			tok:  &ua.AnonymousIdentityToken{PolicyID: "anonymous"},
+		},
+		{
+			name: "anonymous without policy",
+			s:    session(securityPolicyNone, ua.MessageSecurityModeNone, nil, nil),
+			tok:  &ua.AnonymousIdentityToken{},
+		},
+		{
+			name: "user name in clear text",
+			s:    session(securityPolicyNone, ua.MessageSecurityModeNone, endpoints(securityPolicyNone, ua.MessageSecurityModeNone, ""), user()),
+			tok:  &ua.UserNameIdentityToken{PolicyID: "username", UserName: "user", Password: []byte("pass")},
+		},
+		{
+			name: "user name policy requires secure channel",
+			s:    session(securityPolicyNone, ua.MessageSecurityModeNone, endpoints(securityPolicyNone, ua.MessageSecurityModeNone, basic256Sha256), user()),
+			err:  true,
+		},
+		{
+			name: "user name without policy",
+			s:    session(securityPolicyNone, ua.MessageSecurityModeNone, endpoints(basic256Sha256, ua.MessageSecurityModeSign, ""), user()),
+			err:  true,
+		},
+		{
+			name: "user name with unknown policy id",
+			s: session(securityPolicyNone, ua.MessageSecurityModeNone, endpoints(securityPolicyNone, ua.MessageSecurityModeNone, ""),
+				&ua.UserNameIdentityToken{PolicyID: "other", UserName: "user", Password: []byte("pass")}),
+			err: true,
+		},
+		{
+			name: "user name with channel policy",
+			s:    session(basic256Sha256, ua.MessageSecurityModeSignAndEncrypt, endpoints(basic256Sha256, ua.MessageSecurityModeSignAndEncrypt, ""), user()),
+			tok:  &ua.UserNameIdentityToken{PolicyID: "username", UserName: "user", EncryptionAlgorithm: "http://www.w3.org/2001/04/xmlenc#rsa-oaep"},
+			enc:  true,
+		},
+		{
+			name: "user name with token policy",
+			s:    session(basic256Sha256, ua.MessageSecurityModeSign, endpoints(basic256Sha256, ua.MessageSecurityModeSign, basic256Sha256), user()),
+			tok:  &ua.UserNameIdentityToken{PolicyID: "username", UserName: "user", EncryptionAlgorithm: "http://www.w3.org/2001/04/xmlenc#rsa-oaep"},
+			enc:  true,
+		},
+		{
+			name: "certificate with channel policy",
+			s:    session(basic256Sha256, ua.MessageSecurityModeSign, endpoints(basic256Sha256, ua.MessageSecurityModeSign, ""), x509()),
+			tok:  &ua.X509IdentityToken{PolicyID: "certificate", CertificateData: userCert},
+			sig:  true,
+		},
+		{
+			name: "certificate with token policy",
+			s:    session(securityPolicyNone, ua.MessageSecurityModeNone, endpoints(securityPolicyNone, ua.MessageSecurityModeNone, basic256Sha256), x509()),
+			tok:  &ua.X509IdentityToken{PolicyID: "certificate", CertificateData: userCert},
+			sig:  true,
+		},
+		{
+			name: "certificate with policy none",
+			s:    session(securityPolicyNone, ua.MessageSecurityModeNone, endpoints(securityPolicyNone, ua.MessageSecurityModeNone, ""), x509()),
+			err:  true,
+		},
+		{
+			name: "certificate without key",
+			s:    noKey(session(basic256Sha256, ua.MessageSecurityModeSign, endpoints(basic256Sha256, ua.MessageSecurityModeSign, ""), x509())),
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok, sig, err := tt.s.identityToken()
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want %v", err, want)
+			}
+			if tt.err {
+				return
+			}
+
+			if tt.enc {
+				u := tok.(*ua.UserNameIdentityToken)
+				enc, err := securitypolicy.Asymmetric(basic256Sha256, serverKey, &clientKey.PublicKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := enc.Decrypt(u.Password)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := binary.LittleEndian.Uint32(b), uint32(len("pass")+len(nonce)); got != want {
+					t.Fatalf("got length %d want %d", got, want)
+				}
+				if got, want := b[4:], append([]byte("pass"), nonce...); !bytes.Equal(got, want) {
+					t.Fatalf("got secret %q want %q", got, want)
+				}
+				u.Password = nil
+			}
+
+			if tt.sig {
+				enc, err := securitypolicy.Asymmetric(basic256Sha256, serverKey, &userKey.PublicKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := sig.Algorithm, "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"; got != want {
+					t.Fatalf("got algorithm %s want %s", got, want)
+				}
+				if err := enc.VerifySignature(append(append([]byte{}, serverCert...), nonce...), sig.Signature); err != nil {
+					t.Fatalf("invalid signature: %s", err)
+				}
+			} else {
+				verify.Values(t, "", sig, &ua.SignatureData{})
+			}
+			verify.Values(t, "", tok, tt.tok)
+		})
+	}
+
+	// the password of the configuration stays in clear text
+	s := tests[7].s
+	verify.Values(t, "", s.cfg.UserIdentityToken, user())
+}
@@ -6,6 +6,7 @@ package uasc
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/gopcua/opcua/id"
 	"github.com/gopcua/opcua/ua"
@@ -19,6 +20,19 @@ type MessageHeader struct {
 }
 
 func (m *MessageHeader) Decode(b []byte) (int, error) {
+	n, err := m.decodeSecurityHeader(b)
+	if err != nil {
+		return n, err
+	}
+
+	m.SequenceHeader = new(SequenceHeader)
+	k, err := m.SequenceHeader.Decode(b[n:])
+	return n + k, err
+}
+
+// decodeSecurityHeader decodes the message header and the security header
+// which are never encrypted and returns the number of bytes consumed.
+func (m *MessageHeader) decodeSecurityHeader(b []byte) (int, error) {
 	buf := ua.NewBuffer(b)
 
 	m.Header = new(Header)
@@ -37,9 +51,6 @@ func (m *MessageHeader) Decode(b []byte) (int, error) {
 		return buf.Pos(), fmt.Errorf("invalid message type %q", m.Header.MessageType)
 	}
 
-	m.SequenceHeader = new(SequenceHeader)
-	buf.ReadStruct(m.SequenceHeader)
-
 	return buf.Pos(), buf.Error()
 }
 
@@ -123,25 +134,78 @@ func (m *Message) Decode(b []byte) (int, error) {
 }
 
 func (m *Message) Encode() ([]byte, error) {
-	body := ua.NewBuffer(nil)
-	switch m.Header.MessageType {
-	case "OPN":
-		body.WriteStruct(m.AsymmetricSecurityHeader)
-	case "CLO", "MSG":
-		body.WriteStruct(m.SymmetricSecurityHeader)
-	default:
-		return nil, fmt.Errorf("invalid message type %q", m.Header.MessageType)
+	chunks, err := m.EncodeChunks(math.MaxInt32)
+	if err != nil {
+		return nil, err
 	}
-	body.WriteStruct(m.SequenceHeader)
-	body.WriteStruct(m.TypeID)
-	body.WriteStruct(m.Service)
-	if body.Error() != nil {
-		return nil, body.Error()
+	b := chunks[0]
+	m.Header.MessageSize = uint32(len(b))
+	return b, nil
+}
+
+// EncodeChunks encodes the message into one or more chunks which carry at
+// most maxBodySize bytes of the encoded service each. All chunks but the
+// last one have the chunk type 'C' and the last one has the type 'F'.
+// The chunks are numbered consecutively starting with the sequence number
+// of the message.
+//
+// The chunks are not signed or encrypted.
+func (m *Message) EncodeChunks(maxBodySize int) ([][]byte, error) {
+	if maxBodySize <= 0 {
+		return nil, fmt.Errorf("invalid max body size %d", maxBodySize)
+	}
+
+	data := ua.NewBuffer(nil)
+	data.WriteStruct(m.TypeID)
+	data.WriteStruct(m.Service)
+	if data.Error() != nil {
+		return nil, data.Error()
 	}
+	b := data.Bytes()
+
+	nr := (len(b) + maxBodySize - 1) / maxBodySize
+	if nr == 0 {
+		nr = 1
+	}
+
+	chunks := make([][]byte, nr)
+	seqnr := m.SequenceHeader.SequenceNumber
+	for i := 0; i < nr; i++ {
+		lo, hi := i*maxBodySize, (i+1)*maxBodySize
+		if hi > len(b) {
+			hi = len(b)
+		}
 
-	m.Header.MessageSize = uint32(12 + body.Len())
-	buf := ua.NewBuffer(nil)
-	buf.WriteStruct(m.Header)
-	buf.Write(body.Bytes())
-	return buf.Bytes(), buf.Error()
+		chunkType := byte(ChunkTypeIntermediate)
+		if i == nr-1 {
+			chunkType = ChunkTypeFinal
+		}
+
+		body := ua.NewBuffer(nil)
+		switch m.Header.MessageType {
+		case "OPN":
+			body.WriteStruct(m.AsymmetricSecurityHeader)
+		case "CLO", "MSG":
+			body.WriteStruct(m.SymmetricSecurityHeader)
+		default:
+			return nil, fmt.Errorf("invalid message type %q", m.Header.MessageType)
+		}
+		body.WriteStruct(NewSequenceHeader(seqnr, m.SequenceHeader.RequestID))
+		body.Write(b[lo:hi])
+		if body.Error() != nil {
+			return nil, body.Error()
+		}
+
+		h := NewHeader(m.Header.MessageType, chunkType, m.Header.SecureChannelID)
+		h.MessageSize = uint32(hdrlen + body.Len())
+		buf := ua.NewBuffer(nil)
+		buf.WriteStruct(h)
+		buf.Write(body.Bytes())
+		if buf.Error() != nil {
+			return nil, buf.Error()
+		}
+		chunks[i] = buf.Bytes()
+		seqnr = nextSequenceNumber(seqnr)
+	}
+	return chunks, nil
 }
@@ -1,31 +1,49 @@
 package uasc
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"io"
-	"log"
 	mrand "math/rand"
 	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/gopcua/opcua/logger"
+	"github.com/gopcua/opcua/securitypolicy"
 	"github.com/gopcua/opcua/ua"
 	"github.com/gopcua/opcua/uacp"
 )
 
+// hdrlen is the size of the uasc message header
+const hdrlen = 12
+
 const (
 	secureChannelCreated int32 = iota
 	secureChannelOpen
 	secureChannelClosed
 )
 
+// reapInterval is the interval in which expired response handlers
+// are removed.
+const reapInterval = time.Second
+
 type Response struct {
 	V   interface{}
 	Err error
 }
 
+// responseHandler receives the response for an outstanding request.
+type responseHandler struct {
+	ch chan Response
+
+	// deadline is the time after which the request fails with
+	// StatusBadTimeout. A zero deadline never expires.
+	deadline time.Time
+}
+
 type SecureChannel struct {
 	EndpointURL string
 
@@ -35,21 +53,72 @@ type SecureChannel struct {
 	// cfg is the configuration for the secure channel.
 	cfg *Config
 
+	// log is the logger from the configuration or the connection.
+	log logger.Logger
+
 	// reqhdr is the header for the next request.
 	reqhdr *ua.RequestHeader
 
+	// asymEnc secures the OpenSecureChannel messages with the
+	// asymmetric algorithms of the security policy.
+	asymEnc *securitypolicy.EncryptionAlgorithm
+
+	// token is the current security token. Its symmetric algorithm
+	// secures all other messages with the keys derived from the nonces.
+	token *securityToken
+
+	// prevToken is the security token which was replaced by the last
+	// renewal. Received messages secured with it are accepted until
+	// it expires.
+	prevToken *securityToken
+
+	// nextToken is the security token which the server has issued with
+	// the last renewal. The server continues to use the current token
+	// until the client sends the first message with the new one.
+	nextToken *securityToken
+
+	// switchToken is set when the client has used nextToken for the
+	// first time. The next sender then makes it the current token.
+	switchToken bool
+
+	// encMu guards the security tokens for the receiver. Since the
+	// sender uses them as well they are only replaced while holding
+	// both sendMu and encMu. The receiver never takes sendMu since a
+	// sender can block in Write while holding it.
+	encMu sync.RWMutex
+
 	// quit signals the termination of the recv loop.
 	quit chan struct{}
 
+	// quitOnce ensures that quit is closed only once since the
+	// remote end can close the secure channel with an ERR message.
+	quitOnce sync.Once
+
+	// done is closed when the recv loop has terminated and err is the
+	// reason. It is set before done is closed.
+	done chan struct{}
+	err  error
+
 	// state is the state of the secure channel.
 	// Must be accessed with atomic.LoadInt32/StoreInt32
 	state int32
 
-	// mu guards handler which contains the response channels
-	// for the outstanding requests. The key is the request
-	// handle which is part of the Request and Response headers.
+	// sendMu serializes sending messages since the chunks of a
+	// message must be sent in order and must not be interleaved
+	// with the chunks of other messages. It also guards the
+	// sequence numbers, request ids and the request header.
+	sendMu sync.Mutex
+
+	// serverChanID is the secure channel id which the server assigns
+	// when the client opens the secure channel.
+	serverChanID uint32
+
+	// mu guards handler which contains the response handlers
+	// for the outstanding requests. The key is the request id
+	// which is part of the sequence header of the request and
+	// response chunks.
 	mu      sync.Mutex
-	handler map[uint32]chan Response
+	handler map[uint32]*responseHandler
 }
 
 func init() {
@@ -71,59 +140,154 @@ func NewSecureChannel(c *uacp.Conn, cfg *Config) *SecureChannel {
 		AdditionalHeader:    ua.NewExtensionObject(nil),
 	}
 
+	lg := cfg.Logger
+	if lg == nil {
+		lg = c.Logger()
+	}
+
 	return &SecureChannel{
 		c:       c,
 		cfg:     cfg,
+		log:     logger.Or(lg),
 		reqhdr:  reqhdr,
 		state:   secureChannelCreated,
 		quit:    make(chan struct{}),
-		handler: make(map[uint32]chan Response),
+		done:    make(chan struct{}),
+		handler: make(map[uint32]*responseHandler),
 	}
 }
 
-func (s *SecureChannel) Open() error {
+// Open opens the secure channel. It waits for the OpenSecureChannel
+// response until ctx is done.
+func (s *SecureChannel) Open(ctx context.Context) error {
+	if err := s.initAsymmetric(); err != nil {
+		return err
+	}
 	go s.recv()
-	return s.openSecureChannel()
+	go s.reapHandlers()
+	if err := s.openSecureChannel(ctx, ua.SecurityTokenRequestTypeIssue); err != nil {
+		return err
+	}
+	go s.renewToken()
+	return nil
 }
 
 func (s *SecureChannel) Close() error {
+	if atomic.LoadInt32(&s.state) == secureChannelClosed {
+		s.quitOnce.Do(func() { close(s.quit) })
+		return nil
+	}
 	if err := s.closeSecureChannel(); err != nil {
-		log.Print("failed to send close secure channel request")
+		s.log.Warn("sechan: failed to send close secure channel request", "conn", s.c.ID(), "err", err)
 	}
-	close(s.quit)
+	s.quitOnce.Do(func() { close(s.quit) })
 	return s.c.Close()
 }
 
+// Done returns a channel which is closed when the secure channel can no
+// longer receive responses since it was closed or its connection has
+// failed. Err returns the reason.
+func (s *SecureChannel) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns StatusBadSecureChannelClosed if the secure channel was
+// closed, the error of the connection if it has failed or nil if Done is
+// not yet closed.
+func (s *SecureChannel) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
 func (s *SecureChannel) LocalEndpoint() string {
 	return s.EndpointURL
 }
 
-func (s *SecureChannel) openSecureChannel() error {
-	// todo(fs): do we need to set the nonce if the security policy is None?
-	nonce := make([]byte, 32)
-	if _, err := rand.Read(nonce); err != nil {
-		return err
+// openSecureChannel requests a new security token. requestType is either
+// SecurityTokenRequestTypeIssue for a new secure channel or
+// SecurityTokenRequestTypeRenew to replace the token of an open channel.
+func (s *SecureChannel) openSecureChannel(ctx context.Context, requestType ua.SecurityTokenRequestType) error {
+	var nonce []byte
+	if n := s.asymEnc.NonceLength(); n > 0 {
+		nonce = make([]byte, n)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
 	}
 
 	req := &ua.OpenSecureChannelRequest{
 		ClientProtocolVersion: 0,
-		RequestType:           ua.SecurityTokenRequestTypeIssue,
+		RequestType:           requestType,
 		SecurityMode:          s.cfg.SecurityMode,
 		ClientNonce:           nonce,
 		RequestedLifetime:     s.cfg.Lifetime,
 	}
 
-	return s.Send(req, func(v interface{}) error {
+	return s.SendWithContext(ctx, req, func(v interface{}) error {
 		resp, ok := v.(*ua.OpenSecureChannelResponse)
 		if !ok {
 			return fmt.Errorf("got %T, want OpenSecureChannelResponse", req)
 		}
-		s.cfg.SecurityTokenID = resp.SecurityToken.TokenID
+		if err := s.initSymmetric(resp.SecurityToken, nonce, resp.ServerNonce); err != nil {
+			return err
+		}
 		atomic.StoreInt32(&s.state, secureChannelOpen)
 		return nil
 	})
 }
 
+// renewToken renews the security token after 75% of its lifetime has
+// passed until the secure channel is closed. A failed renewal is retried
+// while the current token is still valid.
+func (s *SecureChannel) renewToken() {
+	s.encMu.RLock()
+	wait := time.Until(s.token.renewAt())
+	s.encMu.RUnlock()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(wait):
+		}
+
+		if atomic.LoadInt32(&s.state) != secureChannelOpen {
+			return
+		}
+
+		s.encMu.RLock()
+		expires := s.token.expires
+		s.encMu.RUnlock()
+
+		// the renewal must complete before the current token expires
+		ctx, cancel := context.WithDeadline(context.Background(), expires)
+		err := s.openSecureChannel(ctx, ua.SecurityTokenRequestTypeRenew)
+		cancel()
+
+		s.encMu.RLock()
+		tok := s.token
+		s.encMu.RUnlock()
+
+		if err == nil {
+			s.log.Info("sechan: renewed security token", "conn", s.c.ID(), "token", tok.id)
+			wait = time.Until(tok.renewAt())
+			continue
+		}
+
+		left := time.Until(tok.expires)
+		if left <= 0 {
+			s.log.Error("sechan: renew security token failed. token expired", "conn", s.c.ID(), "token", tok.id, "err", err)
+			return
+		}
+		s.log.Warn("sechan: renew security token failed. retrying", "conn", s.c.ID(), "token", tok.id, "err", err)
+		wait = left / 2
+	}
+}
+
 // closeSecureChannel sends CloseSecureChannelRequest on top of UASC to SecureChannel.
 func (s *SecureChannel) closeSecureChannel() error {
 	req := &ua.CloseSecureChannelRequest{}
@@ -134,7 +298,22 @@ func (s *SecureChannel) closeSecureChannel() error {
 
 // Send sends the service request and calls h with the response.
 func (s *SecureChannel) Send(svc interface{}, h func(interface{}) error) error {
-	ch, err := s.SendAsync(svc)
+	return s.SendWithContext(context.Background(), svc, h)
+}
+
+// SendWithContext sends the service request and calls h with the response.
+//
+// If ctx has a deadline it is sent to the server as the TimeoutHint of the
+// request and SendWithContext fails with StatusBadTimeout when the deadline
+// expires before the response has arrived. If ctx is cancelled before that
+// a CancelRequest for the outstanding request is sent to the server.
+func (s *SecureChannel) SendWithContext(ctx context.Context, svc interface{}, h func(interface{}) error) error {
+	deadline, _ := ctx.Deadline()
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return ua.StatusBadTimeout
+	}
+
+	ch, reqhandle, err := s.sendAsync(svc, deadline)
 	if err != nil {
 		return err
 	}
@@ -143,62 +322,220 @@ func (s *SecureChannel) Send(svc interface{}, h func(interface{}) error) error {
 		return nil
 	}
 
-	// todo(fs): handle timeout
-	resp := <-ch
-	if resp.Err != nil {
-		return resp.Err
+	select {
+	case resp := <-ch:
+		if resp.Err != nil {
+			return resp.Err
+		}
+		return h(resp.V)
+
+	case <-ctx.Done():
+		s.removeHandler(ch)
+		s.cancelRequest(reqhandle)
+		if ctx.Err() == context.DeadlineExceeded {
+			return ua.StatusBadTimeout
+		}
+		return ctx.Err()
+	}
+}
+
+// cancelRequest asks the server to cancel the outstanding request with
+// the given request handle. The response is not awaited.
+func (s *SecureChannel) cancelRequest(reqhandle uint32) {
+	if atomic.LoadInt32(&s.state) != secureChannelOpen {
+		return
+	}
+	req := &ua.CancelRequest{RequestHandle: reqhandle}
+	if _, err := s.SendAsync(req); err != nil {
+		s.log.Warn("sechan: cancel request failed", "conn", s.c.ID(), "handle", reqhandle, "err", err)
 	}
-	return h(resp.V)
 }
 
 // SendAsync sends the service request and returns a channel which will receive the
-// response when it arrives.
+// response when it arrives. The request has no deadline and the channel only
+// receives an error if the request cannot be sent or the secure channel is
+// closed. Use SendWithContext for requests with a deadline.
+//
+// The request is split into multiple chunks if it does not fit into the
+// send buffer of the connection. It fails with StatusBadRequestTooLarge
+// if the message exceeds the maximum message size or the maximum number
+// of chunks the remote end accepts.
 func (s *SecureChannel) SendAsync(svc interface{}) (resp chan Response, err error) {
+	resp, _, err = s.sendAsync(svc, time.Time{})
+	return resp, err
+}
+
+// sendAsync sends the service request and returns the response channel
+// and the request handle of the request. If the deadline is not zero the
+// TimeoutHint of the request header is derived from it and the channel
+// receives StatusBadTimeout when it expires.
+func (s *SecureChannel) sendAsync(svc interface{}, deadline time.Time) (resp chan Response, reqhandle uint32, err error) {
 	typeID := ua.TypeID(svc)
 	if typeID == 0 {
-		return nil, fmt.Errorf("unknown service %T. Did you call register?", svc)
+		return nil, 0, fmt.Errorf("unknown service %T. Did you call register?", svc)
+	}
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.switchNextToken()
+
+	// no response can be received after the recv loop has terminated
+	select {
+	case <-s.done:
+		return nil, 0, ua.StatusBadSecureChannelClosed
+	default:
+	}
+
+	// every request gets its own copy of the request header since the
+	// request handle and the timeout hint differ between requests.
+	s.reqhdr.RequestHandle++
+	hdr := *s.reqhdr
+	hdr.Timestamp = time.Now()
+	if !deadline.IsZero() {
+		timeout := deadline.Sub(hdr.Timestamp)
+		if timeout < time.Millisecond {
+			timeout = time.Millisecond
+		}
+		hdr.TimeoutHint = uint32((timeout + time.Millisecond - 1) / time.Millisecond)
 	}
 
 	// the request header is always the first field
 	val := reflect.ValueOf(svc)
-	val.Elem().Field(0).Set(reflect.ValueOf(s.reqhdr))
+	val.Elem().Field(0).Set(reflect.ValueOf(&hdr))
 
 	// update counters and reset them on error
-	s.cfg.SequenceNumber++
-	s.reqhdr.RequestHandle++
-	s.reqhdr.Timestamp = time.Now()
+	seqnr := s.cfg.SequenceNumber
+	s.cfg.SequenceNumber = nextSequenceNumber(s.cfg.SequenceNumber)
+	s.cfg.RequestID++
 	defer func() {
 		if err != nil {
-			s.cfg.SequenceNumber--
+			s.cfg.SequenceNumber = seqnr
 			s.reqhdr.RequestHandle--
 		}
 	}()
 
 	// encode the message
 	m := NewMessage(svc, typeID, s.cfg)
-	b, err := m.Encode()
+	chunks, err := s.encodeChunks(m)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	reqid := m.SequenceHeader.RequestID
 
+	// register the handler before sending the message
+	// since the response can arrive before Write returns.
+	h := &responseHandler{ch: make(chan Response, 1), deadline: deadline}
+	s.mu.Lock()
+	s.handler[reqid] = h
+	s.mu.Unlock()
+
 	// send the message
-	if _, err := s.c.Write(b); err != nil {
-		return nil, err
+	var size int
+	for _, b := range chunks {
+		if _, err = s.c.Write(b); err != nil {
+			s.mu.Lock()
+			delete(s.handler, reqid)
+			s.mu.Unlock()
+			return nil, 0, err
+		}
+		size += len(b)
+	}
+	for i := 1; i < len(chunks); i++ {
+		s.cfg.SequenceNumber = nextSequenceNumber(s.cfg.SequenceNumber)
 	}
-	log.Printf("conn %d/%d: send %T with %d bytes", s.c.ID(), reqid, svc, len(b))
+	s.log.Debug("sechan: send", "conn", s.c.ID(), "reqid", reqid, "type", fmt.Sprintf("%T", svc), "size", size, "chunks", len(chunks))
 
-	// register the handler
-	resp = make(chan Response)
+	return h.ch, hdr.RequestHandle, nil
+}
+
+// removeHandler removes the response handler with the given channel
+// after the caller has given up waiting for the response.
+func (s *SecureChannel) removeHandler(ch chan Response) {
 	s.mu.Lock()
-	s.handler[reqid] = resp
-	s.mu.Unlock()
-	return resp, nil
+	defer s.mu.Unlock()
+	for reqid, h := range s.handler {
+		if h.ch == ch {
+			delete(s.handler, reqid)
+			return
+		}
+	}
+}
+
+// reapHandlers fails the outstanding requests with StatusBadTimeout
+// when their deadline has expired until the secure channel is closed.
+func (s *SecureChannel) reapHandlers() {
+	t := time.NewTicker(reapInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case now := <-t.C:
+			s.expireHandlers(now)
+		}
+	}
+}
+
+// expireHandlers fails all outstanding requests whose deadline is
+// before now with StatusBadTimeout.
+func (s *SecureChannel) expireHandlers(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for reqid, h := range s.handler {
+		if h.deadline.IsZero() || h.deadline.After(now) {
+			continue
+		}
+		s.log.Warn("sechan: request timed out", "conn", s.c.ID(), "reqid", reqid)
+		delete(s.handler, reqid)
+		h.ch <- Response{nil, ua.StatusBadTimeout}
+		close(h.ch)
+	}
+}
+
+// failHandlers fails all outstanding requests with err since no more
+// responses can be received.
+func (s *SecureChannel) failHandlers(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for reqid, h := range s.handler {
+		delete(s.handler, reqid)
+		h.ch <- Response{nil, err}
+		close(h.ch)
+	}
+}
+
+// encodeChunks encodes the message into chunks which fit into the send
+// buffer of the connection and signs and encrypts them.
+func (s *SecureChannel) encodeChunks(m *Message) ([][]byte, error) {
+	max, err := s.maxBodySize(m.MessageHeader, int(s.c.SendBufSize()))
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := m.EncodeChunks(max)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := s.c.MaxChunkCount(); n > 0 && uint32(len(chunks)) > n {
+		return nil, ua.StatusBadRequestTooLarge
+	}
+
+	// the limit applies to the chunks as they are sent
+	var size int
+	for i, b := range chunks {
+		if chunks[i], err = s.signAndEncrypt(m.MessageHeader, b); err != nil {
+			return nil, err
+		}
+		size += len(chunks[i])
+	}
+	if n := s.c.MaxMessageSize(); n > 0 && uint32(size) > n {
+		return nil, ua.StatusBadRequestTooLarge
+	}
+	return chunks, nil
 }
 
 func (s *SecureChannel) readchunk() (*MessageChunk, error) {
 	// read and decode the header to get the message size
-	const hdrlen = 12
 	b := make([]byte, s.c.ReceiveBufSize())
 	_, err := io.ReadFull(s.c, b[:hdrlen])
 	if err == io.EOF {
@@ -215,8 +552,21 @@ func (s *SecureChannel) readchunk() (*MessageChunk, error) {
 	if _, err := h.Decode(b[:hdrlen]); err != nil {
 		return nil, fmt.Errorf("sechan: decode header failed: %s", err)
 	}
+	if h.MessageSize < hdrlen || h.MessageSize > uint32(len(b)) {
+		return nil, fmt.Errorf("sechan: invalid message size %d", h.MessageSize)
+	}
 	b = b[:h.MessageSize]
 
+	// the remote end has closed the connection with an ERR message
+	// which has no secure channel id and no security header.
+	if h.MessageType == uacp.MessageTypeError {
+		if _, err := io.ReadFull(s.c, b[hdrlen:]); err != nil {
+			return nil, fmt.Errorf("sechan: read error message failed")
+		}
+		// the error follows the 8 byte header of the uacp message
+		return nil, uacp.DecodeError(b[8:])
+	}
+
 	// drop if the channel id does not match
 	if s.cfg.SecureChannelID > 0 && s.cfg.SecureChannelID != h.SecureChannelID {
 		return nil, fmt.Errorf("sechan: secure channel id mismatch: got 0x%04x, want 0x%04x", h.SecureChannelID, s.cfg.SecureChannelID)
@@ -227,18 +577,37 @@ func (s *SecureChannel) readchunk() (*MessageChunk, error) {
 		return nil, fmt.Errorf("sechan: read message failed")
 	}
 
-	// decode the other headers
-	m := new(MessageChunk)
-	if _, err := m.Decode(b); err != nil {
+	// decode the security header which is never encrypted
+	m := &MessageChunk{MessageHeader: new(MessageHeader)}
+	n, err := m.MessageHeader.decodeSecurityHeader(b)
+	if err != nil {
 		return nil, fmt.Errorf("sechan: decode message failed: %s", err)
 	}
 
-	// todo(fs): handle ERR messages
-	// todo(fs): handle crypto
+	// a server learns the client certificate from the first OPN message
+	if m.AsymmetricSecurityHeader != nil && len(s.cfg.RemoteCertificate) == 0 && len(m.SenderCertificate) > 0 {
+		s.cfg.RemoteCertificate = m.SenderCertificate
+		if err := s.initAsymmetric(); err != nil {
+			return nil, err
+		}
+	}
+
+	// decrypt and verify the rest of the message
+	b, err = s.verifyAndDecrypt(m.MessageHeader, n, b)
+	if err != nil {
+		return nil, err
+	}
+
+	m.SequenceHeader = new(SequenceHeader)
+	k, err := m.SequenceHeader.Decode(b[n:])
+	if err != nil {
+		return nil, fmt.Errorf("sechan: decode sequence header failed: %s", err)
+	}
+	m.Data = b[n+k:]
 
 	if s.cfg.SecureChannelID == 0 {
 		s.cfg.SecureChannelID = h.SecureChannelID
-		log.Printf("conn %d/%d: set secure channel id to %d", s.c.ID(), m.SequenceHeader.RequestID, s.cfg.SecureChannelID)
+		s.log.Debug("sechan: set secure channel id", "conn", s.c.ID(), "reqid", m.SequenceHeader.RequestID, "chanid", s.cfg.SecureChannelID)
 	}
 
 	return m, nil
@@ -254,17 +623,27 @@ func (s *SecureChannel) recv() {
 	for {
 		select {
 		case <-s.quit:
+			s.terminate(ua.StatusBadSecureChannelClosed)
 			return
 
 		default:
 			chunk, err := s.readchunk()
-			if err == io.EOF {
+			if err != nil {
+				s.terminate(err)
 				return
 			}
 
 			hdr := chunk.Header
 			reqid := chunk.SequenceHeader.RequestID
-			log.Printf("conn %d/%d: recv %s%c with %d bytes", s.c.ID(), reqid, hdr.MessageType, hdr.ChunkType, hdr.MessageSize)
+			s.log.Debug("sechan: recv chunk", "conn", s.c.ID(), "reqid", reqid, "type", hdr.MessageType+string(hdr.ChunkType), "size", hdr.MessageSize)
+
+			// the sender has aborted the message. Only the
+			// request it belongs to fails.
+			if hdr.ChunkType == ChunkTypeError {
+				delete(chunks, reqid)
+				s.notifyCaller(reqid, nil, uacp.DecodeError(chunk.Data))
+				continue
+			}
 
 			if hdr.ChunkType != 'F' {
 				chunks[reqid] = append(chunks[reqid], chunk)
@@ -292,14 +671,10 @@ func (s *SecureChannel) recv() {
 				continue
 			}
 
-			// since we are not decoding the ResponseHeader separately
-			// we need to drop every message that has an error since we
-			// cannot get to the RequestHandle in the ResponseHeader.
-			// To fix this we must a) decode the ResponseHeader separately
-			// and subsequently remove it and the TypeID from all service
-			// structs and tests. We also need to add a deadline to all
-			// handlers and check them periodically to time them out.
-			_, svc, err := ua.DecodeService(b)
+			// the response header is decoded first so that a bad
+			// ServiceResult is returned to the caller as *ua.ServiceError
+			// even if the rest of the response cannot be decoded.
+			_, svc, err := ua.DecodeResponse(b)
 			if err != nil {
 				s.notifyCaller(reqid, nil, err)
 				continue
@@ -309,30 +684,60 @@ func (s *SecureChannel) recv() {
 	}
 }
 
+// terminate closes the secure channel and the connection after the recv
+// loop has failed with err and fails the outstanding requests. A closed
+// connection fails with StatusBadSecureChannelClosed and an ERR message
+// from the remote end with its *uacp.ProtocolError.
+func (s *SecureChannel) terminate(err error) {
+	select {
+	case <-s.quit:
+		// closed by Close
+		err = ua.StatusBadSecureChannelClosed
+	default:
+		if perr, ok := err.(*uacp.ProtocolError); ok {
+			s.log.Error("sechan: recv ERR", "conn", s.c.ID(), "code", perr.Code, "reason", perr.Reason)
+		} else {
+			s.log.Error("sechan: recv failed", "conn", s.c.ID(), "err", err)
+			err = ua.StatusBadSecureChannelClosed
+		}
+		atomic.StoreInt32(&s.state, secureChannelClosed)
+		s.quitOnce.Do(func() { close(s.quit) })
+		if cerr := s.c.Close(); cerr != nil {
+			s.log.Warn("sechan: close failed", "conn", s.c.ID(), "err", cerr)
+		}
+	}
+
+	// sendAsync must not register new handlers after they have failed
+	s.sendMu.Lock()
+	s.err = err
+	close(s.done)
+	s.sendMu.Unlock()
+	s.failHandlers(err)
+}
+
 func (s *SecureChannel) notifyCaller(reqid uint32, svc interface{}, err error) {
 	if err != nil {
-		log.Printf("conn %d/%d: %v", s.c.ID(), reqid, err)
+		s.log.Debug("sechan: recv error", "conn", s.c.ID(), "reqid", reqid, "err", err)
 	} else {
-		log.Printf("conn %d/%d: recv %T", s.c.ID(), reqid, svc)
+		s.log.Debug("sechan: recv", "conn", s.c.ID(), "reqid", reqid, "type", fmt.Sprintf("%T", svc))
 	}
 
 	// check if we have a pending request handler for this response.
 	s.mu.Lock()
-	ch := s.handler[reqid]
+	h := s.handler[reqid]
 	delete(s.handler, reqid)
 	s.mu.Unlock()
 
 	// no handler -> next response
-	if ch == nil {
-		log.Printf("conn %d/%d: no handler for %T", s.c.ID(), reqid, svc)
+	if h == nil {
+		s.log.Debug("sechan: no handler", "conn", s.c.ID(), "reqid", reqid, "type", fmt.Sprintf("%T", svc))
 		return
 	}
 
-	// send response to caller
-	go func() {
-		ch <- Response{svc, err}
-		close(ch)
-	}()
+	// send response to caller. The channel is buffered and
+	// receives exactly one response so this never blocks.
+	h.ch <- Response{svc, err}
+	close(h.ch)
 }
 
 func mergeChunks(chunks []*MessageChunk) ([]byte, error) {
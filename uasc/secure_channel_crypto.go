@@ -0,0 +1,467 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package uasc
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/gopcua/opcua/securitypolicy"
+	"github.com/gopcua/opcua/ua"
+)
+
+// initAsymmetric sets up the algorithms for the asymmetrically secured
+// OpenSecureChannel messages from the configured keys and certificates.
+func (s *SecureChannel) initAsymmetric() error {
+	if len(s.cfg.RemoteCertificate) > 0 && len(s.cfg.Thumbprint) == 0 {
+		thumbprint := sha1.Sum(s.cfg.RemoteCertificate)
+		s.cfg.Thumbprint = thumbprint[:]
+	}
+
+	if s.cfg.SecurityMode == ua.MessageSecurityModeNone {
+		enc, err := securitypolicy.Asymmetric(s.cfg.SecurityPolicyURI, nil, nil)
+		if err != nil {
+			return err
+		}
+		s.asymEnc = enc
+		return nil
+	}
+
+	if s.cfg.LocalKey == nil {
+		return fmt.Errorf("sechan: security mode %d requires a private key", s.cfg.SecurityMode)
+	}
+	if s.cfg.VerifyCertificate != nil {
+		if err := s.cfg.VerifyCertificate(s.cfg.RemoteCertificate); err != nil {
+			return err
+		}
+	}
+	remoteKey, err := publicKey(s.cfg.RemoteCertificate)
+	if err != nil {
+		return err
+	}
+	enc, err := securitypolicy.Asymmetric(s.cfg.SecurityPolicyURI, s.cfg.LocalKey, remoteKey)
+	if err != nil {
+		return err
+	}
+	s.asymEnc = enc
+	return nil
+}
+
+// securityToken is a security token of the secure channel together with
+// the symmetric algorithm derived from the nonces exchanged when it was
+// issued.
+type securityToken struct {
+	id      uint32
+	enc     *securitypolicy.EncryptionAlgorithm
+	issued  time.Time
+	expires time.Time
+}
+
+// renewAt returns the time when the token should be renewed which is after
+// 75% of its lifetime.
+func (t *securityToken) renewAt() time.Time {
+	return t.issued.Add(t.expires.Sub(t.issued) * 3 / 4)
+}
+
+// initSymmetric derives the keys for the symmetrically secured messages
+// from the nonces exchanged with the OpenSecureChannel service and makes
+// tok the current security token. The previous token is still accepted
+// for received messages until it expires.
+func (s *SecureChannel) initSymmetric(tok *ua.ChannelSecurityToken, localNonce, remoteNonce []byte) error {
+	t, err := s.newSecurityToken(tok, localNonce, remoteNonce)
+	if err != nil {
+		return err
+	}
+	s.activateToken(t)
+	return nil
+}
+
+// newSecurityToken derives the symmetric algorithm for tok from the nonces.
+func (s *SecureChannel) newSecurityToken(tok *ua.ChannelSecurityToken, localNonce, remoteNonce []byte) (*securityToken, error) {
+	if tok == nil {
+		return nil, fmt.Errorf("sechan: security token missing")
+	}
+	enc, err := securitypolicy.Symmetric(s.cfg.SecurityPolicyURI, localNonce, remoteNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime := tok.RevisedLifetime
+	if lifetime == 0 {
+		lifetime = s.cfg.Lifetime
+	}
+
+	// the lifetime is measured with the local clock since the clocks
+	// of client and server are not necessarily in sync.
+	now := time.Now()
+	return &securityToken{
+		id:      tok.TokenID,
+		enc:     enc,
+		issued:  now,
+		expires: now.Add(time.Duration(lifetime) * time.Millisecond),
+	}, nil
+}
+
+// activateToken makes t the current security token.
+func (s *SecureChannel) activateToken(t *securityToken) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.encMu.Lock()
+	defer s.encMu.Unlock()
+	s.setToken(t)
+}
+
+// switchNextToken makes the next security token the current one after the
+// client has used it. The caller must hold sendMu.
+func (s *SecureChannel) switchNextToken() {
+	s.encMu.Lock()
+	defer s.encMu.Unlock()
+	if s.switchToken && s.nextToken != nil {
+		s.setToken(s.nextToken)
+	}
+}
+
+// setToken makes t the current security token. The caller must hold
+// sendMu and encMu.
+func (s *SecureChannel) setToken(t *securityToken) {
+	if s.token != nil && s.token.id != t.id {
+		s.prevToken = s.token
+	}
+	if s.nextToken == t {
+		s.nextToken = nil
+		s.switchToken = false
+	}
+	s.token = t
+	s.cfg.SecurityTokenID = t.id
+}
+
+// publicKey returns the RSA public key of the DER encoded certificate.
+func publicKey(cert []byte) (*rsa.PublicKey, error) {
+	if len(cert) == 0 {
+		return nil, fmt.Errorf("sechan: remote certificate missing")
+	}
+	c, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, fmt.Errorf("sechan: invalid remote certificate: %s", err)
+	}
+	pk, ok := c.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("sechan: remote certificate has %T, want RSA public key", c.PublicKey)
+	}
+	return pk, nil
+}
+
+// isSigned returns true if messages must be signed.
+func (s *SecureChannel) isSigned() bool {
+	return s.cfg.SecurityMode != ua.MessageSecurityModeNone
+}
+
+// isEncrypted returns true if messages of the given type must be encrypted.
+// OpenSecureChannel messages are always encrypted when the channel is secured.
+func (s *SecureChannel) isEncrypted(msgType string) bool {
+	switch msgType {
+	case MessageTypeOpenSecureChannel:
+		return s.cfg.SecurityMode != ua.MessageSecurityModeNone
+	default:
+		return s.cfg.SecurityMode == ua.MessageSecurityModeSignAndEncrypt
+	}
+}
+
+// algorithm returns the security algorithm for the given message type.
+func (s *SecureChannel) algorithm(msgType string) (*securitypolicy.EncryptionAlgorithm, error) {
+	var enc *securitypolicy.EncryptionAlgorithm
+	switch {
+	case msgType == MessageTypeOpenSecureChannel:
+		enc = s.asymEnc
+	case s.token != nil:
+		enc = s.token.enc
+	}
+	if enc == nil {
+		return nil, fmt.Errorf("sechan: no security algorithm for %s message", msgType)
+	}
+	return enc, nil
+}
+
+// recvAlgorithm returns the security algorithm for a received message.
+// Symmetrically secured messages are accepted with the current and the
+// previous security token until the previous token expires and with a
+// token which the server has issued but not used yet.
+func (s *SecureChannel) recvAlgorithm(m *MessageHeader) (*securitypolicy.EncryptionAlgorithm, error) {
+	msgType := m.Header.MessageType
+	if msgType == MessageTypeOpenSecureChannel {
+		return s.algorithm(msgType)
+	}
+
+	id := m.SymmetricSecurityHeader.TokenID
+
+	s.encMu.Lock()
+	defer s.encMu.Unlock()
+
+	switch {
+	case s.token != nil && s.token.id == id:
+		return s.token.enc, nil
+	case s.nextToken != nil && s.nextToken.id == id:
+		// the server switches to a renewed token when the client uses
+		// it for the first time. The switch is left to the next sender
+		// since it must not change while a message is being sent.
+		s.switchToken = true
+		return s.nextToken.enc, nil
+	case s.prevToken != nil && s.prevToken.id == id:
+		if time.Now().After(s.prevToken.expires) {
+			return nil, fmt.Errorf("sechan: security token %d expired", id)
+		}
+		return s.prevToken.enc, nil
+	default:
+		return nil, fmt.Errorf("sechan: unknown security token %d", id)
+	}
+}
+
+// securityHeaderLen returns the length of the message header and the
+// security header which are always sent in clear text.
+func securityHeaderLen(m *MessageHeader) (int, error) {
+	if m.Header.MessageType != MessageTypeOpenSecureChannel {
+		return hdrlen + 4, nil
+	}
+	b, err := m.AsymmetricSecurityHeader.Encode()
+	if err != nil {
+		return 0, err
+	}
+	return hdrlen + len(b), nil
+}
+
+// maxBodySize returns the maximum number of bytes of the encoded service
+// which fit into a single message chunk of the given size after the
+// headers, the padding and the signature have been added.
+func (s *SecureChannel) maxBodySize(m *MessageHeader, chunkSize int) (int, error) {
+	n, err := securityHeaderLen(m)
+	if err != nil {
+		return 0, err
+	}
+
+	// the sequence header is always part of the chunk body
+	const seqhdrlen = 8
+
+	msgType := m.Header.MessageType
+	signed, encrypted := s.isSigned(), s.isEncrypted(msgType)
+	if !signed && !encrypted {
+		return chunkSize - n - seqhdrlen, nil
+	}
+
+	enc, err := s.algorithm(msgType)
+	if err != nil {
+		return 0, err
+	}
+
+	if !encrypted {
+		return chunkSize - n - seqhdrlen - enc.SignatureLength(), nil
+	}
+
+	// only full cipher text blocks fit into the chunk and the padding
+	// needs at least the PaddingSize byte.
+	plainBlock := enc.BlockSize() - enc.MinPadding()
+	blocks := (chunkSize - n) / enc.BlockSize()
+	return blocks*plainBlock - seqhdrlen - enc.SignatureLength() - 1 - extraPadding(msgType, enc.BlockSize()), nil
+}
+
+// signAndEncrypt applies the padding, the signature and the encryption to
+// an encoded message chunk as described in Part 6, 6.7.2 and updates the
+// message size in the header.
+func (s *SecureChannel) signAndEncrypt(m *MessageHeader, b []byte) ([]byte, error) {
+	msgType := m.Header.MessageType
+	signed, encrypted := s.isSigned(), s.isEncrypted(msgType)
+	if !signed && !encrypted {
+		return b, nil
+	}
+
+	enc, err := s.algorithm(msgType)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := securityHeaderLen(m)
+	if err != nil {
+		return nil, err
+	}
+
+	size := len(b) + enc.SignatureLength()
+	if encrypted {
+		plainBlock := enc.BlockSize() - enc.MinPadding()
+		padding := paddingBytes(len(b)-n, plainBlock, enc.SignatureLength(), extraPadding(msgType, enc.BlockSize()))
+		b = append(b, padding...)
+		size = n + (len(b)-n+enc.SignatureLength())/plainBlock*enc.BlockSize()
+	}
+	binary.LittleEndian.PutUint32(b[4:8], uint32(size))
+
+	sig, err := enc.Signature(b)
+	if err != nil {
+		return nil, fmt.Errorf("sechan: sign %s message failed: %s", msgType, err)
+	}
+	b = append(b, sig...)
+
+	if !encrypted {
+		return b, nil
+	}
+
+	c, err := enc.Encrypt(b[n:])
+	if err != nil {
+		return nil, fmt.Errorf("sechan: encrypt %s message failed: %s", msgType, err)
+	}
+	if len(c)+n != size {
+		return nil, fmt.Errorf("sechan: encrypted %s message has %d bytes, want %d", msgType, len(c)+n, size)
+	}
+	return append(b[:n:n], c...), nil
+}
+
+// verifyAndDecrypt decrypts a received message chunk, verifies its signature
+// and removes the padding. n is the length of the message and security headers.
+// It returns the message without padding and signature.
+func (s *SecureChannel) verifyAndDecrypt(m *MessageHeader, n int, b []byte) ([]byte, error) {
+	msgType := m.Header.MessageType
+	signed, encrypted := s.isSigned(), s.isEncrypted(msgType)
+	if !signed && !encrypted {
+		return b, nil
+	}
+
+	enc, err := s.recvAlgorithm(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if msgType == MessageTypeOpenSecureChannel {
+		if err := s.checkThumbprint(m.AsymmetricSecurityHeader); err != nil {
+			return nil, err
+		}
+	}
+
+	if encrypted {
+		p, err := enc.Decrypt(b[n:])
+		if err != nil {
+			return nil, fmt.Errorf("sechan: decrypt %s message failed: %s", msgType, err)
+		}
+		b = append(b[:n:n], p...)
+	}
+
+	siglen := enc.RemoteSignatureLength()
+	if len(b)-n < siglen {
+		return nil, fmt.Errorf("sechan: %s message too short for signature", msgType)
+	}
+	sig := b[len(b)-siglen:]
+	b = b[:len(b)-siglen]
+	if err := enc.VerifySignature(b, sig); err != nil {
+		return nil, fmt.Errorf("sechan: %s message signature invalid: %s", msgType, err)
+	}
+
+	if !encrypted {
+		return b, nil
+	}
+
+	// the sender used our public key for the asymmetric encryption
+	extra := 0
+	if msgType == MessageTypeOpenSecureChannel && s.cfg.LocalKey != nil {
+		extra = extraPadding(msgType, s.cfg.LocalKey.PublicKey.Size())
+	}
+	if len(b)-n < 1+extra {
+		return nil, ua.StatusBadSecurityChecksFailed
+	}
+	padlen := int(b[len(b)-1-extra])
+	if extra > 0 {
+		padlen |= int(b[len(b)-1]) << 8
+	}
+	if len(b)-n < padlen+1+extra {
+		return nil, ua.StatusBadSecurityChecksFailed
+	}
+
+	// the PaddingSize byte and all padding bytes contain the low byte
+	// of the padding size
+	for _, c := range b[len(b)-padlen-1-extra : len(b)-extra] {
+		if c != byte(padlen) {
+			return nil, ua.StatusBadSecurityChecksFailed
+		}
+	}
+	return b[:len(b)-padlen-1-extra], nil
+}
+
+// checkThumbprint verifies that an OpenSecureChannel message was encrypted
+// for our certificate.
+func (s *SecureChannel) checkThumbprint(h *AsymmetricSecurityHeader) error {
+	if len(s.cfg.Certificate) == 0 {
+		return nil
+	}
+	thumbprint := sha1.Sum(s.cfg.Certificate)
+	if !bytes.Equal(thumbprint[:], h.ReceiverCertificateThumbprint) {
+		return fmt.Errorf("sechan: receiver certificate thumbprint mismatch")
+	}
+	return nil
+}
+
+// extraPadding returns the number of bytes for the ExtraPaddingSize field
+// which is only present if the asymmetric key is larger than 2048 bits.
+func extraPadding(msgType string, keySize int) int {
+	if msgType == MessageTypeOpenSecureChannel && keySize > 256 {
+		return 1
+	}
+	return 0
+}
+
+// paddingBytes returns the padding for a message body of n bytes so that
+// body, padding and signature are a multiple of the plain text block size.
+// The padding consists of the PaddingSize byte, the padding bytes and the
+// optional ExtraPaddingSize byte.
+func paddingBytes(n, blockSize, siglen, extra int) []byte {
+	padlen := (blockSize - (n+1+extra+siglen)%blockSize) % blockSize
+	b := bytes.Repeat([]byte{byte(padlen)}, padlen+1)
+	if extra > 0 {
+		b = append(b, byte(padlen>>8))
+	}
+	return b
+}
+
+// NewSessionSignature signs the certificate and the nonce of the remote
+// application with the private key and the asymmetric algorithm of the
+// secure channel. The client signs the certificate and the nonce of the
+// server for ActivateSession and the server those of the client for
+// CreateSession. If the secure channel is not secured the signature is
+// empty.
+//
+// Specification: Part 4, 5.6.2 and 5.6.3
+func (s *SecureChannel) NewSessionSignature(cert, nonce []byte) (*ua.SignatureData, error) {
+	if !s.isSigned() {
+		return &ua.SignatureData{}, nil
+	}
+	sig, err := s.asymEnc.Signature(concat(cert, nonce))
+	if err != nil {
+		return nil, err
+	}
+	return &ua.SignatureData{Algorithm: s.asymEnc.SignatureURI(), Signature: sig}, nil
+}
+
+// VerifySessionSignature verifies the signature of the remote application
+// over the certificate and the nonce of the local application which was
+// created by NewSessionSignature. If the secure channel is not secured
+// the signature is not verified.
+func (s *SecureChannel) VerifySessionSignature(cert, nonce []byte, sig *ua.SignatureData) error {
+	if !s.isSigned() {
+		return nil
+	}
+	if sig == nil || sig.Algorithm != s.asymEnc.SignatureURI() {
+		return ua.StatusBadApplicationSignatureInvalid
+	}
+	if err := s.asymEnc.VerifySignature(concat(cert, nonce), sig.Signature); err != nil {
+		return ua.StatusBadApplicationSignatureInvalid
+	}
+	return nil
+}
+
+// concat returns a new slice with the contents of a and b.
+func concat(a, b []byte) []byte {
+	c := make([]byte, 0, len(a)+len(b))
+	c = append(c, a...)
+	return append(c, b...)
+}
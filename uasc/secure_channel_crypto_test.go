@@ -0,0 +1,495 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package uasc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uacp"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestSignAndEncrypt(t *testing.T) {
+	clientKey, clientCert := newTestCert(t, 2048)
+	serverKey, serverCert := newTestCert(t, 2048)
+
+	cases := []struct {
+		policy string
+		mode   ua.MessageSecurityMode
+	}{
+		{"http://opcfoundation.org/UA/SecurityPolicy#None", ua.MessageSecurityModeNone},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Basic128Rsa15", ua.MessageSecurityModeSign},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Basic128Rsa15", ua.MessageSecurityModeSignAndEncrypt},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Basic256", ua.MessageSecurityModeSignAndEncrypt},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256", ua.MessageSecurityModeSign},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256", ua.MessageSecurityModeSignAndEncrypt},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Aes128_Sha256_RsaOaep", ua.MessageSecurityModeSignAndEncrypt},
+		{"http://opcfoundation.org/UA/SecurityPolicy#Aes256_Sha256_RsaPss", ua.MessageSecurityModeSignAndEncrypt},
+	}
+
+	for _, c := range cases {
+		t.Run(c.policy[len("http://opcfoundation.org/UA/SecurityPolicy#"):], func(t *testing.T) {
+			cli, srv := newTestChannels(t, c.policy, c.mode, clientKey, clientCert, serverKey, serverCert)
+			testRoundTrip(t, cli, srv)
+			testRoundTrip(t, srv, cli)
+		})
+	}
+}
+
+func TestSessionSignature(t *testing.T) {
+	clientKey, clientCert := newTestCert(t, 2048)
+	serverKey, serverCert := newTestCert(t, 2048)
+	nonce := []byte("0123456789abcdef0123456789abcdef")
+
+	t.Run("None", func(t *testing.T) {
+		cli, srv := newTestChannels(t, "http://opcfoundation.org/UA/SecurityPolicy#None", ua.MessageSecurityModeNone, nil, nil, nil, nil)
+		sig, err := cli.NewSessionSignature(nil, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, "", sig, &ua.SignatureData{})
+		if err := srv.VerifySessionSignature(nil, nonce, sig); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Basic256Sha256", func(t *testing.T) {
+		cli, srv := newTestChannels(t, "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256", ua.MessageSecurityModeSign, clientKey, clientCert, serverKey, serverCert)
+
+		// the client signs the certificate and the nonce of the server
+		sig, err := cli.NewSessionSignature(serverCert, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := sig.Algorithm, "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"; got != want {
+			t.Fatalf("got algorithm %s want %s", got, want)
+		}
+		if err := srv.VerifySessionSignature(serverCert, nonce, sig); err != nil {
+			t.Fatal(err)
+		}
+
+		// and the server those of the client
+		sig, err = srv.NewSessionSignature(clientCert, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cli.VerifySessionSignature(clientCert, nonce, sig); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range []struct {
+			name  string
+			nonce []byte
+			sig   *ua.SignatureData
+		}{
+			{"missing signature", nonce, nil},
+			{"empty signature", nonce, &ua.SignatureData{}},
+			{"wrong algorithm", nonce, &ua.SignatureData{Algorithm: "http://www.w3.org/2000/09/xmldsig#rsa-sha1", Signature: sig.Signature}},
+			{"wrong nonce", []byte("other"), sig},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				if got, want := cli.VerifySessionSignature(clientCert, tt.nonce, tt.sig), ua.StatusBadApplicationSignatureInvalid; got != want {
+					t.Fatalf("got %v want %v", got, want)
+				}
+			})
+		}
+	})
+}
+
+func TestSignAndEncryptExtraPadding(t *testing.T) {
+	clientKey, clientCert := newTestCert(t, 2048)
+	serverKey, serverCert := newTestCert(t, 3072)
+
+	policy := "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+	cli, srv := newTestChannels(t, policy, ua.MessageSecurityModeSignAndEncrypt, clientKey, clientCert, serverKey, serverCert)
+	testRoundTrip(t, cli, srv)
+	testRoundTrip(t, srv, cli)
+}
+
+func TestVerifyAndDecryptTampered(t *testing.T) {
+	clientKey, clientCert := newTestCert(t, 2048)
+	serverKey, serverCert := newTestCert(t, 2048)
+
+	policy := "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+	for _, mode := range []ua.MessageSecurityMode{ua.MessageSecurityModeSign, ua.MessageSecurityModeSignAndEncrypt} {
+		cli, srv := newTestChannels(t, policy, mode, clientKey, clientCert, serverKey, serverCert)
+		for _, typeID := range []uint16{id.OpenSecureChannelRequest_Encoding_DefaultBinary, id.ReadRequest_Encoding_DefaultBinary} {
+			m, b := encodeTestMessage(t, cli, typeID)
+			b[len(b)-5] ^= 0xff
+
+			h := new(MessageHeader)
+			n, err := h.decodeSecurityHeader(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := srv.verifyAndDecrypt(h, n, b); err == nil {
+				t.Fatalf("mode %d: tampered %s message not detected", mode, m.Header.MessageType)
+			}
+		}
+	}
+}
+
+func TestVerifyAndDecryptPadding(t *testing.T) {
+	clientKey, clientCert := newTestCert(t, 2048)
+	serverKey, serverCert := newTestCert(t, 2048)
+
+	policy := "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+	cli, srv := newTestChannels(t, policy, ua.MessageSecurityModeSignAndEncrypt, clientKey, clientCert, serverKey, serverCert)
+
+	cases := []struct {
+		name string
+		f    func(body []byte)
+	}{
+		{
+			name: "padding byte",
+			f:    func(body []byte) { body[len(body)-2] ^= 0xff },
+		},
+		{
+			name: "padding size",
+			f:    func(body []byte) { body[len(body)-1] = 0xff },
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, b := encodeTestMessage(t, cli, id.ReadRequest_Encoding_DefaultBinary)
+			h := new(MessageHeader)
+			n, err := h.decodeSecurityHeader(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// decrypt the message, modify the padding and secure it again
+			// with a valid signature.
+			p, err := srv.token.enc.Decrypt(b[n:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := append(b[:n:n], p[:len(p)-cli.token.enc.SignatureLength()]...)
+			if body[len(body)-1] == 0 {
+				t.Fatal("test message has no padding bytes")
+			}
+			tt.f(body)
+			sig, err := cli.token.enc.Signature(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := cli.token.enc.Encrypt(append(body[n:], sig...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b = append(body[:n:n], c...)
+
+			if _, err := srv.verifyAndDecrypt(h, n, b); err != ua.StatusBadSecurityChecksFailed {
+				t.Fatalf("got error %v want %v", err, ua.StatusBadSecurityChecksFailed)
+			}
+		})
+	}
+}
+
+func TestEncodeChunksMaxMessageSize(t *testing.T) {
+	clientKey, clientCert := newTestCert(t, 2048)
+	serverKey, serverCert := newTestCert(t, 2048)
+	policy := "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+
+	req := &ua.ReadRequest{
+		RequestHeader: &ua.RequestHeader{
+			AuthenticationToken: ua.NewTwoByteNodeID(0),
+			Timestamp:           time.Date(2018, time.August, 10, 23, 0, 0, 0, time.UTC),
+			AdditionalHeader:    ua.NewExtensionObject(nil),
+		},
+		TimestampsToReturn: ua.TimestampsToReturnBoth,
+	}
+	for i := 0; i < 1000; i++ {
+		req.NodesToRead = append(req.NodesToRead, &ua.ReadValueID{NodeID: ua.NewNumericNodeID(0, 2258), AttributeID: ua.IntegerIDValue, DataEncoding: &ua.QualifiedName{}})
+	}
+
+	// encode returns the size of the signed and encrypted message which
+	// is sent to a peer with the given maximum message size.
+	encode := func(max uint32) (int, error) {
+		cliConn, srvConn := newTestConns(t, &uacp.Acknowledge{ReceiveBufSize: 8192, SendBufSize: 8192, MaxMessageSize: max})
+		defer cliConn.Close()
+		defer srvConn.Close()
+
+		cli, _ := newTestChannels(t, policy, ua.MessageSecurityModeSignAndEncrypt, clientKey, clientCert, serverKey, serverCert)
+		cli.c = cliConn
+		chunks, err := cli.encodeChunks(NewMessage(req, id.ReadRequest_Encoding_DefaultBinary, cli.cfg))
+		var size int
+		for _, b := range chunks {
+			size += len(b)
+		}
+		return size, err
+	}
+
+	size, err := encode(uacp.DefaultMaxMessageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encode(uint32(size)); err != nil {
+		t.Fatalf("message with maximum size rejected: %v", err)
+	}
+	if _, err := encode(uint32(size - 1)); err != ua.StatusBadRequestTooLarge {
+		t.Fatalf("got error %v want %v", err, ua.StatusBadRequestTooLarge)
+	}
+}
+
+func TestRenewToken(t *testing.T) {
+	clientKey, clientCert := newTestCert(t, 2048)
+	serverKey, serverCert := newTestCert(t, 2048)
+
+	policy := "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+	cli, srv := newTestChannels(t, policy, ua.MessageSecurityModeSignAndEncrypt, clientKey, clientCert, serverKey, serverCert)
+
+	accepted := func(b []byte) bool {
+		h := new(MessageHeader)
+		n, err := h.decodeSecurityHeader(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cli.verifyAndDecrypt(h, n, b)
+		return err == nil
+	}
+
+	_, tok1 := encodeTestMessage(t, srv, id.ReadRequest_Encoding_DefaultBinary)
+	initTestToken(t, cli, srv, 2)
+	_, tok2 := encodeTestMessage(t, srv, id.ReadRequest_Encoding_DefaultBinary)
+
+	if got, want := cli.cfg.SecurityTokenID, uint32(2); got != want {
+		t.Fatalf("got security token %d want %d", got, want)
+	}
+	if !accepted(tok1) {
+		t.Fatal("message with previous token rejected")
+	}
+	if !accepted(tok2) {
+		t.Fatal("message with current token rejected")
+	}
+
+	// the previous token is rejected after it expired
+	cli.prevToken.expires = time.Now().Add(-time.Second)
+	if accepted(tok1) {
+		t.Fatal("message with expired token accepted")
+	}
+
+	// only the last two tokens are accepted
+	initTestToken(t, cli, srv, 3)
+	if accepted(tok1) {
+		t.Fatal("message with unknown token accepted")
+	}
+	if !accepted(tok2) {
+		t.Fatal("message with previous token rejected")
+	}
+}
+
+func TestActivateNextToken(t *testing.T) {
+	clientKey, clientCert := newTestCert(t, 2048)
+	serverKey, serverCert := newTestCert(t, 2048)
+
+	policy := "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+	cli, srv := newTestChannels(t, policy, ua.MessageSecurityModeSignAndEncrypt, clientKey, clientCert, serverKey, serverCert)
+
+	// the server has issued token 2 but keeps using token 1
+	tok := &ua.ChannelSecurityToken{TokenID: 2, RevisedLifetime: 3600000}
+	clientNonce, serverNonce := make([]byte, 32), make([]byte, 32)
+	rand.Read(clientNonce)
+	rand.Read(serverNonce)
+	next, err := srv.newSecurityToken(tok, serverNonce, clientNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.nextToken = next
+	if err := cli.initSymmetric(tok, clientNonce, serverNonce); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.cfg.SecurityTokenID, uint32(1); got != want {
+		t.Fatalf("got security token %d want %d", got, want)
+	}
+
+	// the first message with the new token is accepted without waiting
+	// for a sender which may be blocked in Write.
+	_, b := encodeTestMessage(t, cli, id.ReadRequest_Encoding_DefaultBinary)
+	h := new(MessageHeader)
+	n, err := h.decodeSecurityHeader(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.sendMu.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := srv.verifyAndDecrypt(h, n, b)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiver blocked by sender")
+	}
+	if got, want := srv.cfg.SecurityTokenID, uint32(1); got != want {
+		t.Fatalf("got security token %d want %d", got, want)
+	}
+
+	// the next sender activates the new token
+	srv.switchNextToken()
+	srv.sendMu.Unlock()
+	if got, want := srv.cfg.SecurityTokenID, uint32(2); got != want {
+		t.Fatalf("got security token %d want %d", got, want)
+	}
+	if srv.nextToken != nil {
+		t.Fatal("next token not cleared")
+	}
+}
+
+func TestSecurityTokenRenewAt(t *testing.T) {
+	now := time.Now()
+	tok := &securityToken{issued: now, expires: now.Add(time.Hour)}
+	if got, want := tok.renewAt(), now.Add(45*time.Minute); !got.Equal(want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+// testRoundTrip secures an OPN and a MSG message with the src channel and
+// verifies that dst can restore the original message.
+func testRoundTrip(t *testing.T, src, dst *SecureChannel) {
+	t.Helper()
+	for _, typeID := range []uint16{id.OpenSecureChannelRequest_Encoding_DefaultBinary, id.ReadRequest_Encoding_DefaultBinary} {
+		m, b := encodeTestMessage(t, src, typeID)
+
+		h := new(MessageHeader)
+		n, err := h.decodeSecurityHeader(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := h.Header.MessageSize, uint32(len(b)); got != want {
+			t.Fatalf("%s: got message size %d want %d", h.Header.MessageType, got, want)
+		}
+		p, err := dst.verifyAndDecrypt(h, n, b)
+		if err != nil {
+			t.Fatalf("%s: %s", h.Header.MessageType, err)
+		}
+
+		got := new(Message)
+		if _, err := got.Decode(p); err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, "", got.SequenceHeader, m.SequenceHeader)
+		verify.Values(t, "", got.Service, m.Service)
+	}
+}
+
+func encodeTestMessage(t *testing.T, s *SecureChannel, typeID uint16) (*Message, []byte) {
+	t.Helper()
+	hdr := &ua.RequestHeader{
+		AuthenticationToken: ua.NewTwoByteNodeID(0),
+		Timestamp:           time.Date(2018, time.August, 10, 23, 0, 0, 0, time.UTC),
+		RequestHandle:       1,
+		AdditionalHeader:    ua.NewExtensionObject(nil),
+	}
+
+	var svc interface{}
+	switch typeID {
+	case id.OpenSecureChannelRequest_Encoding_DefaultBinary:
+		svc = &ua.OpenSecureChannelRequest{
+			RequestHeader:     hdr,
+			RequestType:       ua.SecurityTokenRequestTypeIssue,
+			SecurityMode:      s.cfg.SecurityMode,
+			ClientNonce:       make([]byte, 32),
+			RequestedLifetime: 6000000,
+		}
+	default:
+		svc = &ua.ReadRequest{
+			RequestHeader:      hdr,
+			TimestampsToReturn: ua.TimestampsToReturnBoth,
+			NodesToRead: []*ua.ReadValueID{
+				{NodeID: ua.NewNumericNodeID(0, 2258), AttributeID: ua.IntegerIDValue, DataEncoding: &ua.QualifiedName{}},
+			},
+		}
+	}
+
+	m := NewMessage(svc, typeID, s.cfg)
+	b, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = s.signAndEncrypt(m.MessageHeader, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, b
+}
+
+func newTestChannels(t *testing.T, policy string, mode ua.MessageSecurityMode, clientKey *rsa.PrivateKey, clientCert []byte, serverKey *rsa.PrivateKey, serverCert []byte) (cli, srv *SecureChannel) {
+	t.Helper()
+	cli = &SecureChannel{cfg: &Config{
+		SecurityPolicyURI: policy,
+		SecurityMode:      mode,
+		Certificate:       clientCert,
+		LocalKey:          clientKey,
+		RemoteCertificate: serverCert,
+		SequenceNumber:    1,
+		RequestID:         1,
+	}}
+	srv = &SecureChannel{cfg: &Config{
+		SecurityPolicyURI: policy,
+		SecurityMode:      mode,
+		Certificate:       serverCert,
+		LocalKey:          serverKey,
+		RemoteCertificate: clientCert,
+		SequenceNumber:    1,
+		RequestID:         1,
+	}}
+	for _, s := range []*SecureChannel{cli, srv} {
+		if err := s.initAsymmetric(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	initTestToken(t, cli, srv, 1)
+	return cli, srv
+}
+
+// initTestToken derives new symmetric keys for the security token with
+// the given id on both channels.
+func initTestToken(t *testing.T, cli, srv *SecureChannel, id uint32) {
+	t.Helper()
+	tok := &ua.ChannelSecurityToken{TokenID: id, RevisedLifetime: 3600000}
+	clientNonce, serverNonce := make([]byte, 32), make([]byte, 32)
+	rand.Read(clientNonce)
+	rand.Read(serverNonce)
+	if err := cli.initSymmetric(tok, clientNonce, serverNonce); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.initSymmetric(tok, serverNonce, clientNonce); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestCert(t *testing.T, bits int) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gopcua test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
@@ -0,0 +1,355 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package uasc
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uacp"
+)
+
+// Handler handles a service request which a server has received on a
+// secure channel and returns the response. If it returns an error the
+// client receives a ServiceFault with the status code of the error.
+type Handler func(req interface{}) (interface{}, error)
+
+// NewServerSecureChannel creates the server side of a secure channel on
+// an accepted connection. The secure channel id and the id of the first
+// security token are taken from the configuration. The client
+// certificate is learned from the OpenSecureChannel request.
+func NewServerSecureChannel(c *uacp.Conn, cfg *Config) *SecureChannel {
+	if cfg == nil {
+		cfg = NewServerConfig("http://opcfoundation.org/UA/SecurityPolicy#None", nil, nil, uint32(1), ua.MessageSecurityModeNone, 1, 3600000)
+	}
+	chanID := cfg.SecureChannelID
+	if cfg.SequenceNumber == 0 {
+		cfg.SequenceNumber = 1
+	}
+	s := NewSecureChannel(c, cfg)
+	s.serverChanID = chanID
+	return s
+}
+
+// Accept waits for the OpenSecureChannel request of the client and issues
+// the first security token. It fails if the request does not arrive before
+// ctx is done or if the client requests a different security policy or
+// security mode than the one configured for the secure channel.
+func (s *SecureChannel) Accept(ctx context.Context) error {
+	if d, ok := ctx.Deadline(); ok {
+		if err := s.c.SetReadDeadline(d); err != nil {
+			return err
+		}
+		defer s.c.SetReadDeadline(time.Time{})
+	}
+
+	// the asymmetric algorithm for a secure channel with security is
+	// set up when the client certificate has been received.
+	if s.cfg.SecurityMode == ua.MessageSecurityModeNone || len(s.cfg.RemoteCertificate) > 0 {
+		if err := s.initAsymmetric(); err != nil {
+			return err
+		}
+	}
+
+	m, reqid, b, err := s.readRequest()
+	if err != nil {
+		return err
+	}
+	if m.Header.MessageType != MessageTypeOpenSecureChannel {
+		return fmt.Errorf("sechan: got %s message, want %s", m.Header.MessageType, MessageTypeOpenSecureChannel)
+	}
+	_, req, err := ua.DecodeService(b)
+	if err != nil {
+		return err
+	}
+	return s.handleOpen(m, reqid, req)
+}
+
+// Serve reads the service requests from the secure channel and calls h
+// for each of them in a separate goroutine. Requests for renewing the
+// security token are handled by Serve. It returns nil when the client has
+// closed the secure channel or the connection after all handlers have
+// returned. The connection is not closed.
+func (s *SecureChannel) Serve(h Handler) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		m, reqid, b, err := s.readRequest()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, req, err := ua.DecodeService(b)
+		if err != nil {
+			s.log.Warn("sechan: decode request failed", "conn", s.c.ID(), "reqid", reqid, "err", err)
+			if err := s.sendResponse(reqid, nil, NewServiceFault(ua.StatusBadServiceUnsupported)); err != nil {
+				return err
+			}
+			continue
+		}
+		s.log.Debug("sechan: recv", "conn", s.c.ID(), "reqid", reqid, "type", fmt.Sprintf("%T", req))
+
+		switch m.Header.MessageType {
+		case MessageTypeOpenSecureChannel:
+			if err := s.handleOpen(m, reqid, req); err != nil {
+				return err
+			}
+
+		case MessageTypeCloseSecureChannel:
+			atomic.StoreInt32(&s.state, secureChannelClosed)
+			s.quitOnce.Do(func() { close(s.quit) })
+			return nil
+
+		default:
+			wg.Add(1)
+			go func(reqid uint32, req interface{}) {
+				defer wg.Done()
+				resp, err := h(req)
+				if err != nil {
+					resp = NewServiceFault(err)
+				}
+				if err := s.sendResponse(reqid, RequestHeader(req), resp); err != nil {
+					s.log.Error("sechan: send response failed", "conn", s.c.ID(), "reqid", reqid, "err", err)
+				}
+			}(reqid, req)
+		}
+	}
+}
+
+// handleOpen issues a new security token for the OpenSecureChannel
+// request. A renewed token is used for sending when the client has
+// used it for the first time.
+func (s *SecureChannel) handleOpen(m *MessageHeader, reqid uint32, v interface{}) error {
+	req, ok := v.(*ua.OpenSecureChannelRequest)
+	if !ok {
+		return fmt.Errorf("sechan: got %T, want OpenSecureChannelRequest", v)
+	}
+
+	// the secure channel cannot be used when the request is rejected.
+	// The client is notified with an ERR message.
+	reject := func(code ua.StatusCode) error {
+		if err := s.c.SendError(code, ""); err != nil {
+			s.log.Warn("sechan: send error failed", "conn", s.c.ID(), "err", err)
+		}
+		return code
+	}
+
+	open := atomic.LoadInt32(&s.state) == secureChannelOpen
+	switch {
+	case req.RequestType == ua.SecurityTokenRequestTypeIssue && !open:
+	case req.RequestType == ua.SecurityTokenRequestTypeRenew && open:
+	default:
+		return reject(ua.StatusBadRequestTypeInvalid)
+	}
+	if m.SecurityPolicyURI != s.cfg.SecurityPolicyURI {
+		return reject(ua.StatusBadSecurityPolicyRejected)
+	}
+	if req.SecurityMode != s.cfg.SecurityMode {
+		return reject(ua.StatusBadSecurityModeRejected)
+	}
+
+	var nonce []byte
+	if n := s.asymEnc.NonceLength(); n > 0 {
+		nonce = make([]byte, n)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+	}
+
+	lifetime := req.RequestedLifetime
+	if s.cfg.Lifetime > 0 && (lifetime == 0 || lifetime > s.cfg.Lifetime) {
+		lifetime = s.cfg.Lifetime
+	}
+
+	tokenID := s.cfg.SecurityTokenID
+	s.encMu.RLock()
+	if s.nextToken != nil {
+		tokenID = s.nextToken.id
+	}
+	s.encMu.RUnlock()
+	if open || tokenID == 0 {
+		tokenID++
+	}
+	if !open {
+		s.cfg.SecureChannelID = s.serverChanID
+	}
+
+	tok := &ua.ChannelSecurityToken{
+		ChannelID:       s.cfg.SecureChannelID,
+		TokenID:         tokenID,
+		CreatedAt:       time.Now(),
+		RevisedLifetime: lifetime,
+	}
+	t, err := s.newSecurityToken(tok, nonce, req.ClientNonce)
+	if err != nil {
+		return err
+	}
+
+	resp := &ua.OpenSecureChannelResponse{
+		ServerProtocolVersion: 0,
+		SecurityToken:         tok,
+		ServerNonce:           nonce,
+	}
+
+	if !open {
+		s.activateToken(t)
+		if err := s.sendResponse(reqid, req.RequestHeader, resp); err != nil {
+			return err
+		}
+		atomic.StoreInt32(&s.state, secureChannelOpen)
+		return nil
+	}
+
+	s.encMu.Lock()
+	s.nextToken = t
+	s.encMu.Unlock()
+	return s.sendResponse(reqid, req.RequestHeader, resp)
+}
+
+// readRequest reads the chunks of the next complete request and returns
+// the message header of the final chunk, the request id and the body.
+// Aborted requests are dropped.
+func (s *SecureChannel) readRequest() (*MessageHeader, uint32, []byte, error) {
+	// chunks maps request id to message chunks
+	chunks := map[uint32][]*MessageChunk{}
+
+	for {
+		chunk, err := s.readchunk()
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		hdr := chunk.Header
+		reqid := chunk.SequenceHeader.RequestID
+		s.log.Debug("sechan: recv chunk", "conn", s.c.ID(), "reqid", reqid, "type", hdr.MessageType+string(hdr.ChunkType), "size", hdr.MessageSize)
+
+		switch hdr.ChunkType {
+		case ChunkTypeError:
+			delete(chunks, reqid)
+			continue
+
+		case 'C':
+			chunks[reqid] = append(chunks[reqid], chunk)
+			if n := uint32(len(chunks[reqid])); s.c.MaxChunkCount() > 0 && n > s.c.MaxChunkCount() {
+				return nil, 0, nil, fmt.Errorf("sechan: too many chunks: %d > %d", n, s.c.MaxChunkCount())
+			}
+			continue
+		}
+
+		all := append(chunks[reqid], chunk)
+		delete(chunks, reqid)
+		b, err := mergeChunks(all)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if n := uint32(len(b)); s.c.MaxMessageSize() > 0 && n > s.c.MaxMessageSize() {
+			return nil, 0, nil, fmt.Errorf("sechan: message too large: %d > %d", n, s.c.MaxMessageSize())
+		}
+		return chunk.MessageHeader, reqid, b, nil
+	}
+}
+
+// sendResponse sends the response for the request with the given request
+// id. The response header is filled in from the request header. A response
+// which exceeds the limits of the client is replaced with a ServiceFault.
+func (s *SecureChannel) sendResponse(reqid uint32, reqhdr *ua.RequestHeader, resp interface{}) error {
+	typeID := ua.TypeID(resp)
+	if typeID == 0 {
+		return fmt.Errorf("unknown service %T. Did you call register?", resp)
+	}
+
+	// the response header is always the first field
+	val := reflect.ValueOf(resp).Elem().Field(0)
+	hdr, _ := val.Interface().(*ua.ResponseHeader)
+	if hdr == nil {
+		hdr = &ua.ResponseHeader{}
+		val.Set(reflect.ValueOf(hdr))
+	}
+	hdr.Timestamp = time.Now()
+	if reqhdr != nil {
+		hdr.RequestHandle = reqhdr.RequestHandle
+	}
+	if hdr.ServiceDiagnostics == nil {
+		hdr.ServiceDiagnostics = &ua.DiagnosticInfo{}
+	}
+	if hdr.AdditionalHeader == nil {
+		hdr.AdditionalHeader = ua.NewExtensionObject(nil)
+	}
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	s.switchNextToken()
+
+	m := NewMessage(resp, typeID, s.cfg)
+	m.SequenceHeader.RequestID = reqid
+	chunks, err := s.encodeChunks(m)
+	if err == ua.StatusBadRequestTooLarge {
+		fault := NewServiceFault(ua.StatusBadResponseTooLarge)
+		fault.ResponseHeader.Timestamp = hdr.Timestamp
+		fault.ResponseHeader.RequestHandle = hdr.RequestHandle
+		m = NewMessage(fault, ua.TypeID(fault), s.cfg)
+		m.SequenceHeader.RequestID = reqid
+		chunks, err = s.encodeChunks(m)
+	}
+	if err != nil {
+		return err
+	}
+
+	var size int
+	for _, b := range chunks {
+		if _, err := s.c.Write(b); err != nil {
+			return err
+		}
+		size += len(b)
+	}
+	for range chunks {
+		s.cfg.SequenceNumber = nextSequenceNumber(s.cfg.SequenceNumber)
+	}
+	s.log.Debug("sechan: send", "conn", s.c.ID(), "reqid", reqid, "type", fmt.Sprintf("%T", resp), "size", size, "chunks", len(chunks))
+	return nil
+}
+
+// NewServiceFault returns the ServiceFault for the error of a service
+// handler. The status code of a ua.StatusCode or a *ua.ServiceError is
+// returned to the client. All other errors are reported as
+// StatusBadInternalError.
+func NewServiceFault(err error) *ua.ServiceFault {
+	code := ua.StatusBadInternalError
+	switch e := err.(type) {
+	case ua.StatusCode:
+		code = e
+	case *ua.ServiceError:
+		code = e.StatusCode
+	}
+	return &ua.ServiceFault{
+		ResponseHeader: &ua.ResponseHeader{
+			ServiceResult:      code,
+			ServiceDiagnostics: &ua.DiagnosticInfo{},
+			AdditionalHeader:   ua.NewExtensionObject(nil),
+		},
+	}
+}
+
+// RequestHeader returns the request header of a service request or nil.
+// The request header is always the first field of a request.
+func RequestHeader(req interface{}) *ua.RequestHeader {
+	val := reflect.ValueOf(req)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct || val.Elem().NumField() == 0 {
+		return nil
+	}
+	hdr, _ := val.Elem().Field(0).Interface().(*ua.RequestHeader)
+	return hdr
+}
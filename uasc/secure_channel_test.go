@@ -0,0 +1,586 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package uasc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/logger"
+	"github.com/gopcua/opcua/ua"
+	"github.com/gopcua/opcua/uacp"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// newTestConns returns a connected pair of client and server connections
+// where the server accepts chunks of at most 8192 bytes.
+func newTestConns(t *testing.T, ack *uacp.Acknowledge) (cli, srv *uacp.Conn) {
+	t.Helper()
+	ep := "opc.tcp://127.0.0.1:48401/foo/bar"
+	ln, err := uacp.Listen(ep, ack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type result struct {
+		c   *uacp.Conn
+		err error
+	}
+	ch := make(chan result)
+	go func() {
+		c, err := ln.Accept(context.Background())
+		ch <- result{c, err}
+	}()
+
+	cli, err = uacp.Dial(context.Background(), ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		return cli, r.c
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+	return nil, nil
+}
+
+func TestSendChunks(t *testing.T) {
+	cliConn, srvConn := newTestConns(t, &uacp.Acknowledge{
+		ReceiveBufSize: 8192,
+		SendBufSize:    8192,
+		MaxMessageSize: 64 * uacp.KB,
+		MaxChunkCount:  10,
+	})
+	defer cliConn.Close()
+	defer srvConn.Close()
+
+	cli := NewSecureChannel(cliConn, nil)
+	srv := NewSecureChannel(srvConn, nil)
+
+	req := &ua.WriteRequest{
+		NodesToWrite: []*ua.WriteValue{
+			{
+				NodeID:      ua.NewNumericNodeID(0, 2258),
+				AttributeID: ua.IntegerIDValue,
+				Value: &ua.DataValue{
+					EncodingMask: ua.DataValueValue,
+					Value:        ua.MustVariant(bytes.Repeat([]byte{0xab}, 30000)),
+				},
+			},
+		},
+	}
+	if _, err := cli.SendAsync(req); err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks []*MessageChunk
+	for {
+		c, err := srv.readchunk()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Header.MessageSize > 8192 {
+			t.Fatalf("chunk has %d bytes, want at most 8192", c.Header.MessageSize)
+		}
+		chunks = append(chunks, c)
+		if c.Header.ChunkType == ChunkTypeFinal {
+			break
+		}
+		if c.Header.ChunkType != ChunkTypeIntermediate {
+			t.Fatalf("got chunk type %c want %c", c.Header.ChunkType, ChunkTypeIntermediate)
+		}
+	}
+
+	if got, want := len(chunks), 4; got != want {
+		t.Fatalf("got %d chunks want %d", got, want)
+	}
+	for i, c := range chunks {
+		if got, want := c.SequenceHeader.SequenceNumber, chunks[0].SequenceHeader.SequenceNumber+uint32(i); got != want {
+			t.Fatalf("chunk %d: got sequence number %d want %d", i, got, want)
+		}
+		if got, want := c.SequenceHeader.RequestID, chunks[0].SequenceHeader.RequestID; got != want {
+			t.Fatalf("chunk %d: got request id %d want %d", i, got, want)
+		}
+	}
+
+	b, err := mergeChunks(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, svc, err := ua.DecodeService(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := svc.(*ua.WriteRequest)
+	if !ok {
+		t.Fatalf("got %T want *ua.WriteRequest", svc)
+	}
+	verify.Values(t, "", got.NodesToWrite, req.NodesToWrite)
+
+	// the next message continues with the next sequence number
+	if _, err := cli.SendAsync(&ua.ReadRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := srv.readchunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.SequenceHeader.SequenceNumber, chunks[3].SequenceHeader.SequenceNumber+1; got != want {
+		t.Fatalf("got sequence number %d want %d", got, want)
+	}
+	if got, want := c.SequenceHeader.RequestID, chunks[0].SequenceHeader.RequestID+1; got != want {
+		t.Fatalf("got request id %d want %d", got, want)
+	}
+}
+
+func TestSendChunksLimits(t *testing.T) {
+	cliConn, srvConn := newTestConns(t, &uacp.Acknowledge{
+		ReceiveBufSize: 8192,
+		SendBufSize:    8192,
+		MaxMessageSize: 64 * uacp.KB,
+		MaxChunkCount:  4,
+	})
+	defer cliConn.Close()
+	defer srvConn.Close()
+
+	cli := NewSecureChannel(cliConn, nil)
+
+	newReq := func(n int) *ua.WriteRequest {
+		return &ua.WriteRequest{
+			NodesToWrite: []*ua.WriteValue{
+				{
+					NodeID:      ua.NewNumericNodeID(0, 2258),
+					AttributeID: ua.IntegerIDValue,
+					Value: &ua.DataValue{
+						EncodingMask: ua.DataValueValue,
+						Value:        ua.MustVariant(make([]byte, n)),
+					},
+				},
+			},
+		}
+	}
+
+	if _, err := cli.SendAsync(newReq(40000)); err != ua.StatusBadRequestTooLarge {
+		t.Fatalf("got error %v want %v", err, ua.StatusBadRequestTooLarge)
+	}
+	if _, err := cli.SendAsync(newReq(100000)); err != ua.StatusBadRequestTooLarge {
+		t.Fatalf("got error %v want %v", err, ua.StatusBadRequestTooLarge)
+	}
+}
+
+func TestSendWithContext(t *testing.T) {
+	cliConn, srvConn := newTestConns(t, &uacp.Acknowledge{
+		ReceiveBufSize: 8192,
+		SendBufSize:    8192,
+	})
+	defer cliConn.Close()
+	defer srvConn.Close()
+
+	cli := NewSecureChannel(cliConn, nil)
+	srv := NewSecureChannel(srvConn, nil)
+	atomic.StoreInt32(&cli.state, secureChannelOpen)
+	go cli.recv()
+
+	t.Run("response", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		done := make(chan error)
+		go func() {
+			done <- cli.SendWithContext(ctx, &ua.ReadRequest{}, func(v interface{}) error {
+				if _, ok := v.(*ua.ReadResponse); !ok {
+					return fmt.Errorf("got %T want *ua.ReadResponse", v)
+				}
+				return nil
+			})
+		}()
+
+		req, reqid := readTestRequest(t, srv)
+		hint := req.(*ua.ReadRequest).RequestHeader.TimeoutHint
+		if hint == 0 || hint > 10000 {
+			t.Fatalf("got timeout hint %d want 0 < hint <= 10000", hint)
+		}
+		writeTestResponse(t, srv, reqid, &ua.ReadResponse{})
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		done := make(chan error)
+		go func() {
+			done <- cli.SendWithContext(ctx, &ua.ReadRequest{}, func(interface{}) error { return nil })
+		}()
+
+		req, _ := readTestRequest(t, srv)
+		handle := req.(*ua.ReadRequest).RequestHeader.RequestHandle
+		if err := <-done; err != ua.StatusBadTimeout {
+			t.Fatalf("got error %v want %v", err, ua.StatusBadTimeout)
+		}
+
+		// the server is asked to cancel the request
+		req, _ = readTestRequest(t, srv)
+		cr, ok := req.(*ua.CancelRequest)
+		if !ok {
+			t.Fatalf("got %T want *ua.CancelRequest", req)
+		}
+		if got, want := cr.RequestHandle, handle; got != want {
+			t.Fatalf("got request handle %d want %d", got, want)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		done := make(chan error)
+		go func() {
+			done <- cli.SendWithContext(ctx, &ua.ReadRequest{}, func(interface{}) error { return nil })
+		}()
+
+		req, _ := readTestRequest(t, srv)
+		handle := req.(*ua.ReadRequest).RequestHeader.RequestHandle
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Fatalf("got error %v want %v", err, context.Canceled)
+		}
+
+		req, _ = readTestRequest(t, srv)
+		if got, want := req.(*ua.CancelRequest).RequestHandle, handle; got != want {
+			t.Fatalf("got request handle %d want %d", got, want)
+		}
+	})
+
+	t.Run("expired handler", func(t *testing.T) {
+		deadline := time.Now().Add(time.Minute)
+		ch, _, err := cli.sendAsync(&ua.ReadRequest{}, deadline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readTestRequest(t, srv)
+
+		cli.expireHandlers(time.Now())
+		select {
+		case <-ch:
+			t.Fatal("handler expired before its deadline")
+		default:
+		}
+
+		cli.expireHandlers(deadline.Add(time.Millisecond))
+		if resp := <-ch; resp.Err != ua.StatusBadTimeout {
+			t.Fatalf("got error %v want %v", resp.Err, ua.StatusBadTimeout)
+		}
+	})
+
+	t.Run("no deadline", func(t *testing.T) {
+		ch, err := cli.SendAsync(&ua.ReadRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, reqid := readTestRequest(t, srv)
+
+		cli.expireHandlers(time.Now().Add(24 * time.Hour))
+		select {
+		case resp := <-ch:
+			t.Fatalf("request without deadline expired: %v", resp.Err)
+		default:
+		}
+
+		writeTestResponse(t, srv, reqid, &ua.ReadResponse{})
+		if resp := <-ch; resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		ch, err := cli.SendAsync(&ua.ReadRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		readTestRequest(t, srv)
+		srvConn.Close()
+
+		select {
+		case resp := <-ch:
+			if resp.Err != ua.StatusBadSecureChannelClosed {
+				t.Fatalf("got error %v want %v", resp.Err, ua.StatusBadSecureChannelClosed)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out")
+		}
+	})
+}
+
+// readTestRequest reads a request from the secure channel and returns the
+// decoded service and the request id.
+func readTestRequest(t *testing.T, s *SecureChannel) (interface{}, uint32) {
+	t.Helper()
+	var chunks []*MessageChunk
+	for {
+		c, err := s.readchunk()
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, c)
+		if c.Header.ChunkType == ChunkTypeFinal {
+			break
+		}
+	}
+	b, err := mergeChunks(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, svc, err := ua.DecodeService(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc, chunks[0].SequenceHeader.RequestID
+}
+
+// writeTestResponse sends the response for the request with the given id.
+func writeTestResponse(t *testing.T, s *SecureChannel, reqid uint32, svc interface{}) {
+	t.Helper()
+	reflect.ValueOf(svc).Elem().Field(0).Set(reflect.ValueOf(&ua.ResponseHeader{
+		Timestamp:          time.Now(),
+		ServiceDiagnostics: &ua.DiagnosticInfo{},
+		StringTable:        []string{},
+		AdditionalHeader:   ua.NewExtensionObject(nil),
+	}))
+	cfg := *s.cfg
+	cfg.RequestID = reqid
+	b, err := NewMessage(svc, ua.TypeID(svc), &cfg).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.c.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecvError(t *testing.T) {
+	cliConn, srvConn := newTestConns(t, &uacp.Acknowledge{
+		ReceiveBufSize: 8192,
+		SendBufSize:    8192,
+	})
+	defer cliConn.Close()
+	defer srvConn.Close()
+
+	cli := NewSecureChannel(cliConn, nil)
+	srv := NewSecureChannel(srvConn, nil)
+	atomic.StoreInt32(&cli.state, secureChannelOpen)
+	go cli.recv()
+
+	var pending []chan Response
+	for i := 0; i < 2; i++ {
+		ch, err := cli.SendAsync(&ua.ReadRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		readTestRequest(t, srv)
+		pending = append(pending, ch)
+	}
+
+	body, err := ua.Encode(uacp.NewError(uacp.BadTCPMessageTooLarge, "too large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := (&uacp.Header{MessageType: uacp.MessageTypeError, ChunkType: ChunkTypeFinal, MessageSize: uint32(8 + len(body))}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srvConn.Write(append(h, body...)); err != nil {
+		t.Fatal(err)
+	}
+
+	// all pending requests fail with the error
+	want := &uacp.ProtocolError{Code: uacp.BadTCPMessageTooLarge, Reason: "too large"}
+	for _, ch := range pending {
+		select {
+		case resp := <-ch:
+			verify.Values(t, "", resp.Err, want)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out")
+		}
+	}
+
+	// and the secure channel is closed
+	if got, want := atomic.LoadInt32(&cli.state), secureChannelClosed; got != want {
+		t.Fatalf("got state %d want %d", got, want)
+	}
+	if _, err := cliConn.Write([]byte{0}); err == nil {
+		t.Fatal("connection not closed")
+	}
+	select {
+	case <-cli.Done():
+		verify.Values(t, "", cli.Err(), want)
+	default:
+		t.Fatal("done not closed")
+	}
+	if err := cli.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecvConnectionLost(t *testing.T) {
+	cliConn, srvConn := newTestConns(t, &uacp.Acknowledge{
+		ReceiveBufSize: 8192,
+		SendBufSize:    8192,
+	})
+	defer cliConn.Close()
+
+	cli := NewSecureChannel(cliConn, nil)
+	srv := NewSecureChannel(srvConn, nil)
+	atomic.StoreInt32(&cli.state, secureChannelOpen)
+	go cli.recv()
+
+	if err := cli.Err(); err != nil {
+		t.Fatalf("got error %v before failure", err)
+	}
+
+	ch, err := cli.SendAsync(&ua.ReadRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readTestRequest(t, srv)
+	srvConn.Close()
+
+	select {
+	case resp := <-ch:
+		if resp.Err != ua.StatusBadSecureChannelClosed {
+			t.Fatalf("got error %v want %v", resp.Err, ua.StatusBadSecureChannelClosed)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	select {
+	case <-cli.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("done not closed")
+	}
+	if got, want := cli.Err(), ua.StatusBadSecureChannelClosed; got != want {
+		t.Fatalf("got error %v want %v", got, want)
+	}
+
+	// new requests fail immediately
+	if _, err := cli.SendAsync(&ua.ReadRequest{}); err != ua.StatusBadSecureChannelClosed {
+		t.Fatalf("got error %v want %v", err, ua.StatusBadSecureChannelClosed)
+	}
+}
+
+func TestRecvAbort(t *testing.T) {
+	cliConn, srvConn := newTestConns(t, &uacp.Acknowledge{
+		ReceiveBufSize: 8192,
+		SendBufSize:    8192,
+	})
+	defer cliConn.Close()
+	defer srvConn.Close()
+
+	cli := NewSecureChannel(cliConn, nil)
+	srv := NewSecureChannel(srvConn, nil)
+	atomic.StoreInt32(&cli.state, secureChannelOpen)
+	go cli.recv()
+
+	ch1, err := cli.SendAsync(&ua.ReadRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, reqid1 := readTestRequest(t, srv)
+
+	ch2, err := cli.SendAsync(&ua.ReadRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, reqid2 := readTestRequest(t, srv)
+
+	// abort the response to the second request
+	var b []byte
+	for _, v := range []interface{}{
+		NewSymmetricSecurityHeader(srv.cfg.SecurityTokenID),
+		NewSequenceHeader(srv.cfg.SequenceNumber, reqid2),
+		uacp.NewError(uacp.BadRequestTimeout, "aborted"),
+	} {
+		x, err := ua.Encode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(b, x...)
+	}
+	h := NewHeader(MessageTypeMessage, ChunkTypeError, srv.cfg.SecureChannelID)
+	h.MessageSize = uint32(hdrlen + len(b))
+	hb, err := h.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srvConn.Write(append(hb, b...)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case resp := <-ch2:
+		verify.Values(t, "", resp.Err, &uacp.ProtocolError{Code: uacp.BadRequestTimeout, Reason: "aborted"})
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	// the first request is still pending
+	writeTestResponse(t, srv, reqid1, &ua.ReadResponse{})
+	select {
+	case resp := <-ch1:
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+}
+
+func TestSecureChannelLogger(t *testing.T) {
+	cliConn, srvConn := newTestConns(t, nil)
+	defer cliConn.Close()
+	defer srvConn.Close()
+
+	var connLog, cfgLog bytes.Buffer
+	cliConn.SetLogger(logger.NewStd(log.New(&connLog, "", 0), logger.LevelDebug))
+
+	// without a logger in the config the connection logger is used
+	s := NewSecureChannel(cliConn, nil)
+	if _, err := s.SendAsync(&ua.ReadRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(connLog.String(), "DEBUG sechan: send conn=") {
+		t.Fatalf("got log %q", connLog.String())
+	}
+
+	cfg := NewClientConfigSecurityNone(1, 3600000)
+	cfg.Logger = logger.NewStd(log.New(&cfgLog, "", 0), logger.LevelDebug)
+	connLog.Reset()
+	s = NewSecureChannel(cliConn, cfg)
+	if _, err := s.SendAsync(&ua.ReadRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if connLog.Len() != 0 {
+		t.Fatalf("got log %q on connection logger", connLog.String())
+	}
+	if !strings.Contains(cfgLog.String(), "DEBUG sechan: send conn=") {
+		t.Fatalf("got log %q", cfgLog.String())
+	}
+}
@@ -6,10 +6,26 @@ package uasc
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/gopcua/opcua/ua"
 )
 
+// maxSequenceNumber is the largest sequence number before it wraps around.
+//
+// Specification: Part 6, 6.7.2.4
+const maxSequenceNumber = math.MaxUint32 - 1024
+
+// nextSequenceNumber returns the sequence number which follows n.
+// Sequence numbers wrap around to a value below 1024 after
+// maxSequenceNumber has been used.
+func nextSequenceNumber(n uint32) uint32 {
+	if n >= maxSequenceNumber {
+		return 1
+	}
+	return n + 1
+}
+
 // SequenceHeader represents a Sequence Header in OPC UA Secure Conversation.
 type SequenceHeader struct {
 	SequenceNumber uint32
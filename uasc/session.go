@@ -1,6 +1,8 @@
 package uasc
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"fmt"
 	"time"
@@ -12,34 +14,54 @@ type Session struct {
 	sechan *SecureChannel
 	cfg    *SessionConfig
 
+	// authToken identifies the session in the request headers. It is
+	// sent again when the session is activated on a new secure channel.
+	authToken *ua.NodeID
+
 	maxRequestMessageSize uint32
 
-	// mySignature is is the client/serverSignature expected to receive from the other endpoint.
-	// This parameter is automatically calculated and kept temporarily until being used to verify
-	// received client/serverSignature.
-	mySignature *ua.SignatureData
+	// serverNonce is the last nonce of the server. It is returned by
+	// CreateSession and ActivateSession and used to encrypt the secrets
+	// of the user identity token for the next activation.
+	serverNonce []byte
 
-	// signatureToSend is the client/serverSignature defined in Part4, Table 15 and Table 17.
-	// This parameter is automatically calculated and kept temporarily until it is sent in next message.
-	signatureToSend *ua.SignatureData
+	// serverCertificate is the certificate of the server returned by
+	// CreateSession.
+	serverCertificate []byte
 }
 
 func NewSession(sechan *SecureChannel, cfg *SessionConfig) *Session {
 	return &Session{sechan: sechan, cfg: cfg}
 }
 
-func (s *Session) Open() error {
-	if err := s.createSession(); err != nil {
+// Open creates and activates the session. It waits for the
+// responses until ctx is done.
+func (s *Session) Open(ctx context.Context) error {
+	if err := s.createSession(ctx); err != nil {
 		return err
 	}
-	return s.activateSession()
+	return s.activateSession(ctx)
+}
+
+// Activate activates the session on a new secure channel, e.g. after the
+// connection of the previous one has failed. This fails if the server has
+// removed the session since its timeout has expired. It waits for the
+// response until ctx is done.
+//
+// Specification: Part 4, 5.6.3
+func (s *Session) Activate(ctx context.Context, sechan *SecureChannel) error {
+	sechan.sendMu.Lock()
+	sechan.reqhdr.AuthenticationToken = s.authToken
+	sechan.sendMu.Unlock()
+	s.sechan = sechan
+	return s.activateSession(ctx)
 }
 
 func (s *Session) Close() error {
 	return nil
 }
 
-func (s *Session) createSession() error {
+func (s *Session) createSession(ctx context.Context) error {
 	nonce := make([]byte, 32)
 	if _, err := rand.Read(nonce); err != nil {
 		return err
@@ -53,37 +75,63 @@ func (s *Session) createSession() error {
 		ClientCertificate: s.sechan.cfg.Certificate,
 	}
 
-	return s.sechan.Send(req, func(v interface{}) error {
+	return s.sechan.SendWithContext(ctx, req, func(v interface{}) error {
 		resp, ok := v.(*ua.CreateSessionResponse)
 		if !ok {
 			return fmt.Errorf("invalid response. Got %T, want CreateSessionResponse", v)
 		}
 
+		// the server proves the possession of the private key of its
+		// certificate with the signature of our certificate and nonce.
+		if s.sechan.isSigned() && !bytes.Equal(resp.ServerCertificate, s.sechan.cfg.RemoteCertificate) {
+			return fmt.Errorf("session: server certificate does not match the certificate of the secure channel")
+		}
+		if err := s.sechan.VerifySessionSignature(s.sechan.cfg.Certificate, nonce, resp.ServerSignature); err != nil {
+			return err
+		}
+		if verify := s.sechan.cfg.VerifyApplicationURI; verify != nil && len(resp.ServerCertificate) > 0 {
+			appURI := serverURI(resp.ServerEndpoints)
+			if appURI == "" {
+				return fmt.Errorf("session: server endpoints have no application uri")
+			}
+			if err := verify(resp.ServerCertificate, appURI); err != nil {
+				return err
+			}
+		}
+		if len(s.cfg.DiscoveryEndpoints) > 0 {
+			if err := checkEndpoints(s.cfg.DiscoveryEndpoints, resp.ServerEndpoints); err != nil {
+				return err
+			}
+		}
+
+		s.authToken = resp.AuthenticationToken
 		s.sechan.reqhdr.AuthenticationToken = resp.AuthenticationToken
 		s.cfg.ServerEndpoints = resp.ServerEndpoints
 		s.cfg.SessionTimeout = resp.RevisedSessionTimeout
-		// todo(fs): fix crypto: calculate signature data
-		// s.signatureToSend = ua.NewSignatureDataFrom(resp.ServerCertificate, resp.ServerNonce)
-		s.signatureToSend = &ua.SignatureData{}
 		s.maxRequestMessageSize = resp.MaxRequestMessageSize
-
-		// todo(fs): fix crypto
-		// keep SignatureData to verify serverSignature in CreateSessionResponse.
-		// s.mySignature = ua.NewSignatureDataFrom(s.sechan.cfg.Certificate, nonce)
-		s.mySignature = &ua.SignatureData{}
+		s.serverNonce = resp.ServerNonce
+		s.serverCertificate = resp.ServerCertificate
 		return nil
 	})
 }
 
-func (s *Session) activateSession() error {
+func (s *Session) activateSession(ctx context.Context) error {
+	tok, sig, err := s.identityToken()
+	if err != nil {
+		return err
+	}
+	clientSig, err := s.sechan.NewSessionSignature(s.serverCertificate, s.serverNonce)
+	if err != nil {
+		return err
+	}
 	req := &ua.ActivateSessionRequest{
-		ClientSignature:            s.signatureToSend,
+		ClientSignature:            clientSig,
 		ClientSoftwareCertificates: nil,
 		LocaleIDs:                  s.cfg.LocaleIDs,
-		UserIdentityToken:          ua.NewExtensionObject(s.cfg.UserIdentityToken),
-		UserTokenSignature:         s.cfg.UserTokenSignature,
+		UserIdentityToken:          ua.NewExtensionObject(tok),
+		UserTokenSignature:         sig,
 	}
-	return s.sechan.Send(req, func(v interface{}) error {
+	return s.sechan.SendWithContext(ctx, req, func(v interface{}) error {
 		resp, ok := v.(*ua.ActivateSessionResponse)
 		if !ok {
 			return fmt.Errorf("invalid response. Got %T, want ActivateSessionResponse", v)
@@ -94,6 +142,62 @@ func (s *Session) activateSession() error {
 				return fmt.Errorf("rejected")
 			}
 		}
+		s.serverNonce = resp.ServerNonce
 		return nil
 	})
 }
+
+// serverURI returns the ApplicationURI of the server from its endpoints.
+func serverURI(eps []*ua.EndpointDescription) string {
+	for _, ep := range eps {
+		if ep != nil && ep.Server != nil && ep.Server.ApplicationURI != "" {
+			return ep.Server.ApplicationURI
+		}
+	}
+	return ""
+}
+
+// checkEndpoints returns an error if the endpoints returned by
+// CreateSession do not match the endpoints returned by GetEndpoints. Only
+// the parameters which the server should return in both cases are
+// compared. This detects a modification of the unsecured GetEndpoints
+// response, e.g. to downgrade the security.
+//
+// Specification: Part 4, 5.6.2.2
+func checkEndpoints(discovered, returned []*ua.EndpointDescription) error {
+	if len(discovered) != len(returned) {
+		return fmt.Errorf("session: got %d server endpoints, discovered %d", len(returned), len(discovered))
+	}
+	have := make(map[string]int)
+	for _, ep := range returned {
+		have[endpointKey(ep)]++
+	}
+	for _, ep := range discovered {
+		k := endpointKey(ep)
+		if have[k] == 0 {
+			return fmt.Errorf("session: server endpoint %s with security policy %s and mode %d does not match the discovered endpoints",
+				ep.EndpointURL, ep.SecurityPolicyURI, ep.SecurityMode)
+		}
+		have[k]--
+	}
+	return nil
+}
+
+// endpointKey returns the parameters of the endpoint which are compared
+// by checkEndpoints.
+func endpointKey(ep *ua.EndpointDescription) string {
+	if ep == nil {
+		return ""
+	}
+	var appURI string
+	if ep.Server != nil {
+		appURI = ep.Server.ApplicationURI
+	}
+	k := fmt.Sprintf("%s|%s|%d|%s|%s|%d", appURI, ep.EndpointURL, ep.SecurityMode, ep.SecurityPolicyURI, ep.TransportProfileURI, ep.SecurityLevel)
+	for _, p := range ep.UserIdentityTokens {
+		if p != nil {
+			k += fmt.Sprintf("|%s|%d|%s", p.PolicyID, p.TokenType, p.SecurityPolicyURI)
+		}
+	}
+	return k
+}
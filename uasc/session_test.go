@@ -0,0 +1,166 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package uasc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/securitypolicy"
+	"github.com/gopcua/opcua/ua"
+)
+
+func TestCheckEndpoints(t *testing.T) {
+	ep := func(policy string, mode ua.MessageSecurityMode, level uint8) *ua.EndpointDescription {
+		return &ua.EndpointDescription{
+			EndpointURL:       "opc.tcp://example.com:4840",
+			Server:            &ua.ApplicationDescription{ApplicationURI: "urn:example"},
+			SecurityPolicyURI: policy,
+			SecurityMode:      mode,
+			SecurityLevel:     level,
+			UserIdentityTokens: []*ua.UserTokenPolicy{
+				{PolicyID: "anonymous", TokenType: ua.UserTokenTypeAnonymous},
+			},
+			TransportProfileURI: "http://opcfoundation.org/UA-Profile/Transport/uatcp-uasc-uabinary",
+		}
+	}
+	const (
+		none  = "http://opcfoundation.org/UA/SecurityPolicy#None"
+		basic = "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+	)
+	discovered := []*ua.EndpointDescription{
+		ep(none, ua.MessageSecurityModeNone, 0),
+		ep(basic, ua.MessageSecurityModeSignAndEncrypt, 10),
+	}
+
+	// the server should not return the certificate and the description
+	// of the application in the CreateSession response
+	reduced := ep(basic, ua.MessageSecurityModeSignAndEncrypt, 10)
+	reduced.ServerCertificate = []byte("cert")
+	reduced.Server.ApplicationName = &ua.LocalizedText{Text: "example"}
+
+	changedTokens := ep(basic, ua.MessageSecurityModeSignAndEncrypt, 10)
+	changedTokens.UserIdentityTokens[0].SecurityPolicyURI = none
+
+	tests := []struct {
+		name     string
+		returned []*ua.EndpointDescription
+		ok       bool
+	}{
+		{"same", []*ua.EndpointDescription{ep(none, ua.MessageSecurityModeNone, 0), ep(basic, ua.MessageSecurityModeSignAndEncrypt, 10)}, true},
+		{"other order", []*ua.EndpointDescription{ep(basic, ua.MessageSecurityModeSignAndEncrypt, 10), ep(none, ua.MessageSecurityModeNone, 0)}, true},
+		{"other parameters", []*ua.EndpointDescription{ep(none, ua.MessageSecurityModeNone, 0), reduced}, true},
+		{"missing endpoint", []*ua.EndpointDescription{ep(none, ua.MessageSecurityModeNone, 0)}, false},
+		{"duplicate endpoint", []*ua.EndpointDescription{ep(none, ua.MessageSecurityModeNone, 0), ep(none, ua.MessageSecurityModeNone, 0)}, false},
+		{"other mode", []*ua.EndpointDescription{ep(none, ua.MessageSecurityModeNone, 0), ep(basic, ua.MessageSecurityModeSign, 10)}, false},
+		{"other level", []*ua.EndpointDescription{ep(none, ua.MessageSecurityModeNone, 0), ep(basic, ua.MessageSecurityModeSignAndEncrypt, 20)}, false},
+		{"other user tokens", []*ua.EndpointDescription{ep(none, ua.MessageSecurityModeNone, 0), changedTokens}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkEndpoints(discovered, tt.returned)
+			if got, want := err == nil, tt.ok; got != want {
+				t.Fatalf("got error %v want ok %v", err, want)
+			}
+		})
+	}
+}
+
+func TestSessionUserName(t *testing.T) {
+	const policy = "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256"
+
+	clientKey, clientCert := newTestCert(t, 2048)
+	serverKey, serverCert := newTestCert(t, 2048)
+	serverNonce := []byte("0123456789abcdef0123456789abcdef")
+
+	c, sc := newTestConns(t, nil)
+	defer c.Close()
+	defer sc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the server advertises a user token policy for user names and
+	// passes the user identity token of ActivateSession to the test.
+	srvCfg := NewServerConfig(policy, serverCert, nil, 1, ua.MessageSecurityModeSignAndEncrypt, 1, 3600000)
+	srvCfg.LocalKey = serverKey
+	srv := NewServerSecureChannel(sc, srvCfg)
+	tokens := make(chan *ua.UserNameIdentityToken, 1)
+	go func() {
+		if err := srv.Accept(ctx); err != nil {
+			return
+		}
+		srv.Serve(func(req interface{}) (interface{}, error) {
+			switch r := req.(type) {
+			case *ua.CreateSessionRequest:
+				sig, err := srv.NewSessionSignature(r.ClientCertificate, r.ClientNonce)
+				if err != nil {
+					return nil, err
+				}
+				return &ua.CreateSessionResponse{
+					SessionID:           ua.NewNumericNodeID(1, 1),
+					AuthenticationToken: ua.NewByteStringNodeID(0, []byte("token")),
+					ServerNonce:         serverNonce,
+					ServerCertificate:   serverCert,
+					ServerEndpoints: []*ua.EndpointDescription{{
+						Server:            &ua.ApplicationDescription{ApplicationURI: "urn:gopcua:server", ApplicationName: &ua.LocalizedText{}},
+						SecurityPolicyURI: policy,
+						SecurityMode:      ua.MessageSecurityModeSignAndEncrypt,
+						UserIdentityTokens: []*ua.UserTokenPolicy{
+							{PolicyID: "username", TokenType: ua.UserTokenTypeUserName},
+						},
+					}},
+					ServerSignature: sig,
+				}, nil
+			case *ua.ActivateSessionRequest:
+				tok, _ := r.UserIdentityToken.Value.(*ua.UserNameIdentityToken)
+				tokens <- tok
+				return &ua.ActivateSessionResponse{ServerNonce: serverNonce}, nil
+			}
+			return nil, ua.StatusBadServiceUnsupported
+		})
+	}()
+
+	cli := NewSecureChannel(c, NewClientConfigSignAndEncryptBasic256Sha256(clientCert, clientKey, serverCert, 1, 3600000))
+	if err := cli.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	tok := &ua.UserNameIdentityToken{UserName: "user", Password: []byte("pass")}
+	if err := NewSession(cli, NewClientSessionConfig(nil, tok)).Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-tokens
+	if got == nil {
+		t.Fatal("got no user name token")
+	}
+	if got.PolicyID != "username" || got.UserName != "user" {
+		t.Fatalf("got policy %q and user %q want username and user", got.PolicyID, got.UserName)
+	}
+	if got, want := got.EncryptionAlgorithm, "http://www.w3.org/2001/04/xmlenc#rsa-oaep"; got != want {
+		t.Fatalf("got algorithm %s want %s", got, want)
+	}
+
+	// the server decrypts the password and its nonce with its key
+	enc, err := securitypolicy.Asymmetric(policy, serverKey, &clientKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := enc.Decrypt(got.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := binary.LittleEndian.Uint32(b), uint32(len("pass")+len(serverNonce)); got != want {
+		t.Fatalf("got length %d want %d", got, want)
+	}
+	if got, want := b[4:], append([]byte("pass"), serverNonce...); !bytes.Equal(got, want) {
+		t.Fatalf("got secret %q want %q", got, want)
+	}
+}
@@ -11,13 +11,22 @@ import (
 
 // EncodeTimestamp serializes time.Time into given bytes buffer
 // in "100 nanosecond intervals since January 1, 1601" manner.
+// The zero time is encoded as 0 which means that the time is not set.
 func EncodeTimestamp(b []byte, t time.Time) {
+	if t.IsZero() {
+		binary.LittleEndian.PutUint64(b, 0)
+		return
+	}
 	binary.LittleEndian.PutUint64(b, uint64(t.UTC().UnixNano()/100+116444736000000000))
 }
 
 // DecodeTimestamp decodes given bytes into time.Time
 // in "100 nanosecond intervals since January 1, 1601" manner.
+// 0 is decoded as the zero time.
 func DecodeTimestamp(b []byte) time.Time {
 	t := binary.LittleEndian.Uint64(b[:8])
+	if t == 0 {
+		return time.Time{}
+	}
 	return time.Unix(0, int64((t-116444736000000000)*100)).UTC()
 }
@@ -37,3 +37,16 @@ func TestEncodeTime(t *testing.T) {
 	}
 	t.Logf("%x", serialized)
 }
+
+func TestZeroTime(t *testing.T) {
+	serialized := make([]byte, 8)
+	EncodeTimestamp(serialized, time.Time{})
+	for i, s := range serialized {
+		if s != 0 {
+			t.Errorf("Bytes doesn't match. Want: 0, Got: %#x at %dth", s, i)
+		}
+	}
+	if ts := DecodeTimestamp(serialized); !ts.IsZero() {
+		t.Errorf("Timestamp doesn't match. Want: zero time, Got: %s", ts)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/ua"
+)
+
+// DefaultMaxWalkDepth is the depth below which Walk does not descend
+// unless WalkMaxDepth is used.
+const DefaultMaxWalkDepth = 64
+
+// WalkOption configures Walk.
+type WalkOption func(*walkConfig)
+
+// walkConfig contains the settings of Walk.
+type walkConfig struct {
+	maxDepth int
+}
+
+// WalkMaxDepth sets the depth below which Walk does not descend. The
+// children of the root have depth 1.
+func WalkMaxDepth(n int) WalkOption {
+	return func(cfg *walkConfig) {
+		cfg.maxDepth = n
+	}
+}
+
+// SkipChildren is returned by a WalkFunc to skip the children of the
+// node. It is not returned as an error by Walk.
+var SkipChildren = errors.New("skip children")
+
+// WalkFunc is called by Walk for every node below the root. path is the
+// browse path of the node relative to the root in the text format of a
+// RelativePath, e.g. "/Objects/2:Device", and depth is its number of
+// elements. ref is the reference of the parent to the node.
+//
+// If the function returns SkipChildren the children of the node are not
+// visited. Any other error stops Walk and is returned by it.
+type WalkFunc func(path string, depth int, ref *ua.ReferenceDescription) error
+
+// Walk visits the nodes below root depth-first in the order of the
+// forward hierarchical references and calls fn for every node. Every node
+// is visited only once even if it can be reached on different paths or
+// through a cycle. Nodes below the maximum depth, which is
+// DefaultMaxWalkDepth unless WalkMaxDepth is used, and nodes on other
+// servers are not visited.
+func Walk(ctx context.Context, root *Node, fn WalkFunc, opts ...WalkOption) error {
+	cfg := &walkConfig{maxDepth: DefaultMaxWalkDepth}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	visited := map[string]bool{root.ID.String(): true}
+	err := walk(ctx, root, "", 1, cfg, visited, fn)
+	if err == SkipChildren {
+		return nil
+	}
+	return err
+}
+
+// hierarchicalReferences is the reference type of the browse requests
+// and the path elements of Walk.
+var hierarchicalReferences = ua.NewNumericNodeID(0, id.HierarchicalReferences)
+
+func walk(ctx context.Context, n *Node, path string, depth int, cfg *walkConfig, visited map[string]bool, fn WalkFunc) error {
+	if depth > cfg.maxDepth {
+		return nil
+	}
+
+	// the references are collected first so that no continuation point
+	// is kept while the children are visited.
+	var refs []*ua.ReferenceDescription
+	err := n.browse(ctx, ua.BrowseDirectionForward, hierarchicalReferences, ua.NodeClassAll, func(r *ua.ReferenceDescription) error {
+		refs = append(refs, r)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range refs {
+		child := n.c.refNode(r)
+		if child == nil || visited[child.ID.String()] {
+			continue
+		}
+		visited[child.ID.String()] = true
+
+		e := &ua.RelativePathElement{
+			ReferenceTypeID: hierarchicalReferences,
+			IncludeSubtypes: true,
+			TargetName:      r.BrowseName,
+		}
+		p := path + e.String()
+		switch err := fn(p, depth, r); err {
+		case nil:
+			if err := walk(ctx, child, p, depth+1, cfg, visited, fn); err != nil {
+				return err
+			}
+		case SkipChildren:
+		default:
+			return err
+		}
+	}
+	return nil
+}
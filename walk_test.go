@@ -0,0 +1,85 @@
+// Copyright 2018-2019 opcua authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package opcua
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestWalk(t *testing.T) {
+	tr := newTestTree(2)
+	c, stop := newBrowseTestClient(t, tr)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	root := c.Node(ua.NewStringNodeID(1, "root"))
+
+	errStop := errors.New("stop")
+	cases := []struct {
+		name  string
+		fn    func(path string, depth int) error
+		opts  []WalkOption
+		paths []string
+		err   error
+	}{
+		{
+			name: "all",
+			paths: []string{
+				"/1:a", "/1:a/1:a1", "/1:a/1:a2", "/1:a/1:a3",
+				"/1:b", "/1:b/1:b&.1",
+				"/1:c",
+			},
+		},
+		{
+			name: "depth",
+			fn: func(path string, depth int) error {
+				if depth == 1 {
+					return SkipChildren
+				}
+				return nil
+			},
+			paths: []string{"/1:a", "/1:b", "/1:c"},
+		},
+		{
+			name:  "max depth",
+			opts:  []WalkOption{WalkMaxDepth(1)},
+			paths: []string{"/1:a", "/1:b", "/1:c"},
+		},
+		{
+			name: "stop",
+			fn: func(path string, depth int) error {
+				if path == "/1:a/1:a2" {
+					return errStop
+				}
+				return nil
+			},
+			paths: []string{"/1:a", "/1:a/1:a1", "/1:a/1:a2"},
+			err:   errStop,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var paths []string
+			err := Walk(ctx, root, func(path string, depth int, ref *ua.ReferenceDescription) error {
+				paths = append(paths, path)
+				if tc.fn != nil {
+					return tc.fn(path, depth)
+				}
+				return nil
+			}, tc.opts...)
+			if err != tc.err {
+				t.Fatalf("got error %v want %v", err, tc.err)
+			}
+			verify.Values(t, "", paths, tc.paths)
+		})
+	}
+}